@@ -8,7 +8,7 @@ import (
 )
 
 func main() {
-	fmt.Println("=== Keybase Crypto - Ephemeral Key Generation Example ===\n")
+	fmt.Printf("=== Keybase Crypto - Ephemeral Key Generation Example ===\n\n")
 
 	// Example 1: Generate a single ephemeral key pair
 	fmt.Println("1. Generating a single ephemeral key pair...")
@@ -24,7 +24,7 @@ func main() {
 	
 	// Clean up the secret key
 	defer pair.Zero()
-	fmt.Println("   ✓ Key pair generated successfully\n")
+	fmt.Printf("   ✓ Key pair generated successfully\n\n")
 
 	// Example 2: Generate multiple key pairs
 	fmt.Println("2. Generating 5 ephemeral key pairs...")
@@ -37,7 +37,7 @@ func main() {
 		fmt.Printf("   Key pair %d - Public: %x...\n", i+1, p.PublicKey.Bytes()[:8])
 		defer p.Zero()
 	}
-	fmt.Println("   ✓ All key pairs generated successfully\n")
+	fmt.Printf("   ✓ All key pairs generated successfully\n\n")
 
 	// Example 3: Demonstrate key uniqueness
 	fmt.Println("3. Verifying key uniqueness...")
@@ -66,9 +66,9 @@ func main() {
 	}
 	
 	if isUnique {
-		fmt.Println("   ✓ Keys are unique\n")
+		fmt.Printf("   ✓ Keys are unique\n\n")
 	} else {
-		fmt.Println("   ✗ Warning: Keys are identical (very unlikely!)\n")
+		fmt.Printf("   ✗ Warning: Keys are identical (very unlikely!)\n\n")
 	}
 
 	// Example 4: Demonstrate secure key zeroing
@@ -92,9 +92,9 @@ func main() {
 	
 	if allZeros {
 		fmt.Println("   After Zero()  - Secret key: [all zeros]")
-		fmt.Println("   ✓ Secret key successfully zeroed\n")
+		fmt.Printf("   ✓ Secret key successfully zeroed\n\n")
 	} else {
-		fmt.Println("   ✗ Warning: Secret key not completely zeroed\n")
+		fmt.Printf("   ✗ Warning: Secret key not completely zeroed\n\n")
 	}
 
 	fmt.Println("=== Example completed successfully ===")
@@ -17,7 +17,7 @@ type DecryptKeeper interface {
 }
 
 func main() {
-	fmt.Println("=== Keybase Decrypt Method Example ===\n")
+	fmt.Printf("=== Keybase Decrypt Method Example ===\n\n")
 
 	// Example 1: Single Recipient Encrypt/Decrypt
 	fmt.Println("Example 1: Single Recipient")
@@ -289,10 +289,8 @@ func createMockCacheManager(keys map[string]*crypto.KeyPair) (*cache.Manager, er
 
 	// Pre-populate cache with test keys
 	for username, keyPair := range keys {
-		keyID := keyPair.PublicKey.ToKID()
-		
-		// Convert to hex with 0120 prefix (simulates Keybase KID format)
-		keyIDHex := "0120" + fmt.Sprintf("%x", keyID)
+		// Store as a Keybase NaCl DH KID (0121...0a), like a device encryption key
+		keyIDHex := crypto.EncryptionKID(keyPair.PublicKey)
 		mockKeyBundle := "-----BEGIN PGP PUBLIC KEY BLOCK----- test key -----"
 		
 		if err := manager.Cache().Set(username, mockKeyBundle, keyIDHex); err != nil {
@@ -11,14 +11,14 @@ import (
 )
 
 func main() {
-	fmt.Println("=== Keybase Sender Key Example ===\n")
+	fmt.Printf("=== Keybase Sender Key Example ===\n\n")
 
 	// Step 1: Verify Keybase is available
 	fmt.Println("Step 1: Verifying Keybase installation...")
 	if err := credentials.VerifyKeybaseAvailable(); err != nil {
 		// Keybase is not available - demonstrate with test keys instead
 		fmt.Printf("Warning: Keybase not available (%v)\n", err)
-		fmt.Println("Falling back to test keys for demonstration...\n")
+		fmt.Printf("Falling back to test keys for demonstration...\n\n")
 		demonstrateWithTestKeys()
 		return
 	}
@@ -40,7 +40,7 @@ func main() {
 	if err != nil {
 		// If loading from Keybase fails, fall back to test keys
 		fmt.Printf("Warning: Failed to load sender key (%v)\n", err)
-		fmt.Println("Falling back to test keys for demonstration...\n")
+		fmt.Printf("Falling back to test keys for demonstration...\n\n")
 		demonstrateWithTestKeys()
 		return
 	}
@@ -53,7 +53,7 @@ func main() {
 	if err := crypto.ValidateSenderKey(senderKey); err != nil {
 		log.Fatalf("Invalid sender key: %v", err)
 	}
-	fmt.Println("  ✓ Sender key is valid\n")
+	fmt.Printf("  ✓ Sender key is valid\n\n")
 
 	// Step 5: Generate a recipient key (simulating another user)
 	fmt.Println("Step 5: Generating recipient key...")
@@ -61,7 +61,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to generate recipient key: %v", err)
 	}
-	fmt.Println("  ✓ Recipient key generated\n")
+	fmt.Printf("  ✓ Recipient key generated\n\n")
 
 	// Step 6: Create encryptor with sender key
 	fmt.Println("Step 6: Creating encryptor with sender key...")
@@ -71,7 +71,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create encryptor: %v", err)
 	}
-	fmt.Println("  ✓ Encryptor created\n")
+	fmt.Printf("  ✓ Encryptor created\n\n")
 
 	// Step 7: Encrypt a message
 	fmt.Println("Step 7: Encrypting message...")
@@ -88,7 +88,7 @@ func main() {
 	keyring := crypto.NewSimpleKeyring()
 	keyring.AddKeyPair(recipientKey)
 	keyring.AddPublicKey(senderKey.PublicKey) // For sender verification
-	fmt.Println("  ✓ Keyring configured\n")
+	fmt.Printf("  ✓ Keyring configured\n\n")
 
 	// Step 9: Create decryptor
 	fmt.Println("Step 9: Creating decryptor...")
@@ -98,7 +98,7 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to create decryptor: %v", err)
 	}
-	fmt.Println("  ✓ Decryptor created\n")
+	fmt.Printf("  ✓ Decryptor created\n\n")
 
 	// Step 10: Decrypt and verify
 	fmt.Println("Step 10: Decrypting and verifying message...")
@@ -124,7 +124,7 @@ func main() {
 // demonstrateWithTestKeys shows how sender keys work using test keys
 // when Keybase is not available
 func demonstrateWithTestKeys() {
-	fmt.Println("=== Using Test Keys for Demonstration ===\n")
+	fmt.Printf("=== Using Test Keys for Demonstration ===\n\n")
 
 	// Create a test sender key
 	fmt.Println("Creating test sender key...")
@@ -139,7 +139,7 @@ func demonstrateWithTestKeys() {
 	if err := crypto.ValidateSenderKey(senderKey); err != nil {
 		log.Fatalf("Invalid test sender key: %v", err)
 	}
-	fmt.Println("  ✓ Test sender key is valid\n")
+	fmt.Printf("  ✓ Test sender key is valid\n\n")
 
 	// Generate recipient key
 	fmt.Println("Generating recipient key...")
@@ -147,7 +147,7 @@ func demonstrateWithTestKeys() {
 	if err != nil {
 		log.Fatalf("Failed to generate recipient key: %v", err)
 	}
-	fmt.Println("  ✓ Recipient key generated\n")
+	fmt.Printf("  ✓ Recipient key generated\n\n")
 
 	// Create encryptor with test sender key
 	fmt.Println("Creating encryptor with test sender key...")
@@ -157,7 +157,7 @@ func demonstrateWithTestKeys() {
 	if err != nil {
 		log.Fatalf("Failed to create encryptor: %v", err)
 	}
-	fmt.Println("  ✓ Encryptor created\n")
+	fmt.Printf("  ✓ Encryptor created\n\n")
 
 	// Encrypt a message
 	fmt.Println("Encrypting message...")
@@ -173,7 +173,7 @@ func demonstrateWithTestKeys() {
 	keyring := crypto.NewSimpleKeyring()
 	keyring.AddKeyPair(recipientKey)
 	keyring.AddPublicKey(senderKey.PublicKey)
-	fmt.Println("  ✓ Keyring configured\n")
+	fmt.Printf("  ✓ Keyring configured\n\n")
 
 	// Create decryptor
 	fmt.Println("Creating decryptor...")
@@ -183,7 +183,7 @@ func demonstrateWithTestKeys() {
 	if err != nil {
 		log.Fatalf("Failed to create decryptor: %v", err)
 	}
-	fmt.Println("  ✓ Decryptor created\n")
+	fmt.Printf("  ✓ Decryptor created\n\n")
 
 	// Decrypt and verify
 	fmt.Println("Decrypting and verifying message...")
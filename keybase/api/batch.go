@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultLookupBatchSize is the default number of usernames sent in one
+	// user/lookup.json request
+	DefaultLookupBatchSize = 25
+
+	// DefaultMaxConcurrentLookups is the default number of lookup requests
+	// in flight at once
+	DefaultMaxConcurrentLookups = 4
+)
+
+// UserLookupFailure is the reason a single user's keys could not be fetched
+type UserLookupFailure struct {
+	// Username is the username as it was requested
+	Username string
+
+	// Kind classifies the failure: ErrorKindNotFound for unknown users,
+	// ErrorKindInvalidResponse for users without a primary key, and the kind
+	// of the request's error for users whose whole request failed
+	Kind ErrorKind
+
+	// Err is the error for this user
+	Err error
+}
+
+// ErrorKindOf returns the kind of the first *APIError in err's chain, or
+// ErrorKindUnknown if there is none
+func ErrorKindOf(err error) ErrorKind {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	return ErrorKindUnknown
+}
+
+// MultiLookupError is returned by LookupUsers when some users could not be
+// looked up. The keys of the other users are returned alongside it.
+//
+// errors.As finds the *APIError of the first failure that has one, so
+// callers that only check the error kind keep working.
+type MultiLookupError struct {
+	// Requested is the number of users that were asked for
+	Requested int
+
+	// Failures lists the users that failed, in request order
+	Failures []UserLookupFailure
+}
+
+func (e *MultiLookupError) Error() string {
+	parts := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		parts[i] = fmt.Sprintf("%s (%s)", failure.Username, failure.Kind)
+	}
+	return fmt.Sprintf("failed to look up %d of %d users: %s", len(e.Failures), e.Requested, strings.Join(parts, ", "))
+}
+
+// Unwrap returns the error of each failure for errors.Is and errors.As
+func (e *MultiLookupError) Unwrap() []error {
+	errs := make([]error, len(e.Failures))
+	for i, failure := range e.Failures {
+		errs[i] = failure.Err
+	}
+	return errs
+}
+
+// Usernames returns the users that failed, in request order
+func (e *MultiLookupError) Usernames() []string {
+	usernames := make([]string, len(e.Failures))
+	for i, failure := range e.Failures {
+		usernames[i] = failure.Username
+	}
+	return usernames
+}
+
+// lookupBatch is the outcome of one user/lookup.json request
+type lookupBatch struct {
+	usernames []string
+	keys      map[string]UserPublicKey
+	failures  map[string]error
+	err       error
+}
+
+// lookupBatches splits usernames into batches of c.LookupBatchSize and
+// fetches them with at most c.MaxConcurrentLookups requests in flight
+func (c *Client) lookupBatches(ctx context.Context, usernames []string) []*lookupBatch {
+	size := c.LookupBatchSize
+	if size <= 0 {
+		size = DefaultLookupBatchSize
+	}
+	concurrency := c.MaxConcurrentLookups
+	if concurrency <= 0 {
+		concurrency = DefaultMaxConcurrentLookups
+	}
+
+	var batches []*lookupBatch
+	for start := 0; start < len(usernames); start += size {
+		end := min(start+size, len(usernames))
+		batches = append(batches, &lookupBatch{usernames: usernames[start:end]})
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, batch := range batches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				batch.err = wrapContextError(ctx.Err())
+				return
+			}
+
+			batch.keys, batch.failures, batch.err = c.lookupChunk(ctx, batch.usernames)
+		}()
+	}
+	wg.Wait()
+
+	return batches
+}
+
+// lookupChunk fetches the keys of usernames in one request
+//
+// The API fails a whole request with status 205 (NOT_FOUND) when any user in
+// it is unknown, so a chunk of several users that is not found is split in
+// half and each half looked up in turn, until the unknown users are
+// requested on their own. A half that still fails as a whole is recorded
+// as a failure of each of its users.
+func (c *Client) lookupChunk(ctx context.Context, usernames []string) (map[string]UserPublicKey, map[string]error, error) {
+	response, err := c.lookup(ctx, usernames, "public_keys")
+	if err == nil {
+		return c.parseResponse(response, usernames)
+	}
+	if len(usernames) == 1 || ErrorKindOf(err) != ErrorKindNotFound {
+		return nil, nil, err
+	}
+
+	keys := make(map[string]UserPublicKey, len(usernames))
+	failures := make(map[string]error)
+	half := len(usernames) / 2
+	for _, part := range [][]string{usernames[:half], usernames[half:]} {
+		partKeys, partFailures, err := c.lookupChunk(ctx, part)
+		if err != nil {
+			for _, username := range part {
+				failures[strings.ToLower(username)] = err
+			}
+			continue
+		}
+		maps.Copy(keys, partKeys)
+		maps.Copy(failures, partFailures)
+	}
+
+	return keys, failures, nil
+}
+
+// collectLookups returns the keys found by batches in the order of
+// usernames, and a *MultiLookupError for the users that failed
+//
+// A lookup of a single batch that failed as a whole returns that batch's
+// error as is, as does cancellation; a single requested user's failure is
+// also returned as its bare *APIError.
+func collectLookups(ctx context.Context, usernames []string, batches []*lookupBatch) ([]UserPublicKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, wrapContextError(err)
+	}
+	if len(batches) == 1 && batches[0].err != nil {
+		return nil, batches[0].err
+	}
+
+	keys := make(map[string]UserPublicKey, len(usernames))
+	failures := make(map[string]error)
+	for _, batch := range batches {
+		if batch.err != nil {
+			for _, username := range batch.usernames {
+				failures[strings.ToLower(username)] = batch.err
+			}
+			continue
+		}
+		for username, key := range batch.keys {
+			keys[username] = key
+		}
+		for username, err := range batch.failures {
+			failures[username] = err
+		}
+	}
+
+	results := make([]UserPublicKey, 0, len(usernames))
+	multiErr := &MultiLookupError{Requested: len(usernames)}
+	for _, username := range usernames {
+		if err, ok := failures[strings.ToLower(username)]; ok {
+			multiErr.Failures = append(multiErr.Failures, UserLookupFailure{Username: username, Kind: ErrorKindOf(err), Err: err})
+			continue
+		}
+		results = append(results, keys[strings.ToLower(username)])
+	}
+
+	if len(multiErr.Failures) == 0 {
+		return results, nil
+	}
+	if len(usernames) == 1 {
+		return nil, multiErr.Failures[0].Err
+	}
+	return results, multiErr
+}
@@ -0,0 +1,244 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// batchServer serves user/lookup.json for every requested user except
+// those in missing, and without a primary key for those in keyless
+type batchServer struct {
+	missing map[string]bool
+	keyless map[string]bool
+	fail    string // requests naming this user fail with a 500
+	strict  bool   // requests naming a missing user fail with status 205, as the API does
+
+	mu       sync.Mutex
+	requests [][]string
+	inFlight atomic.Int32
+	peak     atomic.Int32
+}
+
+func (s *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	current := s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	for {
+		peak := s.peak.Load()
+		if current <= peak || s.peak.CompareAndSwap(peak, current) {
+			break
+		}
+	}
+	// Hold the request so that concurrent batches overlap
+	time.Sleep(20 * time.Millisecond)
+
+	usernames := strings.Split(r.URL.Query().Get("usernames"), ",")
+	s.mu.Lock()
+	s.requests = append(s.requests, usernames)
+	s.mu.Unlock()
+
+	response := LookupResponse{Status: Status{Code: 0, Name: "OK"}}
+	for _, username := range usernames {
+		if username == s.fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if s.missing[username] {
+			if s.strict {
+				response = LookupResponse{Status: Status{Code: 205, Name: "NOT_FOUND"}}
+				break
+			}
+			continue
+		}
+		user := User{Basics: Basics{Username: username}}
+		if !s.keyless[username] {
+			user.PublicKeys.Primary = PrimaryKey{KID: "kid_" + username, Bundle: "bundle_" + username}
+		}
+		response.Them = append(response.Them, user)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func teamOf(n int) []string {
+	usernames := make([]string, n)
+	for i := range usernames {
+		usernames[i] = fmt.Sprintf("user_%02d", i)
+	}
+	return usernames
+}
+
+func TestLookupUsersBatches(t *testing.T) {
+	backend := &batchServer{
+		missing: map[string]bool{"user_17": true},
+		keyless: map[string]bool{"user_42": true},
+	}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	client := NewClient(&ClientConfig{
+		BaseURL:              server.URL,
+		Timeout:              5 * time.Second,
+		MaxRetries:           0,
+		LookupBatchSize:      10,
+		MaxConcurrentLookups: 2,
+	})
+
+	usernames := teamOf(60)
+	keys, err := client.LookupUsers(context.Background(), usernames)
+
+	if len(backend.requests) != 6 {
+		t.Errorf("LookupUsers() made %d requests, want 6", len(backend.requests))
+	}
+	for _, request := range backend.requests {
+		if len(request) > 10 {
+			t.Errorf("request for %d users exceeds the batch size of 10", len(request))
+		}
+	}
+	if peak := backend.peak.Load(); peak > 2 {
+		t.Errorf("%d requests were in flight at once, want at most 2", peak)
+	}
+
+	multiErr, ok := err.(*MultiLookupError)
+	if !ok {
+		t.Fatalf("LookupUsers() error = %v, want *MultiLookupError", err)
+	}
+	if multiErr.Requested != 60 || len(multiErr.Failures) != 2 {
+		t.Fatalf("MultiLookupError = %v, want 2 failures of 60", multiErr)
+	}
+	if got := multiErr.Failures[0]; got.Username != "user_17" || got.Kind != ErrorKindNotFound {
+		t.Errorf("Failures[0] = %s (%v), want user_17 (NotFound)", got.Username, got.Kind)
+	}
+	if got := multiErr.Failures[1]; got.Username != "user_42" || got.Kind != ErrorKindInvalidResponse {
+		t.Errorf("Failures[1] = %s (%v), want user_42 (InvalidResponse)", got.Username, got.Kind)
+	}
+	if want := "failed to look up 2 of 60 users: user_17 (NotFoundError), user_42 (InvalidResponseError)"; multiErr.Error() != want {
+		t.Errorf("Error() = %q, want %q", multiErr.Error(), want)
+	}
+
+	// The other 58 keys come back in request order
+	if len(keys) != 58 {
+		t.Fatalf("LookupUsers() returned %d keys, want 58", len(keys))
+	}
+	i := 0
+	for _, username := range usernames {
+		if username == "user_17" || username == "user_42" {
+			continue
+		}
+		if keys[i].Username != username {
+			t.Fatalf("keys[%d].Username = %s, want %s", i, keys[i].Username, username)
+		}
+		i++
+	}
+}
+
+func TestLookupUsersBatchFailure(t *testing.T) {
+	backend := &batchServer{fail: "user_05"}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	client := NewClient(&ClientConfig{
+		BaseURL:         server.URL,
+		Timeout:         5 * time.Second,
+		MaxRetries:      0,
+		LookupBatchSize: 4,
+	})
+
+	keys, err := client.LookupUsers(context.Background(), teamOf(10))
+
+	// The batch of user_04..user_07 fails as a whole; the others succeed
+	multiErr, ok := err.(*MultiLookupError)
+	if !ok {
+		t.Fatalf("LookupUsers() error = %v, want *MultiLookupError", err)
+	}
+	want := []string{"user_04", "user_05", "user_06", "user_07"}
+	if got := multiErr.Usernames(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Usernames() = %v, want %v", got, want)
+	}
+	for _, failure := range multiErr.Failures {
+		if failure.Kind != ErrorKindServerError {
+			t.Errorf("%s failed with %v, want ServerError", failure.Username, failure.Kind)
+		}
+	}
+	if len(keys) != 6 {
+		t.Errorf("LookupUsers() returned %d keys, want 6", len(keys))
+	}
+}
+
+func TestLookupUsersSingleBatchFailure(t *testing.T) {
+	server := httptest.NewServer(&batchServer{fail: "alice"})
+	defer server.Close()
+
+	client := NewClient(&ClientConfig{
+		BaseURL:    server.URL,
+		Timeout:    5 * time.Second,
+		MaxRetries: 0,
+	})
+
+	// A request that fits in one batch reports the request's error as is
+	_, err := client.LookupUsers(context.Background(), []string{"alice", "bob"})
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("LookupUsers() error = %T, want *APIError", err)
+	}
+	if apiErr.Kind != ErrorKindServerError {
+		t.Errorf("Kind = %v, want ServerError", apiErr.Kind)
+	}
+}
+
+func TestLookupUsersNotFoundInBatch(t *testing.T) {
+	backend := &batchServer{
+		missing: map[string]bool{"user_02": true, "user_09": true},
+		strict:  true,
+	}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	client := NewClient(&ClientConfig{
+		BaseURL:         server.URL,
+		Timeout:         5 * time.Second,
+		MaxRetries:      0,
+		LookupBatchSize: 8,
+	})
+
+	// Both batches are refused with 205; only the unknown users fail
+	keys, err := client.LookupUsers(context.Background(), teamOf(12))
+
+	multiErr, ok := err.(*MultiLookupError)
+	if !ok {
+		t.Fatalf("LookupUsers() error = %v, want *MultiLookupError", err)
+	}
+	want := []string{"user_02", "user_09"}
+	if got := multiErr.Usernames(); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Usernames() = %v, want %v", got, want)
+	}
+	for _, failure := range multiErr.Failures {
+		if failure.Kind != ErrorKindNotFound {
+			t.Errorf("%s failed with %v, want NotFound", failure.Username, failure.Kind)
+		}
+	}
+	if len(keys) != 10 {
+		t.Errorf("LookupUsers() returned %d keys, want 10", len(keys))
+	}
+
+	// A batch that fits in one request is bisected as well
+	keys, err = client.LookupUsers(context.Background(), []string{"user_01", "user_02", "user_03"})
+	multiErr, ok = err.(*MultiLookupError)
+	if !ok {
+		t.Fatalf("LookupUsers() error = %v, want *MultiLookupError", err)
+	}
+	if got := multiErr.Usernames(); len(got) != 1 || got[0] != "user_02" {
+		t.Errorf("Usernames() = %v, want [user_02]", got)
+	}
+	if len(keys) != 2 || keys[0].Username != "user_01" || keys[1].Username != "user_03" {
+		t.Errorf("LookupUsers() = %v, want user_01 and user_03", keys)
+	}
+}
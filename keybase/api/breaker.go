@@ -0,0 +1,269 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBreakerThreshold is the default number of consecutive failed
+	// calls that opens the circuit breaker
+	DefaultBreakerThreshold = 5
+
+	// DefaultBreakerCooldown is the default time the breaker stays open
+	// before letting a trial call through
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// ErrCircuitOpen is the underlying error of calls refused by an open
+// circuit breaker
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every call through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen refuses every call until the cooldown has passed
+	BreakerOpen
+	// BreakerHalfOpen lets a single trial call through; its outcome closes
+	// or reopens the breaker
+	BreakerHalfOpen
+)
+
+// String returns a string representation of the BreakerState
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// BreakerStats is a snapshot of a circuit breaker, for diagnostics
+type BreakerStats struct {
+	// State is the current state
+	State BreakerState
+	// ConsecutiveFailures counts the failed calls since the last success
+	ConsecutiveFailures int
+	// Trips counts the times the breaker has opened
+	Trips int
+	// OpenUntil is when an open breaker lets a trial call through
+	OpenUntil time.Time
+	// LastError is the most recent failure, or nil
+	LastError error
+}
+
+// CircuitBreaker stops calls to an endpoint that keeps failing
+//
+// Calls that fail with a temporary error (network errors, timeouts, rate
+// limiting and 5xx responses, after retries) count towards the threshold;
+// any other outcome resets the count. Once open, the breaker refuses calls
+// with an *APIError of kind ErrorKindRateLimit or ErrorKindNetwork,
+// depending on what opened it, until the cooldown (or a longer Retry-After)
+// has passed. A breaker is safe for concurrent use and is shared by the
+// clients of an endpoint.
+type CircuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	state     BreakerState
+	failures  int
+	trips     int
+	openUntil time.Time
+	lastErr   *APIError
+	trial     bool
+}
+
+// NewCircuitBreaker returns a breaker that opens after threshold
+// consecutive failed calls and stays open for cooldown
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// State returns the current state of the breaker
+func (b *CircuitBreaker) State() BreakerState {
+	return b.Stats().State
+}
+
+// Stats returns a snapshot of the breaker
+func (b *CircuitBreaker) Stats() BreakerStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stats := BreakerStats{
+		State:               b.state,
+		ConsecutiveFailures: b.failures,
+		Trips:               b.trips,
+		OpenUntil:           b.openUntil,
+	}
+	if b.state == BreakerOpen && !b.now().Before(b.openUntil) {
+		stats.State = BreakerHalfOpen
+	}
+	if b.lastErr != nil {
+		stats.LastError = b.lastErr
+	}
+	return stats
+}
+
+// Reset closes the breaker and forgets past failures
+func (b *CircuitBreaker) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.state = BreakerClosed
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.lastErr = nil
+	b.trial = false
+}
+
+// allow returns an error if the breaker refuses a call
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	switch b.state {
+	case BreakerOpen:
+		if now.Before(b.openUntil) {
+			return b.openError(b.openUntil.Sub(now))
+		}
+		b.state = BreakerHalfOpen
+		b.trial = true
+		return nil
+	case BreakerHalfOpen:
+		if b.trial {
+			// Another call is already finding out whether the endpoint
+			// has recovered
+			return b.openError(0)
+		}
+		b.trial = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of an allowed call
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerHalfOpen {
+		b.trial = false
+	}
+
+	var apiErr *APIError
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// The caller gave up; that says nothing about the endpoint
+		return
+	case err == nil || !errors.As(err, &apiErr) || !apiErr.IsTemporary() && !apiErr.IsRateLimitError():
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	b.lastErr = apiErr
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.trips++
+		b.openUntil = b.now().Add(max(b.cooldown, apiErr.RetryAfter))
+	}
+}
+
+// openError returns the error for a call refused by the breaker; b.mu must
+// be held
+func (b *CircuitBreaker) openError(retryAfter time.Duration) *APIError {
+	kind := ErrorKindNetwork
+	cause := "endpoint failures"
+	if b.lastErr != nil && b.lastErr.IsRateLimitError() {
+		kind = ErrorKindRateLimit
+		cause = "rate limiting"
+	}
+
+	message := fmt.Sprintf("circuit breaker open after %d consecutive %s", b.failures, cause)
+	if b.lastErr != nil {
+		message += fmt.Sprintf(" (last error: %s)", b.lastErr.Message)
+	}
+	if retryAfter > 0 {
+		message += fmt.Sprintf("; retry after %s", retryAfter.Round(time.Second))
+	}
+
+	return &APIError{
+		Message:    message,
+		StatusCode: 0,
+		Kind:       kind,
+		Temporary:  true,
+		RetryAfter: retryAfter,
+		Underlying: ErrCircuitOpen,
+	}
+}
+
+// sharedState holds the limiters and breakers of the clients in the
+// process, so that clients created separately for the same endpoint (one
+// per keeper, say) stay within its limits together
+var sharedState = struct {
+	sync.Mutex
+	limiters map[string]*RateLimiter
+	breakers map[breakerKey]*CircuitBreaker
+}{
+	limiters: make(map[string]*RateLimiter),
+	breakers: make(map[breakerKey]*CircuitBreaker),
+}
+
+type breakerKey struct {
+	baseURL   string
+	threshold int
+	cooldown  time.Duration
+}
+
+// sharedLimiter returns the process-wide limiter for an endpoint
+//
+// The limit is the endpoint's, so clients asking for other settings still
+// share the bucket; it is created with the settings of the first client.
+func sharedLimiter(baseURL string, rate float64, burst int) *RateLimiter {
+	sharedState.Lock()
+	defer sharedState.Unlock()
+
+	limiter, ok := sharedState.limiters[baseURL]
+	if !ok {
+		limiter = NewRateLimiter(rate, burst)
+		sharedState.limiters[baseURL] = limiter
+	}
+	return limiter
+}
+
+// sharedBreaker returns the process-wide breaker for an endpoint and settings
+func sharedBreaker(baseURL string, threshold int, cooldown time.Duration) *CircuitBreaker {
+	sharedState.Lock()
+	defer sharedState.Unlock()
+
+	key := breakerKey{baseURL: baseURL, threshold: threshold, cooldown: cooldown}
+	breaker, ok := sharedState.breakers[key]
+	if !ok {
+		breaker = NewCircuitBreaker(threshold, cooldown)
+		sharedState.breakers[key] = breaker
+	}
+	return breaker
+}
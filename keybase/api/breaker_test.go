@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for circuit breakers
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestBreaker(threshold int, cooldown time.Duration) (*CircuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	breaker := NewCircuitBreaker(threshold, cooldown)
+	breaker.now = clock.Now
+	return breaker, clock
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	breaker, clock := newTestBreaker(2, time.Minute)
+	ctx := context.Background()
+	serverErr := &APIError{Message: "bad gateway", StatusCode: 502, Kind: ErrorKindServerError, Temporary: true}
+
+	breaker.record(ctx, serverErr)
+	if state := breaker.State(); state != BreakerClosed {
+		t.Fatalf("State() after one failure = %v, want closed", state)
+	}
+	breaker.record(ctx, serverErr)
+	if state := breaker.State(); state != BreakerOpen {
+		t.Fatalf("State() after two failures = %v, want open", state)
+	}
+
+	err := breaker.allow()
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindNetwork || !apiErr.IsTemporary() {
+		t.Fatalf("allow() while open = %v, want a temporary NetworkError", err)
+	}
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("allow() while open = %v, want it to wrap ErrCircuitOpen", err)
+	}
+	if apiErr.RetryAfter != time.Minute {
+		t.Errorf("RetryAfter = %v, want the 1m cooldown", apiErr.RetryAfter)
+	}
+
+	// After the cooldown one trial call is let through at a time
+	clock.now = clock.now.Add(time.Minute)
+	if state := breaker.State(); state != BreakerHalfOpen {
+		t.Errorf("State() after the cooldown = %v, want half-open", state)
+	}
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() for the trial call = %v", err)
+	}
+	if err := breaker.allow(); err == nil {
+		t.Error("allow() during the trial call should fail")
+	}
+
+	breaker.record(ctx, nil)
+	stats := breaker.Stats()
+	if stats.State != BreakerClosed || stats.ConsecutiveFailures != 0 || stats.Trips != 1 {
+		t.Errorf("Stats() after a successful trial = %+v, want closed with one trip", stats)
+	}
+	if err := breaker.allow(); err != nil {
+		t.Errorf("allow() when closed = %v", err)
+	}
+}
+
+func TestCircuitBreakerFailedTrialReopens(t *testing.T) {
+	breaker, clock := newTestBreaker(1, time.Minute)
+	ctx := context.Background()
+	timeout := &APIError{Message: "timed out", Kind: ErrorKindTimeout, Temporary: true}
+
+	breaker.record(ctx, timeout)
+	clock.now = clock.now.Add(time.Minute)
+	if err := breaker.allow(); err != nil {
+		t.Fatalf("allow() for the trial call = %v", err)
+	}
+	breaker.record(ctx, timeout)
+
+	stats := breaker.Stats()
+	if stats.State != BreakerOpen || stats.Trips != 2 || !stats.OpenUntil.Equal(clock.now.Add(time.Minute)) {
+		t.Errorf("Stats() after a failed trial = %+v, want open for another minute", stats)
+	}
+}
+
+func TestCircuitBreakerRateLimit(t *testing.T) {
+	breaker, _ := newTestBreaker(1, time.Second)
+	rateLimited := &APIError{Message: "slow down", StatusCode: 429, Kind: ErrorKindRateLimit, RetryAfter: time.Minute}
+
+	breaker.record(context.Background(), rateLimited)
+
+	// A Retry-After longer than the cooldown keeps the breaker open longer
+	var apiErr *APIError
+	if err := breaker.allow(); !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindRateLimit || apiErr.RetryAfter != time.Minute {
+		t.Errorf("allow() after rate limiting = %v, want a RateLimitError retrying after 1m", err)
+	}
+}
+
+func TestCircuitBreakerIgnoresPermanentErrors(t *testing.T) {
+	breaker, _ := newTestBreaker(1, time.Minute)
+
+	breaker.record(context.Background(), &APIError{Message: "no such user", Kind: ErrorKindNotFound})
+	breaker.record(context.Background(), &APIError{Message: "bad request", StatusCode: 400, Kind: ErrorKindInvalidInput})
+
+	// Cancellation by the caller says nothing about the endpoint either
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	breaker.record(ctx, &APIError{Message: "cancelled", Kind: ErrorKindTimeout, Underlying: context.Canceled})
+
+	if state := breaker.State(); state != BreakerClosed {
+		t.Errorf("State() = %v, want closed", state)
+	}
+}
+
+func TestClientCircuitBreaker(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	config := &ClientConfig{
+		BaseURL:          server.URL,
+		Timeout:          5 * time.Second,
+		MaxRetries:       0,
+		RateLimit:        -1,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Minute,
+	}
+	client := NewClient(config)
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.LookupUsers(context.Background(), []string{"alice"}); err == nil {
+			t.Fatal("LookupUsers() against a failing server should fail")
+		}
+	}
+
+	// The breaker is shared with other clients of the endpoint and refuses
+	// the call without a request
+	other := NewClient(config)
+	if other.CircuitBreaker != client.CircuitBreaker {
+		t.Error("clients with the same endpoint and settings should share a breaker")
+	}
+	_, err := other.LookupUsers(context.Background(), []string{"alice"})
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("LookupUsers() with the breaker open = %v, want ErrCircuitOpen", err)
+	}
+	if got := hits.Load(); got != 2 {
+		t.Errorf("server got %d requests, want 2", got)
+	}
+
+	stats := other.BreakerStats()
+	if stats.State != BreakerOpen || stats.Trips != 1 || stats.LastError == nil {
+		t.Errorf("BreakerStats() = %+v, want open after one trip", stats)
+	}
+
+	// A negative threshold disables the breaker
+	config.BreakerThreshold = -1
+	if disabled := NewClient(config); disabled.CircuitBreaker != nil || disabled.BreakerStats().State != BreakerClosed {
+		t.Error("a negative BreakerThreshold should disable the breaker")
+	}
+}
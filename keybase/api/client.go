@@ -34,6 +34,30 @@ type Client struct {
 	HTTPClient *http.Client
 	MaxRetries int
 	RetryDelay time.Duration
+	
+	// Headers are added to every request (e.g. credentials for a mirror)
+	Headers http.Header
+	
+	// LookupBatchSize is the most usernames sent in one lookup request
+	LookupBatchSize int
+	
+	// MaxConcurrentLookups bounds the lookup requests in flight at once
+	MaxConcurrentLookups int
+	
+	// RateLimiter paces requests, retries included (nil: no limit)
+	RateLimiter *RateLimiter
+	
+	// CircuitBreaker refuses calls while the endpoint keeps failing (nil:
+	// no breaker)
+	CircuitBreaker *CircuitBreaker
+	
+	// TrustServerTeams lets LookupTeam return the server's unverified
+	// member lists (see LookupTeam)
+	TrustServerTeams bool
+	
+	// configErr is why the client's transport could not be built; every
+	// call fails with it
+	configErr error
 }
 
 // ClientConfig holds configuration for the API client
@@ -42,6 +66,62 @@ type ClientConfig struct {
 	Timeout    time.Duration
 	MaxRetries int
 	RetryDelay time.Duration
+	
+	// Headers are added to every request, for example an Authorization
+	// header for an internal mirror of the API (optional)
+	Headers http.Header
+	
+	// LookupBatchSize is the most usernames sent in one user/lookup.json
+	// request; larger lookups are split (default: DefaultLookupBatchSize)
+	LookupBatchSize int
+	
+	// MaxConcurrentLookups bounds the batches fetched at once (default:
+	// DefaultMaxConcurrentLookups)
+	MaxConcurrentLookups int
+	
+	// RateLimit is the number of requests per second allowed to BaseURL,
+	// and RateBurst the number allowed at once. Clients in the process with
+	// the same BaseURL share one token bucket, created with the limits of
+	// the first of them. (defaults:
+	// DefaultRateLimit and DefaultRateBurst; a negative RateLimit disables
+	// rate limiting)
+	RateLimit float64
+	RateBurst int
+	
+	// BreakerThreshold is the number of consecutive failed calls that opens
+	// the circuit breaker, which then refuses calls for BreakerCooldown.
+	// Clients in the process with the same BaseURL and settings share one
+	// breaker. (defaults: DefaultBreakerThreshold and
+	// DefaultBreakerCooldown; a negative BreakerThreshold disables it)
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+	
+	// RateLimiter and CircuitBreaker, when set, are used in place of the
+	// shared ones and the settings above (optional)
+	RateLimiter    *RateLimiter
+	CircuitBreaker *CircuitBreaker
+	
+	// ProxyURL is an http, https or socks5 proxy to send requests through,
+	// in place of the HTTPS_PROXY and HTTP_PROXY environment variables
+	// (optional)
+	ProxyURL string
+	
+	// CAFile is a PEM bundle of CA certificates to trust in addition to
+	// the system roots, e.g. that of a TLS-intercepting proxy (optional)
+	CAFile string
+	
+	// ClientCertFile and ClientKeyFile are a PEM certificate and key
+	// presented for mutual TLS (optional; both or neither)
+	ClientCertFile string
+	ClientKeyFile  string
+	
+	// Transport, when set, carries every request; it cannot be combined
+	// with ProxyURL, CAFile or a client certificate (optional)
+	Transport http.RoundTripper
+	
+	// TrustServerTeams accepts team member lists from team/get.json
+	// without verification; see LookupTeam (default: false)
+	TrustServerTeams bool
 }
 
 // DefaultClientConfig returns the default API client configuration
@@ -51,6 +131,14 @@ func DefaultClientConfig() *ClientConfig {
 		Timeout:    DefaultTimeout,
 		MaxRetries: DefaultMaxRetries,
 		RetryDelay: DefaultRetryDelay,
+		
+		LookupBatchSize:      DefaultLookupBatchSize,
+		MaxConcurrentLookups: DefaultMaxConcurrentLookups,
+		
+		RateLimit:        DefaultRateLimit,
+		RateBurst:        DefaultRateBurst,
+		BreakerThreshold: DefaultBreakerThreshold,
+		BreakerCooldown:  DefaultBreakerCooldown,
 	}
 }
 
@@ -82,14 +170,81 @@ func NewClient(config *ClientConfig) *Client {
 		retryDelay = DefaultRetryDelay
 	}
 	
+	batchSize := config.LookupBatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultLookupBatchSize
+	}
+	
+	concurrency := config.MaxConcurrentLookups
+	if concurrency <= 0 {
+		concurrency = DefaultMaxConcurrentLookups
+	}
+	
+	limiter := config.RateLimiter
+	if limiter == nil && config.RateLimit >= 0 {
+		rate := config.RateLimit
+		if rate == 0 {
+			rate = DefaultRateLimit
+		}
+		burst := config.RateBurst
+		if burst <= 0 {
+			burst = DefaultRateBurst
+		}
+		limiter = sharedLimiter(baseURL, rate, burst)
+	}
+	
+	// Transport errors surface from the client's calls, so that NewClient
+	// keeps its signature; NewTransport reports them up front
+	transport, configErr := NewTransport(config)
+	if configErr != nil {
+		configErr = &APIError{
+			Message:    fmt.Sprintf("invalid API client network settings: %v", configErr),
+			StatusCode: 0,
+			Kind:       ErrorKindInvalidInput,
+			Temporary:  false,
+			Underlying: configErr,
+		}
+	}
+	
+	breaker := config.CircuitBreaker
+	if breaker == nil && config.BreakerThreshold >= 0 {
+		threshold := config.BreakerThreshold
+		if threshold == 0 {
+			threshold = DefaultBreakerThreshold
+		}
+		cooldown := config.BreakerCooldown
+		if cooldown <= 0 {
+			cooldown = DefaultBreakerCooldown
+		}
+		breaker = sharedBreaker(baseURL, threshold, cooldown)
+	}
+	
 	return &Client{
 		BaseURL: baseURL,
 		HTTPClient: &http.Client{
-			Timeout: timeout,
+			Timeout:   timeout,
+			Transport: transport,
 		},
 		MaxRetries: maxRetries,
 		RetryDelay: retryDelay,
+		Headers:    config.Headers.Clone(),
+		
+		LookupBatchSize:      batchSize,
+		MaxConcurrentLookups: concurrency,
+		RateLimiter:          limiter,
+		CircuitBreaker:       breaker,
+		TrustServerTeams:     config.TrustServerTeams,
+		configErr:            configErr,
+	}
+}
+
+// BreakerStats returns a snapshot of the client's circuit breaker, for
+// diagnostics; a client without a breaker always reports it closed
+func (c *Client) BreakerStats() BreakerStats {
+	if c.CircuitBreaker == nil {
+		return BreakerStats{State: BreakerClosed}
 	}
+	return c.CircuitBreaker.Stats()
 }
 
 // UserPublicKey represents a user's public key information
@@ -97,9 +252,75 @@ type UserPublicKey struct {
 	Username  string
 	PublicKey string
 	KeyID     string
+	
+	// EldestKID is the KID of the key that started the user's current sigchain
+	EldestKID string
+	
+	// Sibkeys are the KIDs of the user's active signing keys (0120 Ed25519
+	// device keys and PGP keys)
+	Sibkeys []string
+	
+	// Subkeys are the KIDs of the user's active subkeys; those with the 0121
+	// prefix are per-device NaCl Curve25519 encryption keys
+	Subkeys []string
+}
+
+// Keybase KID type bytes (the second byte of a hex KID, after the 01 version)
+const (
+	// KIDTypeEd25519 marks a NaCl Ed25519 signing key
+	KIDTypeEd25519 = "0120"
+	// KIDTypeCurve25519DH marks a NaCl Curve25519 Diffie-Hellman encryption key
+	KIDTypeCurve25519DH = "0121"
+)
+
+// EncryptionKIDs returns the KIDs of every active NaCl encryption key
+//
+// Keybase gives each device its own Curve25519 DH subkey, so a message must
+// be encrypted to all of them to be readable on all of the user's devices.
+// The primary KID is included when it is itself an encryption key.
+func (k *UserPublicKey) EncryptionKIDs() []string {
+	seen := make(map[string]bool)
+	var kids []string
+	
+	for _, kid := range append([]string{k.KeyID}, k.Subkeys...) {
+		kid = strings.ToLower(kid)
+		if !strings.HasPrefix(kid, KIDTypeCurve25519DH) || seen[kid] {
+			continue
+		}
+		seen[kid] = true
+		kids = append(kids, kid)
+	}
+	
+	return kids
+}
+
+// SigningKIDs returns the KIDs of every active NaCl Ed25519 signing key
+//
+// These are the user's device sibkeys (plus the eldest and primary KIDs when
+// they are Ed25519 keys), which sign saltpack signcrypted messages.
+func (k *UserPublicKey) SigningKIDs() []string {
+	seen := make(map[string]bool)
+	var kids []string
+	
+	for _, kid := range append([]string{k.KeyID, k.EldestKID}, k.Sibkeys...) {
+		kid = strings.ToLower(kid)
+		if !strings.HasPrefix(kid, KIDTypeEd25519) || seen[kid] {
+			continue
+		}
+		seen[kid] = true
+		kids = append(kids, kid)
+	}
+	
+	return kids
 }
 
 // LookupUsers fetches public keys for multiple users
+//
+// Large requests are split into batches of LookupBatchSize usernames, at
+// most MaxConcurrentLookups of which are fetched at once. Keys are returned
+// in request order. If some users are unknown or have no primary key, the
+// keys of the others are returned with a *MultiLookupError listing each
+// failure; a request for a single user fails with its *APIError.
 func (c *Client) LookupUsers(ctx context.Context, usernames []string) ([]UserPublicKey, error) {
 	if len(usernames) == 0 {
 		return nil, fmt.Errorf("no usernames provided")
@@ -112,16 +333,58 @@ func (c *Client) LookupUsers(ctx context.Context, usernames []string) ([]UserPub
 		}
 	}
 	
-	// Build request URL
-	reqURL := fmt.Sprintf("%s/user/lookup.json", c.BaseURL)
+	batches := c.lookupBatches(ctx, usernames)
+	return collectLookups(ctx, usernames, batches)
+}
+
+// lookup calls user/lookup.json for the given fields, retrying temporary failures
+func (c *Client) lookup(ctx context.Context, usernames []string, fields string) (*LookupResponse, error) {
 	params := url.Values{}
 	params.Set("usernames", strings.Join(usernames, ","))
-	params.Set("fields", "public_keys")
+	params.Set("fields", fields)
 	
-	fullURL := fmt.Sprintf("%s?%s", reqURL, params.Encode())
+	var response LookupResponse
+	if err := c.get(ctx, "user/lookup.json", params, &response); err != nil {
+		return nil, err
+	}
+	
+	return &response, nil
+}
+
+// apiResponse is a decoded API response body that carries a status
+type apiResponse interface {
+	apiStatus() Status
+}
+
+// get calls an API endpoint and decodes the response into out, retrying
+// temporary failures with exponential backoff
+//
+// Every attempt waits for the rate limiter, and the call as a whole is
+// refused at once while the circuit breaker is open.
+func (c *Client) get(ctx context.Context, endpoint string, params url.Values, out apiResponse) error {
+	if c.configErr != nil {
+		return c.configErr
+	}
+	
+	if c.CircuitBreaker != nil {
+		if err := c.CircuitBreaker.allow(); err != nil {
+			return err
+		}
+	}
+	
+	err := c.getWithRetries(ctx, endpoint, params, out)
+	if c.CircuitBreaker != nil {
+		c.CircuitBreaker.record(ctx, err)
+	}
+	return err
+}
+
+// getWithRetries makes the attempts of a call to get
+func (c *Client) getWithRetries(ctx context.Context, endpoint string, params url.Values, out apiResponse) error {
+	// Build request URL
+	fullURL := fmt.Sprintf("%s/%s?%s", c.BaseURL, endpoint, params.Encode())
 	
 	// Make API call with retries
-	var response *LookupResponse
 	var err error
 	
 	for attempt := 0; attempt <= c.MaxRetries; attempt++ {
@@ -136,12 +399,18 @@ func (c *Client) LookupUsers(ctx context.Context, usernames []string) ([]UserPub
 			
 			select {
 			case <-ctx.Done():
-				return nil, wrapContextError(ctx.Err())
+				return wrapContextError(ctx.Err())
 			case <-time.After(delay):
 			}
 		}
 		
-		response, err = c.doLookup(ctx, fullURL)
+		if c.RateLimiter != nil {
+			if waitErr := c.RateLimiter.Wait(ctx); waitErr != nil {
+				return wrapContextError(waitErr)
+			}
+		}
+		
+		err = c.doGet(ctx, fullURL, out)
 		if err == nil {
 			break
 		}
@@ -154,15 +423,7 @@ func (c *Client) LookupUsers(ctx context.Context, usernames []string) ([]UserPub
 		}
 	}
 	
-	if err != nil {
-		return nil, err
-	}
-	if response == nil {
-		return nil, fmt.Errorf("lookup succeeded but response was nil")
-	}
-	
-	// Parse response
-	return c.parseResponse(response, usernames)
+	return err
 }
 
 // classifyHTTPError classifies HTTP client errors (network, timeout, etc.)
@@ -342,6 +603,8 @@ func classifyAPIStatusCode(code int) ErrorKind {
 		return ErrorKindNotFound
 	case 207: // Bad username
 		return ErrorKindInvalidInput
+	case 2614: // Team not found
+		return ErrorKindNotFound
 	default:
 		return ErrorKindUnknown
 	}
@@ -372,11 +635,11 @@ func wrapContextError(err error) error {
 	return err
 }
 
-// doLookup performs the actual HTTP request
-func (c *Client) doLookup(ctx context.Context, url string) (*LookupResponse, error) {
+// doGet performs the actual HTTP request
+func (c *Client) doGet(ctx context.Context, url string, out apiResponse) error {
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, &APIError{
+		return &APIError{
 			Message:    fmt.Sprintf("failed to create request: %v", err),
 			StatusCode: 0,
 			Kind:       ErrorKindInvalidInput,
@@ -386,17 +649,20 @@ func (c *Client) doLookup(ctx context.Context, url string) (*LookupResponse, err
 	}
 	
 	req.Header.Set("User-Agent", "pulumi-keybase-encryption/1.0")
+	for name, values := range c.Headers {
+		req.Header[name] = values
+	}
 	
 	resp, err := c.HTTPClient.Do(req)
 	if err != nil {
 		// Classify the error based on its type
-		return nil, classifyHTTPError(err)
+		return classifyHTTPError(err)
 	}
 	defer resp.Body.Close()
 	
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, &APIError{
+		return &APIError{
 			Message:    fmt.Sprintf("failed to read response body: %v", err),
 			StatusCode: 0,
 			Kind:       ErrorKindNetwork,
@@ -406,12 +672,11 @@ func (c *Client) doLookup(ctx context.Context, url string) (*LookupResponse, err
 	}
 	
 	if resp.StatusCode != http.StatusOK {
-		return nil, classifyHTTPStatusError(resp, body)
+		return classifyHTTPStatusError(resp, body)
 	}
 	
-	var lookupResp LookupResponse
-	if err := json.Unmarshal(body, &lookupResp); err != nil {
-		return nil, &APIError{
+	if err := json.Unmarshal(body, out); err != nil {
+		return &APIError{
 			Message:    fmt.Sprintf("failed to parse API response: %v", err),
 			StatusCode: resp.StatusCode,
 			Kind:       ErrorKindInvalidResponse,
@@ -420,22 +685,24 @@ func (c *Client) doLookup(ctx context.Context, url string) (*LookupResponse, err
 		}
 	}
 	
-	if lookupResp.Status.Code != 0 {
-		return nil, &APIError{
-			Message:    fmt.Sprintf("API returned error: %s (code: %d)", lookupResp.Status.Name, lookupResp.Status.Code),
+	if status := out.apiStatus(); status.Code != 0 {
+		return &APIError{
+			Message:    fmt.Sprintf("API returned error: %s (code: %d)", status.Name, status.Code),
 			StatusCode: resp.StatusCode,
-			Kind:       classifyAPIStatusCode(lookupResp.Status.Code),
+			Kind:       classifyAPIStatusCode(status.Code),
 			Temporary:  false,
 		}
 	}
 	
-	return &lookupResp, nil
+	return nil
 }
 
-// parseResponse extracts public keys from the API response
-func (c *Client) parseResponse(response *LookupResponse, requestedUsers []string) ([]UserPublicKey, error) {
+// parseResponse extracts public keys from the API response, keyed by
+// lowercase username, along with why each of the other requested users
+// failed
+func (c *Client) parseResponse(response *LookupResponse, requestedUsers []string) (map[string]UserPublicKey, map[string]error, error) {
 	if response == nil {
-		return nil, &APIError{
+		return nil, nil, &APIError{
 			Message:    "received nil response from Keybase API",
 			StatusCode: 0,
 			Kind:       ErrorKindInvalidResponse,
@@ -443,77 +710,54 @@ func (c *Client) parseResponse(response *LookupResponse, requestedUsers []string
 		}
 	}
 	
-	if len(response.Them) == 0 {
-		// No users found - could be because they don't exist
-		if len(requestedUsers) == 1 {
-			return nil, &APIError{
-				Message:    fmt.Sprintf("user %q not found on Keybase", requestedUsers[0]),
-				StatusCode: 0,
-				Kind:       ErrorKindNotFound,
-				Temporary:  false,
-			}
-		}
-		return nil, &APIError{
-			Message:    fmt.Sprintf("none of the requested users were found: %s", strings.Join(requestedUsers, ", ")),
-			StatusCode: 0,
-			Kind:       ErrorKindNotFound,
-			Temporary:  false,
-		}
-	}
-	
-	var results []UserPublicKey
-	foundUsers := make(map[string]bool)
+	results := make(map[string]UserPublicKey, len(response.Them))
+	failures := make(map[string]error)
 	
 	for _, user := range response.Them {
 		if user.Basics.Username == "" {
 			continue
 		}
-		
-		foundUsers[user.Basics.Username] = true
+		username := strings.ToLower(user.Basics.Username)
 		
 		// Extract primary public key
 		if user.PublicKeys.Primary.Bundle == "" {
-			return nil, &APIError{
+			failures[username] = &APIError{
 				Message:    fmt.Sprintf("user %q exists but has no primary public key configured", user.Basics.Username),
 				StatusCode: 0,
 				Kind:       ErrorKindInvalidResponse,
 				Temporary:  false,
 			}
+			continue
 		}
 		
-		results = append(results, UserPublicKey{
+		results[username] = UserPublicKey{
 			Username:  user.Basics.Username,
 			PublicKey: user.PublicKeys.Primary.Bundle,
 			KeyID:     user.PublicKeys.Primary.KID,
-		})
-	}
-	
-	// Check if all requested users were found
-	var missingUsers []string
-	for _, username := range requestedUsers {
-		if !foundUsers[username] {
-			missingUsers = append(missingUsers, username)
+			EldestKID: user.PublicKeys.EldestKID,
+			Sibkeys:   user.PublicKeys.Sibkeys,
+			Subkeys:   user.PublicKeys.Subkeys,
 		}
 	}
 	
-	if len(missingUsers) > 0 {
-		if len(missingUsers) == 1 {
-			return nil, &APIError{
-				Message:    fmt.Sprintf("user %q not found on Keybase", missingUsers[0]),
-				StatusCode: 0,
-				Kind:       ErrorKindNotFound,
-				Temporary:  false,
-			}
+	// Users the API left out do not exist
+	for _, requested := range requestedUsers {
+		username := strings.ToLower(requested)
+		if _, ok := results[username]; ok {
+			continue
+		}
+		if _, ok := failures[username]; ok {
+			continue
 		}
-		return nil, &APIError{
-			Message:    fmt.Sprintf("users not found on Keybase: %s", strings.Join(missingUsers, ", ")),
+		failures[username] = &APIError{
+			Message:    fmt.Sprintf("user %q not found on Keybase", requested),
 			StatusCode: 0,
 			Kind:       ErrorKindNotFound,
 			Temporary:  false,
 		}
 	}
 	
-	return results, nil
+	return results, failures, nil
 }
 
 // ValidateUsername validates a Keybase username
@@ -539,6 +783,10 @@ type LookupResponse struct {
 	Them   []User   `json:"them"`
 }
 
+func (r *LookupResponse) apiStatus() Status {
+	return r.Status
+}
+
 // Status represents the API response status
 type Status struct {
 	Code int    `json:"code"`
@@ -547,8 +795,10 @@ type Status struct {
 
 // User represents a Keybase user
 type User struct {
-	Basics     Basics     `json:"basics"`
-	PublicKeys PublicKeys `json:"public_keys"`
+	Basics        Basics                 `json:"basics"`
+	PublicKeys    PublicKeys             `json:"public_keys"`
+	ProofsSummary ProofsSummary          `json:"proofs_summary"`
+	Devices       map[string]DeviceEntry `json:"devices"`
 }
 
 // Basics contains basic user information
@@ -557,8 +807,14 @@ type Basics struct {
 }
 
 // PublicKeys contains user's public keys
+//
+// Sibkeys and Subkeys only list keys that are currently active; revoked
+// device keys are dropped from them by the API.
 type PublicKeys struct {
-	Primary PrimaryKey `json:"primary"`
+	Primary   PrimaryKey `json:"primary"`
+	EldestKID string     `json:"eldest_kid"`
+	Sibkeys   []string   `json:"sibkeys"`
+	Subkeys   []string   `json:"subkeys"`
 }
 
 // PrimaryKey represents the primary public key
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -187,6 +188,126 @@ func TestLookupUsersSuccess(t *testing.T) {
 	}
 }
 
+func TestLookupUsersKeyFamilies(t *testing.T) {
+	const (
+		pgpKID    = "0101aaaa0a"
+		eldestKID = "0120" + "1111111111111111111111111111111111111111111111111111111111111111" + "0a"
+		phoneDH   = "0121" + "2222222222222222222222222222222222222222222222222222222222222222" + "0a"
+		laptopDH  = "0121" + "3333333333333333333333333333333333333333333333333333333333333333" + "0a"
+	)
+	
+	// A raw API body, so the JSON field names are exercised
+	body := `{
+		"status": {"code": 0, "name": "OK"},
+		"them": [{
+			"basics": {"username": "alice"},
+			"public_keys": {
+				"primary": {"kid": "` + pgpKID + `", "bundle": "-----BEGIN PGP PUBLIC KEY BLOCK-----"},
+				"eldest_kid": "` + eldestKID + `",
+				"sibkeys": ["` + eldestKID + `", "` + pgpKID + `"],
+				"subkeys": ["` + phoneDH + `", "` + laptopDH + `"]
+			}
+		}]
+	}`
+	
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	
+	client := NewClient(&ClientConfig{BaseURL: server.URL, MaxRetries: 0})
+	
+	keys, err := client.LookupUsers(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	
+	key := keys[0]
+	if key.EldestKID != eldestKID {
+		t.Errorf("EldestKID = %v, want %v", key.EldestKID, eldestKID)
+	}
+	if len(key.Sibkeys) != 2 {
+		t.Errorf("len(Sibkeys) = %d, want 2", len(key.Sibkeys))
+	}
+	if len(key.Subkeys) != 2 {
+		t.Errorf("len(Subkeys) = %d, want 2", len(key.Subkeys))
+	}
+	
+	kids := key.EncryptionKIDs()
+	if len(kids) != 2 || kids[0] != phoneDH || kids[1] != laptopDH {
+		t.Errorf("EncryptionKIDs() = %v, want [%s %s]", kids, phoneDH, laptopDH)
+	}
+}
+
+func TestEncryptionKIDs(t *testing.T) {
+	const (
+		signingKID = "0120aa0a"
+		dhKID      = "0121bb0a"
+	)
+	
+	tests := []struct {
+		name string
+		key  UserPublicKey
+		want []string
+	}{
+		{
+			name: "no NaCl keys",
+			key:  UserPublicKey{KeyID: "0101cc0a"},
+			want: nil,
+		},
+		{
+			name: "primary is a DH key",
+			key:  UserPublicKey{KeyID: dhKID},
+			want: []string{dhKID},
+		},
+		{
+			name: "signing keys are skipped",
+			key:  UserPublicKey{KeyID: signingKID, Sibkeys: []string{signingKID}, Subkeys: []string{dhKID}},
+			want: []string{dhKID},
+		},
+		{
+			name: "duplicates and case are normalised",
+			key:  UserPublicKey{KeyID: "0121BB0A", Subkeys: []string{dhKID}},
+			want: []string{dhKID},
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.key.EncryptionKIDs()
+			if len(got) != len(tt.want) {
+				t.Fatalf("EncryptionKIDs() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("EncryptionKIDs()[%d] = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSigningKIDs(t *testing.T) {
+	const (
+		eldestKID = "0120aa0a"
+		deviceKID = "0120cc0a"
+		dhKID     = "0121bb0a"
+	)
+	
+	key := UserPublicKey{
+		KeyID:     "0101dd0a",
+		EldestKID: eldestKID,
+		Sibkeys:   []string{"0120AA0A", deviceKID, "0101dd0a"},
+		Subkeys:   []string{dhKID},
+	}
+	
+	got := key.SigningKIDs()
+	if len(got) != 2 || got[0] != eldestKID || got[1] != deviceKID {
+		t.Errorf("SigningKIDs() = %v, want [%s %s]", got, eldestKID, deviceKID)
+	}
+}
+
 func TestLookupUsersNegativeMaxRetriesDoesNotSkipRequest(t *testing.T) {
 	// This test guards against the bug where MaxRetries < 0 skips the retry loop
 	// entirely, leaving (response, err) as (nil, nil) and causing a nil deref.
@@ -686,23 +807,33 @@ func TestMultipleUsersNotFound(t *testing.T) {
 	}
 
 	client := NewClient(config)
-	_, err := client.LookupUsers(context.Background(), []string{"alice", "bob", "charlie"})
+	keys, err := client.LookupUsers(context.Background(), []string{"alice", "bob", "charlie"})
 
 	if err == nil {
 		t.Fatal("Expected error for missing users")
 	}
 
-	apiErr, ok := err.(*APIError)
+	multiErr, ok := err.(*MultiLookupError)
 	if !ok {
-		t.Fatalf("Expected *APIError, got %T", err)
+		t.Fatalf("Expected *MultiLookupError, got %T", err)
+	}
+	if multiErr.Requested != 3 || !reflect.DeepEqual(multiErr.Usernames(), []string{"bob", "charlie"}) {
+		t.Errorf("Expected bob and charlie of 3 users to fail, got %v", multiErr)
+	}
+	for _, failure := range multiErr.Failures {
+		if failure.Kind != ErrorKindNotFound {
+			t.Errorf("Expected ErrorKindNotFound for %s, got %v", failure.Username, failure.Kind)
+		}
 	}
 
-	if apiErr.Kind != ErrorKindNotFound {
-		t.Errorf("Expected ErrorKindNotFound, got %v", apiErr.Kind)
+	// The users that were found are still returned
+	if len(keys) != 1 || keys[0].Username != "alice" {
+		t.Errorf("Expected alice's key alongside the error, got %+v", keys)
 	}
-	// Should mention multiple users
-	if !strings.Contains(apiErr.Message, "bob") || !strings.Contains(apiErr.Message, "charlie") {
-		t.Errorf("Error message should mention missing users: %s", apiErr.Message)
+
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindNotFound {
+		t.Errorf("errors.As should find the NotFound APIError, got %v", err)
 	}
 }
 
@@ -942,3 +1073,148 @@ func TestHTTPStatusErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupProofs(t *testing.T) {
+	body := `{
+		"status": {"code": 0, "name": "OK"},
+		"them": [{
+			"basics": {"username": "Alice"},
+			"proofs_summary": {"all": [
+				{"proof_type": "github", "nametag": "alice_gh", "state": 1},
+				{"proof_type": "generic_web_site", "nametag": "alice.dev", "state": 1, "service_url": "http://alice.dev"},
+				{"proof_type": "twitter", "nametag": "alice_tw", "state": 2}
+			]}
+		}]
+	}`
+	
+	var gotFields string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotFields = r.URL.Query().Get("fields")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	
+	client := NewClient(&ClientConfig{BaseURL: server.URL, MaxRetries: 0})
+	
+	proofs, err := client.LookupProofs(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatalf("LookupProofs() error = %v", err)
+	}
+	if gotFields != "basics,proofs_summary" {
+		t.Errorf("fields = %q, want %q", gotFields, "basics,proofs_summary")
+	}
+	
+	aliceProofs := proofs["alice"]
+	if len(aliceProofs) != 3 {
+		t.Fatalf("len(proofs[alice]) = %d, want 3", len(aliceProofs))
+	}
+	
+	wantAssertions := []string{"alice_gh@github", "alice.dev@http", "alice_tw@twitter"}
+	wantVerified := []bool{true, true, false}
+	for i, proof := range aliceProofs {
+		if proof.Assertion() != wantAssertions[i] {
+			t.Errorf("proofs[%d].Assertion() = %q, want %q", i, proof.Assertion(), wantAssertions[i])
+		}
+		if proof.IsVerified() != wantVerified[i] {
+			t.Errorf("proofs[%d].IsVerified() = %t, want %t", i, proof.IsVerified(), wantVerified[i])
+		}
+	}
+	
+	// A user missing from the response is a not-found error
+	_, err = client.LookupProofs(context.Background(), []string{"alice", "bob"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindNotFound {
+		t.Errorf("LookupProofs() with missing user error = %v, want ErrorKindNotFound", err)
+	}
+}
+
+func TestLookupDevices(t *testing.T) {
+	body := `{
+		"status": {"code": 0, "name": "OK"},
+		"them": [{
+			"basics": {"username": "Alice"},
+			"devices": {
+				"d2": {"type": "mobile", "name": "phone", "keys": [{"kid": "0120BBBB0a"}, {"kid": "0121bbbb0a"}]},
+				"d1": {"type": "desktop", "name": "laptop", "keys": [{"kid": "0120aaaa0a"}, {"kid": "0121aaaa0a"}]}
+			}
+		}]
+	}`
+	
+	var gotFields string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotFields = r.URL.Query().Get("fields")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	
+	client := NewClient(&ClientConfig{BaseURL: server.URL, MaxRetries: 0})
+	
+	devices, err := client.LookupDevices(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatalf("LookupDevices() error = %v", err)
+	}
+	if gotFields != "basics,devices" {
+		t.Errorf("fields = %q, want %q", gotFields, "basics,devices")
+	}
+	
+	aliceDevices := devices["alice"]
+	if len(aliceDevices) != 2 {
+		t.Fatalf("len(devices[alice]) = %d, want 2", len(aliceDevices))
+	}
+	if aliceDevices[0].Name != "laptop" || aliceDevices[1].Name != "phone" {
+		t.Errorf("devices = %+v, want laptop then phone", aliceDevices)
+	}
+	if !aliceDevices[1].HasKID("0120bbbb0a") || aliceDevices[1].HasKID("0121aaaa0a") {
+		t.Errorf("devices[1].KIDs = %v, want phone keys only", aliceDevices[1].KIDs)
+	}
+	
+	// A user missing from the response is a not-found error
+	_, err = client.LookupDevices(context.Background(), []string{"alice", "bob"})
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindNotFound {
+		t.Errorf("LookupDevices() with missing user error = %v, want ErrorKindNotFound", err)
+	}
+}
+
+func TestLookupKeyOwners(t *testing.T) {
+	body := `{
+		"status": {"code": 0, "name": "OK"},
+		"keys": [
+			{"kid": "0121AAAA0a", "username": "alice"},
+			{"kid": "0121cccc0a"}
+		]
+	}`
+	
+	var gotPath, gotKIDs string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotKIDs = r.URL.Query().Get("kids")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+	
+	client := NewClient(&ClientConfig{BaseURL: server.URL, MaxRetries: 0})
+	
+	owners, err := client.LookupKeyOwners(context.Background(), []string{"0121AAAA0A", "0121cccc0a"})
+	if err != nil {
+		t.Fatalf("LookupKeyOwners() error = %v", err)
+	}
+	if !strings.HasSuffix(gotPath, "/key/fetch.json") {
+		t.Errorf("path = %q, want key/fetch.json", gotPath)
+	}
+	if gotKIDs != "0121aaaa0a,0121cccc0a" {
+		t.Errorf("kids = %q, want %q", gotKIDs, "0121aaaa0a,0121cccc0a")
+	}
+	
+	// Owners are keyed by lowercase KID; keys without an owner are left out
+	if len(owners) != 1 || owners["0121aaaa0a"] != "alice" {
+		t.Errorf("LookupKeyOwners() = %v, want map[0121aaaa0a:alice]", owners)
+	}
+	
+	if _, err := client.LookupKeyOwners(context.Background(), []string{"not-a-kid"}); err == nil {
+		t.Error("LookupKeyOwners() should fail for an invalid KID")
+	}
+}
@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Device is one of a user's Keybase devices and the keys it holds
+type Device struct {
+	// ID is the Keybase device ID
+	ID string
+
+	// Name is the device name chosen by the user, e.g. "work laptop"
+	Name string
+
+	// Type is the device type: "desktop", "mobile", "backup" or "web"
+	Type string
+
+	// KIDs are the device's signing (0120) and encryption (0121) KIDs
+	KIDs []string
+}
+
+// HasKID reports whether kid is one of the device's keys
+func (d *Device) HasKID(kid string) bool {
+	for _, deviceKID := range d.KIDs {
+		if strings.EqualFold(deviceKID, kid) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeviceEntry is one device in a user lookup response
+type DeviceEntry struct {
+	Type string           `json:"type"`
+	Name string           `json:"name"`
+	Keys []DeviceKeyEntry `json:"keys"`
+}
+
+// DeviceKeyEntry is one key of a device in a user lookup response
+type DeviceKeyEntry struct {
+	KID string `json:"kid"`
+}
+
+// LookupDevices fetches the devices of multiple users
+//
+// The result maps each requested username to its devices, sorted by ID.
+// Devices are not cached; callers use them to describe keys, not to
+// choose recipients.
+func (c *Client) LookupDevices(ctx context.Context, usernames []string) (map[string][]Device, error) {
+	if len(usernames) == 0 {
+		return nil, fmt.Errorf("no usernames provided")
+	}
+
+	// Validate usernames
+	for _, username := range usernames {
+		if err := ValidateUsername(username); err != nil {
+			return nil, fmt.Errorf("invalid username %q: %w", username, err)
+		}
+	}
+
+	response, err := c.lookup(ctx, usernames, "basics,devices")
+	if err != nil {
+		return nil, err
+	}
+
+	devices := make(map[string][]Device, len(usernames))
+	for _, user := range response.Them {
+		if user.Basics.Username == "" {
+			continue
+		}
+
+		userDevices := make([]Device, 0, len(user.Devices))
+		for id, entry := range user.Devices {
+			device := Device{ID: id, Name: entry.Name, Type: entry.Type}
+			for _, key := range entry.Keys {
+				device.KIDs = append(device.KIDs, strings.ToLower(key.KID))
+			}
+			userDevices = append(userDevices, device)
+		}
+		sort.Slice(userDevices, func(i, j int) bool { return userDevices[i].ID < userDevices[j].ID })
+
+		devices[strings.ToLower(user.Basics.Username)] = userDevices
+	}
+
+	var missingUsers []string
+	results := make(map[string][]Device, len(usernames))
+	for _, username := range usernames {
+		userDevices, ok := devices[strings.ToLower(username)]
+		if !ok {
+			missingUsers = append(missingUsers, username)
+			continue
+		}
+		results[username] = userDevices
+	}
+
+	if len(missingUsers) > 0 {
+		return nil, &APIError{
+			Message:    fmt.Sprintf("users not found on Keybase: %s", strings.Join(missingUsers, ", ")),
+			StatusCode: 0,
+			Kind:       ErrorKindNotFound,
+			Temporary:  false,
+		}
+	}
+
+	return results, nil
+}
+
+// LookupKeyOwners maps Keybase KIDs to the usernames that own them
+//
+// KIDs the API does not know are left out of the result rather than
+// reported as an error, since a message may name a key of a deleted user.
+func (c *Client) LookupKeyOwners(ctx context.Context, kids []string) (map[string]string, error) {
+	if len(kids) == 0 {
+		return nil, fmt.Errorf("no KIDs provided")
+	}
+
+	for _, kid := range kids {
+		if err := ValidateKID(kid); err != nil {
+			return nil, fmt.Errorf("invalid KID %q: %w", kid, err)
+		}
+	}
+
+	params := url.Values{}
+	params.Set("kids", strings.ToLower(strings.Join(kids, ",")))
+
+	var response KeyFetchResponse
+	if err := c.get(ctx, "key/fetch.json", params, &response); err != nil {
+		return nil, err
+	}
+
+	owners := make(map[string]string, len(response.Keys))
+	for _, key := range response.Keys {
+		if key.KID == "" || key.Username == "" {
+			continue
+		}
+		owners[strings.ToLower(key.KID)] = key.Username
+	}
+
+	return owners, nil
+}
+
+// ValidateKID validates a hex-encoded Keybase KID
+func ValidateKID(kid string) error {
+	if len(kid) < 6 || len(kid)%2 != 0 {
+		return fmt.Errorf("KID must be an even number of hex digits")
+	}
+	for _, r := range kid {
+		if !((r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F')) {
+			return fmt.Errorf("KID contains invalid character: %c", r)
+		}
+	}
+	return nil
+}
+
+// KeyFetchResponse represents the API response for a key fetch
+type KeyFetchResponse struct {
+	Status Status            `json:"status"`
+	Keys   []FetchedKeyEntry `json:"keys"`
+}
+
+func (r *KeyFetchResponse) apiStatus() Status {
+	return r.Status
+}
+
+// FetchedKeyEntry is one key in a key fetch response
+type FetchedKeyEntry struct {
+	KID      string `json:"kid"`
+	Username string `json:"username"`
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// ProofStateOK is the proof state reported for a proof that currently verifies
+const ProofStateOK = 1
+
+// Proof is one identity proof from a user's proof summary
+type Proof struct {
+	// ProofType is the proof service, e.g. "github", "dns", "twitter" or "generic_web_site"
+	ProofType string `json:"proof_type"`
+
+	// Nametag is the identity on the remote service (a username or domain)
+	Nametag string `json:"nametag"`
+
+	// State is the proof state; ProofStateOK means the proof verifies
+	State int `json:"state"`
+
+	// ServiceURL is the profile URL on the remote service
+	ServiceURL string `json:"service_url"`
+
+	// ProofURL is the URL of the posted proof
+	ProofURL string `json:"proof_url"`
+}
+
+// IsVerified reports whether the proof currently verifies
+func (p Proof) IsVerified() bool {
+	return p.State == ProofStateOK
+}
+
+// Assertion returns the proof as a Keybase assertion, e.g. "alice@github"
+func (p Proof) Assertion() string {
+	service := p.ProofType
+	if service == "generic_web_site" {
+		service = "https"
+		if strings.HasPrefix(p.ServiceURL, "http://") {
+			service = "http"
+		}
+	}
+	return strings.ToLower(p.Nametag + "@" + service)
+}
+
+// ProofsSummary contains a user's identity proofs
+type ProofsSummary struct {
+	All []Proof `json:"all"`
+}
+
+// LookupProofs fetches the identity proof summaries of multiple users
+//
+// The result maps each requested username to its proofs, verified or not.
+// Proofs are never cached: they are checked for every lookup so that a
+// revoked proof takes effect immediately.
+func (c *Client) LookupProofs(ctx context.Context, usernames []string) (map[string][]Proof, error) {
+	if len(usernames) == 0 {
+		return nil, fmt.Errorf("no usernames provided")
+	}
+
+	// Validate usernames
+	for _, username := range usernames {
+		if err := ValidateUsername(username); err != nil {
+			return nil, fmt.Errorf("invalid username %q: %w", username, err)
+		}
+	}
+
+	response, err := c.lookup(ctx, usernames, "basics,proofs_summary")
+	if err != nil {
+		return nil, err
+	}
+
+	proofs := make(map[string][]Proof, len(usernames))
+	for _, user := range response.Them {
+		if user.Basics.Username == "" {
+			continue
+		}
+		proofs[strings.ToLower(user.Basics.Username)] = user.ProofsSummary.All
+	}
+
+	var missingUsers []string
+	results := make(map[string][]Proof, len(usernames))
+	for _, username := range usernames {
+		userProofs, ok := proofs[strings.ToLower(username)]
+		if !ok {
+			missingUsers = append(missingUsers, username)
+			continue
+		}
+		results[username] = userProofs
+	}
+
+	if len(missingUsers) > 0 {
+		return nil, &APIError{
+			Message:    fmt.Sprintf("users not found on Keybase: %s", strings.Join(missingUsers, ", ")),
+			StatusCode: 0,
+			Kind:       ErrorKindNotFound,
+			Temporary:  false,
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,113 @@
+package api
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRateLimit is the default number of requests per second the
+	// clients for one endpoint may make between them
+	DefaultRateLimit = 10.0
+
+	// DefaultRateBurst is the default number of requests that may be made
+	// at once before the rate limit applies
+	DefaultRateBurst = 10
+)
+
+// RateLimiter is a token bucket shared by the clients of an endpoint
+//
+// Tokens are added at a steady rate up to the burst size, and every HTTP
+// request, retries included, takes one. A request that finds the bucket
+// empty waits for its token rather than failing.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter allowing rate requests per second with
+// bursts of up to burst requests
+//
+// A rate that is not positive would make a request that finds the bucket
+// empty wait forever, so DefaultRateLimit is used instead.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if !(rate > 0) {
+		rate = DefaultRateLimit
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Rate returns the number of requests allowed per second
+func (l *RateLimiter) Rate() float64 {
+	return l.rate
+}
+
+// Burst returns the largest number of requests allowed at once
+func (l *RateLimiter) Burst() int {
+	return int(l.burst)
+}
+
+// Tokens returns the number of requests that could be made now without
+// waiting; it is negative while requests are queued for tokens
+func (l *RateLimiter) Tokens() float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	return l.tokens
+}
+
+// Wait blocks until a request may be made, or until ctx is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Take a token now, even if that leaves the bucket in debt, so that
+	// waiting requests are served in order
+	l.mu.Lock()
+	l.refill(time.Now())
+	l.tokens--
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Give the token back for the requests queued behind this one
+		l.mu.Lock()
+		l.tokens = min(l.tokens+1, l.burst)
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// refill adds the tokens earned since the last refill; l.mu must be held
+func (l *RateLimiter) refill(now time.Time) {
+	elapsed := now.Sub(l.last).Seconds()
+	if elapsed > 0 {
+		l.tokens = min(l.tokens+elapsed*l.rate, l.burst)
+		l.last = now
+	}
+}
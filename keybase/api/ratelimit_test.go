@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterBurstThenWait(t *testing.T) {
+	limiter := NewRateLimiter(20, 2)
+	ctx := context.Background()
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := limiter.Wait(ctx); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Millisecond {
+		t.Errorf("the burst took %v, want no wait", elapsed)
+	}
+
+	// The bucket is empty; the next token comes 50ms later
+	if err := limiter.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("the third request went out after %v, want about 50ms", elapsed)
+	}
+}
+
+func TestRateLimiterCancel(t *testing.T) {
+	limiter := NewRateLimiter(0.001, 1)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() on an empty bucket = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The cancelled request gave its token back
+	if tokens := limiter.Tokens(); tokens < -0.01 || tokens > 0.01 {
+		t.Errorf("Tokens() = %v, want 0", tokens)
+	}
+}
+
+func TestRateLimiterInvalidRate(t *testing.T) {
+	for _, rate := range []float64{0, -1, math.NaN()} {
+		limiter := NewRateLimiter(rate, 1)
+		if limiter.Rate() != DefaultRateLimit {
+			t.Errorf("NewRateLimiter(%v).Rate() = %v, want %v", rate, limiter.Rate(), DefaultRateLimit)
+		}
+
+		// The second request waits for a token rather than forever
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		for i := 0; i < 2; i++ {
+			if err := limiter.Wait(ctx); err != nil {
+				t.Errorf("NewRateLimiter(%v).Wait() error = %v", rate, err)
+			}
+		}
+		cancel()
+	}
+}
+
+func TestClientRateLimit(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":{"code":0,"name":"OK"},"them":[{"basics":{"username":"alice"},"public_keys":{"primary":{"kid":"kid","bundle":"bundle"}}}]}`))
+	}))
+	defer server.Close()
+
+	config := &ClientConfig{
+		BaseURL:    server.URL,
+		Timeout:    5 * time.Second,
+		MaxRetries: 0,
+		RateLimit:  20,
+		RateBurst:  1,
+	}
+
+	// Two clients share the endpoint's bucket, so their four requests take
+	// three intervals of 50ms
+	clients := []*Client{NewClient(config), NewClient(config)}
+	if clients[0].RateLimiter != clients[1].RateLimiter {
+		t.Fatal("clients with the same endpoint and limits should share a limiter")
+	}
+	other := *config
+	other.RateLimit, other.RateBurst = 1000, 100
+	if NewClient(&other).RateLimiter != clients[0].RateLimiter {
+		t.Fatal("clients of the same endpoint with other limits should share its limiter")
+	}
+
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if _, err := clients[i%2].LookupUsers(context.Background(), []string{"alice"}); err != nil {
+			t.Fatalf("LookupUsers() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("4 requests at 20/s took %v, want at least 150ms", elapsed)
+	}
+	if got := hits.Load(); got != 4 {
+		t.Errorf("server got %d requests, want 4", got)
+	}
+
+	config.RateLimit = -1
+	if NewClient(config).RateLimiter != nil {
+		t.Error("a negative RateLimit should disable rate limiting")
+	}
+}
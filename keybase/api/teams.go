@@ -0,0 +1,213 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// TeamRole is a member's role in a Keybase team
+type TeamRole string
+
+const (
+	// TeamRoleReader can read team content
+	TeamRoleReader TeamRole = "reader"
+	// TeamRoleWriter can read and write team content
+	TeamRoleWriter TeamRole = "writer"
+	// TeamRoleAdmin can manage team membership
+	TeamRoleAdmin TeamRole = "admin"
+	// TeamRoleOwner has full control of the team
+	TeamRoleOwner TeamRole = "owner"
+)
+
+// teamRoleRanks orders roles from least to most privileged
+var teamRoleRanks = map[TeamRole]int{
+	TeamRoleReader: 1,
+	TeamRoleWriter: 2,
+	TeamRoleAdmin:  3,
+	TeamRoleOwner:  4,
+}
+
+// ParseTeamRole parses a role name case-insensitively
+func ParseTeamRole(role string) (TeamRole, error) {
+	teamRole := TeamRole(strings.ToLower(strings.TrimSpace(role)))
+	if _, ok := teamRoleRanks[teamRole]; !ok {
+		return "", fmt.Errorf("unknown team role %q: must be reader, writer, admin or owner", role)
+	}
+	return teamRole, nil
+}
+
+// AtLeast reports whether the role is at least as privileged as min
+// An empty min matches every role
+func (r TeamRole) AtLeast(min TeamRole) bool {
+	if min == "" {
+		return true
+	}
+	return teamRoleRanks[r] >= teamRoleRanks[min]
+}
+
+// TeamMember is a user's membership in a team
+type TeamMember struct {
+	Username string
+	Role     TeamRole
+}
+
+// Team is a Keybase team (or subteam) and its current members
+type Team struct {
+	Name    string
+	Members []TeamMember
+
+	// Verified is set when the member list was checked against the team's
+	// sigchain or comes from a source the operator controls, rather than
+	// taken on a server's word
+	Verified bool
+}
+
+// Usernames returns the sorted usernames of members with at least minRole
+// An empty minRole returns every member
+func (t *Team) Usernames(minRole TeamRole) []string {
+	var usernames []string
+	for _, member := range t.Members {
+		if member.Role.AtLeast(minRole) {
+			usernames = append(usernames, member.Username)
+		}
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// ErrUnverifiedTeam is the underlying error of team lookups refused because
+// the client does not trust the server's member lists
+var ErrUnverifiedTeam = errors.New("team member lists from the HTTP API are not verified")
+
+// LookupTeam fetches the current member list of a team or subteam with
+// GET team/get.json?name=<team>
+//
+// name is the full team name, with subteams separated by dots
+// (e.g. "acme.ops"). Only the members of that exact team are returned;
+// implicit admins of parent teams are not included.
+//
+// team/get.json is not part of the documented keybase.io API
+// (https://keybase.io/docs/api/1.0); the response this client expects is
+// TeamResponse, which is the contract for mirrors that serve teams. The
+// member list is taken on trust: nothing here checks it against the team's
+// sigchain, so a compromised server or mirror could add a member and have
+// secrets encrypted to them. LookupTeam therefore fails with an
+// ErrorKindInvalidInput error wrapping ErrUnverifiedTeam unless the client
+// has TrustServerTeams set. cli.Client.LookupTeam gets members from the
+// local Keybase service, which verifies the sigchain, and a
+// cache.DirectoryResolver takes them from a file the operator controls.
+func (c *Client) LookupTeam(ctx context.Context, name string) (*Team, error) {
+	if err := ValidateTeamName(name); err != nil {
+		return nil, fmt.Errorf("invalid team name %q: %w", name, err)
+	}
+	if !c.TrustServerTeams {
+		return nil, &APIError{
+			Message:    fmt.Sprintf("refusing to look up members of team %q with %s: %v (use engine=cli, list the team in a key directory, or set trust_server_teams)", name, c.BaseURL, ErrUnverifiedTeam),
+			StatusCode: 0,
+			Kind:       ErrorKindInvalidInput,
+			Temporary:  false,
+			Underlying: ErrUnverifiedTeam,
+		}
+	}
+
+	params := url.Values{}
+	params.Set("name", strings.ToLower(name))
+
+	var response TeamResponse
+	if err := c.get(ctx, "team/get.json", params, &response); err != nil {
+		return nil, err
+	}
+
+	team := &Team{Name: response.Name}
+	if team.Name == "" {
+		team.Name = strings.ToLower(name)
+	}
+
+	roles := []struct {
+		role    TeamRole
+		members []TeamMemberEntry
+	}{
+		{TeamRoleOwner, response.Members.Owners},
+		{TeamRoleAdmin, response.Members.Admins},
+		{TeamRoleWriter, response.Members.Writers},
+		{TeamRoleReader, response.Members.Readers},
+	}
+
+	for _, group := range roles {
+		for _, member := range group.members {
+			if member.Username == "" {
+				continue
+			}
+			if err := ValidateUsername(member.Username); err != nil {
+				return nil, &APIError{
+					Message:    fmt.Sprintf("team %q lists invalid member %q: %v", name, member.Username, err),
+					StatusCode: 0,
+					Kind:       ErrorKindInvalidResponse,
+					Temporary:  false,
+				}
+			}
+			team.Members = append(team.Members, TeamMember{Username: member.Username, Role: group.role})
+		}
+	}
+
+	return team, nil
+}
+
+// ValidateTeamName validates a Keybase team name such as "acme" or "acme.ops"
+func ValidateTeamName(name string) error {
+	if name == "" {
+		return fmt.Errorf("team name cannot be empty")
+	}
+
+	// Each dot-separated part follows the username character rules
+	for _, part := range strings.Split(name, ".") {
+		if part == "" {
+			return fmt.Errorf("team name contains an empty part")
+		}
+		for _, r := range part {
+			if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') ||
+				(r >= '0' && r <= '9') || r == '_') {
+				return fmt.Errorf("team name contains invalid character: %c", r)
+			}
+		}
+	}
+
+	return nil
+}
+
+// TeamResponse represents the API response for a team lookup
+//
+// A mirror answers team/get.json?name=acme.ops with the usual status object
+// and the members grouped by role:
+//
+//	{"status": {"code": 0, "name": "OK"}, "name": "acme.ops",
+//	 "members": {"owners": [{"username": "alice"}], "admins": [],
+//	             "writers": [{"username": "bob"}], "readers": []}}
+//
+// An unknown team is reported with a non-zero status code, as for users.
+type TeamResponse struct {
+	Status  Status      `json:"status"`
+	Name    string      `json:"name"`
+	Members TeamMembers `json:"members"`
+}
+
+func (r *TeamResponse) apiStatus() Status {
+	return r.Status
+}
+
+// TeamMembers lists a team's members grouped by role
+type TeamMembers struct {
+	Owners  []TeamMemberEntry `json:"owners"`
+	Admins  []TeamMemberEntry `json:"admins"`
+	Writers []TeamMemberEntry `json:"writers"`
+	Readers []TeamMemberEntry `json:"readers"`
+}
+
+// TeamMemberEntry is one member in a team response
+type TeamMemberEntry struct {
+	Username string `json:"username"`
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLookupTeam(t *testing.T) {
+	body := `{
+		"status": {"code": 0, "name": "OK"},
+		"name": "acme.ops",
+		"members": {
+			"owners": [{"username": "alice"}],
+			"admins": [{"username": "bob"}],
+			"writers": [{"username": "charlie"}, {"username": "dave"}],
+			"readers": [{"username": "eve"}]
+		}
+	}`
+
+	var gotPath, gotName string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotName = r.URL.Query().Get("name")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client := NewClient(&ClientConfig{BaseURL: server.URL, MaxRetries: 0, TrustServerTeams: true})
+
+	team, err := client.LookupTeam(context.Background(), "ACME.ops")
+	if err != nil {
+		t.Fatalf("LookupTeam() error = %v", err)
+	}
+	if gotPath != "/team/get.json" || gotName != "acme.ops" {
+		t.Errorf("request = %s?name=%s, want /team/get.json?name=acme.ops", gotPath, gotName)
+	}
+	if team.Name != "acme.ops" {
+		t.Errorf("Name = %q, want %q", team.Name, "acme.ops")
+	}
+
+	tests := []struct {
+		minRole TeamRole
+		want    string
+	}{
+		{"", "alice,bob,charlie,dave,eve"},
+		{TeamRoleReader, "alice,bob,charlie,dave,eve"},
+		{TeamRoleWriter, "alice,bob,charlie,dave"},
+		{TeamRoleAdmin, "alice,bob"},
+		{TeamRoleOwner, "alice"},
+	}
+
+	for _, tt := range tests {
+		t.Run("min role "+string(tt.minRole), func(t *testing.T) {
+			if got := strings.Join(team.Usernames(tt.minRole), ","); got != tt.want {
+				t.Errorf("Usernames(%q) = %s, want %s", tt.minRole, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLookupTeamErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": {"code": 2614, "name": "TEAM_NOT_FOUND"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(&ClientConfig{BaseURL: server.URL, MaxRetries: 0, TrustServerTeams: true})
+
+	_, err := client.LookupTeam(context.Background(), "acme.missing")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindNotFound {
+		t.Errorf("LookupTeam() error = %v, want ErrorKindNotFound", err)
+	}
+
+	if _, err := client.LookupTeam(context.Background(), "acme..ops"); err == nil {
+		t.Error("LookupTeam() with an invalid name should fail")
+	}
+}
+
+func TestLookupTeamUntrusted(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": {"code": 0, "name": "OK"}, "name": "acme", "members": {"owners": [{"username": "mallory"}]}}`))
+	}))
+	defer server.Close()
+
+	// Member lists from the server are not verified, so by default they
+	// are not asked for
+	client := NewClient(&ClientConfig{BaseURL: server.URL, MaxRetries: 0})
+	_, err := client.LookupTeam(context.Background(), "acme")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindInvalidInput || !errors.Is(err, ErrUnverifiedTeam) {
+		t.Errorf("LookupTeam() error = %v, want an InvalidInputError wrapping ErrUnverifiedTeam", err)
+	}
+	if requests != 0 {
+		t.Errorf("server got %d requests, want none", requests)
+	}
+}
+
+func TestValidateTeamName(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr bool
+	}{
+		{"acme", false},
+		{"acme.ops", false},
+		{"acme.ops.oncall_team", false},
+		{"", true},
+		{".acme", true},
+		{"acme.", true},
+		{"acme-ops", true},
+		{"acme/ops", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateTeamName(tt.name); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateTeamName(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTeamRole(t *testing.T) {
+	if role, err := ParseTeamRole(" Writer "); err != nil || role != TeamRoleWriter {
+		t.Errorf("ParseTeamRole(Writer) = %q, %v, want %q", role, err, TeamRoleWriter)
+	}
+	if _, err := ParseTeamRole("bot"); err == nil {
+		t.Error("ParseTeamRole(bot) should fail")
+	}
+}
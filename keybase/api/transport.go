@@ -0,0 +1,110 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+// HasNetworkSettings reports whether config sets a proxy, CA file or client
+// certificate, which NewTransport turns into a custom transport
+func (c *ClientConfig) HasNetworkSettings() bool {
+	return c.ProxyURL != "" || c.CAFile != "" || c.ClientCertFile != "" || c.ClientKeyFile != ""
+}
+
+// NewTransport returns the HTTP transport for config
+//
+// An injected config.Transport is returned as is. Otherwise, when config
+// sets a proxy, CA file or client certificate, the result is a clone of
+// http.DefaultTransport with them applied: requests go through ProxyURL
+// instead of the proxy from HTTPS_PROXY and friends, the certificates in
+// CAFile are trusted in addition to the system roots (for a TLS-intercepting
+// proxy, say), and ClientCertFile and ClientKeyFile are presented for mutual
+// TLS. With none of these set, NewTransport returns nil, which http.Client
+// takes to mean http.DefaultTransport.
+func NewTransport(config *ClientConfig) (http.RoundTripper, error) {
+	if config == nil {
+		return nil, nil
+	}
+	if config.Transport != nil {
+		if config.HasNetworkSettings() {
+			return nil, errors.New("a custom Transport cannot be combined with ProxyURL, CAFile or client certificates")
+		}
+		return config.Transport, nil
+	}
+	if !config.HasNetworkSettings() {
+		return nil, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.ProxyURL != "" {
+		proxy, err := ParseProxyURL(config.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if config.CAFile != "" {
+		pool, err := LoadCertPool(config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		if config.ClientCertFile == "" || config.ClientKeyFile == "" {
+			return nil, errors.New("a client certificate needs both a certificate file and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// ParseProxyURL parses an http, https or socks5 proxy URL
+func ParseProxyURL(rawURL string) (*url.URL, error) {
+	proxy, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxy.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy URL %q: scheme must be http, https or socks5", rawURL)
+	}
+	if proxy.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL %q: missing host", rawURL)
+	}
+	return proxy, nil
+}
+
+// LoadCertPool returns the system certificate pool with the PEM
+// certificates in path added
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA file: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no PEM certificates found in CA file %s", path)
+	}
+	return pool, nil
+}
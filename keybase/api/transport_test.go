@@ -0,0 +1,216 @@
+package api
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const aliceLookupJSON = `{"status":{"code":0,"name":"OK"},"them":[{"basics":{"username":"alice"},"public_keys":{"primary":{"kid":"kid","bundle":"bundle"}}}]}`
+
+func serveAlice(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(aliceLookupJSON))
+}
+
+// writePEM writes PEM blocks of the given type to a file in dir
+func writePEM(t *testing.T, dir, name, blockType string, blocks ...[]byte) string {
+	t.Helper()
+	var data []byte
+	for _, block := range blocks {
+		data = append(data, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: block})...)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	return path
+}
+
+// newClientCert creates a self-signed client certificate and writes it and
+// its key to dir
+func newClientCert(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "build-agent"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	return cert, writePEM(t, dir, "client.pem", "CERTIFICATE", der), writePEM(t, dir, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+func lookupAlice(client *Client) error {
+	_, err := client.LookupUsers(context.Background(), []string{"alice"})
+	return err
+}
+
+func TestTransportCAFileAndClientCert(t *testing.T) {
+	dir := t.TempDir()
+	clientCert, certFile, keyFile := newClientCert(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(serveAlice))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(clientCert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	caFile := writePEM(t, dir, "ca.pem", "CERTIFICATE", server.Certificate().Raw)
+	config := &ClientConfig{
+		BaseURL:          server.URL,
+		Timeout:          5 * time.Second,
+		MaxRetries:       0,
+		RateLimit:        -1,
+		BreakerThreshold: -1,
+	}
+
+	// The test server's certificate is not in the system roots
+	if err := lookupAlice(NewClient(config)); err == nil {
+		t.Error("LookupUsers() without the CA file should fail")
+	}
+
+	// Trusting the server is not enough; it wants a client certificate
+	config.CAFile = caFile
+	if err := lookupAlice(NewClient(config)); err == nil {
+		t.Error("LookupUsers() without a client certificate should fail")
+	}
+
+	config.ClientCertFile = certFile
+	config.ClientKeyFile = keyFile
+	if err := lookupAlice(NewClient(config)); err != nil {
+		t.Errorf("LookupUsers() with the CA file and client certificate error = %v", err)
+	}
+}
+
+func TestTransportProxy(t *testing.T) {
+	var proxied string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy sees the absolute URL of the request
+		proxied = r.URL.String()
+		serveAlice(w, r)
+	}))
+	defer proxy.Close()
+
+	client := NewClient(&ClientConfig{
+		BaseURL:    "http://keybase.invalid/_/api/1.0",
+		Timeout:    5 * time.Second,
+		MaxRetries: 0,
+		ProxyURL:   proxy.URL,
+	})
+	if err := lookupAlice(client); err != nil {
+		t.Fatalf("LookupUsers() through the proxy error = %v", err)
+	}
+	if !strings.HasPrefix(proxied, "http://keybase.invalid/_/api/1.0/user/lookup.json") {
+		t.Errorf("proxy got %q, want the lookup URL", proxied)
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper backed by a function
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTransportInjected(t *testing.T) {
+	var calls int
+	transport := roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+		calls++
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(aliceLookupJSON)),
+			Request:    r,
+		}, nil
+	})
+
+	client := NewClient(&ClientConfig{
+		BaseURL:    "https://keybase.invalid/_/api/1.0",
+		Timeout:    5 * time.Second,
+		MaxRetries: 0,
+		Transport:  transport,
+	})
+	if err := lookupAlice(client); err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("transport was called %d times, want 1", calls)
+	}
+}
+
+func TestTransportInvalidSettings(t *testing.T) {
+	dir := t.TempDir()
+	_, certFile, _ := newClientCert(t, dir)
+	notPEM := filepath.Join(dir, "ca.txt")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		config ClientConfig
+	}{
+		{"proxy scheme", ClientConfig{ProxyURL: "ftp://proxy.example.com"}},
+		{"proxy host", ClientConfig{ProxyURL: "http://"}},
+		{"missing CA file", ClientConfig{CAFile: filepath.Join(dir, "missing.pem")}},
+		{"CA file without certificates", ClientConfig{CAFile: notPEM}},
+		{"certificate without key", ClientConfig{ClientCertFile: certFile}},
+		{"transport and CA file", ClientConfig{CAFile: notPEM, Transport: http.DefaultTransport}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewTransport(&tt.config); err == nil {
+				t.Error("NewTransport() should fail")
+			}
+
+			// NewClient cannot fail, so its calls do, without a request
+			err := lookupAlice(NewClient(&tt.config))
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) || apiErr.Kind != ErrorKindInvalidInput || apiErr.IsTemporary() {
+				t.Errorf("LookupUsers() error = %v, want a permanent InvalidInputError", err)
+			}
+		})
+	}
+
+	// Without network settings the default transport is used
+	if transport, err := NewTransport(DefaultClientConfig()); err != nil || transport != nil {
+		t.Errorf("NewTransport() with defaults = %v, %v, want nil, nil", transport, err)
+	}
+}
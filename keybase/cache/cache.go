@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 )
@@ -14,6 +16,9 @@ type CacheEntry struct {
 	Username   string    `json:"username"`
 	PublicKey  string    `json:"public_key"`
 	KeyID      string    `json:"key_id"`
+	EldestKID  string    `json:"eldest_kid,omitempty"`
+	Sibkeys    []string  `json:"sibkeys,omitempty"`
+	Subkeys    []string  `json:"subkeys,omitempty"`
 	FetchedAt  time.Time `json:"fetched_at"`
 	ExpiresAt  time.Time `json:"expires_at"`
 }
@@ -23,10 +28,33 @@ func (e *CacheEntry) IsExpired() bool {
 	return time.Now().After(e.ExpiresAt)
 }
 
+// TeamMember is a cached team member and their role
+type TeamMember struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+// TeamEntry represents a cached team member list with expiration
+// Verified records whether the list came from a verified source (see
+// api.Team); entries written without it are unverified
+type TeamEntry struct {
+	Name      string       `json:"name"`
+	Members   []TeamMember `json:"members"`
+	Verified  bool         `json:"verified,omitempty"`
+	FetchedAt time.Time    `json:"fetched_at"`
+	ExpiresAt time.Time    `json:"expires_at"`
+}
+
+// IsExpired checks if the team entry has expired
+func (e *TeamEntry) IsExpired() bool {
+	return time.Now().After(e.ExpiresAt)
+}
+
 // Cache represents the public key cache
 type Cache struct {
 	FilePath string                 `json:"-"`
 	Entries  map[string]*CacheEntry `json:"entries"`
+	Teams    map[string]*TeamEntry  `json:"teams,omitempty"`
 	TTL      time.Duration          `json:"-"`
 	mu       sync.RWMutex
 }
@@ -65,6 +93,7 @@ func NewCache(config *CacheConfig) (*Cache, error) {
 	cache := &Cache{
 		FilePath: config.FilePath,
 		Entries:  make(map[string]*CacheEntry),
+		Teams:    make(map[string]*TeamEntry),
 		TTL:      config.TTL,
 	}
 	
@@ -117,6 +146,90 @@ func (c *Cache) Set(username, publicKey, keyID string) error {
 	return c.save()
 }
 
+// SetEntry stores a full key family entry in the cache
+// FetchedAt and ExpiresAt are set from the cache TTL
+func (c *Cache) SetEntry(entry CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	now := time.Now()
+	entry.FetchedAt = now
+	entry.ExpiresAt = now.Add(c.TTL)
+	c.Entries[entry.Username] = &entry
+	
+	return c.save()
+}
+
+// FindKIDOwner returns the username of the unexpired entry holding kid as
+// its primary key, eldest key, sibkey or subkey
+// Returns "" if no cached key family contains the KID
+func (c *Cache) FindKIDOwner(kid string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	
+	for _, entry := range c.Entries {
+		if entry.IsExpired() {
+			continue
+		}
+		
+		// slices.Concat copies: appending to entry.Sibkeys could write into
+		// its spare capacity while other readers hold the lock
+		for _, entryKID := range slices.Concat([]string{entry.KeyID, entry.EldestKID}, entry.Sibkeys, entry.Subkeys) {
+			if entryKID != "" && strings.EqualFold(entryKID, kid) {
+				return entry.Username
+			}
+		}
+	}
+	
+	return ""
+}
+
+// GetTeam retrieves a team member list from the cache
+// Returns nil if the team is not found or has expired
+func (c *Cache) GetTeam(name string) *TeamEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	
+	entry, exists := c.Teams[name]
+	if !exists || entry.IsExpired() {
+		return nil
+	}
+	
+	return entry
+}
+
+// SetTeam stores a team member list in the cache, and whether it was verified
+// Team entries share the cache TTL, so membership changes are picked up
+// at the same rate as key changes
+func (c *Cache) SetTeam(name string, members []TeamMember, verified bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	if c.Teams == nil {
+		c.Teams = make(map[string]*TeamEntry)
+	}
+	
+	now := time.Now()
+	c.Teams[name] = &TeamEntry{
+		Name:      name,
+		Members:   members,
+		Verified:  verified,
+		FetchedAt: now,
+		ExpiresAt: now.Add(c.TTL),
+	}
+	
+	return c.save()
+}
+
+// DeleteTeam removes a cached team member list
+func (c *Cache) DeleteTeam(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	
+	delete(c.Teams, name)
+	return c.save()
+}
+
 // Delete removes a cache entry for the given username
 func (c *Cache) Delete(username string) error {
 	c.mu.Lock()
@@ -132,6 +245,7 @@ func (c *Cache) Clear() error {
 	defer c.mu.Unlock()
 	
 	c.Entries = make(map[string]*CacheEntry)
+	c.Teams = make(map[string]*TeamEntry)
 	return c.save()
 }
 
@@ -148,6 +262,13 @@ func (c *Cache) PruneExpired() error {
 		}
 	}
 	
+	for name, entry := range c.Teams {
+		if entry.IsExpired() {
+			delete(c.Teams, name)
+			modified = true
+		}
+	}
+	
 	if modified {
 		return c.save()
 	}
@@ -170,6 +291,7 @@ func (c *Cache) Load() error {
 	
 	var diskCache struct {
 		Entries map[string]*CacheEntry `json:"entries"`
+		Teams   map[string]*TeamEntry  `json:"teams"`
 	}
 	
 	if err := json.Unmarshal(data, &diskCache); err != nil {
@@ -181,6 +303,11 @@ func (c *Cache) Load() error {
 		c.Entries = make(map[string]*CacheEntry)
 	}
 	
+	c.Teams = diskCache.Teams
+	if c.Teams == nil {
+		c.Teams = make(map[string]*TeamEntry)
+	}
+	
 	return nil
 }
 
@@ -188,8 +315,10 @@ func (c *Cache) Load() error {
 func (c *Cache) save() error {
 	diskCache := struct {
 		Entries map[string]*CacheEntry `json:"entries"`
+		Teams   map[string]*TeamEntry  `json:"teams,omitempty"`
 	}{
 		Entries: c.Entries,
+		Teams:   c.Teams,
 	}
 	
 	data, err := json.MarshalIndent(diskCache, "", "  ")
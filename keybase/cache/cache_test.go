@@ -553,3 +553,43 @@ func TestPruneExpiredNoModification(t *testing.T) {
 		t.Errorf("ValidEntries = %v, want 1", stats.ValidEntries)
 	}
 }
+
+func TestCacheFindKIDOwner(t *testing.T) {
+	tmpDir := t.TempDir()
+	config := &CacheConfig{
+		FilePath: filepath.Join(tmpDir, "test_cache.json"),
+		TTL:      1 * time.Hour,
+	}
+	
+	cache, err := NewCache(config)
+	if err != nil {
+		t.Fatalf("NewCache() error = %v", err)
+	}
+	
+	// Sibkeys has spare capacity that a lookup must not write into
+	sibkeys := make([]string, 1, 4)
+	sibkeys[0] = "sibkey_kid"
+	err = cache.SetEntry(CacheEntry{
+		Username:  "alice",
+		KeyID:     "primary_kid",
+		EldestKID: "eldest_kid",
+		Sibkeys:   sibkeys,
+		Subkeys:   []string{"subkey_kid"},
+	})
+	if err != nil {
+		t.Fatalf("SetEntry() error = %v", err)
+	}
+	
+	for _, kid := range []string{"primary_kid", "ELDEST_KID", "sibkey_kid", "subkey_kid"} {
+		if owner := cache.FindKIDOwner(kid); owner != "alice" {
+			t.Errorf("FindKIDOwner(%q) = %q, want alice", kid, owner)
+		}
+	}
+	if owner := cache.FindKIDOwner("unknown_kid"); owner != "" {
+		t.Errorf("FindKIDOwner(unknown_kid) = %q, want \"\"", owner)
+	}
+	
+	if spare := sibkeys[:2][1]; spare != "" {
+		t.Errorf("FindKIDOwner() wrote %q into the cached sibkeys", spare)
+	}
+}
@@ -0,0 +1,246 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"gopkg.in/yaml.v3"
+)
+
+// DirectoryResolver serves public keys from a static key directory file,
+// for air-gapped environments and internally managed keys
+//
+// The file is YAML or JSON (any JSON document is also valid YAML). Users
+// are keyed by username and teams by full team name:
+//
+//	users:
+//	  alice:
+//	    kid: 0121...0a          # primary key KID
+//	    subkeys: [0121...0a]    # per-device NaCl encryption KIDs
+//	    sibkeys: [0120...0a]    # Ed25519 signing KIDs
+//	    eldest_kid: 0120...0a
+//	    public_key: |           # optional PGP key bundle, for format=pgp
+//	      -----BEGIN PGP PUBLIC KEY BLOCK-----
+//	      ...
+//	teams:
+//	  acme.ops:
+//	    - username: alice
+//	      role: admin
+//
+// Every user needs a kid or a public_key, and team members without a role
+// are readers. Unknown fields are rejected, so that a misspelt field is not
+// silently ignored.
+type DirectoryResolver struct {
+	users  map[string]api.UserPublicKey
+	owners map[string]string
+	teams  map[string]*api.Team
+}
+
+// directoryFile is the on-disk form of a key directory
+type directoryFile struct {
+	Users map[string]directoryUser         `yaml:"users"`
+	Teams map[string][]directoryTeamMember `yaml:"teams"`
+}
+
+type directoryUser struct {
+	KID       string   `yaml:"kid"`
+	PublicKey string   `yaml:"public_key"`
+	EldestKID string   `yaml:"eldest_kid"`
+	Sibkeys   []string `yaml:"sibkeys"`
+	Subkeys   []string `yaml:"subkeys"`
+}
+
+type directoryTeamMember struct {
+	Username string `yaml:"username"`
+	Role     string `yaml:"role"`
+}
+
+// NewDirectoryResolver loads a key directory file
+func NewDirectoryResolver(path string) (*DirectoryResolver, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	resolver, err := ParseDirectory(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key directory %s: %w", path, err)
+	}
+	return resolver, nil
+}
+
+// ParseDirectory parses the contents of a key directory file
+func ParseDirectory(data []byte) (*DirectoryResolver, error) {
+	var file directoryFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse key directory: %w", err)
+	}
+
+	resolver := &DirectoryResolver{
+		users:  make(map[string]api.UserPublicKey, len(file.Users)),
+		owners: make(map[string]string),
+		teams:  make(map[string]*api.Team, len(file.Teams)),
+	}
+
+	for name, user := range file.Users {
+		username := strings.ToLower(name)
+		if err := api.ValidateUsername(username); err != nil {
+			return nil, fmt.Errorf("invalid username %q: %w", name, err)
+		}
+		if _, ok := resolver.users[username]; ok {
+			return nil, fmt.Errorf("user %q is listed twice", name)
+		}
+		if user.KID == "" && user.PublicKey == "" {
+			return nil, fmt.Errorf("user %q has neither a kid nor a public_key", name)
+		}
+
+		key := api.UserPublicKey{
+			Username:  username,
+			PublicKey: user.PublicKey,
+			KeyID:     strings.ToLower(user.KID),
+			EldestKID: strings.ToLower(user.EldestKID),
+			Sibkeys:   lowerAll(user.Sibkeys),
+			Subkeys:   lowerAll(user.Subkeys),
+		}
+
+		kids := append([]string{key.KeyID, key.EldestKID}, key.Sibkeys...)
+		kids = append(kids, key.Subkeys...)
+		for _, kid := range kids {
+			if kid == "" {
+				continue
+			}
+			if err := api.ValidateKID(kid); err != nil {
+				return nil, fmt.Errorf("user %q: invalid KID %q: %w", name, kid, err)
+			}
+			if owner, ok := resolver.owners[kid]; ok && owner != username {
+				return nil, fmt.Errorf("KID %s is listed for both %q and %q", kid, owner, username)
+			}
+			resolver.owners[kid] = username
+		}
+
+		resolver.users[username] = key
+	}
+
+	for name, members := range file.Teams {
+		teamName := strings.ToLower(name)
+		if err := api.ValidateTeamName(teamName); err != nil {
+			return nil, fmt.Errorf("invalid team name %q: %w", name, err)
+		}
+
+		team := &api.Team{Name: teamName, Verified: true}
+		for _, member := range members {
+			username := strings.ToLower(member.Username)
+			if err := api.ValidateUsername(username); err != nil {
+				return nil, fmt.Errorf("team %q: invalid member %q: %w", name, member.Username, err)
+			}
+			role := api.TeamRoleReader
+			if member.Role != "" {
+				var err error
+				role, err = api.ParseTeamRole(member.Role)
+				if err != nil {
+					return nil, fmt.Errorf("team %q: member %q: %w", name, member.Username, err)
+				}
+			}
+			team.Members = append(team.Members, api.TeamMember{Username: username, Role: role})
+		}
+		resolver.teams[teamName] = team
+	}
+
+	return resolver, nil
+}
+
+// LookupUsers implements KeyResolver
+// Users missing from the directory fail with api.ErrorKindNotFound; when
+// others were found, their keys come with an *api.MultiLookupError
+func (d *DirectoryResolver) LookupUsers(ctx context.Context, usernames []string) ([]api.UserPublicKey, error) {
+	if len(usernames) == 0 {
+		return nil, fmt.Errorf("no usernames provided")
+	}
+
+	results := make([]api.UserPublicKey, 0, len(usernames))
+	multiErr := &api.MultiLookupError{Requested: len(usernames)}
+	for _, username := range usernames {
+		key, ok := d.users[strings.ToLower(username)]
+		if !ok {
+			multiErr.Failures = append(multiErr.Failures, api.UserLookupFailure{
+				Username: username,
+				Kind:     api.ErrorKindNotFound,
+				Err: &api.APIError{
+					Message:   fmt.Sprintf("user %q not found in key directory", username),
+					Kind:      api.ErrorKindNotFound,
+					Temporary: false,
+				},
+			})
+			continue
+		}
+		results = append(results, key)
+	}
+
+	switch {
+	case len(multiErr.Failures) == 0:
+		return results, nil
+	case len(usernames) == 1:
+		return nil, multiErr.Failures[0].Err
+	default:
+		return results, multiErr
+	}
+}
+
+// LookupKeyOwners implements KeyOwnerResolver
+func (d *DirectoryResolver) LookupKeyOwners(ctx context.Context, kids []string) (map[string]string, error) {
+	owners := make(map[string]string, len(kids))
+	for _, kid := range kids {
+		kid = strings.ToLower(kid)
+		if username, ok := d.owners[kid]; ok {
+			owners[kid] = username
+		}
+	}
+	return owners, nil
+}
+
+// LookupTeam implements TeamResolver
+// Teams missing from the directory fail with api.ErrorKindNotFound
+func (d *DirectoryResolver) LookupTeam(ctx context.Context, name string) (*api.Team, error) {
+	team, ok := d.teams[strings.ToLower(name)]
+	if !ok {
+		return nil, &api.APIError{
+			Message:   fmt.Sprintf("team %q not found in key directory", name),
+			Kind:      api.ErrorKindNotFound,
+			Temporary: false,
+		}
+	}
+
+	copied := &api.Team{Name: team.Name, Members: append([]api.TeamMember(nil), team.Members...), Verified: team.Verified}
+	return copied, nil
+}
+
+// Usernames returns the users listed in the directory, sorted
+func (d *DirectoryResolver) Usernames() []string {
+	usernames := make([]string, 0, len(d.users))
+	for username := range d.users {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	return usernames
+}
+
+// lowerAll returns values converted to lower case
+func lowerAll(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	lowered := make([]string, len(values))
+	for i, value := range values {
+		lowered[i] = strings.ToLower(value)
+	}
+	return lowered
+}
@@ -0,0 +1,142 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+)
+
+const testDirectoryYAML = `
+users:
+  Alice:
+    kid: 0121AA0a
+    subkeys: [0121aa0a, 0121ab0a]
+    sibkeys: [0120ac0a]
+    eldest_kid: 0120ac0a
+  bob:
+    public_key: |
+      -----BEGIN PGP PUBLIC KEY BLOCK-----
+      test
+      -----END PGP PUBLIC KEY BLOCK-----
+teams:
+  acme.ops:
+    - username: alice
+      role: admin
+    - username: bob
+`
+
+func TestParseDirectory(t *testing.T) {
+	directory, err := ParseDirectory([]byte(testDirectoryYAML))
+	if err != nil {
+		t.Fatalf("ParseDirectory() error = %v", err)
+	}
+
+	if got := directory.Usernames(); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("Usernames() = %v, want [alice bob]", got)
+	}
+
+	ctx := context.Background()
+	keys, err := directory.LookupUsers(ctx, []string{"bob", "ALICE"})
+	if err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	if len(keys) != 2 || keys[0].Username != "bob" || keys[1].Username != "alice" {
+		t.Fatalf("LookupUsers() = %+v, want bob then alice", keys)
+	}
+	if keys[1].KeyID != "0121aa0a" || !reflect.DeepEqual(keys[1].Subkeys, []string{"0121aa0a", "0121ab0a"}) {
+		t.Errorf("alice = %+v, want lower-case KIDs", keys[1])
+	}
+
+	keys, err = directory.LookupUsers(ctx, []string{"alice", "mallory"})
+	var multiErr *api.MultiLookupError
+	if !isNotFound(err) || !errors.As(err, &multiErr) || !reflect.DeepEqual(multiErr.Usernames(), []string{"mallory"}) {
+		t.Errorf("LookupUsers() with unknown user error = %v, want NotFound for mallory", err)
+	}
+	if len(keys) != 1 || keys[0].Username != "alice" {
+		t.Errorf("LookupUsers() with unknown user = %+v, want alice's key", keys)
+	}
+
+	owners, err := directory.LookupKeyOwners(ctx, []string{"0121AB0A", "0120ac0a", "0121ff0a"})
+	if err != nil {
+		t.Fatalf("LookupKeyOwners() error = %v", err)
+	}
+	if want := map[string]string{"0121ab0a": "alice", "0120ac0a": "alice"}; !reflect.DeepEqual(owners, want) {
+		t.Errorf("LookupKeyOwners() = %v, want %v", owners, want)
+	}
+
+	team, err := directory.LookupTeam(ctx, "Acme.Ops")
+	if err != nil {
+		t.Fatalf("LookupTeam() error = %v", err)
+	}
+	if got := team.Usernames(api.TeamRoleAdmin); !reflect.DeepEqual(got, []string{"alice"}) {
+		t.Errorf("admins = %v, want [alice]", got)
+	}
+	if got := team.Usernames(""); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Errorf("members = %v, want [alice bob]", got)
+	}
+
+	if _, err := directory.LookupTeam(ctx, "acme.dev"); !isNotFound(err) {
+		t.Errorf("LookupTeam() with unknown team error = %v, want NotFound", err)
+	}
+}
+
+func TestParseDirectoryJSON(t *testing.T) {
+	data := `{"users": {"alice": {"kid": "0121aa0a"}}, "teams": {"acme": [{"username": "alice", "role": "owner"}]}}`
+	directory, err := ParseDirectory([]byte(data))
+	if err != nil {
+		t.Fatalf("ParseDirectory() error = %v", err)
+	}
+
+	keys, err := directory.LookupUsers(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	if keys[0].KeyID != "0121aa0a" {
+		t.Errorf("KeyID = %q, want 0121aa0a", keys[0].KeyID)
+	}
+}
+
+func TestParseDirectoryErrors(t *testing.T) {
+	tests := map[string]string{
+		"invalid username": "users: {\"bad user\": {kid: 0121aa0a}}",
+		"no key":           "users: {alice: {eldest_kid: 0120aa0a}}",
+		"invalid KID":      "users: {alice: {kid: xyz}}",
+		"shared KID":       "users: {alice: {kid: 0121aa0a}, bob: {kid: 0121aa0a}}",
+		"unknown field":    "users: {alice: {kid: 0121aa0a, subkey: 0121ab0a}}",
+		"invalid team":     "teams: {\"acme..ops\": []}",
+		"invalid role":     "teams: {acme: [{username: alice, role: janitor}]}",
+		"not YAML":         "users: [",
+	}
+
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ParseDirectory([]byte(data)); err == nil {
+				t.Errorf("ParseDirectory(%q) should fail", data)
+			}
+		})
+	}
+}
+
+func TestNewDirectoryResolver(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	if err := os.WriteFile(path, []byte(testDirectoryYAML), 0600); err != nil {
+		t.Fatalf("Failed to write key directory: %v", err)
+	}
+
+	directory, err := NewDirectoryResolver(path)
+	if err != nil {
+		t.Fatalf("NewDirectoryResolver() error = %v", err)
+	}
+	if len(directory.Usernames()) != 2 {
+		t.Errorf("Usernames() = %v, want 2 users", directory.Usernames())
+	}
+
+	if _, err := NewDirectoryResolver(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("NewDirectoryResolver() with a missing file should fail")
+	}
+}
@@ -2,18 +2,21 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
 )
 
-// Manager manages public key caching with API integration
+// Manager manages public key caching in front of a KeyResolver
 type Manager struct {
-	cache       *Cache
-	apiClient   *api.Client
-	offlineMode bool // If true, only use cache (no API calls)
-	mu          sync.RWMutex
+	cache            *Cache
+	resolver         KeyResolver
+	offlineMode      bool // If true, only use cache (no API calls)
+	trustServerTeams bool // If true, unverified cached team lists are used
+	mu               sync.RWMutex
 }
 
 // ManagerConfig holds configuration for the cache manager
@@ -21,6 +24,10 @@ type ManagerConfig struct {
 	CacheConfig *CacheConfig
 	APIConfig   *api.ClientConfig
 	
+	// Resolver is the source of keys missing from the cache
+	// If nil, a keybase.io client configured by APIConfig is used
+	Resolver KeyResolver
+	
 	// OfflineMode prevents API calls and only uses cached keys
 	// Useful for air-gapped environments or testing
 	OfflineMode bool
@@ -45,16 +52,17 @@ func NewManager(config *ManagerConfig) (*Manager, error) {
 		return nil, fmt.Errorf("failed to create cache: %w", err)
 	}
 	
-	// Only create API client if not in offline mode
-	var apiClient *api.Client
-	if !config.OfflineMode {
-		apiClient = api.NewClient(config.APIConfig)
+	// Only create a resolver if not in offline mode
+	resolver := config.Resolver
+	if resolver == nil && !config.OfflineMode {
+		resolver = api.NewClient(config.APIConfig)
 	}
 	
 	return &Manager{
-		cache:       cache,
-		apiClient:   apiClient,
-		offlineMode: config.OfflineMode,
+		cache:            cache,
+		resolver:         resolver,
+		offlineMode:      config.OfflineMode,
+		trustServerTeams: config.APIConfig != nil && config.APIConfig.TrustServerTeams,
 	}, nil
 }
 
@@ -62,11 +70,7 @@ func NewManager(config *ManagerConfig) (*Manager, error) {
 func (m *Manager) GetPublicKey(ctx context.Context, username string) (*api.UserPublicKey, error) {
 	// Check cache first
 	if entry := m.cache.Get(username); entry != nil {
-		return &api.UserPublicKey{
-			Username:  entry.Username,
-			PublicKey: entry.PublicKey,
-			KeyID:     entry.KeyID,
-		}, nil
+		return entryToUserPublicKey(entry), nil
 	}
 	
 	// If in offline mode, fail if not in cache
@@ -78,8 +82,8 @@ func (m *Manager) GetPublicKey(ctx context.Context, username string) (*api.UserP
 		}
 	}
 	
-	// Fetch from API
-	keys, err := m.apiClient.LookupUsers(ctx, []string{username})
+	// Fetch from the resolver
+	keys, err := m.keyResolver().LookupUsers(ctx, []string{username})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch public key for %s: %w", username, err)
 	}
@@ -91,7 +95,7 @@ func (m *Manager) GetPublicKey(ctx context.Context, username string) (*api.UserP
 	key := keys[0]
 	
 	// Store in cache
-	if err := m.cache.Set(key.Username, key.PublicKey, key.KeyID); err != nil {
+	if err := m.cache.SetEntry(userPublicKeyToEntry(&key)); err != nil {
 		// Log error but don't fail the operation
 		// The key was fetched successfully, caching is just an optimization
 	}
@@ -101,6 +105,10 @@ func (m *Manager) GetPublicKey(ctx context.Context, username string) (*api.UserP
 
 // GetPublicKeys retrieves public keys for multiple usernames
 // Uses batch API call for efficiency, with cache fallback per user
+//
+// If only some users fail, the keys of the others are returned in request
+// order along with an error wrapping an *api.MultiLookupError that lists
+// each failed user and why it failed.
 func (m *Manager) GetPublicKeys(ctx context.Context, usernames []string) ([]api.UserPublicKey, error) {
 	if len(usernames) == 0 {
 		return nil, fmt.Errorf("no usernames provided")
@@ -110,15 +118,11 @@ func (m *Manager) GetPublicKeys(ctx context.Context, usernames []string) ([]api.
 	var needFetch []string
 	results := make([]api.UserPublicKey, 0, len(usernames))
 	resultMap := make(map[string]*api.UserPublicKey)
+	failures := make(map[string]error)
 	
 	for _, username := range usernames {
 		if entry := m.cache.Get(username); entry != nil {
-			key := &api.UserPublicKey{
-				Username:  entry.Username,
-				PublicKey: entry.PublicKey,
-				KeyID:     entry.KeyID,
-			}
-			resultMap[username] = key
+			resultMap[strings.ToLower(username)] = entryToUserPublicKey(entry)
 		} else {
 			needFetch = append(needFetch, username)
 		}
@@ -128,43 +132,230 @@ func (m *Manager) GetPublicKeys(ctx context.Context, usernames []string) ([]api.
 	if len(needFetch) > 0 {
 		// If in offline mode, fail if any keys are missing
 		if m.offlineMode {
-			return nil, &api.APIError{
+			offlineErr := &api.APIError{
 				Message:   fmt.Sprintf("offline mode: public keys not found in cache for users: %v", needFetch),
 				Kind:      api.ErrorKindNotFound,
 				Temporary: false,
 			}
-		}
-		
-		keys, err := m.apiClient.LookupUsers(ctx, needFetch)
-		if err != nil {
-			return nil, fmt.Errorf("failed to fetch public keys: %w", err)
-		}
-		
-		// Cache fetched keys
-		for _, key := range keys {
-			if err := m.cache.Set(key.Username, key.PublicKey, key.KeyID); err != nil {
-				// Log error but continue
+			if len(needFetch) == len(usernames) {
+				return nil, offlineErr
+			}
+			for _, username := range needFetch {
+				failures[strings.ToLower(username)] = offlineErr
+			}
+		} else {
+			keys, err := m.keyResolver().LookupUsers(ctx, needFetch)
+			if err != nil {
+				var multiErr *api.MultiLookupError
+				switch {
+				case errors.As(err, &multiErr):
+					for _, failure := range multiErr.Failures {
+						failures[strings.ToLower(failure.Username)] = failure.Err
+					}
+				case len(needFetch) < len(usernames) && ctx.Err() == nil:
+					// The lookup failed as a whole, but cached keys can still
+					// be returned
+					for _, username := range needFetch {
+						failures[strings.ToLower(username)] = err
+					}
+				default:
+					return nil, fmt.Errorf("failed to fetch public keys: %w", err)
+				}
 			}
-			resultMap[key.Username] = &api.UserPublicKey{
-				Username:  key.Username,
-				PublicKey: key.PublicKey,
-				KeyID:     key.KeyID,
+			
+			// Cache fetched keys
+			for i := range keys {
+				key := keys[i]
+				if err := m.cache.SetEntry(userPublicKeyToEntry(&key)); err != nil {
+					// Log error but continue
+				}
+				resultMap[strings.ToLower(key.Username)] = &key
 			}
 		}
 	}
 	
 	// Build results in original order
+	multiErr := &api.MultiLookupError{Requested: len(usernames)}
 	for _, username := range usernames {
-		if key, ok := resultMap[username]; ok {
+		if key, ok := resultMap[strings.ToLower(username)]; ok {
 			results = append(results, *key)
-		} else {
-			return nil, fmt.Errorf("no public key found for user: %s", username)
+			continue
+		}
+		
+		err, ok := failures[strings.ToLower(username)]
+		if !ok {
+			err = &api.APIError{
+				Message:   fmt.Sprintf("no public key found for user: %s", username),
+				Kind:      api.ErrorKindNotFound,
+				Temporary: false,
+			}
 		}
+		multiErr.Failures = append(multiErr.Failures, api.UserLookupFailure{
+			Username: username,
+			Kind:     api.ErrorKindOf(err),
+			Err:      err,
+		})
+	}
+	
+	if len(multiErr.Failures) > 0 {
+		return results, fmt.Errorf("failed to fetch public keys: %w", multiErr)
 	}
 	
 	return results, nil
 }
 
+// GetProofs fetches the identity proof summaries of multiple users
+// Proofs always come from the API and are not cached, so that a revoked
+// proof is noticed immediately; offline mode therefore cannot verify proofs,
+// and neither can a resolver that does not implement ProofResolver
+func (m *Manager) GetProofs(ctx context.Context, usernames []string) (map[string][]api.Proof, error) {
+	if m.offlineMode {
+		return nil, &api.APIError{
+			Message:   "offline mode: cannot verify identity proofs without the API",
+			Kind:      api.ErrorKindNetwork,
+			Temporary: false,
+		}
+	}
+	
+	proofResolver, ok := m.keyResolver().(ProofResolver)
+	if !ok {
+		return nil, unsupportedLookup("identity proofs")
+	}
+	
+	proofs, err := proofResolver.LookupProofs(ctx, usernames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch identity proofs: %w", err)
+	}
+	
+	return proofs, nil
+}
+
+// ResolveKIDs maps Keybase KIDs to the usernames that own them
+//
+// Cached key families are searched first, and the remaining KIDs are
+// looked up with the resolver unless in offline mode or the resolver does
+// not implement KeyOwnerResolver. KIDs that cannot be resolved are left out
+// of the result.
+func (m *Manager) ResolveKIDs(ctx context.Context, kids []string) (map[string]string, error) {
+	owners := make(map[string]string, len(kids))
+	var unresolved []string
+	
+	for _, kid := range kids {
+		if username := m.cache.FindKIDOwner(kid); username != "" {
+			owners[kid] = username
+		} else {
+			unresolved = append(unresolved, kid)
+		}
+	}
+	
+	if len(unresolved) == 0 || m.offlineMode {
+		return owners, nil
+	}
+	
+	ownerResolver, ok := m.keyResolver().(KeyOwnerResolver)
+	if !ok {
+		return owners, nil
+	}
+	
+	fetched, err := ownerResolver.LookupKeyOwners(ctx, unresolved)
+	if err != nil {
+		return owners, fmt.Errorf("failed to look up key owners: %w", err)
+	}
+	
+	for _, kid := range unresolved {
+		if username, ok := fetched[strings.ToLower(kid)]; ok {
+			owners[kid] = username
+		}
+	}
+	
+	return owners, nil
+}
+
+// GetDevices fetches the devices of multiple users and the keys they hold
+// Devices always come from the API and are not cached
+func (m *Manager) GetDevices(ctx context.Context, usernames []string) (map[string][]api.Device, error) {
+	if m.offlineMode {
+		return nil, &api.APIError{
+			Message:   "offline mode: cannot look up devices without the API",
+			Kind:      api.ErrorKindNetwork,
+			Temporary: false,
+		}
+	}
+	
+	deviceResolver, ok := m.keyResolver().(DeviceResolver)
+	if !ok {
+		return nil, unsupportedLookup("devices")
+	}
+	
+	devices, err := deviceResolver.LookupDevices(ctx, usernames)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch devices: %w", err)
+	}
+	
+	return devices, nil
+}
+
+// GetTeamMembers expands a team into the usernames of its current members
+// with at least minRole (every member if minRole is empty)
+//
+// The full member list is cached under the team name with the cache TTL,
+// so different role filters share one API lookup. The cache file may be
+// shared with processes that trust the server's team lists, so an
+// unverified cached list counts as a miss unless APIConfig.TrustServerTeams
+// is set.
+func (m *Manager) GetTeamMembers(ctx context.Context, team string, minRole api.TeamRole) ([]string, error) {
+	name := strings.ToLower(team)
+	
+	entry := m.cache.GetTeam(name)
+	if entry != nil && !entry.Verified && !m.trustServerTeams {
+		entry = nil
+	}
+	if entry == nil {
+		if m.offlineMode {
+			return nil, &api.APIError{
+				Message:   fmt.Sprintf("offline mode: members of team %q not found in cache", team),
+				Kind:      api.ErrorKindNotFound,
+				Temporary: false,
+			}
+		}
+		
+		teamResolver, ok := m.keyResolver().(TeamResolver)
+		if !ok {
+			return nil, unsupportedLookup("team members")
+		}
+		
+		result, err := teamResolver.LookupTeam(ctx, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch members of team %s: %w", team, err)
+		}
+		
+		members := make([]TeamMember, 0, len(result.Members))
+		for _, member := range result.Members {
+			members = append(members, TeamMember{Username: member.Username, Role: string(member.Role)})
+		}
+		
+		// Caching is just an optimization; the member list is still valid
+		_ = m.cache.SetTeam(name, members, result.Verified)
+		entry = &TeamEntry{Name: name, Members: members, Verified: result.Verified}
+	}
+	
+	teamResult := &api.Team{Name: entry.Name}
+	for _, member := range entry.Members {
+		teamResult.Members = append(teamResult.Members, api.TeamMember{
+			Username: member.Username,
+			Role:     api.TeamRole(member.Role),
+		})
+	}
+	
+	return teamResult.Usernames(minRole), nil
+}
+
+// InvalidateTeam removes a team's member list from the cache
+// Useful after changing team membership
+func (m *Manager) InvalidateTeam(team string) error {
+	return m.cache.DeleteTeam(strings.ToLower(team))
+}
+
 // InvalidateUser removes a user's public key from the cache
 // Useful when key rotation is detected
 func (m *Manager) InvalidateUser(username string) error {
@@ -193,6 +384,53 @@ func (m *Manager) Close() error {
 	return nil
 }
 
+// entryToUserPublicKey converts a cache entry to the API key model
+func entryToUserPublicKey(entry *CacheEntry) *api.UserPublicKey {
+	return &api.UserPublicKey{
+		Username:  entry.Username,
+		PublicKey: entry.PublicKey,
+		KeyID:     entry.KeyID,
+		EldestKID: entry.EldestKID,
+		Sibkeys:   entry.Sibkeys,
+		Subkeys:   entry.Subkeys,
+	}
+}
+
+// userPublicKeyToEntry converts an API key to a cache entry
+func userPublicKeyToEntry(key *api.UserPublicKey) CacheEntry {
+	return CacheEntry{
+		Username:  key.Username,
+		PublicKey: key.PublicKey,
+		KeyID:     key.KeyID,
+		EldestKID: key.EldestKID,
+		Sibkeys:   key.Sibkeys,
+		Subkeys:   key.Subkeys,
+	}
+}
+
+// Resolver returns the source of keys missing from the cache
+// It is nil for a manager created in offline mode without a Resolver
+func (m *Manager) Resolver() KeyResolver {
+	return m.resolver
+}
+
+// keyResolver returns the resolver, or one that fails every lookup if the
+// manager was created offline and has since been switched online
+func (m *Manager) keyResolver() KeyResolver {
+	if m.resolver == nil {
+		return noResolver{}
+	}
+	return m.resolver
+}
+
+// noResolver is a KeyResolver that cannot look anything up
+type noResolver struct{}
+
+// LookupUsers implements KeyResolver
+func (noResolver) LookupUsers(ctx context.Context, usernames []string) ([]api.UserPublicKey, error) {
+	return nil, unsupportedLookup("public keys")
+}
+
 // Cache returns the underlying cache instance
 // This is useful for direct cache operations when needed
 func (m *Manager) Cache() *Cache {
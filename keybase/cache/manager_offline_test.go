@@ -365,8 +365,8 @@ func TestOfflineModeManagerCreation(t *testing.T) {
 				t.Errorf("IsOfflineMode() = %v, want %v", manager.IsOfflineMode(), tt.offlineMode)
 			}
 
-			// In offline mode, apiClient should be nil
-			hasAPIClient := manager.apiClient != nil
+			// In offline mode, the resolver should be nil
+			hasAPIClient := manager.resolver != nil
 			if hasAPIClient != tt.wantAPIClient {
 				t.Errorf("Has API client = %v, want %v", hasAPIClient, tt.wantAPIClient)
 			}
@@ -3,6 +3,7 @@ package cache
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -105,6 +106,173 @@ func TestGetPublicKeyFromAPI(t *testing.T) {
 	}
 }
 
+func TestGetPublicKeysCachesKeyFamilies(t *testing.T) {
+	subkeys := []string{"0121aa0a", "0121bb0a"}
+	requests := 0
+	
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		response := api.LookupResponse{
+			Status: api.Status{Code: 0, Name: "OK"},
+			Them: []api.User{
+				{
+					Basics: api.Basics{Username: "alice"},
+					PublicKeys: api.PublicKeys{
+						Primary:   api.PrimaryKey{KID: "0120cc0a", Bundle: "test_bundle_alice"},
+						EldestKID: "0120cc0a",
+						Sibkeys:   []string{"0120cc0a"},
+						Subkeys:   subkeys,
+					},
+				},
+			},
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+	
+	cacheFile := filepath.Join(t.TempDir(), "test_cache.json")
+	config := &ManagerConfig{
+		CacheConfig: &CacheConfig{FilePath: cacheFile, TTL: time.Hour},
+		APIConfig:   &api.ClientConfig{BaseURL: server.URL, MaxRetries: 0},
+	}
+	
+	manager, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, err := manager.GetPublicKeys(context.Background(), []string{"alice"}); err != nil {
+		t.Fatalf("GetPublicKeys() error = %v", err)
+	}
+	
+	// A second manager reads the key families back from disk, offline
+	config.OfflineMode = true
+	reloaded, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	
+	keys, err := reloaded.GetPublicKeys(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatalf("GetPublicKeys() from cache error = %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("API requests = %d, want 1", requests)
+	}
+	
+	key := keys[0]
+	if key.EldestKID != "0120cc0a" {
+		t.Errorf("EldestKID = %v, want 0120cc0a", key.EldestKID)
+	}
+	if len(key.Sibkeys) != 1 {
+		t.Errorf("len(Sibkeys) = %d, want 1", len(key.Sibkeys))
+	}
+	if len(key.Subkeys) != len(subkeys) {
+		t.Errorf("Subkeys = %v, want %v", key.Subkeys, subkeys)
+	}
+}
+
+func TestGetTeamMembers(t *testing.T) {
+	requests := 0
+	
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		response := api.TeamResponse{
+			Status: api.Status{Code: 0, Name: "OK"},
+			Name:   "acme.ops",
+			Members: api.TeamMembers{
+				Owners:  []api.TeamMemberEntry{{Username: "alice"}},
+				Writers: []api.TeamMemberEntry{{Username: "bob"}},
+				Readers: []api.TeamMemberEntry{{Username: "charlie"}},
+			},
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+	
+	cacheFile := filepath.Join(t.TempDir(), "test_cache.json")
+	config := &ManagerConfig{
+		CacheConfig: &CacheConfig{FilePath: cacheFile, TTL: time.Hour},
+		APIConfig:   &api.ClientConfig{BaseURL: server.URL, MaxRetries: 0, TrustServerTeams: true},
+	}
+	
+	manager, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	
+	members, err := manager.GetTeamMembers(context.Background(), "acme.ops", "")
+	if err != nil {
+		t.Fatalf("GetTeamMembers() error = %v", err)
+	}
+	if len(members) != 3 {
+		t.Errorf("GetTeamMembers() = %v, want 3 members", members)
+	}
+	
+	// A role filter is applied to the cached member list
+	writers, err := manager.GetTeamMembers(context.Background(), "ACME.ops", api.TeamRoleWriter)
+	if err != nil {
+		t.Fatalf("GetTeamMembers() error = %v", err)
+	}
+	if len(writers) != 2 || writers[0] != "alice" || writers[1] != "bob" {
+		t.Errorf("GetTeamMembers(writer) = %v, want [alice bob]", writers)
+	}
+	if requests != 1 {
+		t.Errorf("API requests = %d, want 1", requests)
+	}
+	
+	// A second manager reads the member list back from disk, offline
+	config.OfflineMode = true
+	reloaded, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if members, err := reloaded.GetTeamMembers(context.Background(), "acme.ops", api.TeamRoleOwner); err != nil || len(members) != 1 {
+		t.Errorf("GetTeamMembers() from cache = %v, %v, want [alice]", members, err)
+	}
+	
+	// The cached list came from the server unverified, so a manager that
+	// does not trust server team lists ignores it
+	config.APIConfig.TrustServerTeams = false
+	untrusting, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, err := untrusting.GetTeamMembers(context.Background(), "acme.ops", ""); err == nil {
+		t.Error("GetTeamMembers() offline without TrustServerTeams should ignore the unverified cached list")
+	}
+	config.OfflineMode = false
+	untrusting, err = NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	if _, err := untrusting.GetTeamMembers(context.Background(), "acme.ops", ""); !errors.Is(err, api.ErrUnverifiedTeam) {
+		t.Errorf("GetTeamMembers() without TrustServerTeams error = %v, want ErrUnverifiedTeam", err)
+	}
+	if requests != 1 {
+		t.Errorf("API requests = %d, want 1", requests)
+	}
+	
+	// A verified list is used whatever the setting
+	if err := untrusting.Cache().SetTeam("acme.ops", []TeamMember{{Username: "alice", Role: "owner"}}, true); err != nil {
+		t.Fatalf("SetTeam() error = %v", err)
+	}
+	if members, err := untrusting.GetTeamMembers(context.Background(), "acme.ops", ""); err != nil || len(members) != 1 {
+		t.Errorf("GetTeamMembers() of a verified cached list = %v, %v, want [alice]", members, err)
+	}
+	
+	// Invalidating the team forces a fresh lookup
+	if err := reloaded.InvalidateTeam("acme.ops"); err != nil {
+		t.Fatalf("InvalidateTeam() error = %v", err)
+	}
+	if _, err := reloaded.GetTeamMembers(context.Background(), "acme.ops", ""); err == nil {
+		t.Error("GetTeamMembers() offline after InvalidateTeam() should fail")
+	}
+}
+
 func TestGetPublicKeyFromCache(t *testing.T) {
 	// Create mock server that should not be called
 	callCount := 0
@@ -644,8 +812,8 @@ func TestNewManagerWithNilConfig(t *testing.T) {
 		t.Error("Manager cache is nil")
 	}
 	
-	if manager.apiClient == nil {
-		t.Error("Manager apiClient is nil")
+	if manager.resolver == nil {
+		t.Error("Manager resolver is nil")
 	}
 }
 
@@ -693,3 +861,62 @@ func TestGetPublicKeysEmpty(t *testing.T) {
 		t.Error("GetPublicKeys() should fail with empty usernames")
 	}
 }
+
+func TestResolveKIDs(t *testing.T) {
+	var gotKIDs string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotKIDs = r.URL.Query().Get("kids")
+		response := api.KeyFetchResponse{
+			Status: api.Status{Code: 0, Name: "OK"},
+			Keys:   []api.FetchedKeyEntry{{KID: "0121bbbb0a", Username: "bob"}},
+		}
+		
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+	
+	config := &ManagerConfig{
+		CacheConfig: &CacheConfig{FilePath: filepath.Join(t.TempDir(), "test_cache.json"), TTL: time.Hour},
+		APIConfig:   &api.ClientConfig{BaseURL: server.URL, MaxRetries: 0},
+	}
+	
+	manager, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	
+	if err := manager.Cache().SetEntry(CacheEntry{Username: "alice", KeyID: "0120aaaa0a", Subkeys: []string{"0121aaaa0a"}}); err != nil {
+		t.Fatalf("SetEntry() error = %v", err)
+	}
+	
+	// Cached key families answer first; only the rest reach the API
+	owners, err := manager.ResolveKIDs(context.Background(), []string{"0121AAAA0A", "0121bbbb0a", "0121cccc0a"})
+	if err != nil {
+		t.Fatalf("ResolveKIDs() error = %v", err)
+	}
+	if gotKIDs != "0121bbbb0a,0121cccc0a" {
+		t.Errorf("kids = %q, want %q", gotKIDs, "0121bbbb0a,0121cccc0a")
+	}
+	if owners["0121AAAA0A"] != "alice" || owners["0121bbbb0a"] != "bob" {
+		t.Errorf("ResolveKIDs() = %v, want alice and bob", owners)
+	}
+	if _, ok := owners["0121cccc0a"]; ok {
+		t.Errorf("ResolveKIDs() resolved unknown KID: %v", owners)
+	}
+	
+	// Offline, only the cache is consulted
+	config.OfflineMode = true
+	offline, err := NewManager(config)
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	gotKIDs = ""
+	owners, err = offline.ResolveKIDs(context.Background(), []string{"0121aaaa0a", "0121bbbb0a"})
+	if err != nil {
+		t.Fatalf("ResolveKIDs() offline error = %v", err)
+	}
+	if gotKIDs != "" || len(owners) != 1 || owners["0121aaaa0a"] != "alice" {
+		t.Errorf("ResolveKIDs() offline = %v (API kids %q), want only alice from cache", owners, gotKIDs)
+	}
+}
@@ -0,0 +1,324 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+)
+
+// KeyResolver is a source of users' public keys
+//
+// A Manager asks its resolver for the keys of users missing from the cache.
+// LookupUsers returns a key for every username or an error; an *api.APIError
+// of kind api.ErrorKindNotFound means the source does not know some of them.
+// Resolvers that can tell which users failed return the keys of the others
+// along with an *api.MultiLookupError.
+//
+// *api.Client (keybase.io, or an HTTP mirror of its lookup endpoint; see
+// NewMirrorResolver), DirectoryResolver and ChainResolver implement it.
+// Resolvers may also implement KeyOwnerResolver, TeamResolver, ProofResolver
+// and DeviceResolver; Manager methods that need them fail if they do not.
+type KeyResolver interface {
+	LookupUsers(ctx context.Context, usernames []string) ([]api.UserPublicKey, error)
+}
+
+// KeyOwnerResolver maps Keybase KIDs to the usernames that own them
+// Unknown KIDs are left out of the result rather than reported as an error
+type KeyOwnerResolver interface {
+	LookupKeyOwners(ctx context.Context, kids []string) (map[string]string, error)
+}
+
+// TeamResolver looks up the members of a team
+type TeamResolver interface {
+	LookupTeam(ctx context.Context, name string) (*api.Team, error)
+}
+
+// ProofResolver looks up the identity proofs of users
+type ProofResolver interface {
+	LookupProofs(ctx context.Context, usernames []string) (map[string][]api.Proof, error)
+}
+
+// DeviceResolver looks up the devices of users
+type DeviceResolver interface {
+	LookupDevices(ctx context.Context, usernames []string) (map[string][]api.Device, error)
+}
+
+// NewMirrorResolver returns a resolver for an HTTP mirror of the Keybase
+// API, such as an internal key directory serving user/lookup.json (and
+// optionally key/fetch.json and team/get.json) in the keybase.io format
+//
+// baseURL replaces api.DefaultAPIEndpoint (e.g.
+// "https://keys.example.com/_/api/1.0"); the other settings, including
+// headers for authenticating to the mirror, come from config.
+func NewMirrorResolver(baseURL string, config *api.ClientConfig) (*api.Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mirror URL: %w", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("invalid mirror URL %q: must be an http or https URL", baseURL)
+	}
+
+	mirrorConfig := api.DefaultClientConfig()
+	if config != nil {
+		copied := *config
+		mirrorConfig = &copied
+	}
+	mirrorConfig.BaseURL = strings.TrimSuffix(baseURL, "/")
+
+	return api.NewClient(mirrorConfig), nil
+}
+
+// ChainResolver tries several key sources in order
+//
+// Each source is asked only for the users that earlier sources did not
+// know, so a key directory can pin some users and leave the rest to
+// keybase.io. Sources that fail for any other reason (an unreachable
+// mirror, say) are skipped; their errors are reported only if no later
+// source supplies the keys. When some users are found and others are not,
+// the keys that were found are returned with an *api.MultiLookupError.
+type ChainResolver struct {
+	resolvers []KeyResolver
+}
+
+// NewChainResolver returns a resolver that tries resolvers in order
+func NewChainResolver(resolvers ...KeyResolver) *ChainResolver {
+	chain := &ChainResolver{}
+	for _, resolver := range resolvers {
+		if resolver != nil {
+			chain.resolvers = append(chain.resolvers, resolver)
+		}
+	}
+	return chain
+}
+
+// LookupUsers implements KeyResolver
+func (c *ChainResolver) LookupUsers(ctx context.Context, usernames []string) ([]api.UserPublicKey, error) {
+	if len(usernames) == 0 {
+		return nil, fmt.Errorf("no usernames provided")
+	}
+
+	found := make(map[string]api.UserPublicKey, len(usernames))
+	// failures holds, for users still missing, the last error other than
+	// NotFound that a source reported for them
+	failures := make(map[string]error)
+	remaining := usernames
+
+	for _, resolver := range c.resolvers {
+		if len(remaining) == 0 {
+			break
+		}
+
+		keys, err := resolver.LookupUsers(ctx, remaining)
+		if err != nil && ctx.Err() != nil {
+			return nil, err
+		}
+
+		var multiErr *api.MultiLookupError
+		switch {
+		case err == nil:
+		case errors.As(err, &multiErr):
+			for _, failure := range multiErr.Failures {
+				if failure.Kind != api.ErrorKindNotFound {
+					failures[strings.ToLower(failure.Username)] = failure.Err
+				}
+			}
+		case isNotFound(err) && len(remaining) > 1:
+			// The batch failed as a whole; ask for each user on their own to
+			// learn which ones this source knows
+			keys, err = lookupEach(ctx, resolver, remaining, failures)
+			if err != nil {
+				return nil, err
+			}
+		case !isNotFound(err):
+			for _, username := range remaining {
+				failures[strings.ToLower(username)] = err
+			}
+		}
+
+		for _, key := range keys {
+			found[strings.ToLower(key.Username)] = key
+		}
+		remaining = missingUsernames(remaining, found)
+	}
+
+	if len(remaining) == 1 && len(usernames) == 1 {
+		notFound := &api.APIError{
+			Message:   fmt.Sprintf("no key source knows user %q", remaining[0]),
+			Kind:      api.ErrorKindNotFound,
+			Temporary: false,
+		}
+		if err, ok := failures[strings.ToLower(remaining[0])]; ok {
+			// An unreachable source may have known them; errors.As still
+			// finds the NotFound error first
+			return nil, errors.Join(notFound, err)
+		}
+		return nil, notFound
+	}
+
+	results := make([]api.UserPublicKey, 0, len(usernames))
+	multiErr := &api.MultiLookupError{Requested: len(usernames)}
+	for _, username := range usernames {
+		key, ok := found[strings.ToLower(username)]
+		if ok {
+			results = append(results, key)
+			continue
+		}
+
+		// A source that failed may have known the user, so its error is
+		// reported in preference to NotFound
+		err, ok := failures[strings.ToLower(username)]
+		if !ok {
+			err = &api.APIError{
+				Message:   fmt.Sprintf("no key source knows user %q", username),
+				Kind:      api.ErrorKindNotFound,
+				Temporary: false,
+			}
+		}
+		multiErr.Failures = append(multiErr.Failures, api.UserLookupFailure{
+			Username: username,
+			Kind:     api.ErrorKindOf(err),
+			Err:      err,
+		})
+	}
+
+	if len(multiErr.Failures) > 0 {
+		return results, multiErr
+	}
+	return results, nil
+}
+
+// LookupKeyOwners implements KeyOwnerResolver, asking each source that can
+// map KIDs for those still unresolved
+func (c *ChainResolver) LookupKeyOwners(ctx context.Context, kids []string) (map[string]string, error) {
+	owners := make(map[string]string, len(kids))
+	remaining := kids
+	var errs []error
+
+	for _, resolver := range c.resolvers {
+		ownerResolver, ok := resolver.(KeyOwnerResolver)
+		if !ok || len(remaining) == 0 {
+			continue
+		}
+
+		found, err := ownerResolver.LookupKeyOwners(ctx, remaining)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var unresolved []string
+		for _, kid := range remaining {
+			if username, ok := found[strings.ToLower(kid)]; ok {
+				owners[strings.ToLower(kid)] = username
+			} else {
+				unresolved = append(unresolved, kid)
+			}
+		}
+		remaining = unresolved
+	}
+
+	if len(remaining) > 0 && len(errs) > 0 {
+		return owners, errors.Join(errs...)
+	}
+	return owners, nil
+}
+
+// LookupTeam implements TeamResolver, returning the team from the first
+// source that knows it
+func (c *ChainResolver) LookupTeam(ctx context.Context, name string) (*api.Team, error) {
+	var errs []error
+	for _, resolver := range c.resolvers {
+		teamResolver, ok := resolver.(TeamResolver)
+		if !ok {
+			continue
+		}
+
+		team, err := teamResolver.LookupTeam(ctx, name)
+		if err == nil {
+			return team, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 0 {
+		return nil, unsupportedLookup("team members")
+	}
+	return nil, errors.Join(errs...)
+}
+
+// LookupProofs implements ProofResolver with the first source that supports it
+func (c *ChainResolver) LookupProofs(ctx context.Context, usernames []string) (map[string][]api.Proof, error) {
+	for _, resolver := range c.resolvers {
+		if proofResolver, ok := resolver.(ProofResolver); ok {
+			return proofResolver.LookupProofs(ctx, usernames)
+		}
+	}
+	return nil, unsupportedLookup("identity proofs")
+}
+
+// LookupDevices implements DeviceResolver with the first source that supports it
+func (c *ChainResolver) LookupDevices(ctx context.Context, usernames []string) (map[string][]api.Device, error) {
+	for _, resolver := range c.resolvers {
+		if deviceResolver, ok := resolver.(DeviceResolver); ok {
+			return deviceResolver.LookupDevices(ctx, usernames)
+		}
+	}
+	return nil, unsupportedLookup("devices")
+}
+
+// lookupEach looks users up one at a time, returning the keys that were
+// found; errors other than NotFound are recorded in failures by lowercase
+// username, and the error is only returned if ctx is done
+func lookupEach(ctx context.Context, resolver KeyResolver, usernames []string, failures map[string]error) ([]api.UserPublicKey, error) {
+	var keys []api.UserPublicKey
+
+	for _, username := range usernames {
+		found, err := resolver.LookupUsers(ctx, []string{username})
+		if err != nil {
+			if ctx.Err() != nil {
+				return keys, err
+			}
+			if !isNotFound(err) {
+				failures[strings.ToLower(username)] = err
+			}
+			continue
+		}
+		keys = append(keys, found...)
+	}
+
+	return keys, nil
+}
+
+// missingUsernames returns the usernames without a key in found
+func missingUsernames(usernames []string, found map[string]api.UserPublicKey) []string {
+	var missing []string
+	for _, username := range usernames {
+		if _, ok := found[strings.ToLower(username)]; !ok {
+			missing = append(missing, username)
+		}
+	}
+	return missing
+}
+
+// isNotFound reports whether err says that users or keys are unknown
+func isNotFound(err error) bool {
+	var apiErr *api.APIError
+	return errors.As(err, &apiErr) && apiErr.Kind == api.ErrorKindNotFound
+}
+
+// unsupportedLookup returns the error for a lookup the key resolver cannot do
+func unsupportedLookup(what string) error {
+	return &api.APIError{
+		Message:   fmt.Sprintf("the configured key resolver cannot look up %s", what),
+		Kind:      api.ErrorKindNetwork,
+		Temporary: false,
+	}
+}
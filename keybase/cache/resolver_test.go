@@ -0,0 +1,267 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+)
+
+// fakeResolver serves fixed keys, failing with err when it is set
+type fakeResolver struct {
+	keys  map[string]api.UserPublicKey
+	err   error
+	calls [][]string
+}
+
+func (f *fakeResolver) LookupUsers(ctx context.Context, usernames []string) ([]api.UserPublicKey, error) {
+	f.calls = append(f.calls, usernames)
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	var keys []api.UserPublicKey
+	for _, username := range usernames {
+		key, ok := f.keys[username]
+		if !ok {
+			return nil, &api.APIError{Message: "user not found: " + username, Kind: api.ErrorKindNotFound}
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func TestChainResolver(t *testing.T) {
+	first := &fakeResolver{keys: map[string]api.UserPublicKey{
+		"alice": {Username: "alice", KeyID: "0121aa0a"},
+	}}
+	second := &fakeResolver{keys: map[string]api.UserPublicKey{
+		"alice": {Username: "alice", KeyID: "0121ff0a"},
+		"bob":   {Username: "bob", KeyID: "0121bb0a"},
+	}}
+
+	chain := NewChainResolver(first, nil, second)
+	keys, err := chain.LookupUsers(context.Background(), []string{"bob", "alice"})
+	if err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	if len(keys) != 2 || keys[0].KeyID != "0121bb0a" || keys[1].KeyID != "0121aa0a" {
+		t.Errorf("LookupUsers() = %+v, want bob from the second source and alice from the first", keys)
+	}
+
+	// The second source is only asked for the user the first did not know
+	if last := second.calls[len(second.calls)-1]; len(last) != 1 || last[0] != "bob" {
+		t.Errorf("second source was asked for %v, want [bob]", last)
+	}
+
+	_, err = chain.LookupUsers(context.Background(), []string{"alice", "mallory"})
+	if !isNotFound(err) || !strings.Contains(err.Error(), "mallory") {
+		t.Errorf("LookupUsers() with unknown user error = %v, want NotFound naming mallory", err)
+	}
+}
+
+func TestChainResolverSkipsFailingSource(t *testing.T) {
+	unreachable := &api.APIError{Message: "connection refused", Kind: api.ErrorKindNetwork, Temporary: true}
+	down := &fakeResolver{err: unreachable}
+	directory := &fakeResolver{keys: map[string]api.UserPublicKey{
+		"alice": {Username: "alice", KeyID: "0121aa0a"},
+	}}
+
+	keys, err := NewChainResolver(down, directory).LookupUsers(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0].KeyID != "0121aa0a" {
+		t.Errorf("LookupUsers() = %+v, want alice from the directory", keys)
+	}
+
+	// When no source has the user, the unreachable source's error is kept
+	_, err = NewChainResolver(down, directory).LookupUsers(context.Background(), []string{"bob"})
+	if !errors.Is(err, unreachable) || !isNotFound(err) {
+		t.Errorf("LookupUsers() error = %v, want both the network and the NotFound error", err)
+	}
+}
+
+func TestChainResolverPartialResults(t *testing.T) {
+	directory, err := ParseDirectory([]byte(testDirectoryYAML))
+	if err != nil {
+		t.Fatalf("ParseDirectory() error = %v", err)
+	}
+	second := &fakeResolver{keys: map[string]api.UserPublicKey{
+		"carol": {Username: "carol", KeyID: "0121cc0a"},
+	}}
+	down := &fakeResolver{err: &api.APIError{Message: "connection refused", Kind: api.ErrorKindNetwork, Temporary: true}}
+
+	// The directory knows alice and bob, the second source carol; nobody can
+	// say whether dave or mallory exist while the last source is down
+	usernames := []string{"alice", "dave", "carol", "bob", "mallory"}
+	keys, err := NewChainResolver(directory, second, down).LookupUsers(context.Background(), usernames)
+
+	var multiErr *api.MultiLookupError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("LookupUsers() error = %v, want *api.MultiLookupError", err)
+	}
+	if multiErr.Requested != 5 || !reflect.DeepEqual(multiErr.Usernames(), []string{"dave", "mallory"}) {
+		t.Errorf("MultiLookupError = %v, want dave and mallory of 5 users", multiErr)
+	}
+	for _, failure := range multiErr.Failures {
+		if failure.Kind != api.ErrorKindNetwork {
+			t.Errorf("%s failed with %v, want the unreachable source's NetworkError", failure.Username, failure.Kind)
+		}
+	}
+
+	var got []string
+	for _, key := range keys {
+		got = append(got, key.Username)
+	}
+	if want := []string{"alice", "carol", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("LookupUsers() returned keys for %v, want %v", got, want)
+	}
+}
+
+func TestManagerPartialLookup(t *testing.T) {
+	directory, err := ParseDirectory([]byte(testDirectoryYAML))
+	if err != nil {
+		t.Fatalf("ParseDirectory() error = %v", err)
+	}
+
+	manager, err := NewManager(&ManagerConfig{
+		CacheConfig: &CacheConfig{FilePath: filepath.Join(t.TempDir(), "cache.json"), TTL: time.Hour},
+		Resolver:    directory,
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer manager.Close()
+
+	keys, err := manager.GetPublicKeys(context.Background(), []string{"bob", "mallory", "alice"})
+
+	var multiErr *api.MultiLookupError
+	if !errors.As(err, &multiErr) {
+		t.Fatalf("GetPublicKeys() error = %v, want *api.MultiLookupError", err)
+	}
+	if len(multiErr.Failures) != 1 || multiErr.Failures[0].Username != "mallory" || multiErr.Failures[0].Kind != api.ErrorKindNotFound {
+		t.Errorf("MultiLookupError = %v, want mallory not found", multiErr)
+	}
+	if len(keys) != 2 || keys[0].Username != "bob" || keys[1].Username != "alice" {
+		t.Errorf("GetPublicKeys() = %+v, want bob and alice", keys)
+	}
+
+	// The keys that were found are cached
+	if entry := manager.Cache().Get("bob"); entry == nil {
+		t.Error("keys from a partial lookup were not cached")
+	}
+}
+
+func TestMirrorResolver(t *testing.T) {
+	var gotAuth, gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotPath = r.URL.Path
+		response := api.LookupResponse{
+			Status: api.Status{Code: 0, Name: "OK"},
+			Them: []api.User{
+				{
+					Basics: api.Basics{Username: "alice"},
+					PublicKeys: api.PublicKeys{
+						Primary: api.PrimaryKey{KID: "0121aa0a", Bundle: "test_bundle_alice"},
+					},
+				},
+			},
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	}))
+	defer server.Close()
+
+	config := &api.ClientConfig{
+		Timeout:    5 * time.Second,
+		MaxRetries: 0,
+		Headers:    http.Header{"Authorization": []string{"Bearer secret"}},
+	}
+	mirror, err := NewMirrorResolver(server.URL+"/_/api/1.0/", config)
+	if err != nil {
+		t.Fatalf("NewMirrorResolver() error = %v", err)
+	}
+
+	keys, err := mirror.LookupUsers(context.Background(), []string{"alice"})
+	if err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	if len(keys) != 1 || keys[0].KeyID != "0121aa0a" {
+		t.Errorf("LookupUsers() = %+v, want alice", keys)
+	}
+	if gotPath != "/_/api/1.0/user/lookup.json" {
+		t.Errorf("request path = %q, want /_/api/1.0/user/lookup.json", gotPath)
+	}
+	if gotAuth != "Bearer secret" {
+		t.Errorf("Authorization = %q, want the configured header", gotAuth)
+	}
+
+	for _, url := range []string{"", "ftp://keys.example.com", "keys.example.com"} {
+		if _, err := NewMirrorResolver(url, nil); err == nil {
+			t.Errorf("NewMirrorResolver(%q) should fail", url)
+		}
+	}
+}
+
+func TestManagerWithResolver(t *testing.T) {
+	directory, err := ParseDirectory([]byte(testDirectoryYAML))
+	if err != nil {
+		t.Fatalf("ParseDirectory() error = %v", err)
+	}
+
+	manager, err := NewManager(&ManagerConfig{
+		CacheConfig: &CacheConfig{FilePath: filepath.Join(t.TempDir(), "cache.json"), TTL: time.Hour},
+		Resolver:    directory,
+	})
+	if err != nil {
+		t.Fatalf("NewManager() error = %v", err)
+	}
+	defer manager.Close()
+
+	if manager.Resolver() != directory {
+		t.Error("Resolver() did not return the configured resolver")
+	}
+
+	ctx := context.Background()
+	keys, err := manager.GetPublicKeys(ctx, []string{"Alice", "bob"})
+	if err != nil {
+		t.Fatalf("GetPublicKeys() error = %v", err)
+	}
+	if len(keys) != 2 || keys[0].KeyID != "0121aa0a" {
+		t.Errorf("GetPublicKeys() = %+v, want alice and bob", keys)
+	}
+	if entry := manager.Cache().Get("alice"); entry == nil {
+		t.Error("keys from the resolver were not cached")
+	}
+
+	members, err := manager.GetTeamMembers(ctx, "acme.ops", api.TeamRoleAdmin)
+	if err != nil {
+		t.Fatalf("GetTeamMembers() error = %v", err)
+	}
+	if len(members) != 1 || members[0] != "alice" {
+		t.Errorf("GetTeamMembers() = %v, want [alice]", members)
+	}
+
+	owners, err := manager.ResolveKIDs(ctx, []string{"0120ac0a"})
+	if err != nil || owners["0120ac0a"] != "alice" {
+		t.Errorf("ResolveKIDs() = %v, %v, want alice", owners, err)
+	}
+
+	// A directory cannot look up devices or proofs
+	if _, err := manager.GetDevices(ctx, []string{"alice"}); err == nil {
+		t.Error("GetDevices() should fail with a directory resolver")
+	}
+	if _, err := manager.GetProofs(ctx, []string{"alice"}); err == nil {
+		t.Error("GetProofs() should fail with a directory resolver")
+	}
+}
@@ -0,0 +1,346 @@
+// Package cli runs the local keybase command-line client
+//
+// The Keybase service keeps device keys to itself, so a user who has never
+// exported a key file can still encrypt and decrypt through the client. A
+// Client shells out to `keybase id --json`, `keybase team list-members
+// --json`, `keybase encrypt` and `keybase decrypt`; it also implements
+// cache.KeyResolver and cache.TeamResolver, so public keys and team members
+// can come from the local service instead of the HTTP API.
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/credentials"
+)
+
+// Client runs commands with a keybase binary
+type Client struct {
+	path string
+}
+
+// NewClient returns a client for the keybase binary at path
+//
+// An empty path uses the binary found by credentials.DiscoverCredentials.
+// Whether a user is logged in is left for the client itself to report, so
+// that a missing config.json does not hide a working service.
+func NewClient(path string) (*Client, error) {
+	if path == "" {
+		status, err := credentials.DiscoverCredentials()
+		if status == nil || status.CLIPath == "" {
+			return nil, fmt.Errorf("keybase CLI not available: %w", err)
+		}
+		path = status.CLIPath
+	}
+
+	return &Client{path: path}, nil
+}
+
+// Path returns the path of the keybase binary
+func (c *Client) Path() string {
+	return c.path
+}
+
+// CommandError is returned when the keybase client exits with an error
+type CommandError struct {
+	// Command is the subcommand that failed, e.g. "decrypt"
+	Command string
+
+	// Stderr is what the client wrote to standard error, trimmed
+	Stderr string
+
+	// Err is the error from running the process
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	if e.Stderr == "" {
+		return fmt.Sprintf("keybase %s failed: %v", e.Command, e.Err)
+	}
+	return fmt.Sprintf("keybase %s failed: %v: %s", e.Command, e.Err, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// identity is the part of `keybase id --json` output that is read: the
+// username and the key family, in the format of the user/lookup.json API
+type identity struct {
+	Username string `json:"username"`
+	Basics   struct {
+		Username string `json:"username"`
+	} `json:"basics"`
+	PublicKeys api.PublicKeys `json:"public_keys"`
+}
+
+// LookupUsers implements cache.KeyResolver with `keybase id --json`
+//
+// The service identifies each user, checking their proofs as `keybase id`
+// does. Users the client reports as not found fail with
+// api.ErrorKindNotFound; when only some users fail, the keys of the others
+// are returned with an *api.MultiLookupError.
+func (c *Client) LookupUsers(ctx context.Context, usernames []string) ([]api.UserPublicKey, error) {
+	if len(usernames) == 0 {
+		return nil, fmt.Errorf("no usernames provided")
+	}
+
+	for _, username := range usernames {
+		if err := api.ValidateUsername(username); err != nil {
+			return nil, &api.APIError{
+				Message:   fmt.Sprintf("invalid username %q: %v", username, err),
+				Kind:      api.ErrorKindInvalidInput,
+				Temporary: false,
+			}
+		}
+	}
+
+	keys := make([]api.UserPublicKey, 0, len(usernames))
+	multiErr := &api.MultiLookupError{Requested: len(usernames)}
+	for _, username := range usernames {
+		key, err := c.identify(ctx, username)
+		if err != nil {
+			if len(usernames) == 1 || ctx.Err() != nil {
+				return nil, err
+			}
+			multiErr.Failures = append(multiErr.Failures, api.UserLookupFailure{
+				Username: username,
+				Kind:     api.ErrorKindOf(err),
+				Err:      err,
+			})
+			continue
+		}
+		keys = append(keys, *key)
+	}
+
+	if len(multiErr.Failures) > 0 {
+		return keys, multiErr
+	}
+	return keys, nil
+}
+
+// identify runs `keybase id --json` for one user
+func (c *Client) identify(ctx context.Context, username string) (*api.UserPublicKey, error) {
+	output, err := c.run(ctx, nil, "id", "--json", username)
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) && strings.Contains(strings.ToLower(cmdErr.Stderr), "not found") {
+			return nil, &api.APIError{
+				Message:   fmt.Sprintf("user %q not found by the keybase CLI", username),
+				Kind:      api.ErrorKindNotFound,
+				Temporary: false,
+			}
+		}
+		return nil, err
+	}
+
+	var id identity
+	if err := json.Unmarshal(output, &id); err != nil {
+		return nil, &api.APIError{
+			Message:   fmt.Sprintf("failed to parse keybase id output for %q: %v", username, err),
+			Kind:      api.ErrorKindInvalidResponse,
+			Temporary: false,
+		}
+	}
+
+	identified := id.Username
+	if identified == "" {
+		identified = id.Basics.Username
+	}
+	if !strings.EqualFold(identified, username) {
+		return nil, &api.APIError{
+			Message:   fmt.Sprintf("keybase id %s returned user %q", username, identified),
+			Kind:      api.ErrorKindInvalidResponse,
+			Temporary: false,
+		}
+	}
+
+	return &api.UserPublicKey{
+		Username:  identified,
+		PublicKey: id.PublicKeys.Primary.Bundle,
+		KeyID:     id.PublicKeys.Primary.KID,
+		EldestKID: id.PublicKeys.EldestKID,
+		Sibkeys:   id.PublicKeys.Sibkeys,
+		Subkeys:   id.PublicKeys.Subkeys,
+	}, nil
+}
+
+// teamDetails is the part of `keybase team list-members --json` output that
+// is read (keybase1.TeamDetails): the members, grouped by role
+type teamDetails struct {
+	Name    string `json:"name"`
+	Members struct {
+		Owners  []teamMemberDetails `json:"owners"`
+		Admins  []teamMemberDetails `json:"admins"`
+		Writers []teamMemberDetails `json:"writers"`
+		Readers []teamMemberDetails `json:"readers"`
+	} `json:"members"`
+}
+
+// teamMemberDetails is one member in teamDetails
+type teamMemberDetails struct {
+	Username string `json:"username"`
+	Status   int    `json:"status"`
+}
+
+// teamMemberActive is keybase1.TeamMemberStatus ACTIVE; reset and deleted
+// accounts have no keys to encrypt to
+const teamMemberActive = 0
+
+// LookupTeam implements cache.TeamResolver with
+// `keybase team list-members --json`
+//
+// The service loads the team's sigchain and checks it before listing the
+// members, so unlike api.Client.LookupTeam the list does not have to be
+// taken on the server's word. Bots and members whose accounts were reset or
+// deleted are left out. A team the client reports as not found fails with
+// api.ErrorKindNotFound.
+func (c *Client) LookupTeam(ctx context.Context, name string) (*api.Team, error) {
+	if err := api.ValidateTeamName(name); err != nil {
+		return nil, &api.APIError{
+			Message:   fmt.Sprintf("invalid team name %q: %v", name, err),
+			Kind:      api.ErrorKindInvalidInput,
+			Temporary: false,
+		}
+	}
+	name = strings.ToLower(name)
+
+	output, err := c.run(ctx, nil, "team", "list-members", "--json", name)
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) {
+			stderr := strings.ToLower(cmdErr.Stderr)
+			if strings.Contains(stderr, "not found") || strings.Contains(stderr, "does not exist") {
+				return nil, &api.APIError{
+					Message:    fmt.Sprintf("team %q not found by the keybase CLI", name),
+					Kind:       api.ErrorKindNotFound,
+					Temporary:  false,
+					Underlying: err,
+				}
+			}
+		}
+		return nil, err
+	}
+
+	var details teamDetails
+	if err := json.Unmarshal(output, &details); err != nil {
+		return nil, &api.APIError{
+			Message:   fmt.Sprintf("failed to parse keybase team list-members output for %q: %v", name, err),
+			Kind:      api.ErrorKindInvalidResponse,
+			Temporary: false,
+		}
+	}
+	if details.Name != "" && !strings.EqualFold(details.Name, name) {
+		return nil, &api.APIError{
+			Message:   fmt.Sprintf("keybase team list-members %s returned team %q", name, details.Name),
+			Kind:      api.ErrorKindInvalidResponse,
+			Temporary: false,
+		}
+	}
+
+	team := &api.Team{Name: name, Verified: true}
+	roles := []struct {
+		role    api.TeamRole
+		members []teamMemberDetails
+	}{
+		{api.TeamRoleOwner, details.Members.Owners},
+		{api.TeamRoleAdmin, details.Members.Admins},
+		{api.TeamRoleWriter, details.Members.Writers},
+		{api.TeamRoleReader, details.Members.Readers},
+	}
+	for _, group := range roles {
+		for _, member := range group.members {
+			if member.Status != teamMemberActive {
+				continue
+			}
+			if err := api.ValidateUsername(member.Username); err != nil {
+				return nil, &api.APIError{
+					Message:   fmt.Sprintf("team %q lists invalid member %q: %v", name, member.Username, err),
+					Kind:      api.ErrorKindInvalidResponse,
+					Temporary: false,
+				}
+			}
+			team.Members = append(team.Members, api.TeamMember{Username: member.Username, Role: group.role})
+		}
+	}
+
+	return team, nil
+}
+
+// encryptFlags are passed to every `keybase encrypt`: the client would
+// otherwise also encrypt to the caller's own devices, and its default
+// authentication mode has changed between releases. Repudiable
+// authentication matches what the native engine writes.
+var encryptFlags = []string{"--no-self", "--auth-type=repudiable"}
+
+// Encrypt encrypts plaintext for recipients with `keybase encrypt`,
+// returning an armored Saltpack message
+//
+// The service encrypts to every device key of each recipient, and to no one
+// else: the caller can only decrypt the message when listed as a recipient.
+func (c *Client) Encrypt(ctx context.Context, plaintext []byte, recipients []string) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one recipient is required")
+	}
+
+	args := append([]string{"encrypt"}, encryptFlags...)
+	for _, recipient := range recipients {
+		if err := api.ValidateUsername(recipient); err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", recipient, err)
+		}
+		args = append(args, recipient)
+	}
+
+	return c.run(ctx, plaintext, args...)
+}
+
+// Decrypt decrypts a Saltpack message with `keybase decrypt`, using the
+// device keys held by the Keybase service
+//
+// A message none of the service's keys can open fails with an error that
+// wraps saltpack.ErrNoDecryptionKey.
+func (c *Client) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	plaintext, err := c.run(ctx, ciphertext, "decrypt")
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) && strings.Contains(strings.ToLower(cmdErr.Stderr), "no decryption key") {
+			return nil, fmt.Errorf("%w: %w", saltpack.ErrNoDecryptionKey, err)
+		}
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// run runs the client with args, feeding it stdin, and returns its output
+func (c *Client) run(ctx context.Context, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, c.path, args...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		return nil, &CommandError{
+			Command: args[0],
+			Stderr:  strings.TrimSpace(stderr.String()),
+			Err:     err,
+		}
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,187 @@
+//go:build unix
+
+package cli
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+)
+
+// fakeClient returns a client for the fake keybase in testdata, logging
+// its arguments to the returned file
+func fakeClient(t *testing.T) (*Client, string) {
+	t.Helper()
+
+	path, err := filepath.Abs(filepath.Join("testdata", "keybase"))
+	if err != nil {
+		t.Fatalf("Failed to find fake keybase: %v", err)
+	}
+	log := filepath.Join(t.TempDir(), "keybase.log")
+	t.Setenv("FAKE_KEYBASE_LOG", log)
+
+	client, err := NewClient(path)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client, log
+}
+
+func TestNewClientDiscoversCLI(t *testing.T) {
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatalf("Failed to find testdata: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	client, err := NewClient("")
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if client.Path() != filepath.Join(dir, "keybase") {
+		t.Errorf("Path() = %q, want the fake keybase on PATH", client.Path())
+	}
+
+	t.Setenv("PATH", t.TempDir())
+	if _, err := NewClient(""); err == nil {
+		t.Error("NewClient() without keybase on PATH should fail")
+	}
+}
+
+func TestLookupUsers(t *testing.T) {
+	client, log := fakeClient(t)
+	ctx := context.Background()
+
+	keys, err := client.LookupUsers(ctx, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("LookupUsers() error = %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("LookupUsers() returned %d keys, want 2", len(keys))
+	}
+	if keys[0].Username != "alice" || keys[0].KeyID != "0121aa0a" || keys[0].EldestKID != "0120ac0a" {
+		t.Errorf("alice = %+v", keys[0])
+	}
+	if keys[1].Username != "bob" || len(keys[1].EncryptionKIDs()) != 1 {
+		t.Errorf("bob = %+v, want one encryption KID", keys[1])
+	}
+
+	calls, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if want := "id --json alice\nid --json bob\n"; string(calls) != want {
+		t.Errorf("keybase was run with %q, want %q", calls, want)
+	}
+
+	_, err = client.LookupUsers(ctx, []string{"mallory"})
+	var apiErr *api.APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != api.ErrorKindNotFound {
+		t.Errorf("LookupUsers() with unknown user error = %v, want NotFound", err)
+	}
+
+	keys, err = client.LookupUsers(ctx, []string{"mallory", "bob"})
+	var multiErr *api.MultiLookupError
+	if !errors.As(err, &multiErr) || len(multiErr.Failures) != 1 || multiErr.Failures[0].Kind != api.ErrorKindNotFound {
+		t.Errorf("LookupUsers() with one unknown user error = %v, want a MultiLookupError for mallory", err)
+	}
+	if len(keys) != 1 || keys[0].Username != "bob" {
+		t.Errorf("LookupUsers() with one unknown user = %+v, want bob", keys)
+	}
+
+	if _, err := client.LookupUsers(ctx, []string{"--help"}); err == nil {
+		t.Error("LookupUsers() with an invalid username should fail")
+	}
+}
+
+func TestLookupTeam(t *testing.T) {
+	client, log := fakeClient(t)
+	ctx := context.Background()
+
+	team, err := client.LookupTeam(ctx, "ACME.ops")
+	if err != nil {
+		t.Fatalf("LookupTeam() error = %v", err)
+	}
+	if got := strings.Join(team.Usernames(""), ","); team.Name != "acme.ops" || got != "alice,bob" {
+		t.Errorf("LookupTeam() = %s with %s, want acme.ops with the active members alice,bob", team.Name, got)
+	}
+	if got := strings.Join(team.Usernames(api.TeamRoleOwner), ","); got != "alice" {
+		t.Errorf("owners = %s, want alice", got)
+	}
+
+	calls, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if want := "team list-members --json acme.ops\n"; string(calls) != want {
+		t.Errorf("keybase was run with %q, want %q", calls, want)
+	}
+
+	_, err = client.LookupTeam(ctx, "acme.missing")
+	var apiErr *api.APIError
+	if !errors.As(err, &apiErr) || apiErr.Kind != api.ErrorKindNotFound {
+		t.Errorf("LookupTeam() with unknown team error = %v, want NotFound", err)
+	}
+
+	if _, err := client.LookupTeam(ctx, "--json"); err == nil {
+		t.Error("LookupTeam() with an invalid team name should fail")
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	client, log := fakeClient(t)
+	ctx := context.Background()
+
+	ciphertext, err := client.Encrypt(ctx, []byte("database password"), []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	plaintext, err := client.Decrypt(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(plaintext) != "database password" {
+		t.Errorf("Decrypt() = %q, want database password", plaintext)
+	}
+
+	calls, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatalf("Failed to read log: %v", err)
+	}
+	if want := "encrypt --no-self --auth-type=repudiable alice bob\ndecrypt\n"; string(calls) != want {
+		t.Errorf("keybase was run with %q, want %q", calls, want)
+	}
+
+	if _, err := client.Encrypt(ctx, []byte("x"), nil); err == nil {
+		t.Error("Encrypt() without recipients should fail")
+	}
+	if _, err := client.Encrypt(ctx, []byte("x"), []string{"-b"}); err == nil {
+		t.Error("Encrypt() with a flag as recipient should fail")
+	}
+}
+
+func TestDecryptErrors(t *testing.T) {
+	client, _ := fakeClient(t)
+
+	_, err := client.Decrypt(context.Background(), []byte("FAKE SALTPACK FOR bob\nsecret"))
+	if !errors.Is(err, saltpack.ErrNoDecryptionKey) {
+		t.Errorf("Decrypt() error = %v, want ErrNoDecryptionKey", err)
+	}
+	var cmdErr *CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Command != "decrypt" || !strings.Contains(cmdErr.Stderr, "no decryption key") {
+		t.Errorf("Decrypt() error = %v, want a CommandError with stderr", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.Decrypt(ctx, []byte("FAKE SALTPACK FOR alice\nsecret")); !errors.Is(err, context.Canceled) {
+		t.Errorf("Decrypt() with a cancelled context error = %v, want context.Canceled", err)
+	}
+}
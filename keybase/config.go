@@ -3,11 +3,14 @@ package keybase
 import (
 	"fmt"
 	"net/url"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
 )
 
 // EncryptionFormat represents the encryption format to use
@@ -20,19 +23,111 @@ const (
 	FormatPGP EncryptionFormat = "pgp"
 )
 
+// EncryptionMode represents how Saltpack messages authenticate the sender
+type EncryptionMode string
+
+const (
+	// ModeEncrypt uses Saltpack encryption, authenticating the sender's box key (default)
+	ModeEncrypt EncryptionMode = "encrypt"
+	// ModeSigncrypt uses Saltpack signcryption, signing with the sender's Ed25519 key
+	ModeSigncrypt EncryptionMode = "signcrypt"
+)
+
+// Engine selects what encrypts and decrypts Saltpack messages
+type Engine string
+
+const (
+	// EngineNative encrypts and decrypts in process with local key files (default)
+	EngineNative Engine = "native"
+	// EngineCLI runs the local keybase client, whose service holds the device keys
+	EngineCLI Engine = "cli"
+)
+
+// DefaultSaltpackVersion is the Saltpack major version messages are written
+// with when Config.SaltpackVersion is not set
+const DefaultSaltpackVersion = 2
+
 // Config represents parsed configuration from a Keybase URL scheme
 type Config struct {
 	// Recipients is the list of Keybase usernames to encrypt for
 	Recipients []string
 
+	// Teams is the list of Keybase teams (e.g. "acme.ops") whose current
+	// members are encrypted for, given as team:<name> recipients in the URL
+	Teams []string
+
+	// TeamRole is the minimum role a team member needs to be a recipient
+	// If empty, every member of each team is a recipient
+	TeamRole api.TeamRole
+
 	// Format specifies the encryption format (saltpack or pgp)
 	Format EncryptionFormat
 
+	// Mode specifies the Saltpack message mode (encrypt or signcrypt)
+	// Signcryption proves which signing key wrote a message
+	Mode EncryptionMode
+
+	// Engine selects what encrypts and decrypts (native or cli; empty is
+	// native). The cli engine runs `keybase encrypt` and `keybase decrypt`,
+	// so no key files are needed, and looks users up with `keybase id`
+	Engine Engine
+
+	// SaltpackVersion is the Saltpack major version new messages are written
+	// with (1 or 2; 0 uses version 2). Version 1 is readable by older Keybase
+	// clients; signcryption requires version 2
+	SaltpackVersion int
+
+	// HideRecipients leaves recipient KIDs out of Saltpack message headers,
+	// so stored ciphertext does not reveal who can decrypt it. Recipients
+	// find their key by trial decryption (Saltpack only)
+	HideRecipients bool
+
+	// Envelope enables envelope encryption with the given payload cipher
+	// (secretbox or aes-gcm; empty disables it). Secrets are encrypted with a
+	// random data key and only that key is encrypted to the recipients, so
+	// Rekey rewrites the wrapped key and leaves payloads alone (Saltpack only)
+	Envelope crypto.EnvelopeCipher
+
+	// AllowedVersions lists the Saltpack major versions Decrypt accepts, so
+	// that messages downgraded to a retired version are refused
+	// If empty, every version known to the saltpack library is accepted
+	AllowedVersions []int
+
 	// CacheTTL is the time-to-live for cached public keys
 	CacheTTL time.Duration
 
 	// VerifyProofs requires identity proof verification
+	// When set, Encrypt refuses recipients that do not satisfy ProofPolicy
 	VerifyProofs bool
+
+	// ProofPolicy is the identity proof policy enforced when VerifyProofs
+	// is set. The zero value requires one verified proof of any kind
+	ProofPolicy ProofPolicy
+
+	// SenderPolicy restricts which senders Decrypt accepts messages from
+	// The zero value accepts messages from anyone, including anonymous senders
+	SenderPolicy SenderPolicy
+
+	// CachePath is the path to the public key cache file
+	// If empty, the cache package default is used
+	CachePath string
+
+	// APIConfig configures the Keybase API client
+	// If nil, api.DefaultClientConfig() is used
+	APIConfig *api.ClientConfig
+
+	// KeyDirectory is the path to a YAML or JSON key directory file (see
+	// cache.DirectoryResolver) consulted before any API. Users it does not
+	// list are looked up with KeyMirror or keybase.io
+	KeyDirectory string
+
+	// KeyMirror is the base URL of an HTTP mirror of the Keybase lookup API
+	// (e.g. "https://keys.example.com/_/api/1.0") used instead of keybase.io
+	KeyMirror string
+
+	// PGPSecretKeyPath is the path to an ASCII-armored PGP secret key used
+	// to decrypt PGP messages (e.g. from `keybase pgp export --secret`)
+	PGPSecretKeyPath string
 }
 
 // DefaultConfig returns a Config with default values
@@ -40,6 +135,7 @@ func DefaultConfig() *Config {
 	return &Config{
 		Recipients:   []string{},
 		Format:       FormatSaltpack,
+		Mode:         ModeEncrypt,
 		CacheTTL:     24 * time.Hour, // 24 hours default
 		VerifyProofs: false,
 	}
@@ -51,29 +147,60 @@ func DefaultConfig() *Config {
 //
 // Components:
 //   - Scheme: Must be "keybase"
-//   - Host/Path: Comma-separated list of recipient usernames
+//   - Host/Path: Comma-separated list of recipient usernames and team:<name> teams
 //   - Query parameters:
 //     - format: "saltpack" (default) or "pgp"
+//     - mode: "encrypt" (default) or "signcrypt" (saltpack only)
+//     - engine: "native" (default) or "cli" to encrypt and decrypt with the local keybase client
+//     - saltpack_version: Saltpack major version to encrypt with, 1 or 2 (default: 2)
+//     - allowed_versions: Comma-separated Saltpack major versions to accept when decrypting (default: all)
+//     - hide_recipients: Leave recipient KIDs out of message headers (default: false, saltpack only)
+//     - envelope: Encrypt payloads with a data key wrapped for the recipients, using "secretbox" or "aes-gcm" (saltpack only)
 //     - cache_ttl: Cache TTL in seconds (default: 86400)
 //     - verify_proofs: Require identity proof verification (default: false)
+//     - pgp_secret_key: Path to an armored PGP secret key for decrypting PGP messages
+//     - key_directory: Path to a YAML or JSON key directory consulted before the API
+//     - key_mirror: Base URL of an HTTP mirror of the Keybase API to use instead of keybase.io
+//     - proxy: http, https or socks5 proxy URL for API requests, instead of HTTPS_PROXY
+//     - ca_file: PEM bundle of extra CA certificates to trust for API requests
+//     - client_cert, client_key: PEM client certificate and key for mutual TLS with the API
+//     - proof_types: Comma-separated proof services a recipient must have (implies verify_proofs)
+//     - assert: Repeatable "user:name@service+name@service" proof assertions (implies verify_proofs)
+//     - team_role: Minimum role of team members to encrypt for (reader, writer, admin, owner)
+//     - trust_server_teams: Accept unverified team member lists from the API or mirror (default: false)
+//     - reject_anonymous: Refuse to decrypt messages from anonymous senders (default: false)
+//     - allowed_senders: Comma-separated users whose keys may have written decrypted messages
 func ParseURL(rawURL string) (*Config, error) {
+	config, _, err := parseURL(rawURL, true)
+	return config, err
+}
+
+// parseURL parses a Keybase URL and records which fields the URL set.
+// When requireRecipients is false, a URL without recipients is accepted so
+// that they can be supplied by another source (see LoadConfig).
+func parseURL(rawURL string, requireRecipients bool) (*Config, ConfigSources, error) {
 	if rawURL == "" {
-		return nil, fmt.Errorf("URL cannot be empty")
+		return nil, nil, fmt.Errorf("URL cannot be empty")
 	}
 
+	// Team recipients contain a colon that url.Parse would reject as an
+	// invalid port, so the recipient list is cut out before parsing
+	teamRecipients, rawURL := splitTeamRecipients(rawURL)
+
 	// Parse the URL
 	u, err := url.Parse(rawURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+		return nil, nil, fmt.Errorf("invalid URL: %w", err)
 	}
 
 	// Validate scheme
-	if u.Scheme != "keybase" {
-		return nil, fmt.Errorf("invalid URL scheme: expected 'keybase', got '%s'", u.Scheme)
+	if u.Scheme != Scheme {
+		return nil, nil, fmt.Errorf("invalid URL scheme: expected '%s', got '%s'", Scheme, u.Scheme)
 	}
 
 	// Start with default config
 	config := DefaultConfig()
+	sources := defaultSources()
 
 	// Extract recipients from host and path
 	// The URL format is: keybase://user1,user2,user3
@@ -93,34 +220,25 @@ func ParseURL(rawURL string) (*Config, error) {
 		recipients = strings.TrimPrefix(u.Path, "/")
 	}
 
-	if recipients == "" {
-		return nil, fmt.Errorf("no recipients specified in URL")
+	if teamRecipients != "" {
+		recipients = teamRecipients
 	}
 
-	// Split by comma and validate each username
-	recipientList := strings.Split(recipients, ",")
-	validRecipients := make([]string, 0, len(recipientList))
-
-	for _, recipient := range recipientList {
-		recipient = strings.TrimSpace(recipient)
-		if recipient == "" {
-			continue
+	if recipients != "" {
+		validRecipients, teams, err := parseRecipients(recipients)
+		if err != nil {
+			return nil, nil, err
 		}
-
-		// Validate username format
-		if err := api.ValidateUsername(recipient); err != nil {
-			return nil, fmt.Errorf("invalid recipient username '%s': %w", recipient, err)
+		if len(validRecipients) == 0 && len(teams) == 0 {
+			return nil, nil, fmt.Errorf("no valid recipients specified in URL")
 		}
-
-		validRecipients = append(validRecipients, recipient)
+		config.Recipients = validRecipients
+		config.Teams = teams
+		sources[FieldRecipients] = SourceURL
+	} else if requireRecipients {
+		return nil, nil, fmt.Errorf("no recipients specified in URL")
 	}
 
-	if len(validRecipients) == 0 {
-		return nil, fmt.Errorf("no valid recipients specified in URL")
-	}
-
-	config.Recipients = validRecipients
-
 	// Parse query parameters
 	query := u.Query()
 
@@ -128,33 +246,401 @@ func ParseURL(rawURL string) (*Config, error) {
 	if formatStr := query.Get("format"); formatStr != "" {
 		format := EncryptionFormat(strings.ToLower(formatStr))
 		if err := ValidateFormat(format); err != nil {
-			return nil, fmt.Errorf("invalid format parameter: %w", err)
+			return nil, nil, fmt.Errorf("invalid format parameter: %w", err)
 		}
 		config.Format = format
+		sources[FieldFormat] = SourceURL
+	}
+
+	// Parse mode parameter
+	if modeStr := query.Get("mode"); modeStr != "" {
+		mode := EncryptionMode(strings.ToLower(modeStr))
+		if err := ValidateMode(mode); err != nil {
+			return nil, nil, fmt.Errorf("invalid mode parameter: %w", err)
+		}
+		config.Mode = mode
+		sources[FieldMode] = SourceURL
+	}
+
+	// Parse engine parameter
+	if engineStr := query.Get("engine"); engineStr != "" {
+		engine := Engine(strings.ToLower(engineStr))
+		if err := ValidateEngine(engine); err != nil {
+			return nil, nil, fmt.Errorf("invalid engine parameter: %w", err)
+		}
+		config.Engine = engine
+		sources[FieldEngine] = SourceURL
+	}
+
+	// Signcryption is a Saltpack message type
+	if config.Mode == ModeSigncrypt && config.Format != FormatSaltpack {
+		return nil, nil, fmt.Errorf("mode=%s requires format=%s", ModeSigncrypt, FormatSaltpack)
+	}
+
+	// Parse saltpack_version parameter
+	if versionStr := query.Get("saltpack_version"); versionStr != "" {
+		version, err := parseSaltpackVersion(versionStr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid saltpack_version parameter: %w", err)
+		}
+		config.SaltpackVersion = version
+		sources[FieldSaltpackVersion] = SourceURL
+	}
+
+	// Parse allowed_versions parameter
+	if allowedVersions := query.Get("allowed_versions"); allowedVersions != "" {
+		versions, err := parseAllowedVersions(allowedVersions)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid allowed_versions parameter: %w", err)
+		}
+		config.AllowedVersions = versions
+		sources[FieldAllowedVersions] = SourceURL
+	}
+
+	// Parse hide_recipients parameter
+	if hideRecipients := query.Get("hide_recipients"); hideRecipients != "" {
+		hide, err := strconv.ParseBool(hideRecipients)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid hide_recipients parameter: %w", err)
+		}
+		config.HideRecipients = hide
+		sources[FieldHideRecipients] = SourceURL
+	}
+
+	// Parse envelope parameter
+	if envelope := query.Get("envelope"); envelope != "" {
+		envelopeCipher, err := crypto.ParseEnvelopeCipher(envelope)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid envelope parameter: %w", err)
+		}
+		config.Envelope = envelopeCipher
+		sources[FieldEnvelope] = SourceURL
+	}
+
+	if err := validateSaltpackOptions(config); err != nil {
+		return nil, nil, err
 	}
 
 	// Parse cache_ttl parameter
 	if cacheTTLStr := query.Get("cache_ttl"); cacheTTLStr != "" {
 		cacheTTLSeconds, err := strconv.ParseInt(cacheTTLStr, 10, 64)
 		if err != nil {
-			return nil, fmt.Errorf("invalid cache_ttl parameter: %w", err)
+			return nil, nil, fmt.Errorf("invalid cache_ttl parameter: %w", err)
 		}
 		if cacheTTLSeconds < 0 {
-			return nil, fmt.Errorf("cache_ttl must be non-negative, got %d", cacheTTLSeconds)
+			return nil, nil, fmt.Errorf("cache_ttl must be non-negative, got %d", cacheTTLSeconds)
 		}
 		config.CacheTTL = time.Duration(cacheTTLSeconds) * time.Second
+		sources[FieldCacheTTL] = SourceURL
 	}
 
 	// Parse verify_proofs parameter
 	if verifyProofsStr := query.Get("verify_proofs"); verifyProofsStr != "" {
 		verifyProofs, err := strconv.ParseBool(verifyProofsStr)
 		if err != nil {
-			return nil, fmt.Errorf("invalid verify_proofs parameter: %w", err)
+			return nil, nil, fmt.Errorf("invalid verify_proofs parameter: %w", err)
 		}
 		config.VerifyProofs = verifyProofs
+		sources[FieldVerifyProofs] = SourceURL
+	}
+
+	// Parse pgp_secret_key parameter
+	if pgpSecretKey := query.Get("pgp_secret_key"); pgpSecretKey != "" {
+		config.PGPSecretKeyPath = pgpSecretKey
+		sources[FieldPGPSecretKeyPath] = SourceURL
+	}
+
+	// Parse key_directory and key_mirror parameters
+	if keyDirectory := query.Get("key_directory"); keyDirectory != "" {
+		config.KeyDirectory = keyDirectory
+		sources[FieldKeyDirectory] = SourceURL
+	}
+	if keyMirror := query.Get("key_mirror"); keyMirror != "" {
+		config.KeyMirror = keyMirror
+		sources[FieldKeyMirror] = SourceURL
+	}
+
+	// Parse proxy, ca_file, client_cert and client_key parameters
+	if proxy := query.Get("proxy"); proxy != "" {
+		if _, err := api.ParseProxyURL(proxy); err != nil {
+			return nil, nil, fmt.Errorf("invalid proxy parameter: %w", err)
+		}
+		ensureAPIConfig(config).ProxyURL = proxy
+		sources[FieldAPIProxy] = SourceURL
+	}
+	if caFile := query.Get("ca_file"); caFile != "" {
+		ensureAPIConfig(config).CAFile = caFile
+		sources[FieldAPICAFile] = SourceURL
+	}
+	if clientCert := query.Get("client_cert"); clientCert != "" {
+		ensureAPIConfig(config).ClientCertFile = clientCert
+		sources[FieldAPIClientCert] = SourceURL
+	}
+	if clientKey := query.Get("client_key"); clientKey != "" {
+		ensureAPIConfig(config).ClientKeyFile = clientKey
+		sources[FieldAPIClientKey] = SourceURL
+	}
+
+	// Parse team_role parameter
+	if teamRole := query.Get("team_role"); teamRole != "" {
+		role, err := api.ParseTeamRole(teamRole)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid team_role parameter: %w", err)
+		}
+		config.TeamRole = role
+		sources[FieldTeamRole] = SourceURL
+	}
+
+	// Parse trust_server_teams parameter
+	if trustTeams := query.Get("trust_server_teams"); trustTeams != "" {
+		trust, err := strconv.ParseBool(trustTeams)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid trust_server_teams parameter: %w", err)
+		}
+		ensureAPIConfig(config).TrustServerTeams = trust
+		sources[FieldAPITrustServerTeams] = SourceURL
+	}
+
+	// Parse proof_types parameter
+	if proofTypes := query.Get("proof_types"); proofTypes != "" {
+		types, err := parseProofTypes(proofTypes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid proof_types parameter: %w", err)
+		}
+		config.ProofPolicy.RequiredTypes = types
+		sources[FieldProofPolicy] = SourceURL
 	}
 
-	return config, nil
+	// Parse assert parameters
+	for _, value := range query["assert"] {
+		username, assertions, err := parseProofAssertion(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid assert parameter: %w", err)
+		}
+		if config.ProofPolicy.Assertions == nil {
+			config.ProofPolicy.Assertions = make(map[string][]string)
+		}
+		config.ProofPolicy.Assertions[username] = append(config.ProofPolicy.Assertions[username], assertions...)
+		sources[FieldProofPolicy] = SourceURL
+	}
+
+	// Parse reject_anonymous parameter
+	if rejectAnonymous := query.Get("reject_anonymous"); rejectAnonymous != "" {
+		reject, err := strconv.ParseBool(rejectAnonymous)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid reject_anonymous parameter: %w", err)
+		}
+		config.SenderPolicy.RejectAnonymous = reject
+		sources[FieldSenderPolicy] = SourceURL
+	}
+
+	// Parse allowed_senders parameter
+	if allowedSenders := query.Get("allowed_senders"); allowedSenders != "" {
+		senders, err := parseAllowedSenders(allowedSenders)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid allowed_senders parameter: %w", err)
+		}
+		config.SenderPolicy.AllowedSenders = senders
+		sources[FieldSenderPolicy] = SourceURL
+	}
+
+	// A proof policy is meaningless without proof verification
+	if sources[FieldProofPolicy] == SourceURL && query.Get("verify_proofs") == "" {
+		config.VerifyProofs = true
+		sources[FieldVerifyProofs] = SourceURL
+	}
+
+	if err := validateEngineOptions(config); err != nil {
+		return nil, nil, err
+	}
+	if err := validateEnvelopeOptions(config); err != nil {
+		return nil, nil, err
+	}
+
+	// A client certificate and key are checked as a pair, and assertions
+	// against the recipients, once every source has been applied, since
+	// LoadConfig may take either from the environment
+	if requireRecipients {
+		if err := validateNetworkOptions(config); err != nil {
+			return nil, nil, err
+		}
+		if err := validateProofAssertions(config); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return config, sources, nil
+}
+
+// parseRecipients splits a comma-separated recipient list into usernames
+// and team names (team:<name> entries), validating each. Empty entries are
+// skipped.
+func parseRecipients(list string) ([]string, []string, error) {
+	recipientList := strings.Split(list, ",")
+	validRecipients := make([]string, 0, len(recipientList))
+	var teams []string
+
+	for _, recipient := range recipientList {
+		recipient = strings.TrimSpace(recipient)
+		if recipient == "" {
+			continue
+		}
+
+		if team, ok := strings.CutPrefix(recipient, teamPrefix); ok {
+			if err := api.ValidateTeamName(team); err != nil {
+				return nil, nil, fmt.Errorf("invalid recipient team '%s': %w", team, err)
+			}
+			teams = append(teams, team)
+			continue
+		}
+
+		// Validate username format
+		if err := api.ValidateUsername(recipient); err != nil {
+			return nil, nil, fmt.Errorf("invalid recipient username '%s': %w", recipient, err)
+		}
+
+		validRecipients = append(validRecipients, recipient)
+	}
+
+	return validRecipients, teams, nil
+}
+
+// parseSaltpackVersion parses a Saltpack major version
+func parseSaltpackVersion(value string) (int, error) {
+	major, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("saltpack version must be a number: %w", err)
+	}
+	if _, err := crypto.ParseVersion(major); err != nil {
+		return 0, err
+	}
+	return major, nil
+}
+
+// parseAllowedVersions parses a comma-separated list of Saltpack major
+// versions, dropping duplicates
+func parseAllowedVersions(list string) ([]int, error) {
+	var versions []int
+	seen := make(map[int]bool)
+	for _, value := range strings.Split(list, ",") {
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+		major, err := parseSaltpackVersion(value)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[major] {
+			seen[major] = true
+			versions = append(versions, major)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no versions specified")
+	}
+	return versions, nil
+}
+
+// validateSaltpackOptions checks that Saltpack-only options are not used
+// with another format, and that the version messages are written with can be
+// used by the configured mode and read back under AllowedVersions
+func validateSaltpackOptions(config *Config) error {
+	if config.Format != FormatSaltpack {
+		if config.HideRecipients {
+			return fmt.Errorf("hide_recipients requires format=%s", FormatSaltpack)
+		}
+		if config.Envelope != "" {
+			return fmt.Errorf("envelope requires format=%s", FormatSaltpack)
+		}
+		return nil
+	}
+
+	version := config.SaltpackVersion
+	if version == 0 {
+		version = DefaultSaltpackVersion
+	}
+
+	if config.Mode == ModeSigncrypt && version != 2 {
+		return fmt.Errorf("mode=%s requires saltpack_version=2", ModeSigncrypt)
+	}
+
+	if len(config.AllowedVersions) > 0 && !slices.Contains(config.AllowedVersions, version) {
+		return fmt.Errorf("saltpack_version=%d is not in allowed_versions %v", version, config.AllowedVersions)
+	}
+
+	return nil
+}
+
+// validateEngineOptions checks that the cli engine is not combined with
+// options the keybase client cannot honour
+//
+// `keybase encrypt` chooses the message format itself, and `keybase decrypt`
+// reports neither the sender key nor the Saltpack version to check them.
+func validateEngineOptions(config *Config) error {
+	if config.Engine != EngineCLI {
+		return nil
+	}
+
+	switch {
+	case config.Format != FormatSaltpack:
+		return fmt.Errorf("engine=%s requires format=%s", EngineCLI, FormatSaltpack)
+	case config.Mode == ModeSigncrypt:
+		return fmt.Errorf("engine=%s does not support mode=%s", EngineCLI, ModeSigncrypt)
+	case config.SaltpackVersion != 0:
+		return fmt.Errorf("engine=%s does not support saltpack_version", EngineCLI)
+	case len(config.AllowedVersions) > 0:
+		return fmt.Errorf("engine=%s does not support allowed_versions", EngineCLI)
+	case config.HideRecipients:
+		return fmt.Errorf("engine=%s does not support hide_recipients", EngineCLI)
+	case config.Envelope != "":
+		return fmt.Errorf("engine=%s does not support envelope", EngineCLI)
+	case config.SenderPolicy.Enabled():
+		return fmt.Errorf("engine=%s does not support reject_anonymous or allowed_senders", EngineCLI)
+	}
+
+	return nil
+}
+
+// validateEnvelopeOptions refuses envelopes under a sender policy: the
+// policy can only check who wrapped the data key, not who sealed the
+// payload (see Keeper.unwrapDataKey)
+func validateEnvelopeOptions(config *Config) error {
+	if config.Envelope != "" && config.SenderPolicy.Enabled() {
+		return fmt.Errorf("envelope cannot be combined with reject_anonymous or allowed_senders")
+	}
+	return nil
+}
+
+// validateNetworkOptions checks that a client certificate comes with its
+// key. The files themselves are loaded by NewKeeper.
+func validateNetworkOptions(config *Config) error {
+	if config.APIConfig == nil {
+		return nil
+	}
+	if (config.APIConfig.ClientCertFile == "") != (config.APIConfig.ClientKeyFile == "") {
+		return fmt.Errorf("client_cert and client_key must be set together")
+	}
+	return nil
+}
+
+// teamPrefix marks a team recipient, as in keybase://team:acme.ops
+const teamPrefix = "team:"
+
+// splitTeamRecipients cuts the recipient list out of a keybase:// URL that
+// contains team recipients, returning it and the URL without it. URLs
+// without team recipients are returned unchanged.
+func splitTeamRecipients(rawURL string) (string, string) {
+	rest, ok := strings.CutPrefix(rawURL, Scheme+"://")
+	if !ok {
+		return "", rawURL
+	}
+
+	recipients, query, _ := strings.Cut(rest, "?")
+	if !strings.Contains(recipients, teamPrefix) {
+		return "", rawURL
+	}
+
+	return recipients, Scheme + "://?" + query
 }
 
 // ValidateFormat validates that the encryption format is supported
@@ -167,6 +653,26 @@ func ValidateFormat(format EncryptionFormat) error {
 	}
 }
 
+// ValidateMode validates that the Saltpack message mode is supported
+func ValidateMode(mode EncryptionMode) error {
+	switch mode {
+	case ModeEncrypt, ModeSigncrypt:
+		return nil
+	default:
+		return fmt.Errorf("unsupported mode '%s': must be 'encrypt' or 'signcrypt'", mode)
+	}
+}
+
+// ValidateEngine validates that the engine is supported
+func ValidateEngine(engine Engine) error {
+	switch engine {
+	case EngineNative, EngineCLI:
+		return nil
+	default:
+		return fmt.Errorf("unsupported engine '%s': must be 'native' or 'cli'", engine)
+	}
+}
+
 // String returns a string representation of the Config
 func (c *Config) String() string {
 	return fmt.Sprintf("Config{Recipients: %v, Format: %s, CacheTTL: %s, VerifyProofs: %t}",
@@ -176,9 +682,15 @@ func (c *Config) String() string {
 // ToURL converts a Config back to a URL string
 func (c *Config) ToURL() string {
 	recipients := strings.Join(c.Recipients, ",")
+	for _, team := range c.Teams {
+		if recipients != "" {
+			recipients += ","
+		}
+		recipients += teamPrefix + team
+	}
 	
 	u := &url.URL{
-		Scheme: "keybase",
+		Scheme: Scheme,
 		Host:   recipients,
 	}
 
@@ -187,7 +699,34 @@ func (c *Config) ToURL() string {
 	if c.Format != FormatSaltpack {
 		query.Set("format", string(c.Format))
 	}
+
+	if c.Mode != "" && c.Mode != ModeEncrypt {
+		query.Set("mode", string(c.Mode))
+	}
+
+	if c.Engine != "" && c.Engine != EngineNative {
+		query.Set("engine", string(c.Engine))
+	}
 	
+	if c.SaltpackVersion != 0 {
+		query.Set("saltpack_version", strconv.Itoa(c.SaltpackVersion))
+	}
+
+	if len(c.AllowedVersions) > 0 {
+		versions := make([]string, 0, len(c.AllowedVersions))
+		for _, version := range c.AllowedVersions {
+			versions = append(versions, strconv.Itoa(version))
+		}
+		query.Set("allowed_versions", strings.Join(versions, ","))
+	}
+	
+	if c.HideRecipients {
+		query.Set("hide_recipients", "true")
+	}
+	if c.Envelope != "" {
+		query.Set("envelope", string(c.Envelope))
+	}
+
 	if c.CacheTTL != 24*time.Hour {
 		query.Set("cache_ttl", strconv.FormatInt(int64(c.CacheTTL.Seconds()), 10))
 	}
@@ -195,6 +734,60 @@ func (c *Config) ToURL() string {
 	if c.VerifyProofs {
 		query.Set("verify_proofs", "true")
 	}
+	
+	if c.PGPSecretKeyPath != "" {
+		query.Set("pgp_secret_key", c.PGPSecretKeyPath)
+	}
+
+	if c.KeyDirectory != "" {
+		query.Set("key_directory", c.KeyDirectory)
+	}
+	if c.KeyMirror != "" {
+		query.Set("key_mirror", c.KeyMirror)
+	}
+
+	if c.APIConfig != nil {
+		if c.APIConfig.ProxyURL != "" {
+			query.Set("proxy", c.APIConfig.ProxyURL)
+		}
+		if c.APIConfig.CAFile != "" {
+			query.Set("ca_file", c.APIConfig.CAFile)
+		}
+		if c.APIConfig.ClientCertFile != "" {
+			query.Set("client_cert", c.APIConfig.ClientCertFile)
+		}
+		if c.APIConfig.ClientKeyFile != "" {
+			query.Set("client_key", c.APIConfig.ClientKeyFile)
+		}
+		if c.APIConfig.TrustServerTeams {
+			query.Set("trust_server_teams", "true")
+		}
+	}
+
+	if c.TeamRole != "" {
+		query.Set("team_role", string(c.TeamRole))
+	}
+
+	if len(c.ProofPolicy.RequiredTypes) > 0 {
+		query.Set("proof_types", strings.Join(c.ProofPolicy.RequiredTypes, ","))
+	}
+
+	if c.SenderPolicy.RejectAnonymous {
+		query.Set("reject_anonymous", "true")
+	}
+
+	if len(c.SenderPolicy.AllowedSenders) > 0 {
+		query.Set("allowed_senders", strings.Join(c.SenderPolicy.AllowedSenders, ","))
+	}
+
+	usernames := make([]string, 0, len(c.ProofPolicy.Assertions))
+	for username := range c.ProofPolicy.Assertions {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+	for _, username := range usernames {
+		query.Add("assert", username+":"+strings.Join(c.ProofPolicy.Assertions[username], "+"))
+	}
 
 	u.RawQuery = query.Encode()
 	
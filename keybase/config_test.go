@@ -1,8 +1,13 @@
 package keybase
 
 import (
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
 )
 
 func TestParseURL(t *testing.T) {
@@ -569,3 +574,401 @@ func indexOf(s, substr string) int {
 func hasPrefix(s, prefix string) bool {
 	return len(s) >= len(prefix) && s[:len(prefix)] == prefix
 }
+
+func TestParseURLTeams(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		wantRecipients []string
+		wantTeams      []string
+		wantRole       api.TeamRole
+		wantErr        bool
+	}{
+		{
+			name:      "team only",
+			url:       "keybase://team:acme.ops",
+			wantTeams: []string{"acme.ops"},
+		},
+		{
+			name:           "users and teams with a role filter",
+			url:            "keybase://alice,team:acme.ops,team:acme?team_role=Writer&format=pgp",
+			wantRecipients: []string{"alice"},
+			wantTeams:      []string{"acme.ops", "acme"},
+			wantRole:       api.TeamRoleWriter,
+		},
+		{
+			name:    "invalid team name",
+			url:     "keybase://team:acme..ops",
+			wantErr: true,
+		},
+		{
+			name:    "empty team name",
+			url:     "keybase://alice,team:",
+			wantErr: true,
+		},
+		{
+			name:    "unknown team role",
+			url:     "keybase://team:acme?team_role=bot",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if strings.Join(config.Recipients, ",") != strings.Join(tt.wantRecipients, ",") {
+				t.Errorf("Recipients = %v, want %v", config.Recipients, tt.wantRecipients)
+			}
+			if strings.Join(config.Teams, ",") != strings.Join(tt.wantTeams, ",") {
+				t.Errorf("Teams = %v, want %v", config.Teams, tt.wantTeams)
+			}
+			if config.TeamRole != tt.wantRole {
+				t.Errorf("TeamRole = %q, want %q", config.TeamRole, tt.wantRole)
+			}
+
+			// Team recipients survive a round trip through ToURL
+			roundTrip, err := ParseURL(config.ToURL())
+			if err != nil {
+				t.Fatalf("ParseURL(ToURL()) error = %v", err)
+			}
+			if strings.Join(roundTrip.Teams, ",") != strings.Join(config.Teams, ",") || roundTrip.TeamRole != config.TeamRole {
+				t.Errorf("round trip = %v %q, want %v %q", roundTrip.Teams, roundTrip.TeamRole, config.Teams, config.TeamRole)
+			}
+		})
+	}
+}
+
+func TestParseURLTrustServerTeams(t *testing.T) {
+	config, err := ParseURL("keybase://team:acme.ops")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if config.APIConfig != nil && config.APIConfig.TrustServerTeams {
+		t.Error("TrustServerTeams is set by default")
+	}
+
+	config, err = ParseURL("keybase://team:acme.ops?trust_server_teams=true")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if config.APIConfig == nil || !config.APIConfig.TrustServerTeams {
+		t.Fatal("TrustServerTeams not set from trust_server_teams=true")
+	}
+	if !strings.Contains(config.ToURL(), "trust_server_teams=true") {
+		t.Errorf("ToURL() = %q, want trust_server_teams=true", config.ToURL())
+	}
+
+	if _, err := ParseURL("keybase://team:acme.ops?trust_server_teams=sometimes"); err == nil {
+		t.Error("ParseURL() accepted an invalid trust_server_teams value")
+	}
+}
+
+func TestParseURLMode(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		wantMode EncryptionMode
+		wantErr  bool
+	}{
+		{name: "default", url: "keybase://alice", wantMode: ModeEncrypt},
+		{name: "encrypt", url: "keybase://alice?mode=encrypt", wantMode: ModeEncrypt},
+		{name: "signcrypt", url: "keybase://alice?mode=SignCrypt", wantMode: ModeSigncrypt},
+		{name: "unknown mode", url: "keybase://alice?mode=sign", wantErr: true},
+		{name: "signcrypt with pgp", url: "keybase://alice?mode=signcrypt&format=pgp", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if config.Mode != tt.wantMode {
+				t.Errorf("Mode = %s, want %s", config.Mode, tt.wantMode)
+			}
+
+			// The mode survives a round trip, and the default is omitted
+			parsed, err := ParseURL(config.ToURL())
+			if err != nil {
+				t.Fatalf("ParseURL(ToURL()) error = %v", err)
+			}
+			if parsed.Mode != tt.wantMode {
+				t.Errorf("round trip Mode = %s, want %s", parsed.Mode, tt.wantMode)
+			}
+			if tt.wantMode == ModeEncrypt && strings.Contains(config.ToURL(), "mode=") {
+				t.Errorf("ToURL() = %s, want the default mode omitted", config.ToURL())
+			}
+		})
+	}
+
+	// KEYBASE_FORMAT cannot switch a signcrypt URL to PGP
+	lookupEnv := func(name string) (string, bool) {
+		if name == EnvFormat {
+			return "pgp", true
+		}
+		return "", false
+	}
+	if _, _, err := loadConfig("keybase://alice?mode=signcrypt", lookupEnv); err == nil {
+		t.Error("loadConfig() with mode=signcrypt and KEYBASE_FORMAT=pgp should fail")
+	}
+}
+
+func TestParseURLSaltpackVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		url         string
+		wantVersion int
+		wantAllowed []int
+		wantErr     bool
+	}{
+		{name: "default", url: "keybase://alice"},
+		{name: "version 1", url: "keybase://alice?saltpack_version=1", wantVersion: 1},
+		{name: "version 2", url: "keybase://alice?saltpack_version=2", wantVersion: 2},
+		{name: "allowed versions", url: "keybase://alice?allowed_versions=2,1,2", wantAllowed: []int{2, 1}},
+		{name: "version in allowed versions", url: "keybase://alice?saltpack_version=1&allowed_versions=1", wantVersion: 1, wantAllowed: []int{1}},
+		{name: "unknown version", url: "keybase://alice?saltpack_version=3", wantErr: true},
+		{name: "non-numeric version", url: "keybase://alice?saltpack_version=two", wantErr: true},
+		{name: "unknown allowed version", url: "keybase://alice?allowed_versions=2,3", wantErr: true},
+		{name: "empty allowed versions", url: "keybase://alice?allowed_versions=,", wantErr: true},
+		{name: "version not allowed", url: "keybase://alice?saltpack_version=1&allowed_versions=2", wantErr: true},
+		{name: "default version not allowed", url: "keybase://alice?allowed_versions=1", wantErr: true},
+		{name: "signcrypt with version 1", url: "keybase://alice?mode=signcrypt&saltpack_version=1", wantErr: true},
+		{name: "pgp ignores allowed versions", url: "keybase://alice?format=pgp&allowed_versions=1", wantAllowed: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if config.SaltpackVersion != tt.wantVersion {
+				t.Errorf("SaltpackVersion = %d, want %d", config.SaltpackVersion, tt.wantVersion)
+			}
+			if !reflect.DeepEqual(config.AllowedVersions, tt.wantAllowed) {
+				t.Errorf("AllowedVersions = %v, want %v", config.AllowedVersions, tt.wantAllowed)
+			}
+
+			// The versions survive a round trip
+			parsed, err := ParseURL(config.ToURL())
+			if err != nil {
+				t.Fatalf("ParseURL(ToURL()) error = %v", err)
+			}
+			if parsed.SaltpackVersion != tt.wantVersion || !reflect.DeepEqual(parsed.AllowedVersions, tt.wantAllowed) {
+				t.Errorf("round trip = %d %v, want %d %v", parsed.SaltpackVersion, parsed.AllowedVersions, tt.wantVersion, tt.wantAllowed)
+			}
+		})
+	}
+
+	// The environment is validated together with the URL
+	env := mapEnv(map[string]string{EnvSaltpackVersion: "1"})
+	if _, _, err := loadConfig("keybase://alice?allowed_versions=2", env); err == nil {
+		t.Error("loadConfig() with KEYBASE_SALTPACK_VERSION outside allowed_versions should fail")
+	}
+
+	config, sources, err := loadConfig("keybase://alice?saltpack_version=2", mapEnv(map[string]string{
+		EnvSaltpackVersion: "1",
+		EnvAllowedVersions: "1, 2",
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.SaltpackVersion != 1 || !reflect.DeepEqual(config.AllowedVersions, []int{1, 2}) {
+		t.Errorf("loadConfig() versions = %d %v, want 1 [1 2]", config.SaltpackVersion, config.AllowedVersions)
+	}
+	if sources[FieldSaltpackVersion] != SourceEnv || sources[FieldAllowedVersions] != SourceEnv {
+		t.Errorf("sources = %v, want versions from the environment", sources)
+	}
+}
+
+func TestParseURLHideRecipients(t *testing.T) {
+	config, err := ParseURL("keybase://alice,bob?hide_recipients=true")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if !config.HideRecipients {
+		t.Error("HideRecipients = false, want true")
+	}
+	if !strings.Contains(config.ToURL(), "hide_recipients=true") {
+		t.Errorf("ToURL() = %s, want hide_recipients=true", config.ToURL())
+	}
+
+	config, err = ParseURL("keybase://alice")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if config.HideRecipients || strings.Contains(config.ToURL(), "hide_recipients") {
+		t.Errorf("HideRecipients = %t, ToURL() = %s, want off by default", config.HideRecipients, config.ToURL())
+	}
+
+	for _, url := range []string{
+		"keybase://alice?hide_recipients=maybe",
+		"keybase://alice?hide_recipients=true&format=pgp",
+	} {
+		if _, err := ParseURL(url); err == nil {
+			t.Errorf("ParseURL(%q) should fail", url)
+		}
+	}
+
+	config, sources, err := loadConfig("keybase://alice", mapEnv(map[string]string{EnvHideRecipients: "yes"}))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if !config.HideRecipients || sources[FieldHideRecipients] != SourceEnv {
+		t.Errorf("HideRecipients = %t from %s, want true from env", config.HideRecipients, sources[FieldHideRecipients])
+	}
+	if _, _, err := loadConfig("keybase://alice?hide_recipients=true", mapEnv(map[string]string{EnvFormat: "pgp"})); err == nil {
+		t.Error("loadConfig() with hide_recipients and KEYBASE_FORMAT=pgp should fail")
+	}
+}
+
+func TestParseURLEnvelope(t *testing.T) {
+	config, err := ParseURL("keybase://alice,bob?envelope=AES-GCM")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if config.Envelope != crypto.EnvelopeAESGCM {
+		t.Errorf("Envelope = %q, want %q", config.Envelope, crypto.EnvelopeAESGCM)
+	}
+	if !strings.Contains(config.ToURL(), "envelope=aes-gcm") {
+		t.Errorf("ToURL() = %s, want envelope=aes-gcm", config.ToURL())
+	}
+
+	config, err = ParseURL("keybase://alice")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if config.Envelope != "" || strings.Contains(config.ToURL(), "envelope") {
+		t.Errorf("Envelope = %q, ToURL() = %s, want off by default", config.Envelope, config.ToURL())
+	}
+
+	for _, url := range []string{
+		"keybase://alice?envelope=rot13",
+		"keybase://alice?envelope=secretbox&format=pgp",
+	} {
+		if _, err := ParseURL(url); err == nil {
+			t.Errorf("ParseURL(%q) should fail", url)
+		}
+	}
+
+	config, sources, err := loadConfig("keybase://alice", mapEnv(map[string]string{EnvEnvelope: "secretbox"}))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Envelope != crypto.EnvelopeSecretbox || sources[FieldEnvelope] != SourceEnv {
+		t.Errorf("Envelope = %q from %s, want secretbox from env", config.Envelope, sources[FieldEnvelope])
+	}
+	if _, _, err := loadConfig("keybase://alice?envelope=secretbox", mapEnv(map[string]string{EnvFormat: "pgp"})); err == nil {
+		t.Error("loadConfig() with envelope and KEYBASE_FORMAT=pgp should fail")
+	}
+}
+
+func TestParseURLKeySources(t *testing.T) {
+	config, err := ParseURL("keybase://alice?key_directory=%2Fetc%2Fkeybase%2Fkeys.yaml&key_mirror=https%3A%2F%2Fkeys.example.com%2F_%2Fapi%2F1.0")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if config.KeyDirectory != "/etc/keybase/keys.yaml" {
+		t.Errorf("KeyDirectory = %q, want /etc/keybase/keys.yaml", config.KeyDirectory)
+	}
+	if config.KeyMirror != "https://keys.example.com/_/api/1.0" {
+		t.Errorf("KeyMirror = %q, want https://keys.example.com/_/api/1.0", config.KeyMirror)
+	}
+
+	roundTrip, err := ParseURL(config.ToURL())
+	if err != nil {
+		t.Fatalf("ParseURL(ToURL()) error = %v", err)
+	}
+	if roundTrip.KeyDirectory != config.KeyDirectory || roundTrip.KeyMirror != config.KeyMirror {
+		t.Errorf("round trip = (%q, %q), want (%q, %q)", roundTrip.KeyDirectory, roundTrip.KeyMirror, config.KeyDirectory, config.KeyMirror)
+	}
+}
+
+func TestParseURLNetworkOptions(t *testing.T) {
+	config, err := ParseURL("keybase://alice?proxy=http://proxy.corp.example:3128&ca_file=/etc/pki/corp-ca.pem&client_cert=/etc/pki/agent.pem&client_key=/etc/pki/agent-key.pem")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	want := api.ClientConfig{
+		ProxyURL:       "http://proxy.corp.example:3128",
+		CAFile:         "/etc/pki/corp-ca.pem",
+		ClientCertFile: "/etc/pki/agent.pem",
+		ClientKeyFile:  "/etc/pki/agent-key.pem",
+	}
+	got := config.APIConfig
+	if got == nil || got.ProxyURL != want.ProxyURL || got.CAFile != want.CAFile || got.ClientCertFile != want.ClientCertFile || got.ClientKeyFile != want.ClientKeyFile {
+		t.Fatalf("APIConfig = %+v, want the proxy, CA file and client certificate", got)
+	}
+	if got.Timeout != api.DefaultTimeout {
+		t.Errorf("APIConfig.Timeout = %v, want the default", got.Timeout)
+	}
+
+	roundTrip, err := ParseURL(config.ToURL())
+	if err != nil {
+		t.Fatalf("ParseURL(ToURL()) error = %v", err)
+	}
+	if !reflect.DeepEqual(roundTrip.APIConfig, got) {
+		t.Errorf("round trip APIConfig = %+v, want %+v", roundTrip.APIConfig, got)
+	}
+
+	for _, url := range []string{
+		"keybase://alice?proxy=ftp://proxy.corp.example",
+		"keybase://alice?proxy=proxy.corp.example:3128",
+		"keybase://alice?client_cert=/etc/pki/agent.pem",
+		"keybase://alice?client_key=/etc/pki/agent-key.pem",
+	} {
+		if _, err := ParseURL(url); err == nil {
+			t.Errorf("ParseURL(%q) should fail", url)
+		}
+	}
+}
+
+func TestParseURLEngine(t *testing.T) {
+	config, err := ParseURL("keybase://alice?engine=CLI")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	if config.Engine != EngineCLI {
+		t.Errorf("Engine = %q, want %q", config.Engine, EngineCLI)
+	}
+	if !strings.Contains(config.ToURL(), "engine=cli") {
+		t.Errorf("ToURL() = %s, want engine=cli", config.ToURL())
+	}
+
+	for _, url := range []string{
+		"keybase://alice?engine=gpg",
+		"keybase://alice?engine=cli&format=pgp",
+		"keybase://alice?engine=cli&mode=signcrypt",
+		"keybase://alice?engine=cli&envelope=secretbox",
+		"keybase://alice?engine=cli&hide_recipients=true",
+		"keybase://alice?engine=cli&allowed_senders=bob",
+	} {
+		if _, err := ParseURL(url); err == nil {
+			t.Errorf("ParseURL(%q) should fail", url)
+		}
+	}
+
+	config, sources, err := loadConfig("keybase://alice", mapEnv(map[string]string{EnvEngine: "cli"}))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	if config.Engine != EngineCLI || sources[FieldEngine] != SourceEnv {
+		t.Errorf("Engine = %q from %s, want cli from env", config.Engine, sources[FieldEngine])
+	}
+	if _, _, err := loadConfig("keybase://alice?format=pgp", mapEnv(map[string]string{EnvEngine: "cli"})); err == nil {
+		t.Error("loadConfig() with format=pgp and KEYBASE_ENGINE=cli should fail")
+	}
+}
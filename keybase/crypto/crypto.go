@@ -15,12 +15,24 @@ type Encryptor struct {
 	
 	// SenderKey is the sender's secret key (optional for encryption)
 	SenderKey saltpack.BoxSecretKey
+	
+	// SigningKey is the sender's Ed25519 signing key used by the Signcrypt
+	// methods (nil for an anonymous signcryption sender)
+	SigningKey saltpack.SigningSecretKey
+	
+	// HideRecipients leaves receiver KIDs out of encrypted message headers
+	// (see HiddenReceiver)
+	HideRecipients bool
 }
 
 // Decryptor handles decryption operations using Saltpack
 type Decryptor struct {
 	// Keyring provides access to secret keys for decryption
 	Keyring saltpack.Keyring
+	
+	// AllowedVersions lists the Saltpack major versions accepted when
+	// decrypting (empty accepts every version known to the saltpack library)
+	AllowedVersions []saltpack.Version
 }
 
 // EncryptorConfig holds configuration for the Encryptor
@@ -30,12 +42,25 @@ type EncryptorConfig struct {
 	
 	// SenderKey is the sender's secret key (nil for anonymous sender)
 	SenderKey saltpack.BoxSecretKey
+	
+	// SigningKey is the sender's Ed25519 signing key for signcryption
+	SigningKey saltpack.SigningSecretKey
+	
+	// HideRecipients makes every receiver of encrypted messages hidden, so
+	// headers do not reveal who can decrypt them. Signcrypted messages never
+	// list receiver KIDs, so it has no effect on them
+	HideRecipients bool
 }
 
 // DecryptorConfig holds configuration for the Decryptor
 type DecryptorConfig struct {
 	// Keyring provides access to secret keys for decryption
 	Keyring saltpack.Keyring
+	
+	// AllowedVersions restricts the Saltpack versions accepted when
+	// decrypting, e.g. to refuse messages downgraded to a retired version
+	// (nil accepts every known version)
+	AllowedVersions []saltpack.Version
 }
 
 // NewEncryptor creates a new Encryptor with the given configuration
@@ -52,8 +77,10 @@ func NewEncryptor(config *EncryptorConfig) (*Encryptor, error) {
 	}
 	
 	return &Encryptor{
-		Version:   version,
-		SenderKey: config.SenderKey,
+		Version:        version,
+		SenderKey:      config.SenderKey,
+		SigningKey:     config.SigningKey,
+		HideRecipients: config.HideRecipients,
 	}, nil
 }
 
@@ -64,7 +91,8 @@ func NewDecryptor(config *DecryptorConfig) (*Decryptor, error) {
 	}
 	
 	return &Decryptor{
-		Keyring: config.Keyring,
+		Keyring:         config.Keyring,
+		AllowedVersions: config.AllowedVersions,
 	}, nil
 }
 
@@ -105,7 +133,7 @@ func (e *Encryptor) Encrypt(plaintext []byte, receivers []saltpack.BoxPublicKey)
 	}
 	
 	// Use Saltpack Seal to encrypt for multiple recipients
-	ciphertext, err := saltpack.Seal(*e.Version, plaintext, e.SenderKey, receivers)
+	ciphertext, err := saltpack.Seal(*e.Version, plaintext, e.SenderKey, e.receivers(receivers))
 	if err != nil {
 		return nil, fmt.Errorf("encryption failed: %w", err)
 	}
@@ -113,6 +141,20 @@ func (e *Encryptor) Encrypt(plaintext []byte, receivers []saltpack.BoxPublicKey)
 	return ciphertext, nil
 }
 
+// receivers returns the receivers to write into a message header, hidden if
+// HideRecipients is set
+func (e *Encryptor) receivers(receivers []saltpack.BoxPublicKey) []saltpack.BoxPublicKey {
+	if !e.HideRecipients {
+		return receivers
+	}
+	
+	hidden := make([]saltpack.BoxPublicKey, len(receivers))
+	for i, receiver := range receivers {
+		hidden[i] = HiddenReceiver(receiver)
+	}
+	return hidden
+}
+
 // EncryptArmored encrypts plaintext and returns ASCII-armored output.
 //
 // ARMORING STRATEGY DECISION:
@@ -153,7 +195,7 @@ func (e *Encryptor) EncryptArmored(plaintext []byte, receivers []saltpack.BoxPub
 	// - No special characters (+, /, =) that need escaping
 	// - URL-safe and filesystem-safe (no / character)
 	// - No ambiguous characters (0 vs O, 1 vs l)
-	ciphertext, err := saltpack.EncryptArmor62Seal(*e.Version, plaintext, e.SenderKey, receivers, "")
+	ciphertext, err := saltpack.EncryptArmor62Seal(*e.Version, plaintext, e.SenderKey, e.receivers(receivers), "")
 	if err != nil {
 		return "", fmt.Errorf("armored encryption failed: %w", err)
 	}
@@ -169,7 +211,7 @@ func (e *Encryptor) EncryptStream(plaintext io.Reader, ciphertext io.Writer, rec
 	}
 	
 	// Create streaming encryptor
-	stream, err := saltpack.NewEncryptStream(*e.Version, ciphertext, e.SenderKey, receivers)
+	stream, err := saltpack.NewEncryptStream(*e.Version, ciphertext, e.SenderKey, e.receivers(receivers))
 	if err != nil {
 		return fmt.Errorf("failed to create encrypt stream: %w", err)
 	}
@@ -208,9 +250,9 @@ func (e *Encryptor) EncryptStreamArmored(plaintext io.Reader, ciphertext io.Writ
 	}
 	
 	// Create streaming encryptor with Base62 armoring
-	stream, err := saltpack.NewEncryptArmor62Stream(*e.Version, ciphertext, e.SenderKey, receivers, "")
+	stream, err := e.NewEncryptStreamArmored(ciphertext, receivers)
 	if err != nil {
-		return fmt.Errorf("failed to create armored encrypt stream: %w", err)
+		return err
 	}
 	
 	// Copy plaintext to the encryption stream
@@ -240,7 +282,7 @@ func (d *Decryptor) Decrypt(ciphertext []byte) ([]byte, *saltpack.MessageKeyInfo
 	
 	// Use Saltpack Open to decrypt
 	// The keyring automatically finds the matching private key
-	messageKeyInfo, plaintext, err := saltpack.Open(saltpack.CheckKnownMajorVersion, ciphertext, d.Keyring)
+	messageKeyInfo, plaintext, err := saltpack.Open(d.checkVersion, ciphertext, d.Keyring)
 	if err != nil {
 		return nil, nil, fmt.Errorf("decryption failed: %w", err)
 	}
@@ -280,7 +322,7 @@ func (d *Decryptor) DecryptArmored(armoredCiphertext string) ([]byte, *saltpack.
 	// 5. Decrypts session key with recipient's secret key
 	// 6. Decrypts payload with session key
 	// 7. Verifies authentication tags
-	messageKeyInfo, plaintext, _, err := saltpack.Dearmor62DecryptOpen(saltpack.CheckKnownMajorVersion, armoredCiphertext, d.Keyring)
+	messageKeyInfo, plaintext, _, err := saltpack.Dearmor62DecryptOpen(d.checkVersion, armoredCiphertext, d.Keyring)
 	if err != nil {
 		return nil, nil, fmt.Errorf("armored decryption failed: %w", err)
 	}
@@ -291,7 +333,7 @@ func (d *Decryptor) DecryptArmored(armoredCiphertext string) ([]byte, *saltpack.
 // DecryptStream decrypts data from a reader to a writer using streaming
 func (d *Decryptor) DecryptStream(ciphertext io.Reader, plaintext io.Writer) (*saltpack.MessageKeyInfo, error) {
 	// Create streaming decryptor
-	messageKeyInfo, plaintextReader, err := saltpack.NewDecryptStream(saltpack.CheckKnownMajorVersion, ciphertext, d.Keyring)
+	messageKeyInfo, plaintextReader, err := saltpack.NewDecryptStream(d.checkVersion, ciphertext, d.Keyring)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create decrypt stream: %w", err)
 	}
@@ -307,7 +349,7 @@ func (d *Decryptor) DecryptStream(ciphertext io.Reader, plaintext io.Writer) (*s
 // DecryptStreamArmored decrypts ASCII-armored data from a reader to a writer using streaming
 func (d *Decryptor) DecryptStreamArmored(armoredCiphertext io.Reader, plaintext io.Writer) (*saltpack.MessageKeyInfo, error) {
 	// Create streaming decryptor for armored data
-	messageKeyInfo, plaintextReader, _, err := saltpack.NewDearmor62DecryptStream(saltpack.CheckKnownMajorVersion, armoredCiphertext, d.Keyring)
+	messageKeyInfo, plaintextReader, _, err := saltpack.NewDearmor62DecryptStream(d.checkVersion, armoredCiphertext, d.Keyring)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create armored decrypt stream: %w", err)
 	}
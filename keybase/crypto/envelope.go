@@ -0,0 +1,278 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+// EnvelopeCipher names the cipher that encrypts an envelope's payload
+type EnvelopeCipher string
+
+const (
+	// EnvelopeSecretbox is NaCl secretbox (XSalsa20-Poly1305), the cipher of
+	// Saltpack payloads
+	EnvelopeSecretbox EnvelopeCipher = "secretbox"
+
+	// EnvelopeAESGCM is AES-256-GCM
+	EnvelopeAESGCM EnvelopeCipher = "aes-gcm"
+)
+
+// EnvelopeType marks a serialized Envelope
+const EnvelopeType = "keybase-envelope"
+
+// envelopeVersion is the version of the envelope format written by SealEnvelope
+//
+// Version 2 binds the payload to the envelope's type, version and cipher
+// (see additionalData). Version 1 envelopes, whose payload is sealed with the
+// data key alone, can still be opened.
+const envelopeVersion = 2
+
+// legacyEnvelopeVersion is the first envelope version, without additional data
+const legacyEnvelopeVersion = 1
+
+// DataKeySize is the size of an envelope data key (256 bits)
+const DataKeySize = 32
+
+// DataKey is the symmetric key that encrypts an envelope's payload
+type DataKey [DataKeySize]byte
+
+// GenerateDataKey returns a random data key
+func GenerateDataKey() (*DataKey, error) {
+	key := new(DataKey)
+	if _, err := rand.Read(key[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	return key, nil
+}
+
+// Zero wipes the data key
+func (k *DataKey) Zero() {
+	if k != nil {
+		clear(k[:])
+	}
+}
+
+// ParseDataKey returns the data key held in key, as unwrapped from an
+// envelope's WrappedKey
+func ParseDataKey(key []byte) (*DataKey, error) {
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("data key must be %d bytes, got %d", DataKeySize, len(key))
+	}
+	dataKey := new(DataKey)
+	copy(dataKey[:], key)
+	return dataKey, nil
+}
+
+// ParseEnvelopeCipher validates an envelope cipher name
+func ParseEnvelopeCipher(name string) (EnvelopeCipher, error) {
+	switch c := EnvelopeCipher(strings.ToLower(strings.TrimSpace(name))); c {
+	case EnvelopeSecretbox, EnvelopeAESGCM:
+		return c, nil
+	default:
+		return "", fmt.Errorf("unsupported envelope cipher: %s (supported: %s, %s)", name, EnvelopeSecretbox, EnvelopeAESGCM)
+	}
+}
+
+// Envelope is a payload encrypted with a random data key, and that data key
+// encrypted to the recipients as a Saltpack message
+//
+// Changing the recipients only replaces WrappedKey and leaves the payload as
+// it is, and several envelopes can share one wrapped key. Envelopes are
+// serialized as JSON by Marshal.
+//
+// The payload is authenticated together with the type, version and cipher,
+// so it cannot be downgraded to another version, and moving it under a
+// wrapped key for another data key fails to open. The data key does not
+// authenticate who wrote the payload, though: anyone who can unwrap it can
+// seal a payload of their own under the same wrapped key.
+type Envelope struct {
+	// Type is always EnvelopeType
+	Type string `json:"type"`
+
+	// Version is the version of the envelope format
+	Version int `json:"version"`
+
+	// Cipher is the cipher the payload is encrypted with
+	Cipher EnvelopeCipher `json:"cipher"`
+
+	// WrappedKey is the data key as an ASCII-armored Saltpack message
+	WrappedKey string `json:"wrapped_key"`
+
+	// Nonce is the payload nonce (24 bytes for secretbox, 12 for AES-GCM)
+	Nonce []byte `json:"nonce"`
+
+	// Payload is the encrypted payload, including its authentication tag
+	Payload []byte `json:"payload"`
+}
+
+// SealEnvelope encrypts plaintext with dataKey under a fresh random nonce
+// and returns it in an envelope with wrappedKey
+func SealEnvelope(c EnvelopeCipher, dataKey *DataKey, wrappedKey string, plaintext []byte) (*Envelope, error) {
+	envelope := &Envelope{
+		Type:       EnvelopeType,
+		Version:    envelopeVersion,
+		Cipher:     c,
+		WrappedKey: wrappedKey,
+	}
+
+	switch c {
+	case EnvelopeSecretbox:
+		var nonce [24]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		payloadKey := envelope.secretboxKey(dataKey)
+		defer clear(payloadKey[:])
+		envelope.Nonce = nonce[:]
+		envelope.Payload = secretbox.Seal(nil, plaintext, &nonce, &payloadKey)
+
+	case EnvelopeAESGCM:
+		aead, err := newEnvelopeGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		envelope.Nonce = make([]byte, aead.NonceSize())
+		if _, err := rand.Read(envelope.Nonce); err != nil {
+			return nil, fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		envelope.Payload = aead.Seal(nil, envelope.Nonce, plaintext, envelope.additionalData())
+
+	default:
+		return nil, fmt.Errorf("unsupported envelope cipher: %s", c)
+	}
+
+	return envelope, nil
+}
+
+// Open decrypts and authenticates the payload with dataKey
+func (e *Envelope) Open(dataKey *DataKey) ([]byte, error) {
+	switch e.Cipher {
+	case EnvelopeSecretbox:
+		if len(e.Nonce) != 24 {
+			return nil, fmt.Errorf("invalid secretbox nonce length %d", len(e.Nonce))
+		}
+		payloadKey := e.secretboxKey(dataKey)
+		defer clear(payloadKey[:])
+		plaintext, ok := secretbox.Open(nil, e.Payload, (*[24]byte)(e.Nonce), &payloadKey)
+		if !ok {
+			return nil, fmt.Errorf("failed to open envelope payload: authentication failed")
+		}
+		return plaintext, nil
+
+	case EnvelopeAESGCM:
+		aead, err := newEnvelopeGCM(dataKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(e.Nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("invalid AES-GCM nonce length %d", len(e.Nonce))
+		}
+		plaintext, err := aead.Open(nil, e.Nonce, e.Payload, e.additionalData())
+		if err != nil {
+			return nil, fmt.Errorf("failed to open envelope payload: %w", err)
+		}
+		return plaintext, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported envelope cipher: %s", e.Cipher)
+	}
+}
+
+// Rewrap returns a copy of the envelope whose data key is wrapped by
+// wrappedKey; the nonce and payload are copied unchanged
+func (e *Envelope) Rewrap(wrappedKey string) *Envelope {
+	rewrapped := *e
+	rewrapped.WrappedKey = wrappedKey
+	rewrapped.Nonce = bytes.Clone(e.Nonce)
+	rewrapped.Payload = bytes.Clone(e.Payload)
+	return &rewrapped
+}
+
+// additionalData returns what the payload of a version 2 envelope is
+// authenticated with besides its ciphertext: the envelope type, version and
+// cipher
+func (e *Envelope) additionalData() []byte {
+	if e.Version == legacyEnvelopeVersion {
+		return nil
+	}
+
+	return fmt.Appendf(nil, "%s\x00%d\x00%s", e.Type, e.Version, e.Cipher)
+}
+
+// secretboxKey returns the key that seals a secretbox payload
+//
+// Secretbox takes no additional data, so version 2 envelopes seal their
+// payload with HMAC-SHA256(dataKey, additionalData) instead of the data key
+// itself; version 1 envelopes use the data key.
+func (e *Envelope) secretboxKey(dataKey *DataKey) [DataKeySize]byte {
+	additionalData := e.additionalData()
+	if additionalData == nil {
+		return *dataKey
+	}
+
+	var key [DataKeySize]byte
+	mac := hmac.New(sha256.New, dataKey[:])
+	mac.Write(additionalData)
+	mac.Sum(key[:0])
+	return key
+}
+
+// Marshal serializes the envelope as JSON
+func (e *Envelope) Marshal() ([]byte, error) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal envelope: %w", err)
+	}
+	return data, nil
+}
+
+// ParseEnvelope parses an envelope serialized by Marshal
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse envelope: %w", err)
+	}
+
+	if envelope.Type != EnvelopeType {
+		return nil, fmt.Errorf("not a %s", EnvelopeType)
+	}
+	if envelope.Version != envelopeVersion && envelope.Version != legacyEnvelopeVersion {
+		return nil, fmt.Errorf("unsupported envelope version %d", envelope.Version)
+	}
+	if _, err := ParseEnvelopeCipher(string(envelope.Cipher)); err != nil {
+		return nil, err
+	}
+	if envelope.WrappedKey == "" {
+		return nil, fmt.Errorf("envelope has no wrapped key")
+	}
+
+	return &envelope, nil
+}
+
+// IsEnvelope reports whether data looks like an envelope serialized by
+// Marshal, without parsing the payload
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte(`{"type":"`+EnvelopeType+`"`))
+}
+
+// newEnvelopeGCM returns the AES-256-GCM AEAD for dataKey
+func newEnvelopeGCM(dataKey *DataKey) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(dataKey[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES-GCM: %w", err)
+	}
+	return aead, nil
+}
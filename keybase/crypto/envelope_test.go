@@ -0,0 +1,168 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+func TestEnvelopeSealOpen(t *testing.T) {
+	plaintext := []byte(`{"state": "large blob"}`)
+
+	for _, c := range []EnvelopeCipher{EnvelopeSecretbox, EnvelopeAESGCM} {
+		t.Run(string(c), func(t *testing.T) {
+			dataKey, err := GenerateDataKey()
+			if err != nil {
+				t.Fatalf("GenerateDataKey() error = %v", err)
+			}
+
+			envelope, err := SealEnvelope(c, dataKey, "wrapped", plaintext)
+			if err != nil {
+				t.Fatalf("SealEnvelope() error = %v", err)
+			}
+
+			data, err := envelope.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if !IsEnvelope(data) {
+				t.Errorf("IsEnvelope(%q) = false, want true", data)
+			}
+
+			parsed, err := ParseEnvelope(data)
+			if err != nil {
+				t.Fatalf("ParseEnvelope() error = %v", err)
+			}
+			if parsed.Cipher != c || parsed.WrappedKey != "wrapped" {
+				t.Errorf("ParseEnvelope() = %+v, want cipher %s and the wrapped key", parsed, c)
+			}
+
+			opened, err := parsed.Open(dataKey)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			if !bytes.Equal(opened, plaintext) {
+				t.Errorf("Open() = %q, want %q", opened, plaintext)
+			}
+
+			// Rewrapping copies the payload, which still opens with the same key
+			rewrapped := parsed.Rewrap("rewrapped")
+			if !bytes.Equal(rewrapped.Nonce, parsed.Nonce) || !bytes.Equal(rewrapped.Payload, parsed.Payload) {
+				t.Error("Rewrap() changed the nonce or payload")
+			}
+			if rewrapped.WrappedKey != "rewrapped" || parsed.WrappedKey != "wrapped" {
+				t.Errorf("Rewrap() wrapped keys = %q, %q, want rewrapped, wrapped", rewrapped.WrappedKey, parsed.WrappedKey)
+			}
+			if opened, err := rewrapped.Open(dataKey); err != nil || !bytes.Equal(opened, plaintext) {
+				t.Errorf("Open() after Rewrap() = %q, %v, want %q", opened, err, plaintext)
+			}
+
+			otherKey, err := GenerateDataKey()
+			if err != nil {
+				t.Fatalf("GenerateDataKey() error = %v", err)
+			}
+			if _, err := parsed.Open(otherKey); err == nil {
+				t.Error("Open() with another data key should fail")
+			}
+
+			parsed.Payload[0] ^= 1
+			if _, err := parsed.Open(dataKey); err == nil {
+				t.Error("Open() of a tampered payload should fail")
+			}
+		})
+	}
+}
+
+// TestEnvelopeBinding tests that a payload only opens under the header it
+// was sealed with
+func TestEnvelopeBinding(t *testing.T) {
+	for _, c := range []EnvelopeCipher{EnvelopeSecretbox, EnvelopeAESGCM} {
+		t.Run(string(c), func(t *testing.T) {
+			dataKey, err := GenerateDataKey()
+			if err != nil {
+				t.Fatalf("GenerateDataKey() error = %v", err)
+			}
+			envelope, err := SealEnvelope(c, dataKey, "wrapped", []byte("secret"))
+			if err != nil {
+				t.Fatalf("SealEnvelope() error = %v", err)
+			}
+
+			for name, modify := range map[string]func(*Envelope){
+				"downgraded version": func(e *Envelope) { e.Version = legacyEnvelopeVersion },
+				"other type":         func(e *Envelope) { e.Type = "other" },
+			} {
+				modified := *envelope
+				modify(&modified)
+				if _, err := modified.Open(dataKey); err == nil {
+					t.Errorf("Open() with %s should fail", name)
+				}
+			}
+		})
+	}
+}
+
+// TestEnvelopeLegacyVersion tests that version 1 envelopes, sealed without
+// additional data, still open and stay version 1 when rewrapped
+func TestEnvelopeLegacyVersion(t *testing.T) {
+	dataKey, err := GenerateDataKey()
+	if err != nil {
+		t.Fatalf("GenerateDataKey() error = %v", err)
+	}
+	var nonce [24]byte
+	envelope := &Envelope{
+		Type:       EnvelopeType,
+		Version:    legacyEnvelopeVersion,
+		Cipher:     EnvelopeSecretbox,
+		WrappedKey: "wrapped",
+		Nonce:      nonce[:],
+		Payload:    secretbox.Seal(nil, []byte("secret"), &nonce, (*[DataKeySize]byte)(dataKey)),
+	}
+
+	opened, err := envelope.Open(dataKey)
+	if err != nil || string(opened) != "secret" {
+		t.Fatalf("Open() = %q, %v, want %q", opened, err, "secret")
+	}
+
+	rewrapped := envelope.Rewrap("rewrapped")
+	if rewrapped.Version != legacyEnvelopeVersion {
+		t.Errorf("Rewrap() version = %d, want %d", rewrapped.Version, legacyEnvelopeVersion)
+	}
+	if opened, err := rewrapped.Open(dataKey); err != nil || string(opened) != "secret" {
+		t.Errorf("Open() after Rewrap() = %q, %v, want %q", opened, err, "secret")
+	}
+}
+
+func TestParseEnvelopeCipher(t *testing.T) {
+	for name, want := range map[string]EnvelopeCipher{
+		"secretbox": EnvelopeSecretbox,
+		"AES-GCM":   EnvelopeAESGCM,
+	} {
+		got, err := ParseEnvelopeCipher(name)
+		if err != nil || got != want {
+			t.Errorf("ParseEnvelopeCipher(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	if _, err := ParseEnvelopeCipher("chacha"); err == nil {
+		t.Error("ParseEnvelopeCipher() of an unknown cipher should fail")
+	}
+}
+
+func TestParseEnvelopeInvalid(t *testing.T) {
+	for _, data := range []string{
+		`not json`,
+		`{"type":"other","version":1,"cipher":"secretbox","wrapped_key":"k"}`,
+		`{"type":"keybase-envelope","version":3,"cipher":"secretbox","wrapped_key":"k"}`,
+		`{"type":"keybase-envelope","version":1,"cipher":"rot13","wrapped_key":"k"}`,
+		`{"type":"keybase-envelope","version":1,"cipher":"secretbox"}`,
+	} {
+		if _, err := ParseEnvelope([]byte(data)); err == nil {
+			t.Errorf("ParseEnvelope(%s) should fail", data)
+		}
+	}
+
+	if IsEnvelope([]byte("BEGIN SALTPACK ENCRYPTED MESSAGE.")) {
+		t.Error("IsEnvelope() of a Saltpack message = true, want false")
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/keybase/saltpack"
 	"golang.org/x/crypto/nacl/box"
 )
 
@@ -88,6 +89,18 @@ func (ekc *EphemeralKeyCreator) GenerateKey() (*EphemeralKeyPair, error) {
 	}, nil
 }
 
+// CreateEphemeralKey implements saltpack.EphemeralKeyCreator
+// It generates an ephemeral key pair and returns it as a saltpack.BoxSecretKey
+func (ekc *EphemeralKeyCreator) CreateEphemeralKey() (saltpack.BoxSecretKey, error) {
+	pair, err := ekc.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey := &naclBoxPublicKey{key: pair.PublicKey}
+	return newBoxSecretKey((*[32]byte)(&pair.SecretKey), publicKey), nil
+}
+
 // GenerateKeys generates multiple ephemeral key pairs
 // This is useful when you need to generate keys in batch
 // Returns an error if any key generation fails
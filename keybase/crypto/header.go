@@ -0,0 +1,252 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"io"
+
+	"github.com/keybase/go-codec/codec"
+	"github.com/keybase/saltpack"
+)
+
+// headerPacket is the start of every Saltpack header, whatever the message type
+type headerPacket struct {
+	_struct    bool                 `codec:",toarray"` //nolint
+	FormatName string               `codec:"format_name"`
+	Version    saltpack.Version     `codec:"vers"`
+	Type       saltpack.MessageType `codec:"type"`
+}
+
+// readHeaderPacket returns the encoded header packet of a Saltpack message,
+// armored or binary, along with its format, version and type
+//
+// Only the header is read, so this is cheap even for large messages.
+func readHeaderPacket(ciphertext []byte) ([]byte, *headerPacket, bool, error) {
+	var body io.Reader = bytes.NewReader(ciphertext)
+	armored := bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte("BEGIN "))
+	if armored {
+		dearmored, _, err := saltpack.NewArmor62DecoderStream(bytes.NewReader(ciphertext), nil, nil)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("failed to dearmor message: %w", err)
+		}
+		body = dearmored
+	}
+
+	// The header is msgpack-encoded twice: an outer bin wraps the header
+	// array so that it can be hashed as bytes
+	var headerBytes []byte
+	if err := codec.NewDecoder(bufio.NewReader(body), headerCodec()).Decode(&headerBytes); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to read message header: %w", err)
+	}
+
+	var packet headerPacket
+	if err := codec.NewDecoderBytes(headerBytes, headerCodec()).Decode(&packet); err != nil {
+		return nil, nil, false, fmt.Errorf("failed to decode message header: %w", err)
+	}
+
+	if packet.FormatName != saltpack.FormatName {
+		return nil, nil, false, fmt.Errorf("not a Saltpack message: format %q", packet.FormatName)
+	}
+
+	return headerBytes, &packet, armored, nil
+}
+
+// headerCodec returns the msgpack handle Saltpack headers are encoded with
+func headerCodec() *codec.MsgpackHandle {
+	return &codec.MsgpackHandle{WriteExt: true}
+}
+
+// readHeader decodes the header packet of a Saltpack encrypted or
+// signcrypted message, armored or binary
+//
+// The header is not authenticated here; callers must only trust it alongside
+// a successful decryption of the same ciphertext.
+func readHeader(ciphertext []byte) (*saltpack.EncryptionHeader, error) {
+	headerBytes, packet, _, err := readHeaderPacket(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	if packet.Type != saltpack.MessageTypeEncryption && packet.Type != saltpack.MessageTypeSigncryption {
+		return nil, fmt.Errorf("not an encrypted message: %s", packet.Type)
+	}
+
+	var header saltpack.EncryptionHeader
+	if err := codec.NewDecoderBytes(headerBytes, headerCodec()).Decode(&header); err != nil {
+		return nil, fmt.Errorf("failed to decode message header: %w", err)
+	}
+
+	return &header, nil
+}
+
+// MessageInspection describes a Saltpack message from its header alone
+//
+// Inspecting a message needs no secret key, so anyone who can read the
+// ciphertext can audit who it is encrypted for. For the same reason nothing
+// here is authenticated: a header can claim any recipients, and only
+// decrypting the message proves it was written for them.
+type MessageInspection struct {
+	// Type is the kind of message: encrypted, signcrypted, or an attached or
+	// detached signature
+	Type saltpack.MessageType
+
+	// Version is the Saltpack version of the message
+	Version saltpack.Version
+
+	// Armored indicates the message is ASCII-armored rather than binary
+	Armored bool
+
+	// SenderHidden indicates the header does not reveal the sender. The
+	// sender of an encrypted or signcrypted message is encrypted for its
+	// recipients, who alone can tell whether it is anonymous
+	SenderHidden bool
+
+	// Sender is the signing key of a signed message (nil if SenderHidden)
+	Sender *KeyOwner
+
+	// Recipients lists every recipient slot in header order (empty for
+	// signatures)
+	Recipients []MessageRecipient
+
+	// Envelope is the payload cipher when the message is an Envelope, in
+	// which case the rest of the inspection describes its wrapped key
+	Envelope EnvelopeCipher
+}
+
+// MessageRecipient is one recipient slot of an encrypted message's header
+type MessageRecipient struct {
+	// Index is the position of the slot in the header (0-based), as in
+	// MessageInfo.ReceiverIndex
+	Index int
+
+	// Key identifies the recipient's encryption key (nil if the recipient is
+	// hidden, as are all recipients of signcrypted messages)
+	Key *KeyOwner
+}
+
+// InspectMessage parses the header of a Saltpack message, armored or binary,
+// without decrypting or verifying it
+//
+// For an Envelope, the header of its wrapped key is inspected.
+func InspectMessage(ciphertext []byte) (*MessageInspection, error) {
+	if IsEnvelope(ciphertext) {
+		envelope, err := ParseEnvelope(ciphertext)
+		if err != nil {
+			return nil, err
+		}
+		inspection, err := InspectMessage([]byte(envelope.WrappedKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect wrapped key: %w", err)
+		}
+		inspection.Envelope = envelope.Cipher
+		return inspection, nil
+	}
+
+	headerBytes, packet, armored, err := readHeaderPacket(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	inspection := &MessageInspection{
+		Type:    packet.Type,
+		Version: packet.Version,
+		Armored: armored,
+	}
+
+	switch packet.Type {
+	case saltpack.MessageTypeEncryption, saltpack.MessageTypeSigncryption:
+		var header saltpack.EncryptionHeader
+		if err := codec.NewDecoderBytes(headerBytes, headerCodec()).Decode(&header); err != nil {
+			return nil, fmt.Errorf("failed to decode message header: %w", err)
+		}
+
+		inspection.SenderHidden = true
+		inspection.Recipients = make([]MessageRecipient, len(header.Receivers))
+		for i, receiver := range header.Receivers {
+			inspection.Recipients[i] = MessageRecipient{Index: i}
+
+			// Signcryption receiver identifiers are derived from a shared
+			// secret rather than being public keys
+			if packet.Type == saltpack.MessageTypeEncryption && len(receiver.ReceiverKID) > 0 {
+				publicKey, err := CreatePublicKey(receiver.ReceiverKID)
+				if err != nil {
+					return nil, fmt.Errorf("invalid receiver KID at index %d: %w", i, err)
+				}
+				inspection.Recipients[i].Key = &KeyOwner{KID: EncryptionKID(publicKey)}
+			}
+		}
+
+	case saltpack.MessageTypeAttachedSignature, saltpack.MessageTypeDetachedSignature:
+		var header saltpack.SignatureHeader
+		if err := codec.NewDecoderBytes(headerBytes, headerCodec()).Decode(&header); err != nil {
+			return nil, fmt.Errorf("failed to decode message header: %w", err)
+		}
+
+		signer, err := CreateSigningPublicKey(header.SenderPublic)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signer key: %w", err)
+		}
+		inspection.Sender = &KeyOwner{KID: SigningKID(signer)}
+
+	default:
+		return nil, fmt.Errorf("unknown Saltpack message type %d", int(packet.Type))
+	}
+
+	return inspection, nil
+}
+
+// RecipientIndex returns the index of the recipient slot holding the
+// encryption key with Keybase KID kid, or -1 if it is not listed
+func (m *MessageInspection) RecipientIndex(kid string) int {
+	for _, recipient := range m.Recipients {
+		if recipient.Key != nil && recipient.Key.KID == kid {
+			return recipient.Index
+		}
+	}
+	return -1
+}
+
+// HiddenRecipients returns the number of recipients whose key is not listed
+func (m *MessageInspection) HiddenRecipients() int {
+	hidden := 0
+	for _, recipient := range m.Recipients {
+		if recipient.Key == nil {
+			hidden++
+		}
+	}
+	return hidden
+}
+
+// ReceiverIndex returns the position of receiverKID (a raw 32-byte public
+// key, as in MessageInfo.ReceiverKID) in the recipient list of an
+// encrypted message's header
+//
+// Returns -1 if the message's recipients are hidden (as in signcrypted
+// messages) or receiverKID is not listed.
+func ReceiverIndex(ciphertext []byte, receiverKID []byte) (int, error) {
+	header, err := readHeader(ciphertext)
+	if err != nil {
+		return -1, err
+	}
+
+	return receiverIndex(header, receiverKID), nil
+}
+
+// receiverIndex returns the position of receiverKID in header's recipient
+// list, or -1 if it is not listed
+func receiverIndex(header *saltpack.EncryptionHeader, receiverKID []byte) int {
+	if len(receiverKID) == 0 {
+		return -1
+	}
+
+	for i, receiver := range header.Receivers {
+		if len(receiver.ReceiverKID) == len(receiverKID) &&
+			subtle.ConstantTimeCompare(receiver.ReceiverKID, receiverKID) == 1 {
+			return i
+		}
+	}
+
+	return -1
+}
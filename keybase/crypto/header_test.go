@@ -0,0 +1,165 @@
+package crypto
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+// TestInspectMessage tests reading message headers without any secret key
+func TestInspectMessage(t *testing.T) {
+	recipients := make([]saltpack.BoxPublicKey, 3)
+	for i := range recipients {
+		keyPair, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateKeyPair() error = %v", err)
+		}
+		recipients[i] = keyPair.PublicKey
+	}
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+	plaintext := []byte("stack secret")
+
+	version := saltpack.Version1()
+	v1, err := NewEncryptor(&EncryptorConfig{Version: &version})
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	v2, err := NewEncryptor(&EncryptorConfig{SigningKey: signingKey})
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	hidden, err := NewEncryptor(&EncryptorConfig{HideRecipients: true})
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+
+	armored, err := v2.EncryptArmored(plaintext, recipients)
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	binaryV1, err := v1.Encrypt(plaintext, recipients)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	hiddenArmored, err := hidden.EncryptArmored(plaintext, recipients)
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	signcrypted, err := v2.SigncryptArmored(plaintext, recipients)
+	if err != nil {
+		t.Fatalf("SigncryptArmored() error = %v", err)
+	}
+
+	t.Run("encrypted", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			ciphertext []byte
+			armored    bool
+			version    saltpack.Version
+		}{
+			"armored v2": {[]byte(armored), true, saltpack.Version2()},
+			"binary v1":  {binaryV1, false, saltpack.Version1()},
+		} {
+			inspection, err := InspectMessage(tc.ciphertext)
+			if err != nil {
+				t.Fatalf("%s: InspectMessage() error = %v", name, err)
+			}
+			if inspection.Type != saltpack.MessageTypeEncryption || inspection.Armored != tc.armored || inspection.Version != tc.version {
+				t.Errorf("%s: Type = %s, Armored = %t, Version = %s", name, inspection.Type, inspection.Armored, inspection.Version)
+			}
+			if !inspection.SenderHidden || inspection.Sender != nil {
+				t.Errorf("%s: the sender of an encrypted message should be hidden", name)
+			}
+			if len(inspection.Recipients) != len(recipients) || inspection.HiddenRecipients() != 0 {
+				t.Fatalf("%s: %d recipients (%d hidden), want %d listed", name, len(inspection.Recipients), inspection.HiddenRecipients(), len(recipients))
+			}
+
+			// Saltpack shuffles recipients, but every one is listed once
+			for i, recipient := range inspection.Recipients {
+				if recipient.Index != i {
+					t.Errorf("%s: Recipients[%d].Index = %d", name, i, recipient.Index)
+				}
+			}
+			for _, recipient := range recipients {
+				if inspection.RecipientIndex(EncryptionKID(recipient)) < 0 {
+					t.Errorf("%s: recipient %s is not listed", name, EncryptionKID(recipient))
+				}
+			}
+		}
+	})
+
+	t.Run("hidden recipients", func(t *testing.T) {
+		inspection, err := InspectMessage([]byte(hiddenArmored))
+		if err != nil {
+			t.Fatalf("InspectMessage() error = %v", err)
+		}
+		if len(inspection.Recipients) != len(recipients) || inspection.HiddenRecipients() != len(recipients) {
+			t.Errorf("%d recipients (%d hidden), want all %d hidden", len(inspection.Recipients), inspection.HiddenRecipients(), len(recipients))
+		}
+		if inspection.RecipientIndex(EncryptionKID(recipients[0])) != -1 {
+			t.Error("RecipientIndex() of a hidden recipient should be -1")
+		}
+	})
+
+	t.Run("signcrypted", func(t *testing.T) {
+		inspection, err := InspectMessage([]byte(signcrypted))
+		if err != nil {
+			t.Fatalf("InspectMessage() error = %v", err)
+		}
+		if inspection.Type != saltpack.MessageTypeSigncryption || !inspection.SenderHidden {
+			t.Errorf("Type = %s, SenderHidden = %t", inspection.Type, inspection.SenderHidden)
+		}
+		if inspection.HiddenRecipients() != len(recipients) {
+			t.Errorf("HiddenRecipients() = %d, want %d", inspection.HiddenRecipients(), len(recipients))
+		}
+	})
+
+	t.Run("signatures", func(t *testing.T) {
+		signer, err := NewSigner(&SignerConfig{SigningKey: signingKey})
+		if err != nil {
+			t.Fatalf("NewSigner() error = %v", err)
+		}
+		attached, err := signer.SignArmored(plaintext)
+		if err != nil {
+			t.Fatalf("SignArmored() error = %v", err)
+		}
+		detached, err := signer.SignDetached(plaintext)
+		if err != nil {
+			t.Fatalf("SignDetached() error = %v", err)
+		}
+
+		for want, message := range map[saltpack.MessageType][]byte{
+			saltpack.MessageTypeAttachedSignature: []byte(attached),
+			saltpack.MessageTypeDetachedSignature: detached,
+		} {
+			inspection, err := InspectMessage(message)
+			if err != nil {
+				t.Fatalf("InspectMessage() error = %v", err)
+			}
+			if inspection.Type != want {
+				t.Errorf("Type = %s, want %s", inspection.Type, want)
+			}
+			if inspection.SenderHidden || inspection.Sender == nil || inspection.Sender.KID != signer.KID() {
+				t.Errorf("Sender = %v, want %s", inspection.Sender, signer.KID())
+			}
+			if len(inspection.Recipients) != 0 {
+				t.Errorf("signature has %d recipients, want none", len(inspection.Recipients))
+			}
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for name, input := range map[string][]byte{
+			"empty":     nil,
+			"garbage":   []byte("not a saltpack message"),
+			"truncated": bytes.Clone(binaryV1[:10]),
+		} {
+			if _, err := InspectMessage(input); err == nil {
+				t.Errorf("%s: InspectMessage() should fail", name)
+			}
+		}
+	})
+}
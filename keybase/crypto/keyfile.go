@@ -0,0 +1,340 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/keybase/saltpack"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyFileType marks a passphrase-protected key file
+const KeyFileType = "keybase-encrypted-key"
+
+// keyFileVersion is the version of the key file format written by EncryptKeyFile
+const keyFileVersion = 1
+
+// KeyFileKDF names the function that derives a key file's encryption key
+// from its passphrase
+type KeyFileKDF string
+
+const (
+	// KDFArgon2id is Argon2id with 3 passes over 64 MiB (the default)
+	KDFArgon2id KeyFileKDF = "argon2id"
+
+	// KDFScrypt is scrypt with N=2^15, r=8, p=1
+	KDFScrypt KeyFileKDF = "scrypt"
+)
+
+// Limits on the KDF parameters read from a key file, so that a crafted file
+// cannot make loading it take unbounded memory or time
+const (
+	maxScryptN      = 1 << 20
+	maxArgon2Memory = 1 << 20 // KiB (1 GiB)
+	maxArgon2Time   = 16
+)
+
+// ErrIncorrectPassphrase is returned when a key file cannot be decrypted
+// with the passphrase given
+var ErrIncorrectPassphrase = errors.New("incorrect passphrase for key file")
+
+// encryptedKeyFile is the JSON form of a passphrase-protected key file
+//
+// Ciphertext is the plaintext key file (the JSON read by LoadSenderKey and
+// LoadSigningKey) sealed with secretbox under the derived key.
+type encryptedKeyFile struct {
+	Type       string        `json:"type"`
+	Version    int           `json:"version"`
+	KDF        KeyFileKDF    `json:"kdf"`
+	Scrypt     *scryptParams `json:"scrypt,omitempty"`
+	Argon2id   *argon2Params `json:"argon2id,omitempty"`
+	Salt       []byte        `json:"salt"`
+	Nonce      []byte        `json:"nonce"`
+	Ciphertext []byte        `json:"ciphertext"`
+}
+
+type scryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+type argon2Params struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// IsEncryptedKeyFile reports whether data is a passphrase-protected key file
+func IsEncryptedKeyFile(data []byte) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return false
+	}
+
+	var header struct {
+		Type string `json:"type"`
+	}
+	return json.Unmarshal(data, &header) == nil && header.Type == KeyFileType
+}
+
+// EncryptKeyFile protects the contents of a key file with a passphrase
+//
+// kdf may be empty, in which case Argon2id is used.
+func EncryptKeyFile(plaintext, passphrase []byte, kdf KeyFileKDF) ([]byte, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("passphrase cannot be empty")
+	}
+
+	file := &encryptedKeyFile{
+		Type:    KeyFileType,
+		Version: keyFileVersion,
+		KDF:     kdf,
+		Salt:    make([]byte, 16),
+	}
+	switch kdf {
+	case KDFArgon2id, "":
+		file.KDF = KDFArgon2id
+		file.Argon2id = &argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+	case KDFScrypt:
+		file.Scrypt = &scryptParams{N: 1 << 15, R: 8, P: 1}
+	default:
+		return nil, fmt.Errorf("unsupported key file KDF: %s", kdf)
+	}
+
+	if _, err := rand.Read(file.Salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+	var nonce [24]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	file.Nonce = nonce[:]
+
+	key, err := file.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key[:])
+	file.Ciphertext = secretbox.Seal(nil, plaintext, &nonce, key)
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal key file: %w", err)
+	}
+	return data, nil
+}
+
+// DecryptKeyFile returns the contents of a passphrase-protected key file
+//
+// A wrong passphrase fails with ErrIncorrectPassphrase.
+func DecryptKeyFile(data, passphrase []byte) ([]byte, error) {
+	var file encryptedKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse encrypted key file: %w", err)
+	}
+	if file.Type != KeyFileType {
+		return nil, fmt.Errorf("not a %s file", KeyFileType)
+	}
+	if file.Version != keyFileVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", file.Version)
+	}
+	if len(file.Nonce) != 24 {
+		return nil, fmt.Errorf("invalid key file nonce length %d", len(file.Nonce))
+	}
+
+	key, err := file.deriveKey(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(key[:])
+
+	plaintext, ok := secretbox.Open(nil, file.Ciphertext, (*[24]byte)(file.Nonce), key)
+	if !ok {
+		return nil, ErrIncorrectPassphrase
+	}
+	return plaintext, nil
+}
+
+// deriveKey derives the secretbox key of the file from passphrase
+func (f *encryptedKeyFile) deriveKey(passphrase []byte) (*[32]byte, error) {
+	if len(f.Salt) < 16 {
+		return nil, fmt.Errorf("key file salt is too short")
+	}
+
+	var derived []byte
+	switch f.KDF {
+	case KDFScrypt:
+		p := f.Scrypt
+		if p == nil || p.N <= 1 || p.N > maxScryptN || p.R <= 0 || p.P <= 0 || p.R*p.P >= 1<<30 {
+			return nil, fmt.Errorf("invalid scrypt parameters in key file")
+		}
+		var err error
+		derived, err = scrypt.Key(passphrase, f.Salt, p.N, p.R, p.P, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive key file key: %w", err)
+		}
+
+	case KDFArgon2id:
+		p := f.Argon2id
+		if p == nil || p.Time == 0 || p.Time > maxArgon2Time || p.Memory == 0 || p.Memory > maxArgon2Memory || p.Threads == 0 {
+			return nil, fmt.Errorf("invalid argon2id parameters in key file")
+		}
+		derived = argon2.IDKey(passphrase, f.Salt, p.Time, p.Memory, p.Threads, 32)
+
+	default:
+		return nil, fmt.Errorf("unsupported key file KDF: %s", f.KDF)
+	}
+
+	key := new([32]byte)
+	copy(key[:], derived)
+	clear(derived)
+	return key, nil
+}
+
+// readKeyFile reads a key file, decrypting it with a passphrase from
+// passphrase if it is protected
+func readKeyFile(path string, passphrase PassphraseFunc) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	if !IsEncryptedKeyFile(data) {
+		return data, nil
+	}
+
+	if passphrase == nil {
+		passphrase = DefaultPassphrase()
+	}
+	secret, err := passphrase(path)
+	if err != nil {
+		// Every failed source reports ErrNoPassphrase, so that callers can
+		// tell a locked key file from a missing one
+		if !errors.Is(err, ErrNoPassphrase) && !errors.Is(err, ErrIncorrectPassphrase) {
+			err = fmt.Errorf("%w: %w", ErrNoPassphrase, err)
+		}
+		return nil, fmt.Errorf("key file %s is passphrase-protected: %w", path, err)
+	}
+
+	plaintext, err := DecryptKeyFile(data, secret)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key file %s: %w", path, err)
+	}
+	return plaintext, nil
+}
+
+// KeyFileOptions configures how SaveSenderKey protects a key file
+type KeyFileOptions struct {
+	// Passphrase protects the key file (required)
+	Passphrase []byte
+
+	// KDF derives the file's encryption key from Passphrase
+	// If empty, Argon2id is used
+	KDF KeyFileKDF
+
+	// SigningKey is an optional Ed25519 signing key stored alongside the
+	// encryption key, for signcryption and signatures (see LoadSigningKey)
+	SigningKey saltpack.SigningSecretKey
+}
+
+// SaveSenderKey writes a sender key to the user's device_eks/<user>.eks key
+// file in configDir, protected by a passphrase, and returns its path
+//
+// The file is written with mode 0600 to a temporary file that replaces any
+// existing key file only once it is complete, so a failed save never
+// leaves a truncated key behind. LoadSenderKey and LoadSigningKey read it
+// back given the passphrase.
+func SaveSenderKey(key *SenderKey, configDir string, options *KeyFileOptions) (string, error) {
+	if key == nil || key.SecretKey == nil {
+		return "", fmt.Errorf("invalid key")
+	}
+	if key.Username == "" {
+		return "", fmt.Errorf("sender key has no username")
+	}
+	if options == nil || len(options.Passphrase) == 0 {
+		return "", fmt.Errorf("a passphrase is required to save a key file")
+	}
+
+	secretKeyBytes := ExportSecretKeyBytes(key.SecretKey)
+	if secretKeyBytes == nil {
+		return "", fmt.Errorf("failed to export secret key bytes")
+	}
+
+	keyData := struct {
+		EncryptionKey string `json:"encryption_key"`
+		SigningKey    string `json:"signing_key,omitempty"`
+		Username      string `json:"username"`
+	}{
+		EncryptionKey: hex.EncodeToString(secretKeyBytes),
+		Username:      key.Username,
+	}
+	if options.SigningKey != nil {
+		seed := ExportSigningKeySeed(options.SigningKey)
+		if seed == nil {
+			return "", fmt.Errorf("failed to export signing key")
+		}
+		keyData.SigningKey = hex.EncodeToString(seed)
+	}
+
+	plaintext, err := json.Marshal(keyData)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal key data: %w", err)
+	}
+	defer clear(plaintext)
+
+	data, err := EncryptKeyFile(plaintext, options.Passphrase, options.KDF)
+	if err != nil {
+		return "", err
+	}
+
+	keyDir := filepath.Join(configDir, "device_eks")
+	if err := os.MkdirAll(keyDir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	keyPath := filepath.Join(keyDir, fmt.Sprintf("%s.eks", key.Username))
+	if err := writeFileAtomic(keyPath, data); err != nil {
+		return "", fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return keyPath, nil
+}
+
+// writeFileAtomic writes data to path with mode 0600 via a synced
+// temporary file in the same directory
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,150 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestEncryptDecryptKeyFile(t *testing.T) {
+	plaintext := []byte(`{"encryption_key": "00"}`)
+	passphrase := []byte("correct horse battery staple")
+
+	for _, kdf := range []KeyFileKDF{KDFArgon2id, KDFScrypt} {
+		t.Run(string(kdf), func(t *testing.T) {
+			data, err := EncryptKeyFile(plaintext, passphrase, kdf)
+			if err != nil {
+				t.Fatalf("EncryptKeyFile() error = %v", err)
+			}
+			if !IsEncryptedKeyFile(data) {
+				t.Errorf("IsEncryptedKeyFile() = false, want true")
+			}
+			if bytes.Contains(data, plaintext) {
+				t.Error("encrypted key file contains the plaintext")
+			}
+
+			decrypted, err := DecryptKeyFile(data, passphrase)
+			if err != nil {
+				t.Fatalf("DecryptKeyFile() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("DecryptKeyFile() = %q, want %q", decrypted, plaintext)
+			}
+
+			if _, err := DecryptKeyFile(data, []byte("wrong")); !errors.Is(err, ErrIncorrectPassphrase) {
+				t.Errorf("DecryptKeyFile() with a wrong passphrase error = %v, want ErrIncorrectPassphrase", err)
+			}
+		})
+	}
+
+	if _, err := EncryptKeyFile(plaintext, nil, ""); err == nil {
+		t.Error("EncryptKeyFile() with an empty passphrase should fail")
+	}
+	if _, err := EncryptKeyFile(plaintext, passphrase, "pbkdf2"); err == nil {
+		t.Error("EncryptKeyFile() with an unknown KDF should fail")
+	}
+	if IsEncryptedKeyFile(plaintext) {
+		t.Error("IsEncryptedKeyFile() of a plaintext key file = true, want false")
+	}
+}
+
+func TestDecryptKeyFileRejectsExcessiveParameters(t *testing.T) {
+	data, err := EncryptKeyFile([]byte("key"), []byte("passphrase"), KDFScrypt)
+	if err != nil {
+		t.Fatalf("EncryptKeyFile() error = %v", err)
+	}
+
+	var file encryptedKeyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	file.Scrypt.N = 1 << 30
+	crafted, err := json.Marshal(file)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	if _, err := DecryptKeyFile(crafted, []byte("passphrase")); err == nil {
+		t.Error("DecryptKeyFile() with scrypt N=2^30 should fail")
+	}
+}
+
+func TestSaveSenderKey(t *testing.T) {
+	configDir := t.TempDir()
+	passphrase := []byte("hunter2")
+	t.Setenv(PassphraseEnv, "")
+	t.Setenv(PassphraseFDEnv, "")
+
+	senderKey, err := CreateTestSenderKey("alice")
+	if err != nil {
+		t.Fatalf("CreateTestSenderKey() error = %v", err)
+	}
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+
+	path, err := SaveSenderKey(senderKey, configDir, &KeyFileOptions{
+		Passphrase: passphrase,
+		KDF:        KDFScrypt,
+		SigningKey: signingKey,
+	})
+	if err != nil {
+		t.Fatalf("SaveSenderKey() error = %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if !IsEncryptedKeyFile(data) {
+		t.Fatal("saved key file is not passphrase-protected")
+	}
+
+	config := &SenderKeyConfig{Username: "alice", ConfigDir: configDir, Passphrase: StaticPassphrase(passphrase)}
+	loaded, err := LoadSenderKey(config)
+	if err != nil {
+		t.Fatalf("LoadSenderKey() error = %v", err)
+	}
+	if !KeysEqual(loaded.PublicKey, senderKey.PublicKey) {
+		t.Error("LoadSenderKey() returned a different key")
+	}
+
+	loadedSigning, err := LoadSigningKey(config)
+	if err != nil {
+		t.Fatalf("LoadSigningKey() error = %v", err)
+	}
+	if loadedSigning.KID != SigningKID(signingKey.GetPublicKey()) {
+		t.Errorf("LoadSigningKey() KID = %s, want %s", loadedSigning.KID, SigningKID(signingKey.GetPublicKey()))
+	}
+
+	// A wrong passphrase is reported rather than "key not found"
+	config.Passphrase = StaticPassphrase([]byte("wrong"))
+	if _, err := LoadSenderKey(config); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Errorf("LoadSenderKey() with a wrong passphrase error = %v, want ErrIncorrectPassphrase", err)
+	}
+
+	// Tests do not run in a terminal, so nothing can supply the passphrase
+	config.Passphrase = nil
+	if _, err := LoadSenderKey(config); !errors.Is(err, ErrNoPassphrase) {
+		t.Errorf("LoadSenderKey() without a passphrase error = %v, want ErrNoPassphrase", err)
+	}
+
+	t.Setenv(PassphraseEnv, string(passphrase))
+	if _, err := LoadSenderKey(config); err != nil {
+		t.Errorf("LoadSenderKey() with %s set error = %v", PassphraseEnv, err)
+	}
+
+	if _, err := SaveSenderKey(senderKey, configDir, nil); err == nil {
+		t.Error("SaveSenderKey() without a passphrase should fail")
+	}
+}
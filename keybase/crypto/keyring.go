@@ -10,6 +10,11 @@ import (
 )
 
 // KeyringLoader loads and caches Keybase secret keys from the local configuration
+//
+// Cached keys are held in secure buffers and wiped when they expire or are
+// invalidated. Keyrings and keys returned by the loader hold copies of their
+// own, which stay usable after the cached key is wiped; callers may wipe
+// them with ZeroKey (or SimpleKeyring.Zero) once done.
 type KeyringLoader struct {
 	// mu protects the cache
 	mu sync.RWMutex
@@ -22,6 +27,9 @@ type KeyringLoader struct {
 	
 	// configDir is the Keybase configuration directory
 	configDir string
+	
+	// passphrase unlocks passphrase-protected key files
+	passphrase PassphraseFunc
 }
 
 // cachedKey represents a cached secret key with expiration
@@ -33,6 +41,11 @@ type cachedKey struct {
 	expiresAt  time.Time
 }
 
+// zero wipes the cached secret key
+func (c *cachedKey) zero() {
+	ZeroKey(c.secretKey)
+}
+
 // KeyringLoaderConfig holds configuration for KeyringLoader
 type KeyringLoaderConfig struct {
 	// TTL is the time-to-live for cached keys
@@ -42,6 +55,10 @@ type KeyringLoaderConfig struct {
 	// ConfigDir is the Keybase configuration directory
 	// If empty, uses the default directory from credentials package
 	ConfigDir string
+	
+	// Passphrase unlocks passphrase-protected key files
+	// If nil, DefaultPassphrase is used
+	Passphrase PassphraseFunc
 }
 
 // NewKeyringLoader creates a new KeyringLoader
@@ -66,10 +83,17 @@ func NewKeyringLoader(config *KeyringLoaderConfig) (*KeyringLoader, error) {
 		configDir = status.ConfigDir
 	}
 	
+	// Share one passphrase source so that a prompt is answered only once
+	passphrase := config.Passphrase
+	if passphrase == nil {
+		passphrase = DefaultPassphrase()
+	}
+	
 	return &KeyringLoader{
-		cache:     make(map[string]*cachedKey),
-		ttl:       ttl,
-		configDir: configDir,
+		cache:      make(map[string]*cachedKey),
+		ttl:        ttl,
+		configDir:  configDir,
+		passphrase: passphrase,
 	}, nil
 }
 
@@ -88,17 +112,17 @@ func (kl *KeyringLoader) LoadKeyring() (saltpack.Keyring, error) {
 	// Check if we have a cached key that's still valid
 	if cached, ok := kl.cache[username]; ok {
 		if time.Now().Before(cached.expiresAt) {
-			// Cache hit - create keyring with cached key
+			// Cache hit - create keyring with a copy of the cached key
 			keyring := NewSimpleKeyring()
-			keyring.AddKey(cached.secretKey)
+			keyring.AddKey(cloneSecretKey(cached.secretKey))
 			return keyring, nil
 		}
-		// Cache expired - remove it
-		delete(kl.cache, username)
+		// Cache expired - wipe and remove it
+		kl.evict(username)
 	}
 	
 	// Cache miss or expired - load key from disk
-	secretKey, err := loadPrivateKey(kl.configDir, username)
+	secretKey, err := loadPrivateKey(kl.configDir, username, kl.passphrase)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load private key for user '%s': %w", username, err)
 	}
@@ -126,9 +150,9 @@ func (kl *KeyringLoader) LoadKeyring() (saltpack.Keyring, error) {
 		expiresAt: now.Add(kl.ttl),
 	}
 	
-	// Create keyring with the loaded key
+	// Create keyring with a copy of the loaded key
 	keyring := NewSimpleKeyring()
-	keyring.AddKey(secretKey)
+	keyring.AddKey(cloneSecretKey(secretKey))
 	
 	return keyring, nil
 }
@@ -146,17 +170,17 @@ func (kl *KeyringLoader) LoadKeyringForUser(username string) (saltpack.Keyring,
 	// Check if we have a cached key that's still valid
 	if cached, ok := kl.cache[username]; ok {
 		if time.Now().Before(cached.expiresAt) {
-			// Cache hit - create keyring with cached key
+			// Cache hit - create keyring with a copy of the cached key
 			keyring := NewSimpleKeyring()
-			keyring.AddKey(cached.secretKey)
+			keyring.AddKey(cloneSecretKey(cached.secretKey))
 			return keyring, nil
 		}
-		// Cache expired - remove it
-		delete(kl.cache, username)
+		// Cache expired - wipe and remove it
+		kl.evict(username)
 	}
 	
 	// Cache miss or expired - load key from disk
-	secretKey, err := loadPrivateKey(kl.configDir, username)
+	secretKey, err := loadPrivateKey(kl.configDir, username, kl.passphrase)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load private key for user '%s': %w", username, err)
 	}
@@ -184,9 +208,9 @@ func (kl *KeyringLoader) LoadKeyringForUser(username string) (saltpack.Keyring,
 		expiresAt: now.Add(kl.ttl),
 	}
 	
-	// Create keyring with the loaded key
+	// Create keyring with a copy of the loaded key
 	keyring := NewSimpleKeyring()
-	keyring.AddKey(secretKey)
+	keyring.AddKey(cloneSecretKey(secretKey))
 	
 	return keyring, nil
 }
@@ -207,13 +231,13 @@ func (kl *KeyringLoader) GetSecretKey(username string) (saltpack.BoxSecretKey, e
 	// Check cache
 	if cached, ok := kl.cache[username]; ok {
 		if time.Now().Before(cached.expiresAt) {
-			return cached.secretKey, nil
+			return cloneSecretKey(cached.secretKey), nil
 		}
-		delete(kl.cache, username)
+		kl.evict(username)
 	}
 	
 	// Load from disk
-	secretKey, err := loadPrivateKey(kl.configDir, username)
+	secretKey, err := loadPrivateKey(kl.configDir, username, kl.passphrase)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load private key for user '%s': %w", username, err)
 	}
@@ -235,23 +259,35 @@ func (kl *KeyringLoader) GetSecretKey(username string) (saltpack.BoxSecretKey, e
 		expiresAt: now.Add(kl.ttl),
 	}
 	
-	return secretKey, nil
+	return cloneSecretKey(secretKey), nil
 }
 
-// InvalidateCache removes cached keys, forcing a reload on next access
+// InvalidateCache wipes and removes cached keys, forcing a reload on next access
 func (kl *KeyringLoader) InvalidateCache() {
 	kl.mu.Lock()
 	defer kl.mu.Unlock()
 	
+	for _, cached := range kl.cache {
+		cached.zero()
+	}
 	kl.cache = make(map[string]*cachedKey)
 }
 
-// InvalidateCacheForUser removes a specific user's cached key
+// InvalidateCacheForUser wipes and removes a specific user's cached key
 func (kl *KeyringLoader) InvalidateCacheForUser(username string) {
 	kl.mu.Lock()
 	defer kl.mu.Unlock()
 	
-	delete(kl.cache, username)
+	kl.evict(username)
+}
+
+// evict wipes and removes a user's cached key
+// The caller must hold kl.mu
+func (kl *KeyringLoader) evict(username string) {
+	if cached, ok := kl.cache[username]; ok {
+		cached.zero()
+		delete(kl.cache, username)
+	}
 }
 
 // GetCachedUsers returns a list of usernames with cached keys
@@ -308,7 +344,7 @@ func (kl *KeyringLoader) CleanupExpiredKeys() int {
 	
 	for username, cached := range kl.cache {
 		if now.After(cached.expiresAt) {
-			delete(kl.cache, username)
+			kl.evict(username)
 			removed++
 		}
 	}
@@ -5,7 +5,9 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/keybase/saltpack"
 	"golang.org/x/crypto/curve25519"
@@ -32,6 +34,8 @@ type KeyPair struct {
 // SimpleKeyring is a basic implementation of saltpack.Keyring
 // It holds a set of secret keys for decryption and public keys for verification
 type SimpleKeyring struct {
+	// mu protects the key maps, so that Zero may run alongside lookups
+	mu         sync.RWMutex
 	secretKeys map[string]saltpack.BoxSecretKey
 	publicKeys map[string]saltpack.BoxPublicKey
 }
@@ -52,6 +56,9 @@ func (k *SimpleKeyring) AddKey(secretKey saltpack.BoxSecretKey) {
 	
 	publicKey := secretKey.GetPublicKey()
 	keyID := keyToString(publicKey.ToKID())
+	
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	k.secretKeys[keyID] = secretKey
 	k.publicKeys[keyID] = publicKey
 }
@@ -63,6 +70,9 @@ func (k *SimpleKeyring) AddPublicKey(publicKey saltpack.BoxPublicKey) {
 	}
 	
 	keyID := keyToString(publicKey.ToKID())
+	
+	k.mu.Lock()
+	defer k.mu.Unlock()
 	k.publicKeys[keyID] = publicKey
 }
 
@@ -76,6 +86,9 @@ func (k *SimpleKeyring) AddKeyPair(keyPair *KeyPair) {
 // LookupBoxSecretKey implements saltpack.Keyring interface
 // It finds the secret key corresponding to the given key identifier
 func (k *SimpleKeyring) LookupBoxSecretKey(kids [][]byte) (int, saltpack.BoxSecretKey) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	
 	for i, kid := range kids {
 		keyID := keyToString(kid)
 		if secretKey, ok := k.secretKeys[keyID]; ok {
@@ -88,6 +101,9 @@ func (k *SimpleKeyring) LookupBoxSecretKey(kids [][]byte) (int, saltpack.BoxSecr
 // LookupBoxPublicKey implements saltpack.Keyring interface
 // Returns the public key for a given key identifier
 func (k *SimpleKeyring) LookupBoxPublicKey(kid []byte) saltpack.BoxPublicKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	
 	keyID := keyToString(kid)
 	// Check if we have it as a public key
 	if publicKey, ok := k.publicKeys[keyID]; ok {
@@ -110,19 +126,42 @@ func (k *SimpleKeyring) ImportBoxSecretKey(keyBytes []byte) saltpack.BoxSecretKe
 	var keyArray [32]byte
 	copy(keyArray[:], keyBytes)
 	
-	return &naclBoxSecretKey{key: keyArray}
+	return newBoxSecretKey(&keyArray, nil)
 }
 
 // GetAllBoxSecretKeys implements saltpack.Keyring interface
-// Returns all secret keys in the keyring
+// Returns all secret keys in the keyring, ordered by key ID. Saltpack tries
+// each of them in turn against messages whose receivers are hidden.
 func (k *SimpleKeyring) GetAllBoxSecretKeys() []saltpack.BoxSecretKey {
-	var secretKeys []saltpack.BoxSecretKey
-	for _, secretKey := range k.secretKeys {
-		secretKeys = append(secretKeys, secretKey)
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	
+	keyIDs := make([]string, 0, len(k.secretKeys))
+	for keyID := range k.secretKeys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	
+	secretKeys := make([]saltpack.BoxSecretKey, 0, len(keyIDs))
+	for _, keyID := range keyIDs {
+		secretKeys = append(secretKeys, k.secretKeys[keyID])
 	}
 	return secretKeys
 }
 
+// Zero wipes every secret key in the keyring and removes them
+// Public keys are kept. Secret keys of other implementations are removed
+// but cannot be wiped.
+func (k *SimpleKeyring) Zero() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	
+	for keyID, secretKey := range k.secretKeys {
+		ZeroKey(secretKey)
+		delete(k.secretKeys, keyID)
+	}
+}
+
 // ImportBoxEphemeralKey implements saltpack.Keyring interface
 // Imports an ephemeral public key
 func (k *SimpleKeyring) ImportBoxEphemeralKey(kid []byte) saltpack.BoxPublicKey {
@@ -146,12 +185,7 @@ func (k *SimpleKeyring) CreateEphemeralKey() (saltpack.BoxSecretKey, error) {
 	}
 	
 	publicKey := &naclBoxPublicKey{key: *pub}
-	secretKey := &naclBoxSecretKey{
-		key:       *priv,
-		publicKey: publicKey,
-	}
-	
-	return secretKey, nil
+	return newBoxSecretKey(priv, publicKey), nil
 }
 
 // naclBoxPublicKey implements saltpack.BoxPublicKey using NaCl box
@@ -194,19 +228,40 @@ func (k *naclBoxPublicKey) CreateEphemeralKey() (saltpack.BoxSecretKey, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &naclBoxSecretKey{
-		key:       *priv,
-		publicKey: &naclBoxPublicKey{key: *pub},
-	}, nil
+	return newBoxSecretKey(priv, &naclBoxPublicKey{key: *pub}), nil
 }
 
 func (k *naclBoxPublicKey) HideIdentity() bool {
 	return false
 }
 
+// hiddenBoxPublicKey is a receiver key whose KID is left out of message headers
+type hiddenBoxPublicKey struct {
+	saltpack.BoxPublicKey
+}
+
+// HideIdentity makes saltpack write an empty receiver KID for this key
+func (k *hiddenBoxPublicKey) HideIdentity() bool {
+	return true
+}
+
+// HiddenReceiver returns key marked as a hidden (anonymous) receiver
+//
+// Messages encrypted for a hidden receiver do not list its KID in the
+// header, so the ciphertext does not reveal who can decrypt it. The receiver
+// finds its slot by trial decryption with every key in its keyring.
+func HiddenReceiver(key saltpack.BoxPublicKey) saltpack.BoxPublicKey {
+	if key == nil || key.HideIdentity() {
+		return key
+	}
+	return &hiddenBoxPublicKey{BoxPublicKey: key}
+}
+
 // naclBoxSecretKey implements saltpack.BoxSecretKey using NaCl box
+// The key is held in a SecureBuffer and can be wiped with Zero
 type naclBoxSecretKey struct {
-	key       [32]byte
+	key       *[32]byte
+	buf       *SecureBuffer
 	publicKey *naclBoxPublicKey
 }
 
@@ -223,10 +278,7 @@ func CreateSecretKey(keyBytes []byte) (saltpack.BoxSecretKey, error) {
 	var publicKeyArray [32]byte
 	curve25519ScalarBaseMult(&publicKeyArray, &keyArray)
 	
-	return &naclBoxSecretKey{
-		key:       keyArray,
-		publicKey: &naclBoxPublicKey{key: publicKeyArray},
-	}, nil
+	return newBoxSecretKey(&keyArray, &naclBoxPublicKey{key: publicKeyArray}), nil
 }
 
 // CreateSecretKeyFromHex creates a secret key from a hex-encoded string
@@ -235,6 +287,7 @@ func CreateSecretKeyFromHex(hexKey string) (saltpack.BoxSecretKey, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid hex string: %w", err)
 	}
+	defer clear(keyBytes)
 	
 	return CreateSecretKey(keyBytes)
 }
@@ -243,36 +296,41 @@ func (k *naclBoxSecretKey) GetPublicKey() saltpack.BoxPublicKey {
 	if k.publicKey == nil {
 		// Derive public key if not set
 		var publicKeyArray [32]byte
-		curve25519ScalarBaseMult(&publicKeyArray, &k.key)
+		curve25519ScalarBaseMult(&publicKeyArray, k.key)
 		k.publicKey = &naclBoxPublicKey{key: publicKeyArray}
 	}
 	return k.publicKey
 }
 
 func (k *naclBoxSecretKey) ToRawBoxKeyPointer() *saltpack.RawBoxKey {
-	return (*saltpack.RawBoxKey)(&k.key)
+	return (*saltpack.RawBoxKey)(k.key)
 }
 
 func (k *naclBoxSecretKey) Precompute(publicKey saltpack.BoxPublicKey) saltpack.BoxPrecomputedSharedKey {
 	var sharedKey [32]byte
-	box.Precompute(&sharedKey, (*[32]byte)(publicKey.ToRawBoxKeyPointer()), &k.key)
+	box.Precompute(&sharedKey, (*[32]byte)(publicKey.ToRawBoxKeyPointer()), k.key)
 	return &naclBoxPrecomputedSharedKey{key: sharedKey}
 }
 
 func (k *naclBoxSecretKey) Box(receiver saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) []byte {
 	noncePtr := (*[24]byte)(&nonce)
-	return box.Seal(nil, msg, noncePtr, (*[32]byte)(receiver.ToRawBoxKeyPointer()), &k.key)
+	return box.Seal(nil, msg, noncePtr, (*[32]byte)(receiver.ToRawBoxKeyPointer()), k.key)
 }
 
 func (k *naclBoxSecretKey) Unbox(sender saltpack.BoxPublicKey, nonce saltpack.Nonce, msg []byte) ([]byte, error) {
 	noncePtr := (*[24]byte)(&nonce)
-	out, ok := box.Open(nil, msg, noncePtr, (*[32]byte)(sender.ToRawBoxKeyPointer()), &k.key)
+	out, ok := box.Open(nil, msg, noncePtr, (*[32]byte)(sender.ToRawBoxKeyPointer()), k.key)
 	if !ok {
 		return nil, fmt.Errorf("unbox failed")
 	}
 	return out, nil
 }
 
+// Zero wipes the secret key; it cannot decrypt or encrypt afterwards
+func (k *naclBoxSecretKey) Zero() {
+	k.buf.Zero()
+}
+
 // naclBoxPrecomputedSharedKey implements saltpack.BoxPrecomputedSharedKey
 type naclBoxPrecomputedSharedKey struct {
 	key [32]byte
@@ -304,7 +362,7 @@ func GenerateKeyPair() (*KeyPair, error) {
 	}
 	
 	publicKey := &naclBoxPublicKey{key: *pub}
-	secretKey := &naclBoxSecretKey{key: *priv, publicKey: publicKey}
+	secretKey := newBoxSecretKey(priv, publicKey)
 	
 	return &KeyPair{
 		PublicKey:  publicKey,
@@ -321,11 +379,12 @@ func GenerateKeyPair() (*KeyPair, error) {
 // 2. Extract the Curve25519 encryption subkey (if available)
 // 3. Handle different PGP key types
 //
-// For now, this returns an error indicating that key conversion is needed
+// PGP bundles return an error; they are handled by ParsePGPPublicKeyBundle
+// and PGPEncryptor when the pgp format is configured
 func ParseKeybasePublicKey(pgpKeyBundle string) (saltpack.BoxPublicKey, error) {
 	// Check if it's a PGP key
 	if strings.Contains(pgpKeyBundle, "BEGIN PGP") {
-		return nil, fmt.Errorf("PGP key conversion not yet implemented; Keybase API returns PGP keys but Saltpack requires NaCl/Curve25519 keys")
+		return nil, fmt.Errorf("PGP key bundles cannot be converted to NaCl/Curve25519 keys for Saltpack; use ParsePGPPublicKeyBundle with the pgp format instead")
 	}
 	
 	// If it's already a raw key in hex format, parse it
@@ -351,6 +410,48 @@ func ParseKeybaseKeyID(kid string) ([]byte, error) {
 	return keyID, nil
 }
 
+// ParseEncryptionKID parses a Keybase NaCl DH KID into a Saltpack public key
+//
+// A Keybase KID is hex-encoded as: 01 (version) | 21 (Curve25519 DH type) |
+// 32-byte public key | 0a (suffix). Only DH KIDs carry an encryption key;
+// Ed25519 (0120) signing KIDs and PGP fingerprints are rejected.
+func ParseEncryptionKID(kid string) (saltpack.BoxPublicKey, error) {
+	keyID, err := ParseKeybaseKeyID(strings.ToLower(kid))
+	if err != nil {
+		return nil, err
+	}
+	
+	if len(keyID) != 35 {
+		return nil, fmt.Errorf("invalid KID length: expected 35 bytes, got %d", len(keyID))
+	}
+	if keyID[0] != kidVersion || keyID[34] != kidSuffix {
+		return nil, fmt.Errorf("invalid KID framing: %s", kid)
+	}
+	if keyID[1] != kidTypeCurve25519DH {
+		return nil, fmt.Errorf("KID %s is not a NaCl DH encryption key (type 0x%02x)", kid, keyID[1])
+	}
+	
+	return CreatePublicKey(keyID[2:34])
+}
+
+// EncryptionKID returns the Keybase NaCl DH KID for a Curve25519 public key
+// This is the inverse of ParseEncryptionKID
+func EncryptionKID(key saltpack.BoxPublicKey) string {
+	raw := key.ToRawBoxKeyPointer()
+	keyID := make([]byte, 0, 35)
+	keyID = append(keyID, kidVersion, kidTypeCurve25519DH)
+	keyID = append(keyID, raw[:]...)
+	keyID = append(keyID, kidSuffix)
+	return hex.EncodeToString(keyID)
+}
+
+// Keybase KID framing bytes
+const (
+	kidVersion          = 0x01
+	kidTypeCurve25519DH = 0x21
+	kidSuffix           = 0x0a
+)
+
 // keyToString converts a key identifier to a string for map lookups
 func keyToString(kid []byte) string {
 	return hex.EncodeToString(kid)
@@ -3,6 +3,7 @@ package crypto
 import (
 	"bytes"
 	"encoding/hex"
+	"strings"
 	"testing"
 )
 
@@ -703,3 +704,114 @@ func BenchmarkPrecompute(b *testing.B) {
 		_ = kp1.SecretKey.Precompute(kp2.PublicKey)
 	}
 }
+
+func TestParseEncryptionKID(t *testing.T) {
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	kid := EncryptionKID(keyPair.PublicKey)
+
+	tests := []struct {
+		name    string
+		kid     string
+		wantErr bool
+	}{
+		{
+			name:    "valid DH KID",
+			kid:     kid,
+			wantErr: false,
+		},
+		{
+			name:    "uppercase DH KID",
+			kid:     strings.ToUpper(kid),
+			wantErr: false,
+		},
+		{
+			name:    "Ed25519 signing KID",
+			kid:     "0120" + kid[4:],
+			wantErr: true,
+		},
+		{
+			name:    "missing suffix",
+			kid:     kid[:len(kid)-2],
+			wantErr: true,
+		},
+		{
+			name:    "bad suffix",
+			kid:     kid[:len(kid)-2] + "0b",
+			wantErr: true,
+		},
+		{
+			name:    "not hex",
+			kid:     "0121zz",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publicKey, err := ParseEncryptionKID(tt.kid)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseEncryptionKID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !KeysEqual(publicKey, keyPair.PublicKey) {
+				t.Error("ParseEncryptionKID() returned a different key")
+			}
+		})
+	}
+}
+
+func TestSimpleKeyringGetAllBoxSecretKeys(t *testing.T) {
+	keyring := NewSimpleKeyring()
+	for i := 0; i < 5; i++ {
+		keyPair, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateKeyPair() error = %v", err)
+		}
+		keyring.AddKeyPair(keyPair)
+	}
+
+	// Trial decryption tries keys in a stable order
+	first := keyring.GetAllBoxSecretKeys()
+	if len(first) != 5 {
+		t.Fatalf("GetAllBoxSecretKeys() returned %d keys, want 5", len(first))
+	}
+	for i := 1; i < len(first); i++ {
+		if keyToString(first[i-1].GetPublicKey().ToKID()) >= keyToString(first[i].GetPublicKey().ToKID()) {
+			t.Errorf("GetAllBoxSecretKeys() is not ordered by key ID at %d", i)
+		}
+	}
+	for attempt := 0; attempt < 5; attempt++ {
+		again := keyring.GetAllBoxSecretKeys()
+		for i := range again {
+			if again[i] != first[i] {
+				t.Fatalf("GetAllBoxSecretKeys() order changed between calls")
+			}
+		}
+	}
+}
+
+func TestHiddenReceiver(t *testing.T) {
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+
+	hidden := HiddenReceiver(keyPair.PublicKey)
+	if !hidden.HideIdentity() {
+		t.Error("HiddenReceiver().HideIdentity() = false, want true")
+	}
+	if keyPair.PublicKey.HideIdentity() {
+		t.Error("HiddenReceiver() changed the wrapped key")
+	}
+	if !KeysEqual(hidden, keyPair.PublicKey) {
+		t.Error("HiddenReceiver() changed the key")
+	}
+	if HiddenReceiver(hidden) != hidden {
+		t.Error("HiddenReceiver() of a hidden key should return it unchanged")
+	}
+	if HiddenReceiver(nil) != nil {
+		t.Error("HiddenReceiver(nil) should return nil")
+	}
+}
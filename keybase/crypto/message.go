@@ -24,9 +24,60 @@ type MessageInfo struct {
 	// IsAnonymousSender indicates if the sender is anonymous
 	IsAnonymousSender bool
 	
+	// IsHiddenReceiver indicates the message header did not list the
+	// receiver's KID, so the key was found by trial decryption
+	IsHiddenReceiver bool
+	
 	// ReceiverIndex is the index of the recipient in the receivers list (0-based)
 	// This indicates which recipient slot was used for decryption
 	ReceiverIndex int
+	
+	// Version is the Saltpack version of the message, read from its header
+	// (zero when only the MessageKeyInfo was parsed)
+	Version saltpack.Version
+	
+	// Signcrypted indicates the message used Saltpack signcryption, so the
+	// sender fields identify a verified Ed25519 signing key
+	Signcrypted bool
+	
+	// SignerKID is the Keybase KID (0120...0a) of the verified signing key
+	// (empty unless Signcrypted and the sender is not anonymous)
+	SignerKID string
+	
+	// Receiver identifies the key that opened the message (nil for
+	// signcrypted messages, which do not reveal the receiver key)
+	Receiver *KeyOwner
+	
+	// Sender identifies the key that wrote the message (nil if anonymous)
+	Sender *KeyOwner
+}
+
+// KeyOwner identifies a Keybase key and, once resolved, its owner
+type KeyOwner struct {
+	// KID is the Keybase KID of the key (0121... for encryption keys,
+	// 0120... for signing keys)
+	KID string
+	
+	// Username is the Keybase user owning the key (empty if unresolved)
+	Username string
+	
+	// Device is the name of the user's device holding the key (empty if
+	// unresolved or the key is not a device key)
+	Device string
+}
+
+// String returns "username/device", falling back to the KID for what is unresolved
+func (o *KeyOwner) String() string {
+	if o == nil {
+		return "<unknown>"
+	}
+	if o.Username == "" {
+		return o.KID
+	}
+	if o.Device == "" {
+		return o.Username
+	}
+	return o.Username + "/" + o.Device
 }
 
 // ParseMessageKeyInfo extracts information from saltpack.MessageKeyInfo
@@ -56,11 +107,14 @@ func ParseMessageKeyInfo(info *saltpack.MessageKeyInfo) (*MessageInfo, error) {
 		if receiverPublicKey != nil {
 			messageInfo.ReceiverKID = receiverPublicKey.ToKID()
 			messageInfo.ReceiverKIDHex = hex.EncodeToString(messageInfo.ReceiverKID)
+			messageInfo.Receiver = &KeyOwner{KID: EncryptionKID(receiverPublicKey)}
 		}
 	} else {
 		return nil, fmt.Errorf("ReceiverKey is nil in MessageKeyInfo")
 	}
 	
+	messageInfo.IsHiddenReceiver = info.ReceiverIsAnon
+	
 	// Extract sender key information (may be nil for anonymous sender)
 	// Use the SenderIsAnon field for explicit anonymous check
 	messageInfo.IsAnonymousSender = info.SenderIsAnon
@@ -68,16 +122,42 @@ func ParseMessageKeyInfo(info *saltpack.MessageKeyInfo) (*MessageInfo, error) {
 	if !info.SenderIsAnon && info.SenderKey != nil {
 		messageInfo.SenderKID = info.SenderKey.ToKID()
 		messageInfo.SenderKIDHex = hex.EncodeToString(messageInfo.SenderKID)
+		messageInfo.Sender = &KeyOwner{KID: EncryptionKID(info.SenderKey)}
 	}
 	
-	// Note: ReceiverIndex would need to be extracted from the message header
-	// The saltpack.MessageKeyInfo doesn't directly expose this, but it's available
-	// in the internal message header structure. For now, we set it to -1 to indicate unknown.
+	// MessageKeyInfo does not say which header slot was used; use
+	// ParseMessageInfo to read it from the ciphertext
 	messageInfo.ReceiverIndex = -1
 	
 	return messageInfo, nil
 }
 
+// ParseMessageInfo extracts information from saltpack.MessageKeyInfo like
+// ParseMessageKeyInfo, and also reads Version and ReceiverIndex from the
+// header of the ciphertext the MessageKeyInfo came from
+func ParseMessageInfo(info *saltpack.MessageKeyInfo, ciphertext []byte) (*MessageInfo, error) {
+	messageInfo, err := ParseMessageKeyInfo(info)
+	if err != nil {
+		return nil, err
+	}
+	
+	inspection, err := InspectMessage(ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read message header: %w", err)
+	}
+	if inspection.Type != saltpack.MessageTypeEncryption {
+		return nil, fmt.Errorf("not an encrypted message: %s", inspection.Type)
+	}
+	messageInfo.Version = inspection.Version
+	
+	// Hidden receivers have no KID in the header, leaving the index unknown
+	if !info.ReceiverIsAnon {
+		messageInfo.ReceiverIndex = inspection.RecipientIndex(messageInfo.Receiver.KID)
+	}
+	
+	return messageInfo, nil
+}
+
 // GetReceiverKeyID extracts the receiver key ID from MessageKeyInfo
 // This is a convenience function for the common use case of just needing
 // the key ID of the recipient that decrypted the message.
@@ -199,15 +279,29 @@ func MessageInfoString(info *MessageInfo) string {
 	
 	result := "MessageInfo{\n"
 	result += fmt.Sprintf("  ReceiverKID: %s\n", FormatKeyID(info.ReceiverKID))
+	if info.Receiver != nil && info.Receiver.Username != "" {
+		result += fmt.Sprintf("  Receiver: %s\n", info.Receiver)
+	}
 	
 	if info.IsAnonymousSender {
 		result += "  Sender: <anonymous>\n"
+	} else if info.Signcrypted {
+		result += fmt.Sprintf("  SignerKID: %s\n", info.SignerKID)
 	} else {
 		result += fmt.Sprintf("  SenderKID: %s\n", FormatKeyID(info.SenderKID))
 	}
+	if info.Sender != nil && info.Sender.Username != "" {
+		result += fmt.Sprintf("  Sender: %s\n", info.Sender)
+	}
+	
+	if info.Version.Major != 0 {
+		result += fmt.Sprintf("  Version: %s\n", info.Version)
+	}
 	
 	if info.ReceiverIndex >= 0 {
 		result += fmt.Sprintf("  ReceiverIndex: %d\n", info.ReceiverIndex)
+	} else if info.IsHiddenReceiver {
+		result += "  ReceiverIndex: <hidden>\n"
 	} else {
 		result += "  ReceiverIndex: <unknown>\n"
 	}
@@ -608,6 +608,113 @@ func TestMultipleRecipientsKeyInfo(t *testing.T) {
 	}
 }
 
+// TestParseMessageInfoReceiverIndex tests reading the receiver index from the header
+func TestParseMessageInfoReceiverIndex(t *testing.T) {
+	var recipients []*KeyPair
+	var publicKeys []saltpack.BoxPublicKey
+	for i := 0; i < 3; i++ {
+		recipient, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("Failed to generate recipient %d key: %v", i, err)
+		}
+		recipients = append(recipients, recipient)
+		publicKeys = append(publicKeys, recipient.PublicKey)
+	}
+	
+	enc, err := NewEncryptor(nil)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+	
+	ciphertext, err := enc.Encrypt([]byte("Message for all team members"), publicKeys)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	
+	armored, err := enc.EncryptArmored([]byte("Armored message"), publicKeys)
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	
+	for name, message := range map[string][]byte{"binary": ciphertext, "armored": []byte(armored)} {
+		// Saltpack shuffles the recipients, so each must land on a distinct slot
+		seen := make(map[int]bool)
+		for i, recipient := range recipients {
+			keyring := NewSimpleKeyring()
+			keyring.AddKeyPair(recipient)
+			
+			dec, err := NewDecryptor(&DecryptorConfig{Keyring: keyring})
+			if err != nil {
+				t.Fatalf("Failed to create decryptor for recipient %d: %v", i, err)
+			}
+			
+			var messageKeyInfo *saltpack.MessageKeyInfo
+			if name == "armored" {
+				_, messageKeyInfo, err = dec.DecryptArmored(armored)
+			} else {
+				_, messageKeyInfo, err = dec.Decrypt(message)
+			}
+			if err != nil {
+				t.Fatalf("%s: recipient %d failed to decrypt: %v", name, i, err)
+			}
+			
+			messageInfo, err := ParseMessageInfo(messageKeyInfo, message)
+			if err != nil {
+				t.Fatalf("%s: ParseMessageInfo() error = %v", name, err)
+			}
+			
+			index := messageInfo.ReceiverIndex
+			if index < 0 || index >= len(recipients) || seen[index] {
+				t.Errorf("%s: recipient %d ReceiverIndex = %d, want a distinct index in [0, %d)", name, i, index, len(recipients))
+			}
+			seen[index] = true
+			
+			if messageInfo.Receiver == nil || messageInfo.Receiver.KID != EncryptionKID(recipient.PublicKey) {
+				t.Errorf("%s: recipient %d Receiver = %v, want KID %s", name, i, messageInfo.Receiver, EncryptionKID(recipient.PublicKey))
+			}
+		}
+	}
+	
+	// A key that is not a recipient has no index
+	outsider, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate outsider key: %v", err)
+	}
+	index, err := ReceiverIndex(ciphertext, outsider.PublicKey.ToKID())
+	if err != nil {
+		t.Fatalf("ReceiverIndex() error = %v", err)
+	}
+	if index != -1 {
+		t.Errorf("ReceiverIndex() for outsider = %d, want -1", index)
+	}
+	
+	if _, err := ReceiverIndex([]byte("not a saltpack message"), outsider.PublicKey.ToKID()); err == nil {
+		t.Error("ReceiverIndex() should fail for a non-saltpack message")
+	}
+}
+
+// TestKeyOwnerString tests formatting of resolved and unresolved key owners
+func TestKeyOwnerString(t *testing.T) {
+	tests := []struct {
+		name  string
+		owner *KeyOwner
+		want  string
+	}{
+		{"nil", nil, "<unknown>"},
+		{"unresolved", &KeyOwner{KID: "0121abcd0a"}, "0121abcd0a"},
+		{"user only", &KeyOwner{KID: "0121abcd0a", Username: "alice"}, "alice"},
+		{"user and device", &KeyOwner{KID: "0121abcd0a", Username: "alice", Device: "laptop"}, "alice/laptop"},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.owner.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
 // TestFormatKeyID tests key ID formatting
 func TestFormatKeyID(t *testing.T) {
 	tests := []struct {
@@ -3,7 +3,9 @@ package crypto
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"testing"
 	"time"
 
@@ -741,3 +743,103 @@ func BenchmarkEncryptLargeMessage(b *testing.B) {
 		})
 	}
 }
+
+// TestHiddenRecipients tests that HideRecipients leaves receiver KIDs out of
+// the header and that every receiver finds its key by trial decryption
+func TestHiddenRecipients(t *testing.T) {
+	recipients := make([]*KeyPair, 3)
+	receivers := make([]saltpack.BoxPublicKey, len(recipients))
+	for i := range recipients {
+		keyPair, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("GenerateKeyPair() error = %v", err)
+		}
+		recipients[i] = keyPair
+		receivers[i] = keyPair.PublicKey
+	}
+	
+	enc, err := NewEncryptor(&EncryptorConfig{HideRecipients: true})
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	
+	plaintext := []byte("prod database password")
+	armored, err := enc.EncryptArmored(plaintext, receivers)
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	var streamed bytes.Buffer
+	if err := enc.EncryptStream(bytes.NewReader(plaintext), &streamed, receivers); err != nil {
+		t.Fatalf("EncryptStream() error = %v", err)
+	}
+	
+	for name, ciphertext := range map[string][]byte{"armored": []byte(armored), "binary stream": streamed.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			header, err := readHeader(ciphertext)
+			if err != nil {
+				t.Fatalf("readHeader() error = %v", err)
+			}
+			if len(header.Receivers) != len(receivers) {
+				t.Fatalf("header has %d receivers, want %d", len(header.Receivers), len(receivers))
+			}
+			for i, receiver := range header.Receivers {
+				if len(receiver.ReceiverKID) != 0 {
+					t.Errorf("header receiver %d has KID %x, want it hidden", i, receiver.ReceiverKID)
+				}
+			}
+			
+			for i, recipient := range recipients {
+				// Unrelated keys in the keyring are tried too
+				keyring := NewSimpleKeyring()
+				other, err := GenerateKeyPair()
+				if err != nil {
+					t.Fatalf("GenerateKeyPair() error = %v", err)
+				}
+				keyring.AddKeyPair(other)
+				keyring.AddKeyPair(recipient)
+				
+				dec, err := NewDecryptor(&DecryptorConfig{Keyring: keyring})
+				if err != nil {
+					t.Fatalf("NewDecryptor() error = %v", err)
+				}
+				stream, err := dec.NewDecryptStream(bytes.NewReader(ciphertext))
+				if err != nil {
+					t.Fatalf("recipient %d: NewDecryptStream() error = %v", i, err)
+				}
+				decrypted, err := io.ReadAll(stream)
+				if err != nil {
+					t.Fatalf("recipient %d: ReadAll() error = %v", i, err)
+				}
+				if !bytes.Equal(decrypted, plaintext) {
+					t.Errorf("recipient %d: plaintext = %q, want %q", i, decrypted, plaintext)
+				}
+				
+				info, err := ParseMessageInfo(stream.KeyInfo, ciphertext)
+				if err != nil {
+					t.Fatalf("ParseMessageInfo() error = %v", err)
+				}
+				if !info.IsHiddenReceiver || info.ReceiverIndex != -1 {
+					t.Errorf("IsHiddenReceiver = %t, ReceiverIndex = %d, want true, -1", info.IsHiddenReceiver, info.ReceiverIndex)
+				}
+				if info.Receiver.KID != EncryptionKID(recipient.PublicKey) {
+					t.Errorf("recipient %d: Receiver = %s, want %s", i, info.Receiver.KID, EncryptionKID(recipient.PublicKey))
+				}
+			}
+			
+			// A keyring without any of the receivers' keys still fails
+			outsider, err := GenerateKeyPair()
+			if err != nil {
+				t.Fatalf("GenerateKeyPair() error = %v", err)
+			}
+			keyring := NewSimpleKeyring()
+			keyring.AddKeyPair(outsider)
+			dec, err := NewDecryptor(&DecryptorConfig{Keyring: keyring})
+			if err != nil {
+				t.Fatalf("NewDecryptor() error = %v", err)
+			}
+			if _, err := dec.NewDecryptStream(bytes.NewReader(ciphertext)); !errors.Is(err, saltpack.ErrNoDecryptionKey) {
+				t.Errorf("NewDecryptStream() error = %v, want %v", err, saltpack.ErrNoDecryptionKey)
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// Environment variables read by DefaultPassphrase
+const (
+	// PassphraseEnv holds the passphrase of protected key files
+	PassphraseEnv = "KEYBASE_KEY_PASSPHRASE"
+
+	// PassphraseFDEnv is the number of an inherited file descriptor from
+	// which the passphrase is read, as with gpg --passphrase-fd
+	PassphraseFDEnv = "KEYBASE_KEY_PASSPHRASE_FD"
+)
+
+// ErrNoPassphrase is returned when a key file is passphrase-protected but no
+// passphrase source is available
+var ErrNoPassphrase = errors.New("no passphrase available: set " + PassphraseEnv + " or " + PassphraseFDEnv + ", or run in a terminal")
+
+// PassphraseFunc returns the passphrase of the protected key file at path
+type PassphraseFunc func(path string) ([]byte, error)
+
+// StaticPassphrase returns passphrase for every key file
+func StaticPassphrase(passphrase []byte) PassphraseFunc {
+	return func(string) ([]byte, error) {
+		return passphrase, nil
+	}
+}
+
+// PassphraseFromEnv reads the passphrase from the environment variable name
+func PassphraseFromEnv(name string) PassphraseFunc {
+	return func(string) ([]byte, error) {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("%w (%s is not set)", ErrNoPassphrase, name)
+		}
+		return []byte(value), nil
+	}
+}
+
+// PassphraseFromFD reads the passphrase from the first line of the file
+// descriptor fd, which is then closed
+//
+// The descriptor can only be read once, so the passphrase is remembered for
+// later key files. A failed read is not remembered.
+func PassphraseFromFD(fd uintptr) PassphraseFunc {
+	return NewPassphraseCache(readPassphraseFD(fd)).Passphrase
+}
+
+// readPassphraseFD returns a source that reads the passphrase from the file
+// descriptor fd
+func readPassphraseFD(fd uintptr) PassphraseFunc {
+	return func(string) ([]byte, error) {
+		file := os.NewFile(fd, "passphrase-fd")
+		if file == nil {
+			return nil, fmt.Errorf("invalid passphrase file descriptor %d", fd)
+		}
+		defer file.Close()
+
+		line, err := bufio.NewReader(file).ReadBytes('\n')
+		if err != nil && len(line) == 0 {
+			return nil, fmt.Errorf("failed to read passphrase from file descriptor %d: %w", fd, err)
+		}
+		return bytes.TrimRight(line, "\r\n"), nil
+	}
+}
+
+// promptAttempts is how many times PromptPassphrase asks for a passphrase
+// that does not unlock the key file
+const promptAttempts = 3
+
+// PromptPassphrase asks for the passphrase on the terminal without echoing
+// it, and remembers the answer for later key files
+//
+// An answer is checked against the key file at path before it is returned,
+// and the question asked again if it is wrong; after promptAttempts wrong
+// answers it fails with ErrIncorrectPassphrase. Only an answer that unlocked
+// a key file is remembered. It fails with ErrNoPassphrase when standard input
+// is not a terminal, so unattended runs never block on a prompt.
+func PromptPassphrase() PassphraseFunc {
+	return NewPassphraseCache(promptPassphrase).Passphrase
+}
+
+// promptPassphrase asks for the passphrase of the key file at path, as
+// described by PromptPassphrase
+func promptPassphrase(path string) ([]byte, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return nil, ErrNoPassphrase
+	}
+
+	for attempt := 1; ; attempt++ {
+		fmt.Fprintf(os.Stderr, "Passphrase for %s: ", path)
+		passphrase, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read passphrase: %w", err)
+		}
+
+		err = checkKeyFilePassphrase(path, passphrase)
+		if err == nil {
+			return passphrase, nil
+		}
+		clear(passphrase)
+		if !errors.Is(err, ErrIncorrectPassphrase) || attempt == promptAttempts {
+			return nil, err
+		}
+		fmt.Fprintln(os.Stderr, "Incorrect passphrase, try again.")
+	}
+}
+
+// DefaultPassphrase takes the passphrase from KEYBASE_KEY_PASSPHRASE, then
+// from the descriptor in KEYBASE_KEY_PASSPHRASE_FD, and otherwise prompts
+// on the terminal
+//
+// The first passphrase found is remembered for later key files; use
+// NewDefaultPassphraseCache to be able to wipe it.
+func DefaultPassphrase() PassphraseFunc {
+	return NewDefaultPassphraseCache().Passphrase
+}
+
+// NewDefaultPassphraseCache returns a PassphraseCache over the sources of
+// DefaultPassphrase
+func NewDefaultPassphraseCache() *PassphraseCache {
+	return NewPassphraseCache(func(path string) ([]byte, error) {
+		if value := os.Getenv(PassphraseEnv); value != "" {
+			return []byte(value), nil
+		}
+
+		if value := os.Getenv(PassphraseFDEnv); value != "" {
+			fd, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", PassphraseFDEnv, err)
+			}
+			return readPassphraseFD(uintptr(fd))(path)
+		}
+
+		return promptPassphrase(path)
+	})
+}
+
+// PassphraseCache calls a passphrase source until it returns a passphrase,
+// and returns that passphrase for every later key file, so that a prompt is
+// answered only once
+//
+// Errors are not remembered, so a failed read or prompt is tried again for
+// the next key file. Zero wipes the remembered passphrase.
+type PassphraseCache struct {
+	mu         sync.Mutex
+	source     PassphraseFunc
+	passphrase []byte
+}
+
+// NewPassphraseCache returns a cache of the passphrase returned by source
+func NewPassphraseCache(source PassphraseFunc) *PassphraseCache {
+	return &PassphraseCache{source: source}
+}
+
+// Passphrase is the PassphraseFunc of the cache
+func (c *PassphraseCache) Passphrase(path string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.passphrase == nil {
+		passphrase, err := c.source(path)
+		if err != nil {
+			return nil, err
+		}
+		c.passphrase = passphrase
+	}
+	return c.passphrase, nil
+}
+
+// Zero wipes the remembered passphrase, which is the slice the source
+// returned; the source is asked again next time
+func (c *PassphraseCache) Zero() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	clear(c.passphrase)
+	c.passphrase = nil
+}
+
+// checkKeyFilePassphrase reports whether passphrase unlocks the key file at
+// path, failing with ErrIncorrectPassphrase if it does not. Files that are
+// not passphrase-protected are not checked.
+func checkKeyFilePassphrase(path string, passphrase []byte) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read key file: %w", err)
+	}
+	if !IsEncryptedKeyFile(data) {
+		return nil
+	}
+
+	plaintext, err := DecryptKeyFile(data, passphrase)
+	if err != nil {
+		return err
+	}
+	clear(plaintext)
+	return nil
+}
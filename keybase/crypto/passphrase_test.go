@@ -0,0 +1,66 @@
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestPassphraseCache(t *testing.T) {
+	calls := 0
+	answers := []error{ErrNoPassphrase, nil}
+	var answer []byte
+	cache := NewPassphraseCache(func(string) ([]byte, error) {
+		err := answers[calls]
+		calls++
+		if err != nil {
+			return nil, err
+		}
+		answer = []byte("hunter2")
+		return answer, nil
+	})
+
+	if _, err := cache.Passphrase("key"); !errors.Is(err, ErrNoPassphrase) {
+		t.Fatalf("Passphrase() error = %v, want ErrNoPassphrase", err)
+	}
+
+	// The failure is not remembered, but the answer that follows it is
+	for i := 0; i < 2; i++ {
+		got, err := cache.Passphrase("key")
+		if err != nil {
+			t.Fatalf("Passphrase() error = %v", err)
+		}
+		if string(got) != "hunter2" {
+			t.Errorf("Passphrase() = %q, want %q", got, "hunter2")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("source called %d times, want 2", calls)
+	}
+
+	cache.Zero()
+	if !bytes.Equal(answer, make([]byte, len(answer))) {
+		t.Errorf("Zero() left the passphrase %q", answer)
+	}
+}
+
+func TestCheckKeyFilePassphrase(t *testing.T) {
+	senderKey, err := CreateTestSenderKey("alice")
+	if err != nil {
+		t.Fatalf("CreateTestSenderKey() error = %v", err)
+	}
+	path, err := SaveSenderKey(senderKey, t.TempDir(), &KeyFileOptions{
+		Passphrase: []byte("hunter2"),
+		KDF:        KDFScrypt,
+	})
+	if err != nil {
+		t.Fatalf("SaveSenderKey() error = %v", err)
+	}
+
+	if err := checkKeyFilePassphrase(path, []byte("hunter2")); err != nil {
+		t.Errorf("checkKeyFilePassphrase() with the passphrase error = %v", err)
+	}
+	if err := checkKeyFilePassphrase(path, []byte("hunter3")); !errors.Is(err, ErrIncorrectPassphrase) {
+		t.Errorf("checkKeyFilePassphrase() with a typo error = %v, want ErrIncorrectPassphrase", err)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build unix
+
+package crypto
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestDefaultPassphraseFromFD(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	defer r.Close()
+	if _, err := w.WriteString("from-fd\n"); err != nil {
+		t.Fatalf("WriteString() error = %v", err)
+	}
+	w.Close()
+
+	// The passphrase source closes the descriptor it reads, so hand it a copy
+	fd, err := syscall.Dup(int(r.Fd()))
+	if err != nil {
+		t.Fatalf("Dup() error = %v", err)
+	}
+
+	t.Setenv(PassphraseEnv, "")
+	t.Setenv(PassphraseFDEnv, strconv.Itoa(fd))
+
+	passphrase := DefaultPassphrase()
+	for i := 0; i < 2; i++ {
+		got, err := passphrase("key")
+		if err != nil {
+			t.Fatalf("passphrase() error = %v", err)
+		}
+		if string(got) != "from-fd" {
+			t.Errorf("passphrase() = %q, want %q", got, "from-fd")
+		}
+	}
+}
@@ -0,0 +1,418 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/dsa"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/ecdh"
+	"github.com/ProtonMail/go-crypto/openpgp/ecdsa"
+	"github.com/ProtonMail/go-crypto/openpgp/ed25519"
+	"github.com/ProtonMail/go-crypto/openpgp/ed448"
+	"github.com/ProtonMail/go-crypto/openpgp/eddsa"
+	"github.com/ProtonMail/go-crypto/openpgp/elgamal"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/ProtonMail/go-crypto/openpgp/x25519"
+	"github.com/ProtonMail/go-crypto/openpgp/x448"
+)
+
+const (
+	// PGPMessageType is the armor block type of an OpenPGP message
+	PGPMessageType = "PGP MESSAGE"
+
+	// PGPPublicKeyType is the armor block type of an OpenPGP public key bundle
+	PGPPublicKeyType = "PGP PUBLIC KEY BLOCK"
+)
+
+// PGPEncryptor handles encryption operations using OpenPGP
+//
+// It produces ASCII-armored "BEGIN PGP MESSAGE" output that standard GPG
+// tooling can decrypt with the recipient's Keybase PGP secret key. Recipient
+// keys may be RSA, ElGamal or the Ed25519/cv25519 keys that current GnuPG
+// and Keybase generate by default.
+type PGPEncryptor struct {
+	// Config holds OpenPGP packet settings (nil uses the library defaults)
+	Config *packet.Config
+}
+
+// NewPGPEncryptor creates a new PGPEncryptor
+//
+// config may be nil, in which case the OpenPGP defaults are used
+// (AES-128, SHA-256, no compression).
+func NewPGPEncryptor(config *packet.Config) *PGPEncryptor {
+	return &PGPEncryptor{Config: config}
+}
+
+// IsPGPKeyBundle reports whether bundle looks like an armored PGP public key
+func IsPGPKeyBundle(bundle string) bool {
+	return strings.Contains(bundle, "BEGIN "+PGPPublicKeyType)
+}
+
+// ParsePGPPublicKeyBundle parses an ASCII-armored PGP public key bundle
+//
+// This is the format returned in api.UserPublicKey.PublicKey for users whose
+// Keybase primary key is a PGP key. The first entity in the bundle is
+// returned, and it must have a key usable for encryption.
+func ParsePGPPublicKeyBundle(bundle string) (*openpgp.Entity, error) {
+	if !IsPGPKeyBundle(bundle) {
+		return nil, fmt.Errorf("not an armored PGP public key bundle")
+	}
+
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(bundle))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PGP key bundle: %w", err)
+	}
+	if len(entities) == 0 {
+		return nil, fmt.Errorf("PGP key bundle contains no keys")
+	}
+
+	entity := entities[0]
+	if !hasPGPEncryptionKey(entity) {
+		return nil, fmt.Errorf("PGP key %X has no encryption-capable key", entity.PrimaryKey.Fingerprint)
+	}
+
+	return entity, nil
+}
+
+// hasPGPEncryptionKey reports whether the entity has a key usable for encryption
+func hasPGPEncryptionKey(entity *openpgp.Entity) bool {
+	for _, subkey := range entity.Subkeys {
+		if subkey.Sig.FlagsValid && !subkey.Sig.FlagEncryptCommunications && !subkey.Sig.FlagEncryptStorage {
+			continue
+		}
+		if subkey.PublicKey.PubKeyAlgo.CanEncrypt() {
+			return true
+		}
+	}
+	return entity.PrimaryKey.PubKeyAlgo.CanEncrypt()
+}
+
+// EncryptArmored encrypts plaintext for the given PGP recipients
+//
+// Returns an ASCII-armored OpenPGP message ("BEGIN PGP MESSAGE").
+// The message is not signed.
+func (e *PGPEncryptor) EncryptArmored(plaintext []byte, recipients []*openpgp.Entity) (string, error) {
+	if len(plaintext) == 0 {
+		return "", fmt.Errorf("plaintext cannot be empty")
+	}
+
+	var ciphertext bytes.Buffer
+	if err := e.EncryptStreamArmored(bytes.NewReader(plaintext), &ciphertext, recipients); err != nil {
+		return "", err
+	}
+
+	return ciphertext.String(), nil
+}
+
+// EncryptStreamArmored encrypts data from plaintext and writes an
+// ASCII-armored OpenPGP message to ciphertext
+func (e *PGPEncryptor) EncryptStreamArmored(plaintext io.Reader, ciphertext io.Writer, recipients []*openpgp.Entity) error {
+	stream, err := e.NewEncryptStreamArmored(ciphertext, recipients)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(stream, plaintext); err != nil {
+		stream.Close()
+		return fmt.Errorf("encryption failed: %w", err)
+	}
+
+	return stream.Close()
+}
+
+// NewEncryptStreamArmored returns a stream that encrypts everything written
+// to it for the given PGP recipients and writes an ASCII-armored OpenPGP
+// message to ciphertext
+//
+// Close must be called to finish the message and the armor block.
+func (e *PGPEncryptor) NewEncryptStreamArmored(ciphertext io.Writer, recipients []*openpgp.Entity) (io.WriteCloser, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("at least one receiver is required")
+	}
+
+	armorWriter, err := armor.Encode(ciphertext, PGPMessageType, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create armor encoder: %w", err)
+	}
+
+	hints := &openpgp.FileHints{IsBinary: true}
+	encryptWriter, err := openpgp.Encrypt(armorWriter, recipients, nil, hints, e.Config)
+	if err != nil {
+		armorWriter.Close()
+		return nil, fmt.Errorf("encryption failed: %w", err)
+	}
+
+	return &pgpArmoredWriter{WriteCloser: encryptWriter, armor: armorWriter}, nil
+}
+
+// pgpArmoredWriter finishes the OpenPGP message and then its armor on Close
+type pgpArmoredWriter struct {
+	io.WriteCloser
+	armor io.WriteCloser
+}
+
+func (w *pgpArmoredWriter) Close() error {
+	if err := w.WriteCloser.Close(); err != nil {
+		w.armor.Close()
+		return fmt.Errorf("failed to finalize encryption: %w", err)
+	}
+
+	if err := w.armor.Close(); err != nil {
+		return fmt.Errorf("failed to finalize armor: %w", err)
+	}
+
+	return nil
+}
+
+// PGPDecryptor handles decryption of OpenPGP messages
+type PGPDecryptor struct {
+	// Keyring holds the PGP secret keys available for decryption
+	Keyring openpgp.EntityList
+}
+
+// NewPGPDecryptor creates a new PGPDecryptor from a keyring holding
+// decrypted PGP secret keys (see LoadPGPSecretKey)
+func NewPGPDecryptor(keyring openpgp.EntityList) (*PGPDecryptor, error) {
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("PGP keyring is required for decryption")
+	}
+
+	return &PGPDecryptor{Keyring: keyring}, nil
+}
+
+// Zero wipes the private keys in the keyring; the decryptor cannot decrypt
+// afterwards
+//
+// The secret values of every algorithm go-crypto supports are overwritten.
+// Copies made inside go-crypto or the Go standard library while decrypting,
+// such as the precomputed values of crypto/rsa, are out of reach and are
+// left to the garbage collector.
+func (d *PGPDecryptor) Zero() {
+	for _, entity := range d.Keyring {
+		zeroPGPPrivateKey(entity.PrivateKey)
+		for _, subkey := range entity.Subkeys {
+			zeroPGPPrivateKey(subkey.PrivateKey)
+		}
+	}
+	d.Keyring = nil
+}
+
+// zeroPGPPrivateKey overwrites the secret values of a decrypted private key
+func zeroPGPPrivateKey(key *packet.PrivateKey) {
+	if key == nil {
+		return
+	}
+
+	switch priv := key.PrivateKey.(type) {
+	case *rsa.PrivateKey:
+		zeroBigInt(priv.D)
+		for _, prime := range priv.Primes {
+			zeroBigInt(prime)
+		}
+		zeroBigInt(priv.Precomputed.Dp)
+		zeroBigInt(priv.Precomputed.Dq)
+		zeroBigInt(priv.Precomputed.Qinv)
+		for _, value := range priv.Precomputed.CRTValues {
+			zeroBigInt(value.Exp)
+			zeroBigInt(value.Coeff)
+			zeroBigInt(value.R)
+		}
+	case *dsa.PrivateKey:
+		zeroBigInt(priv.X)
+	case *elgamal.PrivateKey:
+		zeroBigInt(priv.X)
+	case *ecdsa.PrivateKey:
+		zeroBigInt(priv.D)
+	case *ecdh.PrivateKey:
+		clear(priv.D)
+	case *eddsa.PrivateKey:
+		clear(priv.D)
+	case *x25519.PrivateKey:
+		clear(priv.Secret)
+	case *x448.PrivateKey:
+		clear(priv.Secret)
+	case *ed25519.PrivateKey:
+		clear(priv.Key)
+	case *ed448.PrivateKey:
+		clear(priv.Key)
+	}
+	key.PrivateKey = nil
+}
+
+// zeroBigInt overwrites the words of x and sets it to zero
+func zeroBigInt(x *big.Int) {
+	if x != nil {
+		clear(x.Bits())
+		x.SetInt64(0)
+	}
+}
+
+// LoadPGPSecretKey loads an ASCII-armored PGP secret key from a file
+//
+// This is the format produced by `keybase pgp export --secret`. If the key is
+// passphrase-protected, passphrase is used to decrypt the primary key and all
+// subkeys; a nil passphrase is only accepted for unprotected keys.
+func LoadPGPSecretKey(path string, passphrase []byte) (openpgp.EntityList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PGP secret key file: %w", err)
+	}
+
+	return ParsePGPSecretKey(string(data), passphrase)
+}
+
+// ParsePGPSecretKey parses an ASCII-armored PGP secret key
+//
+// See LoadPGPSecretKey for passphrase handling.
+func ParsePGPSecretKey(armored string, passphrase []byte) (openpgp.EntityList, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse PGP secret key: %w", err)
+	}
+
+	var keyring openpgp.EntityList
+	for _, entity := range entities {
+		if entity.PrivateKey == nil {
+			continue
+		}
+
+		if err := decryptPGPPrivateKey(entity.PrivateKey, passphrase); err != nil {
+			return nil, err
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey == nil {
+				continue
+			}
+			if err := decryptPGPPrivateKey(subkey.PrivateKey, passphrase); err != nil {
+				return nil, err
+			}
+		}
+
+		keyring = append(keyring, entity)
+	}
+
+	if len(keyring) == 0 {
+		return nil, fmt.Errorf("no PGP secret keys found; export one with 'keybase pgp export --secret'")
+	}
+
+	return keyring, nil
+}
+
+// decryptPGPPrivateKey decrypts a passphrase-protected private key in place
+func decryptPGPPrivateKey(key *packet.PrivateKey, passphrase []byte) error {
+	if !key.Encrypted {
+		return nil
+	}
+	if len(passphrase) == 0 {
+		return fmt.Errorf("PGP secret key %s is passphrase-protected but no passphrase was provided", key.KeyIdString())
+	}
+	if err := key.Decrypt(passphrase); err != nil {
+		return fmt.Errorf("failed to unlock PGP secret key %s: %w", key.KeyIdString(), err)
+	}
+	return nil
+}
+
+// OpenPGP packet tags that can start an encrypted message (RFC 4880 4.3)
+const (
+	packetTagEncryptedKey          = 1
+	packetTagSymmetricKeyEncrypted = 3
+)
+
+// IsPGPMessage reports whether ciphertext is an OpenPGP message
+//
+// Both ASCII-armored ("BEGIN PGP MESSAGE") and binary messages are detected.
+// Binary messages start with a public-key or symmetric-key encrypted session
+// key packet, whose tag byte cannot collide with the msgpack bin header that
+// starts a binary Saltpack message.
+func IsPGPMessage(ciphertext []byte) bool {
+	trimmed := bytes.TrimSpace(ciphertext)
+	if bytes.HasPrefix(trimmed, []byte("-----BEGIN "+PGPMessageType+"-----")) {
+		return true
+	}
+	if len(trimmed) == 0 {
+		return false
+	}
+
+	tag := trimmed[0]
+	if tag&0x80 == 0 {
+		return false
+	}
+	if tag&0x40 != 0 {
+		// New format packet header: tag in the low 6 bits
+		tag &= 0x3f
+	} else {
+		// Old format packet header: tag in bits 2-5
+		tag = (tag & 0x3f) >> 2
+	}
+	return tag == packetTagEncryptedKey || tag == packetTagSymmetricKeyEncrypted
+}
+
+// Decrypt decrypts an ASCII-armored or binary OpenPGP message
+//
+// Signatures on the message, if any, are not verified.
+func (d *PGPDecryptor) Decrypt(ciphertext []byte) ([]byte, error) {
+	var plaintext bytes.Buffer
+	if err := d.DecryptStream(bytes.NewReader(ciphertext), &plaintext); err != nil {
+		return nil, err
+	}
+	return plaintext.Bytes(), nil
+}
+
+// DecryptStream decrypts an ASCII-armored or binary OpenPGP message from
+// ciphertext and writes the plaintext to plaintext
+func (d *PGPDecryptor) DecryptStream(ciphertext io.Reader, plaintext io.Writer) error {
+	body, err := d.NewDecryptStream(ciphertext)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(plaintext, body); err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+
+	return nil
+}
+
+// NewDecryptStream opens an ASCII-armored or binary OpenPGP message from a
+// reader and returns a reader of its plaintext
+//
+// Only messages encrypted to a key in the keyring are accepted: a bare
+// literal data packet, which anyone could write, is refused rather than
+// returned as plaintext, and so is symmetrically encrypted data without a
+// modification detection code (see packet.Config's
+// InsecureAllowUnauthenticatedMessages, which is left off). The integrity of
+// the message is only checked at its end: plaintext must not be trusted
+// until Read has returned io.EOF.
+func (d *PGPDecryptor) NewDecryptStream(ciphertext io.Reader) (io.Reader, error) {
+	reader := bufio.NewReader(ciphertext)
+
+	body := io.Reader(reader)
+	if peek, _ := reader.Peek(64); bytes.Contains(peek, []byte("-----BEGIN ")) {
+		block, err := armor.Decode(reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode PGP armor: %w", err)
+		}
+		if block.Type != PGPMessageType {
+			return nil, fmt.Errorf("unexpected PGP armor type %q", block.Type)
+		}
+		body = block.Body
+	}
+
+	md, err := openpgp.ReadMessage(body, d.Keyring, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+	if !md.IsEncrypted || md.DecryptedWith.PrivateKey == nil {
+		return nil, fmt.Errorf("decryption failed: PGP message is not encrypted to a key in the keyring")
+	}
+
+	return md.UnverifiedBody, nil
+}
@@ -0,0 +1,499 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/ProtonMail/go-crypto/openpgp/ecdh"
+	"github.com/ProtonMail/go-crypto/openpgp/packet"
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/internal/pgptest"
+)
+
+// Passphrase of testdata/pgp_secret_key_protected.asc, exported with
+// `gpg --export-secret-keys --armor` the same way `keybase pgp export --secret` does
+const testPGPPassphrase = "correct horse"
+
+// decryptPGPWith decrypts an armored PGP message with the given entities
+func decryptPGPWith(t *testing.T, ciphertext string, keyring openpgp.EntityList) ([]byte, error) {
+	t.Helper()
+
+	block, err := armor.Decode(strings.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("Failed to decode armor: %v", err)
+	}
+	if block.Type != PGPMessageType {
+		t.Fatalf("armor type = %q, want %q", block.Type, PGPMessageType)
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, keyring, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
+
+func TestParsePGPPublicKeyBundle(t *testing.T) {
+	entity, bundle := pgptest.NewEntity(t, "alice")
+
+	tests := []struct {
+		name    string
+		bundle  string
+		wantErr bool
+	}{
+		{
+			name:    "valid bundle",
+			bundle:  bundle,
+			wantErr: false,
+		},
+		{
+			name:    "not armored",
+			bundle:  "0120abcdef",
+			wantErr: true,
+		},
+		{
+			name:    "corrupt bundle",
+			bundle:  "-----BEGIN PGP PUBLIC KEY BLOCK-----\n\nnot base64\n-----END PGP PUBLIC KEY BLOCK-----",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParsePGPPublicKeyBundle(tt.bundle)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePGPPublicKeyBundle() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !bytes.Equal(parsed.PrimaryKey.Fingerprint, entity.PrimaryKey.Fingerprint) {
+				t.Errorf("fingerprint = %X, want %X", parsed.PrimaryKey.Fingerprint, entity.PrimaryKey.Fingerprint)
+			}
+		})
+	}
+}
+
+func TestPGPEncryptArmored(t *testing.T) {
+	alice, aliceBundle := pgptest.NewEntity(t, "alice")
+	bob, bobBundle := pgptest.NewEntity(t, "bob")
+	eve, _ := pgptest.NewEntity(t, "eve")
+
+	var recipients []*openpgp.Entity
+	for _, bundle := range []string{aliceBundle, bobBundle} {
+		entity, err := ParsePGPPublicKeyBundle(bundle)
+		if err != nil {
+			t.Fatalf("ParsePGPPublicKeyBundle() error = %v", err)
+		}
+		recipients = append(recipients, entity)
+	}
+
+	plaintext := []byte("stack secret for gpg users")
+	ciphertext, err := NewPGPEncryptor(nil).EncryptArmored(plaintext, recipients)
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+
+	if !strings.HasPrefix(ciphertext, "-----BEGIN PGP MESSAGE-----") {
+		t.Errorf("ciphertext is not an armored PGP message: %q", ciphertext[:min(len(ciphertext), 40)])
+	}
+
+	// Every recipient can decrypt with their own secret key
+	for _, entity := range []*openpgp.Entity{alice, bob} {
+		decrypted, err := decryptPGPWith(t, ciphertext, openpgp.EntityList{entity})
+		if err != nil {
+			t.Fatalf("decrypt as %v error = %v", entity.PrimaryKey.KeyIdString(), err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+		}
+	}
+
+	// A non-recipient cannot
+	if _, err := decryptPGPWith(t, ciphertext, openpgp.EntityList{eve}); err == nil {
+		t.Error("non-recipient decrypted the message")
+	}
+}
+
+func TestPGPEncryptCurve25519(t *testing.T) {
+	// The default for `keybase pgp gen` and `gpg --quick-gen-key`: an
+	// Ed25519 primary key with a cv25519 encryption subkey
+	config := &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Curve: packet.Curve25519}
+	entity, err := openpgp.NewEntity("carol", "", "carol@keybase.io", config)
+	if err != nil {
+		t.Fatalf("Failed to generate PGP entity: %v", err)
+	}
+	if algo := entity.Subkeys[0].PublicKey.PubKeyAlgo; algo != packet.PubKeyAlgoECDH {
+		t.Fatalf("encryption subkey algorithm = %v, want ECDH", algo)
+	}
+
+	recipient, err := ParsePGPPublicKeyBundle(pgptest.PublicKeyBundle(t, entity))
+	if err != nil {
+		t.Fatalf("ParsePGPPublicKeyBundle() error = %v", err)
+	}
+
+	plaintext := []byte("stack secret for a cv25519 key")
+	ciphertext, err := NewPGPEncryptor(nil).EncryptArmored(plaintext, []*openpgp.Entity{recipient})
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+
+	decrypted, err := decryptPGPWith(t, ciphertext, openpgp.EntityList{entity})
+	if err != nil {
+		t.Fatalf("decrypt error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+	}
+}
+
+func TestPGPEncryptErrors(t *testing.T) {
+	alice, _ := pgptest.NewEntity(t, "alice")
+	encryptor := NewPGPEncryptor(nil)
+
+	if _, err := encryptor.EncryptArmored(nil, []*openpgp.Entity{alice}); err == nil {
+		t.Error("EncryptArmored() with empty plaintext should fail")
+	}
+	if _, err := encryptor.EncryptArmored([]byte("secret"), nil); err == nil {
+		t.Error("EncryptArmored() with no recipients should fail")
+	}
+}
+
+func TestLoadPGPSecretKey(t *testing.T) {
+	alice, _ := pgptest.NewEntity(t, "alice")
+	unprotected := pgptest.ExportSecretKey(t, alice)
+	protected := filepath.Join("testdata", "pgp_secret_key_protected.asc")
+
+	tests := []struct {
+		name       string
+		path       string
+		passphrase []byte
+		wantErr    bool
+	}{
+		{
+			name:    "unprotected key",
+			path:    unprotected,
+			wantErr: false,
+		},
+		{
+			name:       "protected key with passphrase",
+			path:       protected,
+			passphrase: []byte(testPGPPassphrase),
+			wantErr:    false,
+		},
+		{
+			name:    "protected key without passphrase",
+			path:    protected,
+			wantErr: true,
+		},
+		{
+			name:       "protected key with wrong passphrase",
+			path:       protected,
+			passphrase: []byte("wrong"),
+			wantErr:    true,
+		},
+		{
+			name:    "public key only",
+			path:    filepath.Join("testdata", "pgp_public_key_protected.asc"),
+			wantErr: true,
+		},
+		{
+			name:    "missing file",
+			path:    filepath.Join(t.TempDir(), "missing.asc"),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyring, err := LoadPGPSecretKey(tt.path, tt.passphrase)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadPGPSecretKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(keyring) != 1 {
+				t.Errorf("len(keyring) = %d, want 1", len(keyring))
+			}
+		})
+	}
+}
+
+func TestPGPDecrypt(t *testing.T) {
+	publicKey, err := os.ReadFile(filepath.Join("testdata", "pgp_public_key_protected.asc"))
+	if err != nil {
+		t.Fatalf("Failed to read public key: %v", err)
+	}
+	recipient, err := ParsePGPPublicKeyBundle(string(publicKey))
+	if err != nil {
+		t.Fatalf("ParsePGPPublicKeyBundle() error = %v", err)
+	}
+
+	keyring, err := LoadPGPSecretKey(filepath.Join("testdata", "pgp_secret_key_protected.asc"), []byte(testPGPPassphrase))
+	if err != nil {
+		t.Fatalf("LoadPGPSecretKey() error = %v", err)
+	}
+	decryptor, err := NewPGPDecryptor(keyring)
+	if err != nil {
+		t.Fatalf("NewPGPDecryptor() error = %v", err)
+	}
+
+	plaintext := []byte("existing PGP-encrypted secret")
+	armored, err := NewPGPEncryptor(nil).EncryptArmored(plaintext, []*openpgp.Entity{recipient})
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+
+	// Binary form of the same message
+	block, err := armor.Decode(strings.NewReader(armored))
+	if err != nil {
+		t.Fatalf("Failed to decode armor: %v", err)
+	}
+	binary, err := io.ReadAll(block.Body)
+	if err != nil {
+		t.Fatalf("Failed to read binary message: %v", err)
+	}
+
+	for name, ciphertext := range map[string][]byte{
+		"armored": []byte(armored),
+		"binary":  binary,
+	} {
+		t.Run(name, func(t *testing.T) {
+			if !IsPGPMessage(ciphertext) {
+				t.Fatal("IsPGPMessage() = false, want true")
+			}
+			decrypted, err := decryptor.Decrypt(ciphertext)
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+			}
+		})
+	}
+
+	// A message encrypted by gpg itself, as existing secrets were
+	t.Run("gpg", func(t *testing.T) {
+		ciphertext, err := os.ReadFile(filepath.Join("testdata", "pgp_message_gpg.asc"))
+		if err != nil {
+			t.Fatalf("Failed to read message: %v", err)
+		}
+		decrypted, err := decryptor.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt() error = %v", err)
+		}
+		if string(decrypted) != "secret encrypted by gpg" {
+			t.Errorf("Decrypt() = %q, want %q", decrypted, "secret encrypted by gpg")
+		}
+	})
+
+	// A message for someone else is rejected
+	other, _ := pgptest.NewEntity(t, "other")
+	foreign, err := NewPGPEncryptor(nil).EncryptArmored(plaintext, []*openpgp.Entity{other})
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	if _, err := decryptor.Decrypt([]byte(foreign)); err == nil {
+		t.Error("Decrypt() of a message for another key should fail")
+	}
+}
+
+// nopWriteCloser adds a no-op Close to a bytes.Buffer
+type nopWriteCloser struct {
+	*bytes.Buffer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// armorPGPMessage wraps a binary OpenPGP message in "PGP MESSAGE" armor
+func armorPGPMessage(t *testing.T, message []byte) []byte {
+	t.Helper()
+
+	var armored bytes.Buffer
+	armorWriter, err := armor.Encode(&armored, PGPMessageType, nil)
+	if err != nil {
+		t.Fatalf("Failed to create armor encoder: %v", err)
+	}
+	if _, err := armorWriter.Write(message); err != nil {
+		t.Fatalf("Failed to write armor: %v", err)
+	}
+	if err := armorWriter.Close(); err != nil {
+		t.Fatalf("Failed to close armor encoder: %v", err)
+	}
+	return armored.Bytes()
+}
+
+// literalPacket returns a literal data packet holding plaintext
+func literalPacket(t *testing.T, plaintext []byte) []byte {
+	t.Helper()
+
+	var literal bytes.Buffer
+	writer, err := packet.SerializeLiteral(nopWriteCloser{&literal}, true, "", 0)
+	if err != nil {
+		t.Fatalf("Failed to create literal packet: %v", err)
+	}
+	if _, err := writer.Write(plaintext); err != nil {
+		t.Fatalf("Failed to write literal packet: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close literal packet: %v", err)
+	}
+	return literal.Bytes()
+}
+
+// unprotectedPGPMessage encrypts plaintext to recipient in a legacy
+// Symmetrically Encrypted Data packet (tag 9), which has no modification
+// detection code
+func unprotectedPGPMessage(t *testing.T, recipient *openpgp.Entity, plaintext []byte) []byte {
+	t.Helper()
+
+	encryptionKey, ok := recipient.EncryptionKey(time.Now())
+	if !ok {
+		t.Fatal("recipient has no encryption key")
+	}
+	sessionKey := make([]byte, 16)
+	if _, err := rand.Read(sessionKey); err != nil {
+		t.Fatalf("Failed to generate session key: %v", err)
+	}
+
+	var message bytes.Buffer
+	if err := packet.SerializeEncryptedKey(&message, encryptionKey.PublicKey, packet.CipherAES128, sessionKey, nil); err != nil {
+		t.Fatalf("Failed to serialize encrypted key: %v", err)
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		t.Fatalf("Failed to create cipher: %v", err)
+	}
+	randData := make([]byte, block.BlockSize())
+	if _, err := rand.Read(randData); err != nil {
+		t.Fatalf("Failed to generate prefix: %v", err)
+	}
+	stream, prefix := packet.NewOCFBEncrypter(block, randData, packet.OCFBResync)
+	body := literalPacket(t, plaintext)
+	stream.XORKeyStream(body, body)
+	body = append(prefix, body...)
+
+	// New format packet header with a five-octet length
+	message.WriteByte(0xc0 | 9)
+	message.WriteByte(0xff)
+	message.Write(binary.BigEndian.AppendUint32(nil, uint32(len(body))))
+	message.Write(body)
+	return message.Bytes()
+}
+
+func TestPGPDecryptRejectsUnprotectedMessages(t *testing.T) {
+	entity, _ := pgptest.NewEntity(t, "alice")
+	decryptor, err := NewPGPDecryptor(openpgp.EntityList{entity})
+	if err != nil {
+		t.Fatalf("NewPGPDecryptor() error = %v", err)
+	}
+	injected := []byte("injected secret")
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+	}{
+		// Anyone can write a plaintext literal packet and armor it as a
+		// PGP message; no key is involved
+		{"literal data only", armorPGPMessage(t, literalPacket(t, injected))},
+		{"no modification detection code", armorPGPMessage(t, unprotectedPGPMessage(t, entity, injected))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsPGPMessage(tt.ciphertext) {
+				t.Fatal("IsPGPMessage() = false, want true")
+			}
+			if plaintext, err := decryptor.Decrypt(tt.ciphertext); err == nil {
+				t.Errorf("Decrypt() = %q, want an error", plaintext)
+			}
+			if _, err := decryptor.NewDecryptStream(bytes.NewReader(tt.ciphertext)); err == nil {
+				t.Error("NewDecryptStream() should fail")
+			}
+		})
+	}
+}
+
+func TestPGPDecryptorZero(t *testing.T) {
+	rsaEntity, _ := pgptest.NewEntity(t, "alice")
+	curveEntity, err := openpgp.NewEntity("carol", "", "carol@keybase.io", &packet.Config{Algorithm: packet.PubKeyAlgoEdDSA, Curve: packet.Curve25519})
+	if err != nil {
+		t.Fatalf("Failed to generate PGP entity: %v", err)
+	}
+
+	ciphertext, err := NewPGPEncryptor(nil).EncryptArmored([]byte("secret"), []*openpgp.Entity{rsaEntity})
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+
+	rsaKey := rsaEntity.Subkeys[0].PrivateKey.PrivateKey.(*rsa.PrivateKey)
+	ecdhKey := curveEntity.Subkeys[0].PrivateKey.PrivateKey.(*ecdh.PrivateKey)
+	decryptor, err := NewPGPDecryptor(openpgp.EntityList{rsaEntity, curveEntity})
+	if err != nil {
+		t.Fatalf("NewPGPDecryptor() error = %v", err)
+	}
+	decryptor.Zero()
+
+	if rsaKey.D.Sign() != 0 || rsaKey.Primes[0].Sign() != 0 {
+		t.Error("Zero() left the RSA private exponent or primes")
+	}
+	if !bytes.Equal(ecdhKey.D, make([]byte, len(ecdhKey.D))) {
+		t.Error("Zero() left the cv25519 secret")
+	}
+	if _, err := decryptor.Decrypt([]byte(ciphertext)); err == nil {
+		t.Error("Decrypt() after Zero() should fail")
+	}
+}
+
+func TestIsPGPMessage(t *testing.T) {
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	encryptor, err := NewEncryptor(nil)
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	receivers := []saltpack.BoxPublicKey{keyPair.PublicKey}
+
+	saltpackBinary, err := encryptor.Encrypt([]byte("secret"), receivers)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	saltpackArmored, err := encryptor.EncryptArmored([]byte("secret"), receivers)
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		want       bool
+	}{
+		{"armored PGP", []byte("-----BEGIN PGP MESSAGE-----\n\nwcBM\n-----END PGP MESSAGE-----"), true},
+		{"old format session key packet", []byte{0x85, 0x01, 0x0c}, true},
+		{"new format session key packet", []byte{0xc1, 0x01}, true},
+		{"binary saltpack", saltpackBinary, false},
+		{"armored saltpack", []byte(saltpackArmored), false},
+		{"empty", nil, false},
+		{"plain text", []byte("hello"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPGPMessage(tt.ciphertext); got != tt.want {
+				t.Errorf("IsPGPMessage() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package crypto
+
+import (
+	"os"
+	"runtime"
+	"unsafe"
+
+	"github.com/keybase/saltpack"
+)
+
+// Zeroer is implemented by secret material that can be wiped from memory:
+// SecureBuffer, the secret and signing keys created by this package,
+// SimpleKeyring and DataKey
+type Zeroer interface {
+	Zero()
+}
+
+// ZeroKey wipes key if it implements Zeroer; other values are left as they are
+func ZeroKey(key interface{}) {
+	if z, ok := key.(Zeroer); ok && z != nil {
+		z.Zero()
+	}
+}
+
+// SecureBuffer holds secret material in page-aligned memory that is locked
+// into RAM on a best-effort basis
+//
+// The buffer is an ordinary Go heap slice, over-allocated so that the secret
+// starts on a page boundary and shares no page with other allocations. Where
+// the platform allows it (mlock on Unix) those pages are locked so that they
+// are not written to swap; if locking fails, for example because
+// RLIMIT_MEMLOCK is exhausted, the buffer still works and Locked reports
+// false. The lock relies on the garbage collector not moving heap objects,
+// which holds for the current Go runtime but is not guaranteed, and the
+// buffer is not protected from core dumps or from other code in the process.
+//
+// Zero wipes the contents. Buffers that become unreachable without being
+// zeroed are wiped and unlocked by a finalizer.
+type SecureBuffer struct {
+	data   []byte
+	locked bool
+}
+
+// NewSecureBuffer allocates a zero-filled secure buffer of size bytes
+func NewSecureBuffer(size int) *SecureBuffer {
+	if size <= 0 {
+		return &SecureBuffer{}
+	}
+
+	// Allocate whole pages plus one, so that the buffer can start on a page
+	// boundary and share no page with other allocations
+	pageSize := os.Getpagesize()
+	total := (size+pageSize-1)/pageSize*pageSize + pageSize
+	raw := make([]byte, total)
+	offset := 0
+	if rem := int(uintptr(unsafe.Pointer(&raw[0])) % uintptr(pageSize)); rem != 0 {
+		offset = pageSize - rem
+	}
+	region := raw[offset : offset+(size+pageSize-1)/pageSize*pageSize]
+
+	buf := &SecureBuffer{
+		data:   region[:size:size],
+		locked: lockMemory(region) == nil,
+	}
+
+	// The finalizer is attached to the allocation itself rather than to buf,
+	// because keys hold pointers into the data after buf is unreachable
+	locked := buf.locked
+	regionOffset, regionLen := offset, len(region)
+	runtime.SetFinalizer(&raw[0], func(p *byte) {
+		region := unsafe.Slice(p, total)[regionOffset : regionOffset+regionLen]
+		clear(region)
+		if locked {
+			unlockMemory(region)
+		}
+	})
+
+	return buf
+}
+
+// NewSecureBufferFrom copies data into a new secure buffer and wipes data
+func NewSecureBufferFrom(data []byte) *SecureBuffer {
+	buf := NewSecureBuffer(len(data))
+	copy(buf.data, data)
+	clear(data)
+	return buf
+}
+
+// Bytes returns the contents of the buffer
+//
+// The slice aliases the buffer: it must not be retained after Zero, and
+// copying it out defeats the purpose of the buffer.
+func (b *SecureBuffer) Bytes() []byte {
+	if b == nil {
+		return nil
+	}
+	return b.data
+}
+
+// Len returns the size of the buffer in bytes
+func (b *SecureBuffer) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.data)
+}
+
+// Locked reports whether the buffer's pages are locked into RAM
+func (b *SecureBuffer) Locked() bool {
+	return b != nil && b.locked
+}
+
+// Zero overwrites the contents of the buffer with zeros
+//
+// The buffer keeps its size, so slices returned by Bytes read as zeros
+// afterwards. Zero may be called more than once.
+func (b *SecureBuffer) Zero() {
+	if b == nil {
+		return
+	}
+	clear(b.data)
+}
+
+// newSecureKey returns a 32-byte key held in a secure buffer, initialized
+// from key, which is wiped
+func newSecureKey(key *[32]byte) (*[32]byte, *SecureBuffer) {
+	buf := NewSecureBufferFrom(key[:])
+	return (*[32]byte)(buf.Bytes()), buf
+}
+
+// newBoxSecretKey returns a NaCl box secret key held in a secure buffer;
+// key is wiped. publicKey may be nil, in which case it is derived on demand.
+func newBoxSecretKey(key *[32]byte, publicKey *naclBoxPublicKey) *naclBoxSecretKey {
+	secureKey, buf := newSecureKey(key)
+	return &naclBoxSecretKey{key: secureKey, buf: buf, publicKey: publicKey}
+}
+
+// cloneSecretKey returns a copy of key in its own secure buffer, so that the
+// copy and the original can be wiped independently
+//
+// Keys of other implementations cannot be copied and are returned as they are.
+func cloneSecretKey(key saltpack.BoxSecretKey) saltpack.BoxSecretKey {
+	naclKey, ok := key.(*naclBoxSecretKey)
+	if !ok {
+		return key
+	}
+	var raw [32]byte
+	copy(raw[:], naclKey.key[:])
+	return newBoxSecretKey(&raw, naclKey.publicKey)
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package crypto
+
+import "errors"
+
+// lockMemory is unsupported on this platform; buffers are still wiped
+func lockMemory(b []byte) error {
+	return errors.New("memory locking is not supported on this platform")
+}
+
+// unlockMemory is a no-op on this platform
+func unlockMemory(b []byte) {}
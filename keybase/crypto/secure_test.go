@@ -0,0 +1,195 @@
+package crypto
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/keybase/saltpack"
+)
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSecureBuffer(t *testing.T) {
+	buf := NewSecureBuffer(100)
+	if buf.Len() != 100 || len(buf.Bytes()) != 100 {
+		t.Fatalf("Len() = %d, want 100", buf.Len())
+	}
+	if !isZero(buf.Bytes()) {
+		t.Error("new buffer is not zero-filled")
+	}
+	t.Logf("Locked() = %v", buf.Locked())
+
+	copy(buf.Bytes(), "secret")
+	buf.Zero()
+	if !isZero(buf.Bytes()) {
+		t.Error("Zero() left data in the buffer")
+	}
+	buf.Zero()
+
+	source := []byte("plaintext")
+	buf = NewSecureBufferFrom(source)
+	if string(buf.Bytes()) != "plaintext" {
+		t.Errorf("NewSecureBufferFrom() = %q, want %q", buf.Bytes(), "plaintext")
+	}
+	if !isZero(source) {
+		t.Error("NewSecureBufferFrom() did not wipe its source")
+	}
+
+	if empty := NewSecureBuffer(0); empty.Len() != 0 || empty.Bytes() != nil {
+		t.Error("NewSecureBuffer(0) should be empty")
+	}
+	// Unreachable buffers are wiped and unlocked by their finalizers, so
+	// locked memory does not run out
+	for i := 0; i < 1000; i++ {
+		NewSecureBufferFrom([]byte("short-lived secret"))
+	}
+	runtime.GC()
+	runtime.GC()
+
+	var nilBuf *SecureBuffer
+	nilBuf.Zero()
+	if nilBuf.Len() != 0 || nilBuf.Locked() {
+		t.Error("nil buffer should be empty and unlocked")
+	}
+}
+
+func TestSecretKeyZero(t *testing.T) {
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+
+	keyring := NewSimpleKeyring()
+	keyring.AddKey(keyPair.SecretKey)
+	encryptor, _ := NewEncryptor(nil)
+	decryptor, _ := NewDecryptor(&DecryptorConfig{Keyring: keyring})
+
+	ciphertext, err := encryptor.EncryptArmored([]byte("secret"), []saltpack.BoxPublicKey{keyPair.PublicKey})
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	if _, _, err := decryptor.DecryptArmored(ciphertext); err != nil {
+		t.Fatalf("DecryptArmored() error = %v", err)
+	}
+
+	ZeroKey(keyPair.SecretKey)
+	if !isZero(ExportSecretKeyBytes(keyPair.SecretKey)) {
+		t.Error("ZeroKey() left secret key material")
+	}
+	if _, _, err := decryptor.DecryptArmored(ciphertext); err == nil {
+		t.Error("DecryptArmored() with a wiped key should fail")
+	}
+
+	// Keys of other implementations are left alone
+	ZeroKey(nil)
+	ZeroKey(keyPair.PublicKey)
+}
+
+func TestSigningKeyZero(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+
+	message := []byte("message")
+	signature, err := signingKey.Sign(message)
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := signingKey.GetPublicKey().Verify(message, signature); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	ZeroKey(signingKey)
+	if !isZero(ExportSigningKeySeed(signingKey)) {
+		t.Error("ZeroKey() left signing key material")
+	}
+	// The public key is a copy and survives
+	if isZero(signingKey.GetPublicKey().ToKID()) {
+		t.Error("ZeroKey() wiped the public key")
+	}
+}
+
+func TestSimpleKeyringZero(t *testing.T) {
+	keyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+
+	keyring := NewSimpleKeyring()
+	keyring.AddKeyPair(keyPair)
+	keyring.Zero()
+
+	if i, key := keyring.LookupBoxSecretKey([][]byte{keyPair.PublicKey.ToKID()}); i != -1 || key != nil {
+		t.Error("LookupBoxSecretKey() found a key after Zero()")
+	}
+	if len(keyring.GetAllBoxSecretKeys()) != 0 {
+		t.Error("GetAllBoxSecretKeys() is not empty after Zero()")
+	}
+	if keyring.LookupBoxPublicKey(keyPair.PublicKey.ToKID()) == nil {
+		t.Error("Zero() removed the public key")
+	}
+	if !isZero(ExportSecretKeyBytes(keyPair.SecretKey)) {
+		t.Error("Zero() left secret key material")
+	}
+}
+
+func TestKeyringLoaderWipesCachedKeys(t *testing.T) {
+	tempDir := t.TempDir()
+	testKey, err := CreateTestSenderKey("testuser")
+	if err != nil {
+		t.Fatalf("CreateTestSenderKey() error = %v", err)
+	}
+	want := append([]byte(nil), ExportSecretKeyBytes(testKey.SecretKey)...)
+	if err := SaveSenderKeyForTesting(testKey, tempDir); err != nil {
+		t.Fatalf("SaveSenderKeyForTesting() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "config.json"), []byte(`{"current_user": "testuser"}`), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	loader, err := NewKeyringLoader(&KeyringLoaderConfig{ConfigDir: tempDir, TTL: time.Hour})
+	if err != nil {
+		t.Fatalf("NewKeyringLoader() error = %v", err)
+	}
+
+	secretKey, err := loader.GetSecretKey("testuser")
+	if err != nil {
+		t.Fatalf("GetSecretKey() error = %v", err)
+	}
+	cached := loader.cache["testuser"].secretKey
+
+	loader.InvalidateCache()
+	if !isZero(ExportSecretKeyBytes(cached)) {
+		t.Error("InvalidateCache() left the cached key in memory")
+	}
+	// The caller's copy is its own
+	if !bytes.Equal(ExportSecretKeyBytes(secretKey), want) {
+		t.Error("InvalidateCache() wiped the key returned by GetSecretKey()")
+	}
+
+	// Expired keys are wiped when they are evicted
+	loader.SetTTL(time.Nanosecond)
+	if _, err := loader.LoadKeyringForUser("testuser"); err != nil {
+		t.Fatalf("LoadKeyringForUser() error = %v", err)
+	}
+	cached = loader.cache["testuser"].secretKey
+	time.Sleep(time.Millisecond)
+	if removed := loader.CleanupExpiredKeys(); removed != 1 {
+		t.Fatalf("CleanupExpiredKeys() = %d, want 1", removed)
+	}
+	if !isZero(ExportSecretKeyBytes(cached)) {
+		t.Error("CleanupExpiredKeys() left the expired key in memory")
+	}
+}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package crypto
+
+import "golang.org/x/sys/unix"
+
+// lockMemory locks the pages of b into RAM
+func lockMemory(b []byte) error {
+	return unix.Mlock(b)
+}
+
+// unlockMemory unlocks pages locked by lockMemory
+func unlockMemory(b []byte) {
+	_ = unix.Munlock(b)
+}
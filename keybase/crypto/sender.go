@@ -20,6 +20,10 @@ type SenderKeyConfig struct {
 	// ConfigDir is the Keybase configuration directory
 	// If empty, the default directory is used (~/.config/keybase on Linux/macOS)
 	ConfigDir string
+
+	// Passphrase unlocks passphrase-protected key files (see SaveSenderKey)
+	// If nil, DefaultPassphrase is used
+	Passphrase PassphraseFunc
 }
 
 // SenderKey represents a loaded sender key
@@ -74,7 +78,7 @@ func LoadSenderKey(config *SenderKeyConfig) (*SenderKey, error) {
 	}
 
 	// Step 3: Load the sender's private key
-	secretKey, err := loadPrivateKey(configDir, username)
+	secretKey, err := loadPrivateKey(configDir, username, config.Passphrase)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load sender private key for user '%s': %w", username, err)
 	}
@@ -110,7 +114,10 @@ func LoadSenderKey(config *SenderKeyConfig) (*SenderKey, error) {
 //
 // Note: The actual Keybase key storage format is complex and may vary.
 // This is a simplified implementation that handles the common case.
-func loadPrivateKey(configDir, username string) (saltpack.BoxSecretKey, error) {
+//
+// Passphrase-protected key files are decrypted with a passphrase from
+// passphrase (DefaultPassphrase if nil).
+func loadPrivateKey(configDir, username string, passphrase PassphraseFunc) (saltpack.BoxSecretKey, error) {
 	// Try multiple possible key locations
 	possiblePaths := []string{
 		// Modern Keybase stores keys in the device_eks directory
@@ -123,11 +130,14 @@ func loadPrivateKey(configDir, username string) (saltpack.BoxSecretKey, error) {
 
 	var lastErr error
 	for _, keyPath := range possiblePaths {
-		secretKey, err := loadKeyFromFile(keyPath)
+		secretKey, err := loadKeyFromFile(keyPath, passphrase)
 		if err == nil {
 			return secretKey, nil
 		}
-		lastErr = err
+		// Report a key file that exists but cannot be used over missing ones
+		if lastErr == nil || os.IsNotExist(lastErr) {
+			lastErr = err
+		}
 	}
 
 	// If we couldn't find the key in any location, provide a helpful error
@@ -138,18 +148,15 @@ func loadPrivateKey(configDir, username string) (saltpack.BoxSecretKey, error) {
 	return nil, fmt.Errorf("failed to load sender key: %w", lastErr)
 }
 
-// loadKeyFromFile loads a key from a specific file path
-func loadKeyFromFile(path string) (saltpack.BoxSecretKey, error) {
-	// Check if file exists
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return nil, err
-	}
-
+// loadKeyFromFile loads a key from a specific file path, decrypting
+// passphrase-protected key files
+func loadKeyFromFile(path string, passphrase PassphraseFunc) (saltpack.BoxSecretKey, error) {
 	// Read the key file
-	data, err := os.ReadFile(path)
+	data, err := readKeyFile(path, passphrase)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read key file: %w", err)
+		return nil, err
 	}
+	defer clear(data)
 
 	// Try to parse as JSON first (common Keybase format)
 	var keyData struct {
@@ -231,6 +238,110 @@ func stripWhitespace(s string) string {
 	return string(result)
 }
 
+// SigningKey represents a loaded Ed25519 signing key, used as the sender
+// key for signcryption
+type SigningKey struct {
+	// Username is the Keybase username for this key
+	Username string
+
+	// SecretKey is the loaded signing key
+	SecretKey saltpack.SigningSecretKey
+
+	// PublicKey is the corresponding verification key
+	PublicKey saltpack.SigningPublicKey
+
+	// KID is the Keybase KID of the key (0120...0a)
+	KID string
+}
+
+// LoadSigningKey loads the sender's Ed25519 signing key from the Keybase
+// configuration directory
+//
+// The user and directory are determined exactly as in LoadSenderKey. The key
+// is read from the "signing_key" field of the user's device_eks/<user>.eks
+// file, or from a hex seed in signingkeys/<user>.
+func LoadSigningKey(config *SenderKeyConfig) (*SigningKey, error) {
+	if config == nil {
+		config = &SenderKeyConfig{}
+	}
+
+	username := config.Username
+	if username == "" {
+		currentUser, err := credentials.GetUsername()
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine sender identity: %w", err)
+		}
+		username = currentUser
+	}
+
+	configDir := config.ConfigDir
+	if configDir == "" {
+		status, err := credentials.DiscoverCredentials()
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover Keybase configuration: %w", err)
+		}
+		configDir = status.ConfigDir
+	}
+
+	possiblePaths := []string{
+		filepath.Join(configDir, "device_eks", fmt.Sprintf("%s.eks", username)),
+		filepath.Join(configDir, "signingkeys", username),
+	}
+
+	var lastErr error
+	for _, keyPath := range possiblePaths {
+		secretKey, err := loadSigningKeyFromFile(keyPath, config.Passphrase)
+		if err == nil {
+			publicKey := secretKey.GetPublicKey()
+			return &SigningKey{
+				Username:  username,
+				SecretKey: secretKey,
+				PublicKey: publicKey,
+				KID:       SigningKID(publicKey),
+			}, nil
+		}
+		if lastErr == nil || os.IsNotExist(lastErr) {
+			lastErr = err
+		}
+	}
+
+	if os.IsNotExist(lastErr) {
+		return nil, fmt.Errorf("signing key not found for user '%s': ensure Keybase is properly configured with a device signing key", username)
+	}
+
+	return nil, fmt.Errorf("failed to load signing key for user '%s': %w", username, lastErr)
+}
+
+// loadSigningKeyFromFile loads an Ed25519 signing key from a JSON key file
+// with a "signing_key" field, or from a file holding just the hex key;
+// passphrase-protected key files are decrypted first
+func loadSigningKeyFromFile(path string, passphrase PassphraseFunc) (saltpack.SigningSecretKey, error) {
+	data, err := readKeyFile(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(data)
+
+	var keyData struct {
+		SigningKey string `json:"signing_key"`
+	}
+
+	keyHex := stripWhitespace(string(data))
+	if err := json.Unmarshal(data, &keyData); err == nil {
+		if keyData.SigningKey == "" {
+			return nil, fmt.Errorf("key file %s has no signing_key", path)
+		}
+		keyHex = keyData.SigningKey
+	}
+
+	secretKey, err := CreateSigningSecretKeyFromHex(trimKeyPrefix(keyHex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	return secretKey, nil
+}
+
 // GetSenderIdentity determines the sender identity (username) to use
 //
 // If username is provided, it validates that the user exists and returns it.
@@ -318,7 +429,8 @@ func KeyIDToHex(keyID []byte) string {
 }
 
 // SaveSenderKeyForTesting saves a sender key to a file for testing
-// This should only be used in tests
+// This should only be used in tests: the key is written unprotected.
+// SaveSenderKey writes passphrase-protected key files
 func SaveSenderKeyForTesting(key *SenderKey, configDir string) error {
 	if key == nil || key.SecretKey == nil {
 		return fmt.Errorf("invalid key")
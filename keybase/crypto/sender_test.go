@@ -138,7 +138,7 @@ func TestLoadKeyFromFileJSON(t *testing.T) {
 	}
 
 	// Load the key from the file
-	loadedKey, err := loadKeyFromFile(keyPath)
+	loadedKey, err := loadKeyFromFile(keyPath, nil)
 	if err != nil {
 		t.Fatalf("Failed to load key from file: %v", err)
 	}
@@ -177,7 +177,7 @@ func TestLoadKeyFromFileHex(t *testing.T) {
 	}
 
 	// Load the key from the file
-	loadedKey, err := loadKeyFromFile(keyPath)
+	loadedKey, err := loadKeyFromFile(keyPath, nil)
 	if err != nil {
 		t.Fatalf("Failed to load key from file: %v", err)
 	}
@@ -216,7 +216,7 @@ func TestLoadKeyFromFileWithPrefix(t *testing.T) {
 	}
 
 	// Load the key from the file
-	loadedKey, err := loadKeyFromFile(keyPath)
+	loadedKey, err := loadKeyFromFile(keyPath, nil)
 	if err != nil {
 		t.Fatalf("Failed to load key from file: %v", err)
 	}
@@ -234,7 +234,7 @@ func TestLoadKeyFromFileWithPrefix(t *testing.T) {
 
 func TestLoadKeyFromFileNonExistent(t *testing.T) {
 	// Try to load from a non-existent file
-	_, err := loadKeyFromFile("/nonexistent/path/to/key")
+	_, err := loadKeyFromFile("/nonexistent/path/to/key", nil)
 	if err == nil {
 		t.Fatal("Expected error when loading from non-existent file, got nil")
 	}
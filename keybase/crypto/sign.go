@@ -0,0 +1,288 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/keybase/saltpack"
+)
+
+// Signer signs messages with an Ed25519 signing key using Saltpack's
+// attached and detached signature formats
+//
+// Attached signatures wrap the message, which is recovered when verifying.
+// Detached signatures are stored next to the message (for example a
+// checkpoint file and its .sig), which is left untouched.
+type Signer struct {
+	// SigningKey is the signer's Ed25519 key
+	SigningKey saltpack.SigningSecretKey
+
+	// Version is the Saltpack version signatures are written with
+	Version *saltpack.Version
+}
+
+// SignerConfig holds configuration for the Signer
+type SignerConfig struct {
+	// SigningKey is the Ed25519 key to sign with
+	// If nil, the Keybase user's signing key is loaded with LoadSigningKey
+	SigningKey saltpack.SigningSecretKey
+
+	// KeyConfig selects the user and configuration directory the signing key
+	// is loaded from when SigningKey is nil (nil uses the logged-in user)
+	KeyConfig *SenderKeyConfig
+
+	// Version specifies the Saltpack version (defaults to nil which uses Version2)
+	Version *saltpack.Version
+}
+
+// NewSigner creates a new Signer with the given configuration
+func NewSigner(config *SignerConfig) (*Signer, error) {
+	if config == nil {
+		config = &SignerConfig{}
+	}
+
+	signingKey := config.SigningKey
+	if signingKey == nil {
+		localKey, err := LoadSigningKey(config.KeyConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load signing key: %w", err)
+		}
+		signingKey = localKey.SecretKey
+	}
+
+	version := config.Version
+	if version == nil {
+		v := saltpack.Version2()
+		version = &v
+	}
+
+	return &Signer{
+		SigningKey: signingKey,
+		Version:    version,
+	}, nil
+}
+
+// KID returns the Keybase KID (0120...0a) of the signing key, which
+// verifiers pin in VerifierConfig.TrustedKIDs
+func (s *Signer) KID() string {
+	return SigningKID(s.SigningKey.GetPublicKey())
+}
+
+// Sign returns message wrapped in a binary attached signature
+func (s *Signer) Sign(message []byte) ([]byte, error) {
+	signed, err := saltpack.Sign(*s.Version, message, s.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	return signed, nil
+}
+
+// SignArmored returns message wrapped in an ASCII-armored attached signature
+func (s *Signer) SignArmored(message []byte) (string, error) {
+	signed, err := saltpack.SignArmor62(*s.Version, message, s.SigningKey, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	return signed, nil
+}
+
+// SignDetached returns a binary detached signature of message
+func (s *Signer) SignDetached(message []byte) ([]byte, error) {
+	signature, err := saltpack.SignDetached(*s.Version, message, s.SigningKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	return signature, nil
+}
+
+// SignDetachedArmored returns an ASCII-armored detached signature of message
+func (s *Signer) SignDetachedArmored(message []byte) (string, error) {
+	signature, err := saltpack.SignDetachedArmor62(*s.Version, message, s.SigningKey, "")
+	if err != nil {
+		return "", fmt.Errorf("failed to sign message: %w", err)
+	}
+
+	return signature, nil
+}
+
+// NewSignDetachedStreamArmored returns a stream that hashes everything
+// written to it and, on Close, writes an ASCII-armored detached signature of
+// it to signature
+//
+// This signs large files without holding them in memory.
+func (s *Signer) NewSignDetachedStreamArmored(signature io.Writer) (io.WriteCloser, error) {
+	stream, err := saltpack.NewSignDetachedArmor62Stream(*s.Version, signature, s.SigningKey, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create detached sign stream: %w", err)
+	}
+
+	return stream, nil
+}
+
+// UntrustedSignerError reports a valid signature made by a key that is not
+// one of the Verifier's TrustedKIDs
+type UntrustedSignerError struct {
+	// KID is the Keybase KID of the key that signed the message
+	KID string
+}
+
+func (e *UntrustedSignerError) Error() string {
+	return fmt.Sprintf("message is signed by untrusted key %s", e.KID)
+}
+
+// Verifier checks Saltpack attached and detached signatures, optionally
+// against a set of pinned signing keys
+//
+// Verification needs no network access or Keybase installation: Ed25519
+// signing KIDs embed the public key, so pinned KIDs are all that CI needs to
+// check that a file was signed by a known device.
+type Verifier struct {
+	// AllowedVersions lists the Saltpack major versions accepted when
+	// verifying (empty accepts every version known to the saltpack library)
+	AllowedVersions []saltpack.Version
+
+	// trusted maps the raw public keys of TrustedKIDs to their keys
+	trusted map[string]saltpack.SigningPublicKey
+}
+
+// VerifierConfig holds configuration for the Verifier
+type VerifierConfig struct {
+	// TrustedKIDs pins the Keybase signing KIDs (0120...0a) whose signatures
+	// are accepted. If empty, any valid signature is accepted and the caller
+	// must decide whether the returned signer is trusted
+	TrustedKIDs []string
+
+	// AllowedVersions restricts the Saltpack versions accepted when verifying
+	// (nil accepts every known version)
+	AllowedVersions []saltpack.Version
+}
+
+// NewVerifier creates a new Verifier with the given configuration
+func NewVerifier(config *VerifierConfig) (*Verifier, error) {
+	if config == nil {
+		config = &VerifierConfig{}
+	}
+
+	var trusted map[string]saltpack.SigningPublicKey
+	if len(config.TrustedKIDs) > 0 {
+		trusted = make(map[string]saltpack.SigningPublicKey, len(config.TrustedKIDs))
+		for _, kid := range config.TrustedKIDs {
+			publicKey, err := ParseSigningKID(kid)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted signing KID: %w", err)
+			}
+			trusted[string(publicKey.ToKID())] = publicKey
+		}
+	}
+
+	return &Verifier{
+		AllowedVersions: config.AllowedVersions,
+		trusted:         trusted,
+	}, nil
+}
+
+// LookupSigningPublicKey implements saltpack.SigKeyring
+//
+// With TrustedKIDs only pinned keys are returned; otherwise the key is taken
+// directly from the signer KID in the message, as in SigncryptKeyring.
+func (v *Verifier) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
+	if v.trusted != nil {
+		return v.trusted[string(kid)]
+	}
+
+	key, err := CreateSigningPublicKey(kid)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// Verify verifies a binary attached signature and returns the signed
+// message and the key that signed it
+func (v *Verifier) Verify(signed []byte) ([]byte, saltpack.SigningPublicKey, error) {
+	signer, message, err := saltpack.Verify(v.checkVersion, signed, v)
+	if err != nil {
+		return nil, nil, v.verifyError(err)
+	}
+
+	return message, signer, nil
+}
+
+// VerifyArmored verifies an ASCII-armored attached signature and returns the
+// signed message and the key that signed it
+func (v *Verifier) VerifyArmored(signed string) ([]byte, saltpack.SigningPublicKey, error) {
+	signer, message, _, err := saltpack.Dearmor62Verify(v.checkVersion, signed, v)
+	if err != nil {
+		return nil, nil, v.verifyError(err)
+	}
+
+	return message, signer, nil
+}
+
+// VerifyDetached verifies a binary detached signature of message and returns
+// the key that signed it
+func (v *Verifier) VerifyDetached(message, signature []byte) (saltpack.SigningPublicKey, error) {
+	signer, err := saltpack.VerifyDetached(v.checkVersion, message, signature, v)
+	if err != nil {
+		return nil, v.verifyError(err)
+	}
+
+	return signer, nil
+}
+
+// VerifyDetachedArmored verifies an ASCII-armored detached signature of
+// message and returns the key that signed it
+func (v *Verifier) VerifyDetachedArmored(message []byte, signature string) (saltpack.SigningPublicKey, error) {
+	signer, _, err := saltpack.Dearmor62VerifyDetached(v.checkVersion, message, signature, v)
+	if err != nil {
+		return nil, v.verifyError(err)
+	}
+
+	return signer, nil
+}
+
+// VerifyDetachedReader verifies a detached signature, ASCII-armored or
+// binary, of the message read from message and returns the key that signed it
+//
+// The message is hashed as it is read, so large files are verified without
+// holding them in memory.
+func (v *Verifier) VerifyDetachedReader(message io.Reader, signature []byte) (saltpack.SigningPublicKey, error) {
+	var signer saltpack.SigningPublicKey
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("BEGIN ")) {
+		signer, _, err = saltpack.Dearmor62VerifyDetachedReader(v.checkVersion, bufio.NewReader(message), string(signature), v)
+	} else {
+		signer, err = saltpack.VerifyDetachedReader(v.checkVersion, bufio.NewReader(message), signature, v)
+	}
+	if err != nil {
+		return nil, v.verifyError(err)
+	}
+
+	return signer, nil
+}
+
+// checkVersion is the saltpack.VersionValidator of the Verifier
+func (v *Verifier) checkVersion(version saltpack.Version) error {
+	return checkAllowedVersion(version, v.AllowedVersions)
+}
+
+// verifyError wraps a verification failure, reporting a signer missing from
+// the pinned keys as an UntrustedSignerError
+func (v *Verifier) verifyError(err error) error {
+	var noSenderKey saltpack.ErrNoSenderKey
+	if v.trusted != nil && errors.As(err, &noSenderKey) {
+		kid := "unknown"
+		if publicKey, keyErr := CreateSigningPublicKey(noSenderKey.Sender); keyErr == nil {
+			kid = SigningKID(publicKey)
+		}
+		return fmt.Errorf("failed to verify signature: %w", &UntrustedSignerError{KID: kid})
+	}
+
+	return fmt.Errorf("failed to verify signature: %w", err)
+}
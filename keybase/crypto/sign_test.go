@@ -0,0 +1,228 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+func newTestSigner(t *testing.T) *Signer {
+	t.Helper()
+
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+	signer, err := NewSigner(&SignerConfig{SigningKey: signingKey})
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+	return signer
+}
+
+func TestSignVerify(t *testing.T) {
+	signer := newTestSigner(t)
+	message := []byte(`{"checkpoint": {"stack": "dev"}}`)
+
+	verifier, err := NewVerifier(&VerifierConfig{TrustedKIDs: []string{signer.KID()}})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+
+	checkSigner := func(t *testing.T, key saltpack.SigningPublicKey) {
+		t.Helper()
+		if SigningKID(key) != signer.KID() {
+			t.Errorf("signer = %s, want %s", SigningKID(key), signer.KID())
+		}
+	}
+
+	t.Run("attached", func(t *testing.T) {
+		signed, err := signer.Sign(message)
+		if err != nil {
+			t.Fatalf("Sign() error = %v", err)
+		}
+		verified, key, err := verifier.Verify(signed)
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if !bytes.Equal(verified, message) {
+			t.Errorf("Verify() = %q, want %q", verified, message)
+		}
+		checkSigner(t, key)
+	})
+
+	t.Run("attached armored", func(t *testing.T) {
+		signed, err := signer.SignArmored(message)
+		if err != nil {
+			t.Fatalf("SignArmored() error = %v", err)
+		}
+		verified, key, err := verifier.VerifyArmored(signed)
+		if err != nil {
+			t.Fatalf("VerifyArmored() error = %v", err)
+		}
+		if !bytes.Equal(verified, message) {
+			t.Errorf("VerifyArmored() = %q, want %q", verified, message)
+		}
+		checkSigner(t, key)
+	})
+
+	t.Run("detached", func(t *testing.T) {
+		signature, err := signer.SignDetached(message)
+		if err != nil {
+			t.Fatalf("SignDetached() error = %v", err)
+		}
+		key, err := verifier.VerifyDetached(message, signature)
+		if err != nil {
+			t.Fatalf("VerifyDetached() error = %v", err)
+		}
+		checkSigner(t, key)
+
+		key, err = verifier.VerifyDetachedReader(bytes.NewReader(message), signature)
+		if err != nil {
+			t.Fatalf("VerifyDetachedReader() error = %v", err)
+		}
+		checkSigner(t, key)
+
+		if _, err := verifier.VerifyDetached([]byte("tampered"), signature); err == nil {
+			t.Error("VerifyDetached() of a different message should fail")
+		}
+	})
+
+	t.Run("detached armored", func(t *testing.T) {
+		signature, err := signer.SignDetachedArmored(message)
+		if err != nil {
+			t.Fatalf("SignDetachedArmored() error = %v", err)
+		}
+		key, err := verifier.VerifyDetachedArmored(message, signature)
+		if err != nil {
+			t.Fatalf("VerifyDetachedArmored() error = %v", err)
+		}
+		checkSigner(t, key)
+
+		if _, err := verifier.VerifyDetachedArmored([]byte("tampered"), signature); err == nil {
+			t.Error("VerifyDetachedArmored() of a different message should fail")
+		}
+	})
+
+	t.Run("detached stream", func(t *testing.T) {
+		var signature bytes.Buffer
+		stream, err := signer.NewSignDetachedStreamArmored(&signature)
+		if err != nil {
+			t.Fatalf("NewSignDetachedStreamArmored() error = %v", err)
+		}
+		if _, err := stream.Write(message); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := stream.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		key, err := verifier.VerifyDetachedReader(bytes.NewReader(message), signature.Bytes())
+		if err != nil {
+			t.Fatalf("VerifyDetachedReader() error = %v", err)
+		}
+		checkSigner(t, key)
+	})
+}
+
+func TestVerifierTrustedKIDs(t *testing.T) {
+	signer := newTestSigner(t)
+	other := newTestSigner(t)
+	message := []byte("manifest")
+
+	signature, err := other.SignDetachedArmored(message)
+	if err != nil {
+		t.Fatalf("SignDetachedArmored() error = %v", err)
+	}
+
+	// A valid signature from a key that is not pinned is refused
+	pinned, err := NewVerifier(&VerifierConfig{TrustedKIDs: []string{signer.KID()}})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	_, err = pinned.VerifyDetachedArmored(message, signature)
+	var untrusted *UntrustedSignerError
+	if !errors.As(err, &untrusted) {
+		t.Fatalf("VerifyDetachedArmored() error = %v, want *UntrustedSignerError", err)
+	}
+	if untrusted.KID != other.KID() {
+		t.Errorf("UntrustedSignerError.KID = %s, want %s", untrusted.KID, other.KID())
+	}
+
+	// Without pinned keys any valid signer is returned for the caller to check
+	open, err := NewVerifier(nil)
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	key, err := open.VerifyDetachedArmored(message, signature)
+	if err != nil {
+		t.Fatalf("VerifyDetachedArmored() error = %v", err)
+	}
+	if SigningKID(key) != other.KID() {
+		t.Errorf("signer = %s, want %s", SigningKID(key), other.KID())
+	}
+
+	if _, err := NewVerifier(&VerifierConfig{TrustedKIDs: []string{"0120abcd"}}); err == nil {
+		t.Error("NewVerifier() with an invalid KID should fail")
+	}
+}
+
+func TestVerifierAllowedVersions(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+	version := saltpack.Version1()
+	signer, err := NewSigner(&SignerConfig{SigningKey: signingKey, Version: &version})
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+
+	signed, err := signer.SignArmored([]byte("v1 message"))
+	if err != nil {
+		t.Fatalf("SignArmored() error = %v", err)
+	}
+
+	verifier, err := NewVerifier(&VerifierConfig{AllowedVersions: []saltpack.Version{saltpack.Version2()}})
+	if err != nil {
+		t.Fatalf("NewVerifier() error = %v", err)
+	}
+	var versionErr *VersionError
+	if _, _, err := verifier.VerifyArmored(signed); !errors.As(err, &versionErr) {
+		t.Errorf("VerifyArmored() error = %v, want *VersionError", err)
+	}
+}
+
+func TestNewSignerLoadsSigningKey(t *testing.T) {
+	configDir := t.TempDir()
+	seed := bytes.Repeat([]byte{7}, 32)
+
+	if err := os.MkdirAll(filepath.Join(configDir, "signingkeys"), 0700); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "signingkeys", "alice"), []byte(hex.EncodeToString(seed)), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	signer, err := NewSigner(&SignerConfig{KeyConfig: &SenderKeyConfig{Username: "alice", ConfigDir: configDir}})
+	if err != nil {
+		t.Fatalf("NewSigner() error = %v", err)
+	}
+
+	expected, err := CreateSigningSecretKey(seed)
+	if err != nil {
+		t.Fatalf("CreateSigningSecretKey() error = %v", err)
+	}
+	if signer.KID() != SigningKID(expected.GetPublicKey()) {
+		t.Errorf("KID() = %s, want %s", signer.KID(), SigningKID(expected.GetPublicKey()))
+	}
+
+	if _, err := NewSigner(&SignerConfig{KeyConfig: &SenderKeyConfig{Username: "bob", ConfigDir: configDir}}); err == nil {
+		t.Error("NewSigner() for a user without a signing key should fail")
+	}
+}
@@ -0,0 +1,390 @@
+package crypto
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/keybase/saltpack"
+)
+
+// kidTypeEd25519 is the Keybase KID type byte of an Ed25519 signing key
+const kidTypeEd25519 = 0x20
+
+// ed25519SigningPublicKey implements saltpack.SigningPublicKey
+type ed25519SigningPublicKey struct {
+	key ed25519.PublicKey
+}
+
+// ToKID returns the raw 32-byte public key, like naclBoxPublicKey
+func (k *ed25519SigningPublicKey) ToKID() []byte {
+	return k.key
+}
+
+// Verify verifies an Ed25519 signature of message
+func (k *ed25519SigningPublicKey) Verify(message []byte, signature []byte) error {
+	if !ed25519.Verify(k.key, message, signature) {
+		return saltpack.ErrBadSignature
+	}
+	return nil
+}
+
+// ed25519SigningSecretKey implements saltpack.SigningSecretKey
+// The private key is held in a SecureBuffer and can be wiped with Zero
+type ed25519SigningSecretKey struct {
+	key       ed25519.PrivateKey
+	buf       *SecureBuffer
+	publicKey *ed25519SigningPublicKey
+}
+
+// Sign signs message with the Ed25519 private key
+func (k *ed25519SigningSecretKey) Sign(message []byte) ([]byte, error) {
+	return ed25519.Sign(k.key, message), nil
+}
+
+// GetPublicKey returns the Ed25519 public key
+func (k *ed25519SigningSecretKey) GetPublicKey() saltpack.SigningPublicKey {
+	return k.publicKey
+}
+
+// Zero wipes the private key; signatures made with it afterwards are invalid
+func (k *ed25519SigningSecretKey) Zero() {
+	k.buf.Zero()
+}
+
+// GenerateSigningKey generates a new random Ed25519 signing key
+func GenerateSigningKey() (saltpack.SigningSecretKey, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	return newSigningSecretKey(privateKey), nil
+}
+
+// CreateSigningSecretKey creates an Ed25519 signing key from a 32-byte seed
+// or a 64-byte private key (seed followed by public key)
+func CreateSigningSecretKey(keyBytes []byte) (saltpack.SigningSecretKey, error) {
+	switch len(keyBytes) {
+	case ed25519.SeedSize:
+		return newSigningSecretKey(ed25519.NewKeyFromSeed(keyBytes)), nil
+	case ed25519.PrivateKeySize:
+		privateKey := ed25519.NewKeyFromSeed(keyBytes[:ed25519.SeedSize])
+		if subtle.ConstantTimeCompare(privateKey[ed25519.SeedSize:], keyBytes[ed25519.SeedSize:]) != 1 {
+			return nil, fmt.Errorf("signing key public half does not match its seed")
+		}
+		return newSigningSecretKey(privateKey), nil
+	default:
+		return nil, fmt.Errorf("invalid signing key length: expected %d or %d bytes, got %d",
+			ed25519.SeedSize, ed25519.PrivateKeySize, len(keyBytes))
+	}
+}
+
+// CreateSigningSecretKeyFromHex creates an Ed25519 signing key from a hex string
+func CreateSigningSecretKeyFromHex(hexKey string) (saltpack.SigningSecretKey, error) {
+	keyBytes, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex encoding: %w", err)
+	}
+	defer clear(keyBytes)
+	return CreateSigningSecretKey(keyBytes)
+}
+
+// ExportSigningKeySeed returns the 32-byte seed of a signing key created by
+// this package, the form CreateSigningSecretKey accepts (nil for other
+// implementations)
+func ExportSigningKeySeed(key saltpack.SigningSecretKey) []byte {
+	if edKey, ok := key.(*ed25519SigningSecretKey); ok {
+		return edKey.key.Seed()
+	}
+	return nil
+}
+
+// CreateSigningPublicKey creates an Ed25519 verification key from 32 bytes
+func CreateSigningPublicKey(keyBytes []byte) (saltpack.SigningPublicKey, error) {
+	if len(keyBytes) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signing public key length: expected %d bytes, got %d",
+			ed25519.PublicKeySize, len(keyBytes))
+	}
+
+	key := make(ed25519.PublicKey, ed25519.PublicKeySize)
+	copy(key, keyBytes)
+	return &ed25519SigningPublicKey{key: key}, nil
+}
+
+// newSigningSecretKey moves an Ed25519 private key into a secure buffer;
+// privateKey is wiped
+func newSigningSecretKey(privateKey ed25519.PrivateKey) *ed25519SigningSecretKey {
+	publicKey := make(ed25519.PublicKey, ed25519.PublicKeySize)
+	copy(publicKey, privateKey.Public().(ed25519.PublicKey))
+
+	buf := NewSecureBufferFrom(privateKey)
+	return &ed25519SigningSecretKey{
+		key:       ed25519.PrivateKey(buf.Bytes()),
+		buf:       buf,
+		publicKey: &ed25519SigningPublicKey{key: publicKey},
+	}
+}
+
+// ParseSigningKID converts a Keybase Ed25519 KID (0120...0a) to a signing
+// public key
+func ParseSigningKID(kid string) (saltpack.SigningPublicKey, error) {
+	kidBytes, err := hex.DecodeString(strings.TrimSpace(kid))
+	if err != nil {
+		return nil, fmt.Errorf("invalid KID hex encoding: %w", err)
+	}
+
+	if len(kidBytes) != 35 || kidBytes[0] != kidVersion || kidBytes[1] != kidTypeEd25519 || kidBytes[34] != kidSuffix {
+		return nil, fmt.Errorf("not a Keybase Ed25519 signing KID: %s", kid)
+	}
+
+	return CreateSigningPublicKey(kidBytes[2:34])
+}
+
+// SigningKID returns the Keybase KID (0120...0a) of a signing public key
+func SigningKID(key saltpack.SigningPublicKey) string {
+	if key == nil {
+		return ""
+	}
+
+	kid := make([]byte, 0, 35)
+	kid = append(kid, kidVersion, kidTypeEd25519)
+	kid = append(kid, key.ToKID()...)
+	kid = append(kid, kidSuffix)
+	return hex.EncodeToString(kid)
+}
+
+// SigncryptKeyring adapts a saltpack.Keyring for signcryption
+//
+// Decryption keys come from the wrapped keyring. Signing public keys are
+// looked up in the wrapped keyring if it implements saltpack.SigKeyring, and
+// are otherwise taken directly from the sender KID in the message, which for
+// Ed25519 is the public key itself. The signature is verified against that
+// key either way; deciding whether the signer is trusted is up to the caller.
+type SigncryptKeyring struct {
+	saltpack.Keyring
+}
+
+// LookupSigningPublicKey implements saltpack.SigKeyring
+func (k *SigncryptKeyring) LookupSigningPublicKey(kid []byte) saltpack.SigningPublicKey {
+	if sigKeyring, ok := k.Keyring.(saltpack.SigKeyring); ok {
+		if key := sigKeyring.LookupSigningPublicKey(kid); key != nil {
+			return key
+		}
+	}
+
+	key, err := CreateSigningPublicKey(kid)
+	if err != nil {
+		return nil
+	}
+	return key
+}
+
+// Signcrypt encrypts and signs plaintext for multiple recipients using
+// Saltpack signcryption
+//
+// Unlike Encrypt, every payload chunk is signed with the Encryptor's
+// SigningKey, so recipients learn which Ed25519 key wrote the message and
+// can prove it to third parties. With a nil SigningKey the sender is
+// anonymous. The output is binary; use SigncryptArmored for text.
+func (e *Encryptor) Signcrypt(plaintext []byte, receivers []saltpack.BoxPublicKey) ([]byte, error) {
+	if len(receivers) == 0 {
+		return nil, fmt.Errorf("at least one receiver is required")
+	}
+
+	if len(plaintext) == 0 {
+		return nil, fmt.Errorf("plaintext cannot be empty")
+	}
+
+	ciphertext, err := saltpack.SigncryptSeal(plaintext, NewEphemeralKeyCreator(), e.SigningKey, receivers, nil)
+	if err != nil {
+		return nil, fmt.Errorf("signcryption failed: %w", err)
+	}
+
+	return ciphertext, nil
+}
+
+// SigncryptArmored signcrypts plaintext and returns ASCII-armored output
+// The armor is the same BEGIN KEYBASE SALTPACK ENCRYPTED MESSAGE block as
+// EncryptArmored; the message mode is recorded in the header
+func (e *Encryptor) SigncryptArmored(plaintext []byte, receivers []saltpack.BoxPublicKey) (string, error) {
+	if len(receivers) == 0 {
+		return "", fmt.Errorf("at least one receiver is required")
+	}
+
+	if len(plaintext) == 0 {
+		return "", fmt.Errorf("plaintext cannot be empty")
+	}
+
+	ciphertext, err := saltpack.SigncryptArmor62Seal(plaintext, NewEphemeralKeyCreator(), e.SigningKey, receivers, nil, "")
+	if err != nil {
+		return "", fmt.Errorf("armored signcryption failed: %w", err)
+	}
+
+	return ciphertext, nil
+}
+
+// SigncryptStream signcrypts data from a reader to a writer using streaming
+func (e *Encryptor) SigncryptStream(plaintext io.Reader, ciphertext io.Writer, receivers []saltpack.BoxPublicKey) error {
+	if len(receivers) == 0 {
+		return fmt.Errorf("at least one receiver is required")
+	}
+
+	stream, err := saltpack.NewSigncryptSealStream(ciphertext, NewEphemeralKeyCreator(), e.SigningKey, receivers, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create signcrypt stream: %w", err)
+	}
+
+	return copyAndClose(stream, plaintext)
+}
+
+// SigncryptStreamArmored signcrypts data from a reader to a writer using
+// streaming with ASCII armoring
+func (e *Encryptor) SigncryptStreamArmored(plaintext io.Reader, ciphertext io.Writer, receivers []saltpack.BoxPublicKey) error {
+	if len(receivers) == 0 {
+		return fmt.Errorf("at least one receiver is required")
+	}
+
+	stream, err := e.NewSigncryptStreamArmored(ciphertext, receivers)
+	if err != nil {
+		return err
+	}
+
+	return copyAndClose(stream, plaintext)
+}
+
+// copyAndClose copies plaintext into a sealing stream and finalizes it
+func copyAndClose(stream io.WriteCloser, plaintext io.Reader) error {
+	if _, err := io.Copy(stream, plaintext); err != nil {
+		return fmt.Errorf("signcryption stream failed: %w", err)
+	}
+
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("failed to close signcrypt stream: %w", err)
+	}
+
+	return nil
+}
+
+// DecryptSigncrypted opens a binary signcrypted message
+//
+// Returns:
+//   - Plaintext
+//   - The verified signer's public key (nil if the sender is anonymous)
+//   - Error if decryption or signature verification fails
+func (d *Decryptor) DecryptSigncrypted(ciphertext []byte) ([]byte, saltpack.SigningPublicKey, error) {
+	if len(ciphertext) == 0 {
+		return nil, nil, fmt.Errorf("ciphertext cannot be empty")
+	}
+
+	if err := d.checkSigncryptVersion(); err != nil {
+		return nil, nil, fmt.Errorf("signcrypted decryption failed: %w", err)
+	}
+
+	signer, plaintext, err := saltpack.SigncryptOpen(ciphertext, d.signcryptKeyring(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("signcrypted decryption failed: %w", err)
+	}
+
+	return plaintext, signer, nil
+}
+
+// DecryptSigncryptedArmored opens an ASCII-armored signcrypted message
+func (d *Decryptor) DecryptSigncryptedArmored(armoredCiphertext string) ([]byte, saltpack.SigningPublicKey, error) {
+	if armoredCiphertext == "" {
+		return nil, nil, fmt.Errorf("armored ciphertext cannot be empty")
+	}
+
+	if err := d.checkSigncryptVersion(); err != nil {
+		return nil, nil, fmt.Errorf("armored signcrypted decryption failed: %w", err)
+	}
+
+	signer, plaintext, _, err := saltpack.Dearmor62SigncryptOpen(armoredCiphertext, d.signcryptKeyring(), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("armored signcrypted decryption failed: %w", err)
+	}
+
+	return plaintext, signer, nil
+}
+
+// DecryptSigncryptedStream opens a binary signcrypted message from a reader
+// The signer is known from the header, but each chunk's signature is only
+// verified as it is read, so a forged chunk fails the copy
+func (d *Decryptor) DecryptSigncryptedStream(ciphertext io.Reader, plaintext io.Writer) (saltpack.SigningPublicKey, error) {
+	if err := d.checkSigncryptVersion(); err != nil {
+		return nil, fmt.Errorf("failed to create signcrypt open stream: %w", err)
+	}
+
+	signer, plaintextReader, err := saltpack.NewSigncryptOpenStream(ciphertext, d.signcryptKeyring(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signcrypt open stream: %w", err)
+	}
+
+	if _, err := io.Copy(plaintext, plaintextReader); err != nil {
+		return nil, fmt.Errorf("signcrypted decryption stream failed: %w", err)
+	}
+
+	return signer, nil
+}
+
+// DecryptSigncryptedStreamArmored opens an ASCII-armored signcrypted message from a reader
+func (d *Decryptor) DecryptSigncryptedStreamArmored(armoredCiphertext io.Reader, plaintext io.Writer) (saltpack.SigningPublicKey, error) {
+	if err := d.checkSigncryptVersion(); err != nil {
+		return nil, fmt.Errorf("failed to create armored signcrypt open stream: %w", err)
+	}
+
+	signer, plaintextReader, _, err := saltpack.NewDearmor62SigncryptOpenStream(armoredCiphertext, d.signcryptKeyring(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create armored signcrypt open stream: %w", err)
+	}
+
+	if _, err := io.Copy(plaintext, plaintextReader); err != nil {
+		return nil, fmt.Errorf("signcrypted decryption stream failed: %w", err)
+	}
+
+	return signer, nil
+}
+
+// SigncryptMessageInfo describes a signcrypted message opened with signer
+// as its verified sender
+//
+// Signcryption does not reveal which receiver key opened the message, so
+// the receiver fields are left empty and ReceiverIndex is -1. Signcryption
+// only exists in Saltpack version 2.
+func SigncryptMessageInfo(signer saltpack.SigningPublicKey) *MessageInfo {
+	messageInfo := &MessageInfo{
+		IsAnonymousSender: signer == nil,
+		ReceiverIndex:     -1,
+		Version:           saltpack.Version2(),
+		Signcrypted:       true,
+	}
+
+	if signer != nil {
+		messageInfo.SenderKID = signer.ToKID()
+		messageInfo.SenderKIDHex = hex.EncodeToString(messageInfo.SenderKID)
+		messageInfo.SignerKID = SigningKID(signer)
+		messageInfo.Sender = &KeyOwner{KID: messageInfo.SignerKID}
+	}
+
+	return messageInfo
+}
+
+// signcryptKeyring returns the Decryptor's keyring adapted for signcryption
+func (d *Decryptor) signcryptKeyring() saltpack.SigncryptKeyring {
+	if keyring, ok := d.Keyring.(saltpack.SigncryptKeyring); ok {
+		return keyring
+	}
+	return &SigncryptKeyring{Keyring: d.Keyring}
+}
+
+// IsSigncryptionMismatch reports whether err means a message is signcrypted
+// but was opened as an encrypted message, or vice versa
+func IsSigncryptionMismatch(err error) bool {
+	var wrongType saltpack.ErrWrongMessageType
+	return errors.As(err, &wrongType)
+}
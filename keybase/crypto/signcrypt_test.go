@@ -0,0 +1,303 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+// newSigncryptPair returns an encryptor signing with a fresh key, a
+// decryptor for a fresh receiver, and the receiver's public key
+func newSigncryptPair(t *testing.T, signingKey saltpack.SigningSecretKey) (*Encryptor, *Decryptor, saltpack.BoxPublicKey) {
+	t.Helper()
+
+	receiver, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+
+	encryptor, err := NewEncryptor(&EncryptorConfig{SigningKey: signingKey})
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+
+	keyring := NewSimpleKeyring()
+	keyring.AddKeyPair(receiver)
+	decryptor, err := NewDecryptor(&DecryptorConfig{Keyring: keyring})
+	if err != nil {
+		t.Fatalf("NewDecryptor() error = %v", err)
+	}
+
+	return encryptor, decryptor, receiver.PublicKey
+}
+
+func TestSigncryptRoundTrip(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+	plaintext := []byte("rotated by alice")
+
+	tests := []struct {
+		name    string
+		roundTr func(e *Encryptor, d *Decryptor, receivers []saltpack.BoxPublicKey) ([]byte, saltpack.SigningPublicKey, error)
+	}{
+		{
+			name: "binary",
+			roundTr: func(e *Encryptor, d *Decryptor, receivers []saltpack.BoxPublicKey) ([]byte, saltpack.SigningPublicKey, error) {
+				ciphertext, err := e.Signcrypt(plaintext, receivers)
+				if err != nil {
+					return nil, nil, err
+				}
+				return d.DecryptSigncrypted(ciphertext)
+			},
+		},
+		{
+			name: "armored",
+			roundTr: func(e *Encryptor, d *Decryptor, receivers []saltpack.BoxPublicKey) ([]byte, saltpack.SigningPublicKey, error) {
+				ciphertext, err := e.SigncryptArmored(plaintext, receivers)
+				if err != nil {
+					return nil, nil, err
+				}
+				if !strings.Contains(ciphertext, "BEGIN SALTPACK ENCRYPTED MESSAGE") {
+					t.Errorf("SigncryptArmored() output is not an armored saltpack message")
+				}
+				return d.DecryptSigncryptedArmored(ciphertext)
+			},
+		},
+		{
+			name: "binary stream",
+			roundTr: func(e *Encryptor, d *Decryptor, receivers []saltpack.BoxPublicKey) ([]byte, saltpack.SigningPublicKey, error) {
+				var ciphertext, decrypted bytes.Buffer
+				if err := e.SigncryptStream(bytes.NewReader(plaintext), &ciphertext, receivers); err != nil {
+					return nil, nil, err
+				}
+				signer, err := d.DecryptSigncryptedStream(&ciphertext, &decrypted)
+				return decrypted.Bytes(), signer, err
+			},
+		},
+		{
+			name: "armored stream",
+			roundTr: func(e *Encryptor, d *Decryptor, receivers []saltpack.BoxPublicKey) ([]byte, saltpack.SigningPublicKey, error) {
+				var ciphertext, decrypted bytes.Buffer
+				if err := e.SigncryptStreamArmored(bytes.NewReader(plaintext), &ciphertext, receivers); err != nil {
+					return nil, nil, err
+				}
+				signer, err := d.DecryptSigncryptedStreamArmored(&ciphertext, &decrypted)
+				return decrypted.Bytes(), signer, err
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encryptor, decryptor, receiver := newSigncryptPair(t, signingKey)
+
+			decrypted, signer, err := tt.roundTr(encryptor, decryptor, []saltpack.BoxPublicKey{receiver})
+			if err != nil {
+				t.Fatalf("round trip error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+			}
+			if signer == nil || !saltpack.PublicKeyEqual(signer, signingKey.GetPublicKey()) {
+				t.Errorf("signer = %v, want the signing key", signer)
+			}
+		})
+	}
+}
+
+func TestSigncryptAnonymousSender(t *testing.T) {
+	encryptor, decryptor, receiver := newSigncryptPair(t, nil)
+
+	ciphertext, err := encryptor.Signcrypt([]byte("anonymous"), []saltpack.BoxPublicKey{receiver})
+	if err != nil {
+		t.Fatalf("Signcrypt() error = %v", err)
+	}
+
+	_, signer, err := decryptor.DecryptSigncrypted(ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptSigncrypted() error = %v", err)
+	}
+	if signer != nil {
+		t.Errorf("signer = %v, want nil for an anonymous sender", signer)
+	}
+}
+
+func TestSigncryptErrors(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+	encryptor, decryptor, receiver := newSigncryptPair(t, signingKey)
+
+	if _, err := encryptor.Signcrypt([]byte("secret"), nil); err == nil {
+		t.Error("Signcrypt() without receivers should fail")
+	}
+	if _, err := encryptor.Signcrypt(nil, []saltpack.BoxPublicKey{receiver}); err == nil {
+		t.Error("Signcrypt() with empty plaintext should fail")
+	}
+
+	// A signcrypted message is not a plain encrypted message, and vice versa
+	signcrypted, err := encryptor.Signcrypt([]byte("secret"), []saltpack.BoxPublicKey{receiver})
+	if err != nil {
+		t.Fatalf("Signcrypt() error = %v", err)
+	}
+	if _, _, err := decryptor.Decrypt(signcrypted); !IsSigncryptionMismatch(err) {
+		t.Errorf("Decrypt(signcrypted) error = %v, want a message type mismatch", err)
+	}
+
+	encrypted, err := encryptor.Encrypt([]byte("secret"), []saltpack.BoxPublicKey{receiver})
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if _, _, err := decryptor.DecryptSigncrypted(encrypted); !IsSigncryptionMismatch(err) {
+		t.Errorf("DecryptSigncrypted(encrypted) error = %v, want a message type mismatch", err)
+	}
+
+	// A message for someone else cannot be opened
+	_, otherDecryptor, _ := newSigncryptPair(t, nil)
+	if _, _, err := otherDecryptor.DecryptSigncrypted(signcrypted); err == nil {
+		t.Error("DecryptSigncrypted() by a non-recipient should fail")
+	}
+}
+
+func TestSigningKeys(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+	publicKey := signingKey.GetPublicKey()
+
+	kid := SigningKID(publicKey)
+	if len(kid) != 70 || !strings.HasPrefix(kid, "0120") || !strings.HasSuffix(kid, "0a") {
+		t.Fatalf("SigningKID() = %s, want a 0120...0a KID", kid)
+	}
+
+	parsed, err := ParseSigningKID(kid)
+	if err != nil {
+		t.Fatalf("ParseSigningKID() error = %v", err)
+	}
+	if !saltpack.PublicKeyEqual(parsed, publicKey) {
+		t.Error("ParseSigningKID() returned a different key")
+	}
+
+	if _, err := ParseSigningKID("0121" + strings.Repeat("00", 32) + "0a"); err == nil {
+		t.Error("ParseSigningKID() should reject an encryption KID")
+	}
+
+	// Signatures made by the secret key verify with the public key only
+	signature, err := signingKey.Sign([]byte("message"))
+	if err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	if err := publicKey.Verify([]byte("message"), signature); err != nil {
+		t.Errorf("Verify() error = %v", err)
+	}
+	if err := publicKey.Verify([]byte("tampered"), signature); err == nil {
+		t.Error("Verify() of a tampered message should fail")
+	}
+
+	tests := []struct {
+		name    string
+		length  int
+		wantErr bool
+	}{
+		{"seed", 32, false},
+		{"private key", 64, false},
+		{"wrong length", 31, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seed := bytes.Repeat([]byte{7}, 32)
+			keyBytes := seed
+			if tt.length == 64 {
+				fromSeed, _ := CreateSigningSecretKey(seed)
+				keyBytes = append(append([]byte{}, seed...), fromSeed.GetPublicKey().ToKID()...)
+			} else if tt.length != 32 {
+				keyBytes = seed[:tt.length]
+			}
+
+			key, err := CreateSigningSecretKey(keyBytes)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CreateSigningSecretKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && SigningKID(key.GetPublicKey()) == "" {
+				t.Error("CreateSigningSecretKey() returned a key without a public key")
+			}
+		})
+	}
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	configDir := t.TempDir()
+	seed := bytes.Repeat([]byte{42}, 32)
+
+	if err := os.MkdirAll(filepath.Join(configDir, "device_eks"), 0700); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	eks := `{"encryption_key": "` + strings.Repeat("11", 32) + `", "signing_key": "` + hex.EncodeToString(seed) + `"}`
+	if err := os.WriteFile(filepath.Join(configDir, "device_eks", "alice.eks"), []byte(eks), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(configDir, "signingkeys"), 0700); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "signingkeys", "bob"), []byte("0x"+hex.EncodeToString(seed)+"\n"), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	expected, err := CreateSigningSecretKey(seed)
+	if err != nil {
+		t.Fatalf("CreateSigningSecretKey() error = %v", err)
+	}
+
+	for _, username := range []string{"alice", "bob"} {
+		t.Run(username, func(t *testing.T) {
+			key, err := LoadSigningKey(&SenderKeyConfig{Username: username, ConfigDir: configDir})
+			if err != nil {
+				t.Fatalf("LoadSigningKey() error = %v", err)
+			}
+			if key.Username != username {
+				t.Errorf("Username = %s, want %s", key.Username, username)
+			}
+			if key.KID != SigningKID(expected.GetPublicKey()) {
+				t.Errorf("KID = %s, want %s", key.KID, SigningKID(expected.GetPublicKey()))
+			}
+		})
+	}
+
+	if _, err := LoadSigningKey(&SenderKeyConfig{Username: "carol", ConfigDir: configDir}); err == nil {
+		t.Error("LoadSigningKey() for a user without a key should fail")
+	}
+}
+
+func TestSigncryptMessageInfo(t *testing.T) {
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("GenerateSigningKey() error = %v", err)
+	}
+
+	info := SigncryptMessageInfo(signingKey.GetPublicKey())
+	if !info.Signcrypted || info.IsAnonymousSender || info.ReceiverIndex != -1 {
+		t.Errorf("SigncryptMessageInfo() = %+v, want a signcrypted message with a known signer", info)
+	}
+	if info.SignerKID != SigningKID(signingKey.GetPublicKey()) {
+		t.Errorf("SignerKID = %s, want %s", info.SignerKID, SigningKID(signingKey.GetPublicKey()))
+	}
+	if !strings.Contains(MessageInfoString(info), info.SignerKID) {
+		t.Errorf("MessageInfoString() does not mention the signer")
+	}
+
+	anonymous := SigncryptMessageInfo(nil)
+	if !anonymous.IsAnonymousSender || anonymous.SenderKID != nil || anonymous.SignerKID != "" {
+		t.Errorf("SigncryptMessageInfo(nil) = %+v, want an anonymous sender", anonymous)
+	}
+}
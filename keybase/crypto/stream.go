@@ -0,0 +1,140 @@
+package crypto
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/keybase/saltpack"
+)
+
+// StreamChunkSize is the size of a Saltpack payload chunk (1 MiB)
+// Writes larger than this are split so that callers can check for
+// cancellation between chunks.
+const StreamChunkSize = 1 << 20
+
+// NewEncryptStreamArmored returns a stream that encrypts everything written
+// to it for receivers and writes ASCII-armored ciphertext to ciphertext
+//
+// Memory use is bounded by one payload chunk regardless of message size.
+// Close must be called to write the final chunk and the armor footer.
+func (e *Encryptor) NewEncryptStreamArmored(ciphertext io.Writer, receivers []saltpack.BoxPublicKey) (io.WriteCloser, error) {
+	if len(receivers) == 0 {
+		return nil, fmt.Errorf("at least one receiver is required")
+	}
+
+	stream, err := saltpack.NewEncryptArmor62Stream(*e.Version, ciphertext, e.SenderKey, e.receivers(receivers), "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create armored encrypt stream: %w", err)
+	}
+
+	return stream, nil
+}
+
+// NewSigncryptStreamArmored is like NewEncryptStreamArmored, but signcrypts
+// with the Encryptor's SigningKey
+func (e *Encryptor) NewSigncryptStreamArmored(ciphertext io.Writer, receivers []saltpack.BoxPublicKey) (io.WriteCloser, error) {
+	if len(receivers) == 0 {
+		return nil, fmt.Errorf("at least one receiver is required")
+	}
+
+	stream, err := saltpack.NewSigncryptArmor62SealStream(ciphertext, NewEphemeralKeyCreator(), e.SigningKey, receivers, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create armored signcrypt stream: %w", err)
+	}
+
+	return stream, nil
+}
+
+// DecryptedStream is a Saltpack message being decrypted from a reader
+//
+// The header has been opened and authenticated, so the sender is known
+// before any plaintext is read. Each payload chunk is authenticated as it
+// is read, but a truncated message is only detected at its end: plaintext
+// must not be trusted until Read has returned io.EOF.
+type DecryptedStream struct {
+	// Reader yields the plaintext
+	io.Reader
+
+	// KeyInfo describes the keys of an encrypted message (nil if signcrypted)
+	KeyInfo *saltpack.MessageKeyInfo
+
+	// Signer is the verified signer of a signcrypted message (nil if
+	// anonymous or not signcrypted)
+	Signer saltpack.SigningPublicKey
+
+	// Signcrypted reports whether the message is signcrypted
+	Signcrypted bool
+}
+
+// NewDecryptStream opens an encrypted or signcrypted Saltpack message,
+// ASCII-armored or binary, from a reader
+//
+// Only the message header is read here. The ciphertext read while trying
+// the encrypted format is replayed if the message turns out to be
+// signcrypted, so the reader does not need to support seeking.
+func (d *Decryptor) NewDecryptStream(ciphertext io.Reader) (*DecryptedStream, error) {
+	buffered := bufio.NewReader(ciphertext)
+	peek, _ := buffered.Peek(64)
+	armored := bytes.HasPrefix(bytes.TrimSpace(peek), []byte("BEGIN "))
+
+	recorder := &recordingReader{reader: buffered, recording: true}
+
+	var keyInfo *saltpack.MessageKeyInfo
+	var plaintext io.Reader
+	var err error
+	if armored {
+		keyInfo, plaintext, _, err = saltpack.NewDearmor62DecryptStream(d.checkVersion, recorder, d.Keyring)
+	} else {
+		keyInfo, plaintext, err = saltpack.NewDecryptStream(d.checkVersion, recorder, d.Keyring)
+	}
+	if err == nil {
+		recorder.stop()
+		return &DecryptedStream{Reader: plaintext, KeyInfo: keyInfo}, nil
+	}
+	if !IsSigncryptionMismatch(err) {
+		return nil, fmt.Errorf("failed to create decrypt stream: %w", err)
+	}
+
+	// The message is signcrypted: open it again from the start
+	if err := d.checkSigncryptVersion(); err != nil {
+		return nil, fmt.Errorf("failed to create signcrypt open stream: %w", err)
+	}
+	replay := io.MultiReader(bytes.NewReader(recorder.stop()), buffered)
+
+	var signer saltpack.SigningPublicKey
+	if armored {
+		signer, plaintext, _, err = saltpack.NewDearmor62SigncryptOpenStream(replay, d.signcryptKeyring(), nil)
+	} else {
+		signer, plaintext, err = saltpack.NewSigncryptOpenStream(replay, d.signcryptKeyring(), nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signcrypt open stream: %w", err)
+	}
+
+	return &DecryptedStream{Reader: plaintext, Signer: signer, Signcrypted: true}, nil
+}
+
+// recordingReader keeps a copy of what is read until stopped
+type recordingReader struct {
+	reader    io.Reader
+	recorded  bytes.Buffer
+	recording bool
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if r.recording {
+		r.recorded.Write(p[:n])
+	}
+	return n, err
+}
+
+// stop ends recording and returns what was read so far
+func (r *recordingReader) stop() []byte {
+	r.recording = false
+	recorded := r.recorded.Bytes()
+	r.recorded = bytes.Buffer{}
+	return recorded
+}
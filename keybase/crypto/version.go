@@ -0,0 +1,70 @@
+package crypto
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keybase/saltpack"
+)
+
+// ParseVersion returns the Saltpack version with the given major version
+// Only major versions known to the saltpack library (1 and 2) are accepted.
+func ParseVersion(major int) (saltpack.Version, error) {
+	for _, version := range saltpack.KnownVersions() {
+		if version.Major == major {
+			return version, nil
+		}
+	}
+	return saltpack.Version{}, fmt.Errorf("unsupported saltpack version %d: must be 1 or 2", major)
+}
+
+// VersionError reports a message whose Saltpack version the Decryptor or
+// Verifier does not allow
+type VersionError struct {
+	// Version is the version in the message header
+	Version saltpack.Version
+
+	// Allowed lists the versions that are accepted
+	Allowed []saltpack.Version
+}
+
+func (e *VersionError) Error() string {
+	allowed := make([]string, 0, len(e.Allowed))
+	for _, version := range e.Allowed {
+		allowed = append(allowed, version.String())
+	}
+	return fmt.Sprintf("saltpack version %s is not allowed (allowed: %s)", e.Version, strings.Join(allowed, ", "))
+}
+
+// checkVersion is the saltpack.VersionValidator of the Decryptor
+func (d *Decryptor) checkVersion(version saltpack.Version) error {
+	return checkAllowedVersion(version, d.AllowedVersions)
+}
+
+// checkAllowedVersion refuses versions unknown to the saltpack library and,
+// if allowed is not empty, versions whose major version it does not list;
+// minor versions are compatible with each other
+func checkAllowedVersion(version saltpack.Version, allowed []saltpack.Version) error {
+	if err := saltpack.CheckKnownMajorVersion(version); err != nil {
+		return err
+	}
+
+	if len(allowed) == 0 {
+		return nil
+	}
+
+	for _, candidate := range allowed {
+		if version.Major == candidate.Major {
+			return nil
+		}
+	}
+
+	return &VersionError{Version: version, Allowed: allowed}
+}
+
+// checkSigncryptVersion checks that signcrypted messages are allowed
+// Signcryption only exists in Saltpack version 2, which the saltpack
+// library enforces when opening the message.
+func (d *Decryptor) checkSigncryptVersion() error {
+	return d.checkVersion(saltpack.Version2())
+}
@@ -0,0 +1,115 @@
+package crypto
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/keybase/saltpack"
+)
+
+// TestParseVersion tests mapping major versions to Saltpack versions
+func TestParseVersion(t *testing.T) {
+	for _, major := range []int{1, 2} {
+		version, err := ParseVersion(major)
+		if err != nil {
+			t.Fatalf("ParseVersion(%d) error = %v", major, err)
+		}
+		if version.Major != major {
+			t.Errorf("ParseVersion(%d) = %s", major, version)
+		}
+	}
+
+	for _, major := range []int{0, 3, -1} {
+		if _, err := ParseVersion(major); err == nil {
+			t.Errorf("ParseVersion(%d) should fail", major)
+		}
+	}
+}
+
+// TestDecryptorAllowedVersions tests that messages in versions outside
+// AllowedVersions are refused, and that the version is reported
+func TestDecryptorAllowedVersions(t *testing.T) {
+	recipient, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate recipient key: %v", err)
+	}
+	signingKey, err := GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	receivers := []saltpack.BoxPublicKey{recipient.PublicKey}
+	plaintext := []byte("versioned state")
+
+	keyring := NewSimpleKeyring()
+	keyring.AddKeyPair(recipient)
+
+	onlyV2, err := NewDecryptor(&DecryptorConfig{
+		Keyring:         keyring,
+		AllowedVersions: []saltpack.Version{saltpack.Version2()},
+	})
+	if err != nil {
+		t.Fatalf("NewDecryptor() error = %v", err)
+	}
+	onlyV1, err := NewDecryptor(&DecryptorConfig{
+		Keyring:         keyring,
+		AllowedVersions: []saltpack.Version{saltpack.Version1()},
+	})
+	if err != nil {
+		t.Fatalf("NewDecryptor() error = %v", err)
+	}
+
+	for _, version := range []saltpack.Version{saltpack.Version1(), saltpack.Version2()} {
+		version := version
+		enc, err := NewEncryptor(&EncryptorConfig{Version: &version})
+		if err != nil {
+			t.Fatalf("NewEncryptor() error = %v", err)
+		}
+		ciphertext, err := enc.Encrypt(plaintext, receivers)
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+
+		allowed, refused := onlyV2, onlyV1
+		if version.Major == 1 {
+			allowed, refused = onlyV1, onlyV2
+		}
+
+		_, keyInfo, err := allowed.Decrypt(ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt() of version %s error = %v", version, err)
+		}
+		info, err := ParseMessageInfo(keyInfo, ciphertext)
+		if err != nil {
+			t.Fatalf("ParseMessageInfo() error = %v", err)
+		}
+		if info.Version != version {
+			t.Errorf("MessageInfo.Version = %s, want %s", info.Version, version)
+		}
+
+		_, _, err = refused.Decrypt(ciphertext)
+		var versionErr *VersionError
+		if !errors.As(err, &versionErr) {
+			t.Fatalf("Decrypt() of version %s error = %v, want *VersionError", version, err)
+		}
+		if versionErr.Version != version {
+			t.Errorf("VersionError.Version = %s, want %s", versionErr.Version, version)
+		}
+	}
+
+	// Signcryption only exists in version 2
+	enc, err := NewEncryptor(&EncryptorConfig{SigningKey: signingKey})
+	if err != nil {
+		t.Fatalf("NewEncryptor() error = %v", err)
+	}
+	signcrypted, err := enc.SigncryptArmored(plaintext, receivers)
+	if err != nil {
+		t.Fatalf("SigncryptArmored() error = %v", err)
+	}
+	if _, _, err := onlyV2.DecryptSigncryptedArmored(signcrypted); err != nil {
+		t.Errorf("DecryptSigncryptedArmored() error = %v", err)
+	}
+	var versionErr *VersionError
+	if _, _, err := onlyV1.DecryptSigncryptedArmored(signcrypted); !errors.As(err, &versionErr) {
+		t.Errorf("DecryptSigncryptedArmored() error = %v, want *VersionError", err)
+	}
+}
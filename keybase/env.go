@@ -0,0 +1,425 @@
+package keybase
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+)
+
+// Environment variables read by LoadConfig
+// See ENVIRONMENT_VARIABLES.md for the full reference
+const (
+	// EnvRecipients is a comma-separated list of recipient usernames and
+	// team:<name> teams
+	EnvRecipients = "KEYBASE_RECIPIENTS"
+	// EnvFormat is the encryption format ("saltpack" or "pgp")
+	EnvFormat = "KEYBASE_FORMAT"
+	// EnvEngine selects what encrypts and decrypts ("native" or "cli")
+	EnvEngine = "KEYBASE_ENGINE"
+	// EnvSaltpackVersion is the Saltpack major version to encrypt with (1 or 2)
+	EnvSaltpackVersion = "KEYBASE_SALTPACK_VERSION"
+	// EnvAllowedVersions is a comma-separated list of Saltpack major
+	// versions accepted when decrypting
+	EnvAllowedVersions = "KEYBASE_ALLOWED_VERSIONS"
+	// EnvHideRecipients leaves recipient KIDs out of message headers
+	EnvHideRecipients = "KEYBASE_HIDE_RECIPIENTS"
+	// EnvEnvelope enables envelope encryption with the given payload cipher
+	EnvEnvelope = "KEYBASE_ENVELOPE"
+	// EnvCacheTTL is the public key cache TTL in seconds
+	EnvCacheTTL = "KEYBASE_CACHE_TTL"
+	// EnvVerifyProofs enables identity proof verification
+	EnvVerifyProofs = "KEYBASE_VERIFY_PROOFS"
+	// EnvCachePath is the path to the public key cache file
+	EnvCachePath = "KEYBASE_CACHE_PATH"
+	// EnvAPITimeout is the Keybase API HTTP timeout in seconds
+	EnvAPITimeout = "KEYBASE_API_TIMEOUT"
+	// EnvAPIMaxRetries is the maximum number of API retries
+	EnvAPIMaxRetries = "KEYBASE_API_MAX_RETRIES"
+	// EnvAPIRetryDelay is the initial delay between API retries in seconds
+	EnvAPIRetryDelay = "KEYBASE_API_RETRY_DELAY"
+	// EnvKeyDirectory is the path to a YAML or JSON key directory file
+	EnvKeyDirectory = "KEYBASE_KEY_DIRECTORY"
+	// EnvKeyMirror is the base URL of an HTTP mirror of the Keybase API
+	EnvKeyMirror = "KEYBASE_KEY_MIRROR"
+	// EnvAPIProxy is an http, https or socks5 proxy URL for API requests
+	EnvAPIProxy = "KEYBASE_API_PROXY"
+	// EnvAPICAFile is a PEM bundle of extra CA certificates for API requests
+	EnvAPICAFile = "KEYBASE_API_CA_FILE"
+	// EnvAPIClientCert is a PEM client certificate for mutual TLS with the API
+	EnvAPIClientCert = "KEYBASE_API_CLIENT_CERT"
+	// EnvAPIClientKey is the PEM key of the EnvAPIClientCert certificate
+	EnvAPIClientKey = "KEYBASE_API_CLIENT_KEY"
+	// EnvTrustServerTeams accepts unverified team member lists from the API
+	EnvTrustServerTeams = "KEYBASE_TRUST_SERVER_TEAMS"
+	// EnvPGPSecretKey is the path to an armored PGP secret key for decryption
+	EnvPGPSecretKey = "KEYBASE_PGP_SECRET_KEY"
+	// EnvPGPPassphrase is the passphrase protecting the PGP secret key
+	// It is read by NewKeeper, never by LoadConfig, so it does not end up in Config
+	EnvPGPPassphrase = "KEYBASE_PGP_PASSPHRASE"
+	// EnvKeyPassphrase is the passphrase protecting local key files
+	// Like EnvPGPPassphrase it is read when keys are loaded, not by LoadConfig
+	EnvKeyPassphrase = crypto.PassphraseEnv
+	// EnvKeyPassphraseFD is a file descriptor to read the key file
+	// passphrase from, as with gpg --passphrase-fd
+	EnvKeyPassphraseFD = crypto.PassphraseFDEnv
+)
+
+// ConfigSource identifies where a configuration value came from
+type ConfigSource string
+
+const (
+	// SourceDefault means the built-in default was used
+	SourceDefault ConfigSource = "default"
+	// SourceURL means the value was set by the keybase:// URL
+	SourceURL ConfigSource = "url"
+	// SourceEnv means the value was set by a KEYBASE_* environment variable
+	SourceEnv ConfigSource = "env"
+)
+
+// Config field names used as keys in ConfigSources
+const (
+	FieldRecipients          = "Recipients"
+	FieldFormat              = "Format"
+	FieldMode                = "Mode"
+	FieldEngine              = "Engine"
+	FieldSaltpackVersion     = "SaltpackVersion"
+	FieldAllowedVersions     = "AllowedVersions"
+	FieldHideRecipients      = "HideRecipients"
+	FieldEnvelope            = "Envelope"
+	FieldCacheTTL            = "CacheTTL"
+	FieldVerifyProofs        = "VerifyProofs"
+	FieldCachePath           = "CachePath"
+	FieldAPITimeout          = "APIConfig.Timeout"
+	FieldAPIMaxRetries       = "APIConfig.MaxRetries"
+	FieldAPIRetryDelay       = "APIConfig.RetryDelay"
+	FieldAPIProxy            = "APIConfig.ProxyURL"
+	FieldAPICAFile           = "APIConfig.CAFile"
+	FieldAPIClientCert       = "APIConfig.ClientCertFile"
+	FieldAPIClientKey        = "APIConfig.ClientKeyFile"
+	FieldAPITrustServerTeams = "APIConfig.TrustServerTeams"
+	FieldKeyDirectory        = "KeyDirectory"
+	FieldKeyMirror           = "KeyMirror"
+	FieldPGPSecretKeyPath    = "PGPSecretKeyPath"
+	FieldProofPolicy         = "ProofPolicy"
+	FieldSenderPolicy        = "SenderPolicy"
+	FieldTeamRole            = "TeamRole"
+)
+
+// ConfigSources records which source set each Config field
+type ConfigSources map[string]ConfigSource
+
+// defaultSources returns ConfigSources with every field marked as default
+func defaultSources() ConfigSources {
+	return ConfigSources{
+		FieldRecipients:          SourceDefault,
+		FieldFormat:              SourceDefault,
+		FieldMode:                SourceDefault,
+		FieldEngine:              SourceDefault,
+		FieldSaltpackVersion:     SourceDefault,
+		FieldAllowedVersions:     SourceDefault,
+		FieldHideRecipients:      SourceDefault,
+		FieldEnvelope:            SourceDefault,
+		FieldCacheTTL:            SourceDefault,
+		FieldVerifyProofs:        SourceDefault,
+		FieldCachePath:           SourceDefault,
+		FieldAPITimeout:          SourceDefault,
+		FieldAPIMaxRetries:       SourceDefault,
+		FieldAPIRetryDelay:       SourceDefault,
+		FieldAPIProxy:            SourceDefault,
+		FieldAPICAFile:           SourceDefault,
+		FieldAPIClientCert:       SourceDefault,
+		FieldAPIClientKey:        SourceDefault,
+		FieldAPITrustServerTeams: SourceDefault,
+		FieldKeyDirectory:        SourceDefault,
+		FieldKeyMirror:           SourceDefault,
+		FieldPGPSecretKeyPath:    SourceDefault,
+		FieldProofPolicy:         SourceDefault,
+		FieldSenderPolicy:        SourceDefault,
+		FieldTeamRole:            SourceDefault,
+	}
+}
+
+// LoadConfig builds a Config from a Keybase URL and KEYBASE_* environment variables
+//
+// Precedence, highest first:
+//  1. Environment variables (KEYBASE_RECIPIENTS, KEYBASE_FORMAT, ...)
+//  2. URL host and query parameters (keybase://alice,bob?format=saltpack)
+//  3. Built-in defaults (DefaultConfig)
+//
+// rawURL may be empty, or omit its recipients (keybase://?format=pgp), when
+// KEYBASE_RECIPIENTS is set. The returned ConfigSources reports which source
+// set each field.
+func LoadConfig(rawURL string) (*Config, ConfigSources, error) {
+	return loadConfig(rawURL, os.LookupEnv)
+}
+
+// loadConfig implements LoadConfig with an injectable environment lookup
+func loadConfig(rawURL string, lookupEnv func(string) (string, bool)) (*Config, ConfigSources, error) {
+	config := DefaultConfig()
+	sources := defaultSources()
+
+	if rawURL != "" {
+		var err error
+		config, sources, err = parseURL(rawURL, false)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := applyEnv(config, sources, lookupEnv); err != nil {
+		return nil, nil, err
+	}
+
+	if len(config.Recipients) == 0 && len(config.Teams) == 0 {
+		return nil, nil, fmt.Errorf("no recipients specified: set them in the URL or %s", EnvRecipients)
+	}
+
+	// KEYBASE_FORMAT may have overridden the format a URL mode relied on
+	if config.Mode == ModeSigncrypt && config.Format != FormatSaltpack {
+		return nil, nil, fmt.Errorf("mode=%s requires format=%s", ModeSigncrypt, FormatSaltpack)
+	}
+
+	// Environment Saltpack options must agree with each other and with the URL
+	if err := validateSaltpackOptions(config); err != nil {
+		return nil, nil, err
+	}
+
+	if err := validateEngineOptions(config); err != nil {
+		return nil, nil, err
+	}
+	if err := validateEnvelopeOptions(config); err != nil {
+		return nil, nil, err
+	}
+
+	// A certificate from the environment needs its key, wherever that is set
+	if err := validateNetworkOptions(config); err != nil {
+		return nil, nil, err
+	}
+
+	// KEYBASE_RECIPIENTS may have replaced the users a URL assertion named
+	if err := validateProofAssertions(config); err != nil {
+		return nil, nil, err
+	}
+
+	return config, sources, nil
+}
+
+// applyEnv overrides config fields with any KEYBASE_* environment variables that are set
+func applyEnv(config *Config, sources ConfigSources, lookupEnv func(string) (string, bool)) error {
+	if value, ok := lookupNonEmpty(lookupEnv, EnvRecipients); ok {
+		recipients, teams, err := parseRecipients(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvRecipients, err)
+		}
+		if len(recipients) == 0 && len(teams) == 0 {
+			return fmt.Errorf("invalid %s: no valid recipients", EnvRecipients)
+		}
+		config.Recipients = recipients
+		config.Teams = teams
+		sources[FieldRecipients] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvFormat); ok {
+		format := EncryptionFormat(strings.ToLower(value))
+		if err := ValidateFormat(format); err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvFormat, err)
+		}
+		config.Format = format
+		sources[FieldFormat] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvEngine); ok {
+		engine := Engine(strings.ToLower(value))
+		if err := ValidateEngine(engine); err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvEngine, err)
+		}
+		config.Engine = engine
+		sources[FieldEngine] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvSaltpackVersion); ok {
+		version, err := parseSaltpackVersion(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvSaltpackVersion, err)
+		}
+		config.SaltpackVersion = version
+		sources[FieldSaltpackVersion] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAllowedVersions); ok {
+		versions, err := parseAllowedVersions(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvAllowedVersions, err)
+		}
+		config.AllowedVersions = versions
+		sources[FieldAllowedVersions] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvHideRecipients); ok {
+		hide, err := parseBoolEnv(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvHideRecipients, err)
+		}
+		config.HideRecipients = hide
+		sources[FieldHideRecipients] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvEnvelope); ok {
+		envelopeCipher, err := crypto.ParseEnvelopeCipher(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvEnvelope, err)
+		}
+		config.Envelope = envelopeCipher
+		sources[FieldEnvelope] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvCacheTTL); ok {
+		seconds, err := parseSecondsEnv(EnvCacheTTL, value, 0, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		config.CacheTTL = seconds
+		sources[FieldCacheTTL] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvVerifyProofs); ok {
+		verifyProofs, err := parseBoolEnv(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvVerifyProofs, err)
+		}
+		config.VerifyProofs = verifyProofs
+		sources[FieldVerifyProofs] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvCachePath); ok {
+		config.CachePath = value
+		sources[FieldCachePath] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAPITimeout); ok {
+		timeout, err := parseSecondsEnv(EnvAPITimeout, value, 1, 300)
+		if err != nil {
+			return err
+		}
+		ensureAPIConfig(config).Timeout = timeout
+		sources[FieldAPITimeout] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAPIMaxRetries); ok {
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvAPIMaxRetries, err)
+		}
+		if retries < 0 || retries > 10 {
+			return fmt.Errorf("invalid %s: must be between 0 and 10, got %d", EnvAPIMaxRetries, retries)
+		}
+		ensureAPIConfig(config).MaxRetries = retries
+		sources[FieldAPIMaxRetries] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAPIRetryDelay); ok {
+		delay, err := parseSecondsEnv(EnvAPIRetryDelay, value, 0, 60)
+		if err != nil {
+			return err
+		}
+		ensureAPIConfig(config).RetryDelay = delay
+		sources[FieldAPIRetryDelay] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAPIProxy); ok {
+		if _, err := api.ParseProxyURL(value); err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvAPIProxy, err)
+		}
+		ensureAPIConfig(config).ProxyURL = value
+		sources[FieldAPIProxy] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAPICAFile); ok {
+		ensureAPIConfig(config).CAFile = value
+		sources[FieldAPICAFile] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAPIClientCert); ok {
+		ensureAPIConfig(config).ClientCertFile = value
+		sources[FieldAPIClientCert] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvAPIClientKey); ok {
+		ensureAPIConfig(config).ClientKeyFile = value
+		sources[FieldAPIClientKey] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvTrustServerTeams); ok {
+		trust, err := parseBoolEnv(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", EnvTrustServerTeams, err)
+		}
+		ensureAPIConfig(config).TrustServerTeams = trust
+		sources[FieldAPITrustServerTeams] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvKeyDirectory); ok {
+		config.KeyDirectory = value
+		sources[FieldKeyDirectory] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvKeyMirror); ok {
+		config.KeyMirror = value
+		sources[FieldKeyMirror] = SourceEnv
+	}
+
+	if value, ok := lookupNonEmpty(lookupEnv, EnvPGPSecretKey); ok {
+		config.PGPSecretKeyPath = value
+		sources[FieldPGPSecretKeyPath] = SourceEnv
+	}
+
+	return nil
+}
+
+// ensureAPIConfig returns config.APIConfig, initialising it with defaults if needed
+func ensureAPIConfig(config *Config) *api.ClientConfig {
+	if config.APIConfig == nil {
+		config.APIConfig = api.DefaultClientConfig()
+	}
+	return config.APIConfig
+}
+
+// lookupNonEmpty returns the trimmed value of an environment variable
+// Unset and empty variables are treated the same
+func lookupNonEmpty(lookupEnv func(string) (string, bool), name string) (string, bool) {
+	value, ok := lookupEnv(name)
+	if !ok {
+		return "", false
+	}
+	value = strings.TrimSpace(value)
+	return value, value != ""
+}
+
+// parseSecondsEnv parses an integer number of seconds within [min, max]
+func parseSecondsEnv(name, value string, min, max int64) (time.Duration, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if seconds < min || seconds > max {
+		return 0, fmt.Errorf("invalid %s: must be between %d and %d seconds, got %d", name, min, max, seconds)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// parseBoolEnv parses a boolean, also accepting "yes" and "no"
+func parseBoolEnv(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes", "y", "on":
+		return true, nil
+	case "no", "n", "off":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
@@ -0,0 +1,360 @@
+package keybase
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+)
+
+// mapEnv returns an environment lookup function backed by a map
+func mapEnv(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	tests := []struct {
+		name           string
+		url            string
+		env            map[string]string
+		wantRecipients []string
+		wantFormat     EncryptionFormat
+		wantCacheTTL   time.Duration
+		wantVerify     bool
+		wantSources    ConfigSources
+		wantErr        bool
+	}{
+		{
+			name:           "URL only",
+			url:            "keybase://alice,bob?format=pgp",
+			wantRecipients: []string{"alice", "bob"},
+			wantFormat:     FormatPGP,
+			wantCacheTTL:   24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldRecipients: SourceURL,
+				FieldFormat:     SourceURL,
+				FieldCacheTTL:   SourceDefault,
+			},
+		},
+		{
+			name: "environment overrides URL",
+			url:  "keybase://alice,bob?cache_ttl=3600",
+			env: map[string]string{
+				EnvRecipients:   "charlie, dave",
+				EnvCacheTTL:     "60",
+				EnvVerifyProofs: "yes",
+			},
+			wantRecipients: []string{"charlie", "dave"},
+			wantFormat:     FormatSaltpack,
+			wantCacheTTL:   time.Minute,
+			wantVerify:     true,
+			wantSources: ConfigSources{
+				FieldRecipients:   SourceEnv,
+				FieldFormat:       SourceDefault,
+				FieldCacheTTL:     SourceEnv,
+				FieldVerifyProofs: SourceEnv,
+			},
+		},
+		{
+			name: "environment only",
+			env: map[string]string{
+				EnvRecipients: "alice",
+				EnvFormat:     "SALTPACK",
+			},
+			wantRecipients: []string{"alice"},
+			wantFormat:     FormatSaltpack,
+			wantCacheTTL:   24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldRecipients: SourceEnv,
+				FieldFormat:     SourceEnv,
+			},
+		},
+		{
+			name: "URL without recipients",
+			url:  "keybase://?format=pgp",
+			env: map[string]string{
+				EnvRecipients: "alice",
+			},
+			wantRecipients: []string{"alice"},
+			wantFormat:     FormatPGP,
+			wantCacheTTL:   24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldRecipients: SourceEnv,
+				FieldFormat:     SourceURL,
+			},
+		},
+		{
+			name: "empty environment variable is ignored",
+			url:  "keybase://alice",
+			env: map[string]string{
+				EnvRecipients: "",
+			},
+			wantRecipients: []string{"alice"},
+			wantFormat:     FormatSaltpack,
+			wantCacheTTL:   24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldRecipients: SourceURL,
+			},
+		},
+		{
+			name: "PGP secret key from URL and environment",
+			url:  "keybase://alice?pgp_secret_key=/url/secret.asc",
+			env: map[string]string{
+				EnvPGPSecretKey: "/env/secret.asc",
+			},
+			wantRecipients: []string{"alice"},
+			wantFormat:     FormatSaltpack,
+			wantCacheTTL:   24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldPGPSecretKeyPath: SourceEnv,
+			},
+		},
+		{
+			name: "key directory from URL, mirror from environment",
+			url:  "keybase://alice?key_directory=/etc/keybase/keys.yaml",
+			env: map[string]string{
+				EnvKeyMirror: "https://keys.example.com/_/api/1.0",
+			},
+			wantRecipients: []string{"alice"},
+			wantFormat:     FormatSaltpack,
+			wantCacheTTL:   24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldKeyDirectory: SourceURL,
+				FieldKeyMirror:    SourceEnv,
+			},
+		},
+		{
+			name: "client certificate from URL, key and proxy from environment",
+			url:  "keybase://alice?client_cert=/etc/pki/agent.pem",
+			env: map[string]string{
+				EnvAPIClientKey: "/etc/pki/agent-key.pem",
+				EnvAPIProxy:     "http://proxy.corp.example:3128",
+			},
+			wantRecipients: []string{"alice"},
+			wantFormat:     FormatSaltpack,
+			wantCacheTTL:   24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldAPIClientCert: SourceURL,
+				FieldAPIClientKey:  SourceEnv,
+				FieldAPIProxy:      SourceEnv,
+				FieldAPICAFile:     SourceDefault,
+			},
+		},
+		{
+			name: "unverified team lookups from environment",
+			url:  "keybase://team:acme.ops",
+			env: map[string]string{
+				EnvTrustServerTeams: "true",
+			},
+			wantFormat:   FormatSaltpack,
+			wantCacheTTL: 24 * time.Hour,
+			wantSources: ConfigSources{
+				FieldAPITrustServerTeams: SourceEnv,
+			},
+		},
+		{
+			name:    "invalid trust_server_teams in environment",
+			url:     "keybase://team:acme.ops",
+			env:     map[string]string{EnvTrustServerTeams: "sometimes"},
+			wantErr: true,
+		},
+		{
+			name:    "envelope from environment with a sender policy",
+			url:     "keybase://alice?allowed_senders=bob",
+			env:     map[string]string{EnvEnvelope: "aes-gcm"},
+			wantErr: true,
+		},
+		{
+			name:    "client certificate without a key",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvAPIClientCert: "/etc/pki/agent.pem"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid proxy in environment",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvAPIProxy: "proxy.corp.example:3128"},
+			wantErr: true,
+		},
+		{
+			name:    "no recipients anywhere",
+			url:     "keybase://?format=pgp",
+			wantErr: true,
+		},
+		{
+			name:    "no URL and no environment",
+			wantErr: true,
+		},
+		{
+			name:    "invalid recipient in environment",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvRecipients: "bob@example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid format in environment",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvFormat: "age"},
+			wantErr: true,
+		},
+		{
+			name:    "negative cache TTL in environment",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvCacheTTL: "-1"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid boolean in environment",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvVerifyProofs: "maybe"},
+			wantErr: true,
+		},
+		{
+			name:    "API timeout out of range",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvAPITimeout: "0"},
+			wantErr: true,
+		},
+		{
+			name:    "API max retries out of range",
+			url:     "keybase://alice",
+			env:     map[string]string{EnvAPIMaxRetries: "11"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid URL",
+			url:     "https://alice",
+			env:     map[string]string{EnvRecipients: "alice"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, sources, err := loadConfig(tt.url, mapEnv(tt.env))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if len(config.Recipients) != len(tt.wantRecipients) {
+				t.Fatalf("Recipients = %v, want %v", config.Recipients, tt.wantRecipients)
+			}
+			for i := range config.Recipients {
+				if config.Recipients[i] != tt.wantRecipients[i] {
+					t.Errorf("Recipients[%d] = %s, want %s", i, config.Recipients[i], tt.wantRecipients[i])
+				}
+			}
+			if config.Format != tt.wantFormat {
+				t.Errorf("Format = %s, want %s", config.Format, tt.wantFormat)
+			}
+			if config.CacheTTL != tt.wantCacheTTL {
+				t.Errorf("CacheTTL = %s, want %s", config.CacheTTL, tt.wantCacheTTL)
+			}
+			if config.VerifyProofs != tt.wantVerify {
+				t.Errorf("VerifyProofs = %t, want %t", config.VerifyProofs, tt.wantVerify)
+			}
+			for field, want := range tt.wantSources {
+				if sources[field] != want {
+					t.Errorf("sources[%s] = %s, want %s", field, sources[field], want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigAPIAndCacheSettings(t *testing.T) {
+	config, sources, err := loadConfig("keybase://alice", mapEnv(map[string]string{
+		EnvCachePath:     "/tmp/keybase-ci-cache.json",
+		EnvAPITimeout:    "60",
+		EnvAPIMaxRetries: "0",
+	}))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+
+	if config.CachePath != "/tmp/keybase-ci-cache.json" {
+		t.Errorf("CachePath = %q, want %q", config.CachePath, "/tmp/keybase-ci-cache.json")
+	}
+	if config.APIConfig == nil {
+		t.Fatal("APIConfig is nil")
+	}
+	if config.APIConfig.Timeout != time.Minute {
+		t.Errorf("APIConfig.Timeout = %s, want %s", config.APIConfig.Timeout, time.Minute)
+	}
+	if config.APIConfig.MaxRetries != 0 {
+		t.Errorf("APIConfig.MaxRetries = %d, want 0", config.APIConfig.MaxRetries)
+	}
+	// Unset API fields keep their defaults
+	if config.APIConfig.RetryDelay != api.DefaultRetryDelay {
+		t.Errorf("APIConfig.RetryDelay = %s, want %s", config.APIConfig.RetryDelay, api.DefaultRetryDelay)
+	}
+	if config.APIConfig.BaseURL != api.DefaultAPIEndpoint {
+		t.Errorf("APIConfig.BaseURL = %s, want %s", config.APIConfig.BaseURL, api.DefaultAPIEndpoint)
+	}
+
+	wantSources := ConfigSources{
+		FieldCachePath:     SourceEnv,
+		FieldAPITimeout:    SourceEnv,
+		FieldAPIMaxRetries: SourceEnv,
+		FieldAPIRetryDelay: SourceDefault,
+	}
+	for field, want := range wantSources {
+		if sources[field] != want {
+			t.Errorf("sources[%s] = %s, want %s", field, sources[field], want)
+		}
+	}
+}
+
+func TestLoadConfigFromProcessEnvironment(t *testing.T) {
+	t.Setenv(EnvRecipients, "bob")
+	t.Setenv(EnvFormat, "pgp")
+
+	config, sources, err := LoadConfig("keybase://alice")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	if len(config.Recipients) != 1 || config.Recipients[0] != "bob" {
+		t.Errorf("Recipients = %v, want [bob]", config.Recipients)
+	}
+	if config.Format != FormatPGP {
+		t.Errorf("Format = %s, want %s", config.Format, FormatPGP)
+	}
+	if sources[FieldRecipients] != SourceEnv {
+		t.Errorf("sources[%s] = %s, want %s", FieldRecipients, sources[FieldRecipients], SourceEnv)
+	}
+}
+
+func TestParseBoolEnv(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    bool
+		wantErr bool
+	}{
+		{"true", true, false},
+		{"1", true, false},
+		{"YES", true, false},
+		{"false", false, false},
+		{"0", false, false},
+		{"no", false, false},
+		{"maybe", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseBoolEnv(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBoolEnv(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseBoolEnv(%q) = %t, want %t", tt.value, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package keybase
+
+import (
+	"context"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// EnvelopeKey is a data key wrapped for a Keeper's recipients
+//
+// Every secret sealed with it is an envelope sharing the one wrapped key, so
+// a whole stack can be protected by a single Saltpack message and rekeyed by
+// replacing it. Each Seal uses a fresh random nonce.
+type EnvelopeKey struct {
+	cipher     crypto.EnvelopeCipher
+	dataKey    *crypto.DataKey
+	wrappedKey string
+}
+
+// NewEnvelopeKey generates a data key and wraps it for the configured
+// recipients, as Encrypt does in envelope mode
+//
+// The configured envelope cipher is used, or secretbox if envelope mode is
+// off. Envelopes are a Saltpack feature, so format=pgp is refused.
+func (k *Keeper) NewEnvelopeKey(ctx context.Context) (*EnvelopeKey, error) {
+	if err := k.requireNativeEngine("envelope encryption"); err != nil {
+		return nil, err
+	}
+	if k.config.Format != FormatSaltpack {
+		return nil, &KeeperError{
+			Message: "envelope encryption requires format=saltpack",
+			Code:    gcerrors.FailedPrecondition,
+		}
+	}
+
+	userPublicKeys, err := k.recipientKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	receivers, err := saltpackReceivers(userPublicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return k.newEnvelopeKey(receivers)
+}
+
+// Zero wipes the data key; Seal fails afterwards
+func (e *EnvelopeKey) Zero() {
+	e.dataKey.Zero()
+	e.dataKey = nil
+}
+
+// Seal encrypts plaintext into an envelope under the key
+func (e *EnvelopeKey) Seal(plaintext []byte) ([]byte, error) {
+	if e.dataKey == nil {
+		return nil, &KeeperError{
+			Message: "envelope key has been zeroed",
+			Code:    gcerrors.FailedPrecondition,
+		}
+	}
+
+	envelope, err := crypto.SealEnvelope(e.cipher, e.dataKey, e.wrappedKey, plaintext)
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "envelope encryption failed",
+			Code:       gcerrors.Internal,
+			Underlying: err,
+		}
+	}
+
+	return marshalEnvelope(envelope)
+}
+
+// newEnvelopeKey generates a data key and wraps it for receivers
+func (k *Keeper) newEnvelopeKey(receivers []saltpack.BoxPublicKey) (*EnvelopeKey, error) {
+	dataKey, err := crypto.GenerateDataKey()
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "envelope encryption failed",
+			Code:       gcerrors.Internal,
+			Underlying: err,
+		}
+	}
+
+	wrappedKey, err := k.encryptArmored(dataKey[:], receivers)
+	if err != nil {
+		return nil, err
+	}
+
+	envelopeCipher := k.config.Envelope
+	if envelopeCipher == "" {
+		envelopeCipher = crypto.EnvelopeSecretbox
+	}
+
+	return &EnvelopeKey{
+		cipher:     envelopeCipher,
+		dataKey:    dataKey,
+		wrappedKey: string(wrappedKey),
+	}, nil
+}
+
+// encryptEnvelope encrypts plaintext into an envelope with a data key of its own
+func (k *Keeper) encryptEnvelope(plaintext []byte, receivers []saltpack.BoxPublicKey) ([]byte, error) {
+	key, err := k.newEnvelopeKey(receivers)
+	if err != nil {
+		return nil, err
+	}
+	defer key.Zero()
+
+	return key.Seal(plaintext)
+}
+
+// decryptEnvelope unwraps the data key of an envelope with the decryptor
+// and opens the payload
+func (k *Keeper) decryptEnvelope(decryptor *crypto.Decryptor, ciphertext []byte) ([]byte, error) {
+	envelope, err := k.parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := k.unwrapDataKey(decryptor, envelope)
+	if err != nil {
+		return nil, err
+	}
+	defer dataKey.Zero()
+
+	return k.openEnvelope(envelope, dataKey[:])
+}
+
+// unwrapDataKey decrypts the wrapped key of an envelope
+//
+// Envelopes are refused under a SenderPolicy. The sender of the wrapped key
+// is known, but not who sealed the payload: any recipient can unwrap the
+// data key and seal a payload of their own under the same wrapped key.
+// Signing the payload hash inside the wrapped key would close that gap, but
+// then envelopes could no longer share one wrapped key (see EnvelopeKey),
+// so policies that need the writer of each secret use plain messages.
+func (k *Keeper) unwrapDataKey(decryptor *crypto.Decryptor, envelope *crypto.Envelope) (*crypto.DataKey, error) {
+	if err := k.checkEnvelopeDecryption(); err != nil {
+		return nil, err
+	}
+
+	message, err := k.decryptSaltpack(decryptor, []byte(envelope.WrappedKey))
+	if err != nil {
+		return nil, err
+	}
+	defer clear(message.plaintext)
+
+	dataKey, err := crypto.ParseDataKey(message.plaintext)
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "invalid envelope",
+			Code:       gcerrors.InvalidArgument,
+			Underlying: err,
+		}
+	}
+
+	return dataKey, nil
+}
+
+// checkEnvelopeDecryption refuses envelopes under a SenderPolicy (see
+// unwrapDataKey)
+func (k *Keeper) checkEnvelopeDecryption() error {
+	if k.config.SenderPolicy.Enabled() {
+		return &KeeperError{
+			Message:    "refusing to decrypt envelope",
+			Code:       gcerrors.PermissionDenied,
+			Underlying: &SenderPolicyError{Reason: "envelope payloads do not identify their writer"},
+		}
+	}
+	return nil
+}
+
+// rewrapEnvelope wraps the data key of an envelope for receivers, leaving
+// the payload untouched
+//
+// rewrapped maps wrapped keys already replaced in this batch to their
+// replacements, so that envelopes sharing a data key keep sharing one.
+func (k *Keeper) rewrapEnvelope(decryptor *crypto.Decryptor, ciphertext []byte, receivers []saltpack.BoxPublicKey, rewrapped map[string]string) ([]byte, error) {
+	envelope, err := k.parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	wrappedKey, ok := rewrapped[envelope.WrappedKey]
+	if !ok {
+		dataKey, err := k.unwrapDataKey(decryptor, envelope)
+		if err != nil {
+			return nil, err
+		}
+		defer dataKey.Zero()
+
+		newWrappedKey, err := k.encryptArmored(dataKey[:], receivers)
+		if err != nil {
+			return nil, err
+		}
+		wrappedKey = string(newWrappedKey)
+		rewrapped[envelope.WrappedKey] = wrappedKey
+	}
+
+	return marshalEnvelope(envelope.Rewrap(wrappedKey))
+}
+
+// parseEnvelope parses a serialized envelope
+func (k *Keeper) parseEnvelope(ciphertext []byte) (*crypto.Envelope, error) {
+	envelope, err := crypto.ParseEnvelope(ciphertext)
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "invalid envelope",
+			Code:       gcerrors.InvalidArgument,
+			Underlying: err,
+		}
+	}
+
+	return envelope, nil
+}
+
+// openEnvelope decrypts the payload of an envelope with its unwrapped data key
+func (k *Keeper) openEnvelope(envelope *crypto.Envelope, key []byte) ([]byte, error) {
+	dataKey, err := crypto.ParseDataKey(key)
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "invalid envelope",
+			Code:       gcerrors.InvalidArgument,
+			Underlying: err,
+		}
+	}
+	defer dataKey.Zero()
+
+	plaintext, err := envelope.Open(dataKey)
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "envelope decryption failed",
+			Code:       gcerrors.InvalidArgument,
+			Underlying: err,
+		}
+	}
+
+	return plaintext, nil
+}
+
+// marshalEnvelope serializes an envelope
+func marshalEnvelope(envelope *crypto.Envelope) ([]byte, error) {
+	ciphertext, err := envelope.Marshal()
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "envelope encryption failed",
+			Code:       gcerrors.Internal,
+			Underlying: err,
+		}
+	}
+
+	return ciphertext, nil
+}
@@ -0,0 +1,245 @@
+package keybase
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// TestKeeperEnvelope tests Encrypt and every decryption path in envelope mode
+func TestKeeperEnvelope(t *testing.T) {
+	plaintext := []byte("state blob")
+
+	for _, c := range []crypto.EnvelopeCipher{crypto.EnvelopeSecretbox, crypto.EnvelopeAESGCM} {
+		t.Run(string(c), func(t *testing.T) {
+			ctx := context.Background()
+			keeper, receiver := newStreamTestKeeper(t, "keybase://alice?envelope="+string(c))
+
+			ciphertext, err := keeper.Encrypt(ctx, plaintext)
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			envelope, err := crypto.ParseEnvelope(ciphertext)
+			if err != nil {
+				t.Fatalf("ParseEnvelope() error = %v", err)
+			}
+			if envelope.Cipher != c {
+				t.Errorf("envelope cipher = %s, want %s", envelope.Cipher, c)
+			}
+
+			decrypted, err := keeper.Decrypt(ctx, ciphertext)
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+			}
+
+			decrypted, info, err := keeper.DecryptWithInfo(ctx, ciphertext)
+			if err != nil {
+				t.Fatalf("DecryptWithInfo() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("DecryptWithInfo() = %q, want %q", decrypted, plaintext)
+			}
+			if info.Receiver.KID != crypto.EncryptionKID(receiver.PublicKey) {
+				t.Errorf("MessageInfo.Receiver.KID = %s, want %s", info.Receiver.KID, crypto.EncryptionKID(receiver.PublicKey))
+			}
+
+			reader, err := keeper.NewDecryptReader(ctx, bytes.NewReader(ciphertext))
+			if err != nil {
+				t.Fatalf("NewDecryptReader() error = %v", err)
+			}
+			decrypted, err = io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("NewDecryptReader() = %q, want %q", decrypted, plaintext)
+			}
+
+			inspection, err := keeper.InspectMessage(ctx, ciphertext)
+			if err != nil {
+				t.Fatalf("InspectMessage() error = %v", err)
+			}
+			if inspection.Envelope != c || inspection.RecipientIndex(crypto.EncryptionKID(receiver.PublicKey)) != 0 {
+				t.Errorf("InspectMessage() = %+v, want cipher %s and alice's key", inspection, c)
+			}
+		})
+	}
+}
+
+// TestKeeperEnvelopeErrors tests that broken envelopes are refused
+func TestKeeperEnvelopeErrors(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice?envelope=secretbox")
+
+	ciphertext, err := keeper.Encrypt(ctx, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	envelope, err := crypto.ParseEnvelope(ciphertext)
+	if err != nil {
+		t.Fatalf("ParseEnvelope() error = %v", err)
+	}
+
+	envelope.Payload[0] ^= 1
+	tampered, err := envelope.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	for name, ciphertext := range map[string][]byte{
+		"tampered payload": tampered,
+		"malformed":        []byte(`{"type":"keybase-envelope","version":1}`),
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := keeper.Decrypt(ctx, ciphertext)
+			if code := keeper.ErrorCode(err); code != gcerrors.InvalidArgument {
+				t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, gcerrors.InvalidArgument, err)
+			}
+		})
+	}
+
+	// Envelopes cannot be streamed, and a plain message would ignore the mode
+	if _, err := keeper.NewEncryptWriter(ctx, io.Discard); keeper.ErrorCode(err) != gcerrors.FailedPrecondition {
+		t.Errorf("NewEncryptWriter() in envelope mode error = %v, want FailedPrecondition", err)
+	}
+}
+
+// TestKeeperEnvelopeSenderPolicy tests that envelopes are refused under a
+// SenderPolicy, since their payload does not identify its writer
+func TestKeeperEnvelopeSenderPolicy(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice?envelope=secretbox")
+
+	ciphertext, err := keeper.Encrypt(ctx, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	keeper.config.SenderPolicy = SenderPolicy{RejectAnonymous: true}
+	var policyErr *SenderPolicyError
+	if _, err := keeper.Decrypt(ctx, ciphertext); keeper.ErrorCode(err) != gcerrors.PermissionDenied || !errors.As(err, &policyErr) {
+		t.Errorf("Decrypt() error = %v, want PermissionDenied with a SenderPolicyError", err)
+	}
+	if _, _, err := keeper.DecryptWithInfo(ctx, ciphertext); keeper.ErrorCode(err) != gcerrors.PermissionDenied {
+		t.Errorf("DecryptWithInfo() error = %v, want PermissionDenied", err)
+	}
+
+	if _, err := ParseURL("keybase://alice?envelope=secretbox&allowed_senders=bob"); err == nil {
+		t.Error("ParseURL() accepted envelope with allowed_senders")
+	}
+}
+
+// TestKeeperEnvelopeKey tests sealing many secrets under one wrapped key
+func TestKeeperEnvelopeKey(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice")
+
+	key, err := keeper.NewEnvelopeKey(ctx)
+	if err != nil {
+		t.Fatalf("NewEnvelopeKey() error = %v", err)
+	}
+
+	var wrappedKeys []string
+	for _, secret := range []string{"one", "two"} {
+		ciphertext, err := key.Seal([]byte(secret))
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		envelope, err := crypto.ParseEnvelope(ciphertext)
+		if err != nil {
+			t.Fatalf("ParseEnvelope() error = %v", err)
+		}
+		if envelope.Cipher != crypto.EnvelopeSecretbox {
+			t.Errorf("envelope cipher = %s, want %s", envelope.Cipher, crypto.EnvelopeSecretbox)
+		}
+		wrappedKeys = append(wrappedKeys, envelope.WrappedKey)
+
+		decrypted, err := keeper.Decrypt(ctx, ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt() error = %v", err)
+		}
+		if string(decrypted) != secret {
+			t.Errorf("Decrypt() = %q, want %q", decrypted, secret)
+		}
+	}
+	if wrappedKeys[0] != wrappedKeys[1] {
+		t.Error("envelopes sealed with one EnvelopeKey have different wrapped keys")
+	}
+
+	pgp, _ := newStreamTestKeeper(t, "keybase://alice?format=pgp")
+	if _, err := pgp.NewEnvelopeKey(ctx); pgp.ErrorCode(err) != gcerrors.FailedPrecondition {
+		t.Errorf("NewEnvelopeKey() with format=pgp error = %v, want FailedPrecondition", err)
+	}
+}
+
+// TestKeeperRekeyEnvelope tests that rekeying an envelope only rewraps its key
+func TestKeeperRekeyEnvelope(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice?envelope=aes-gcm")
+	bob := addRekeyTestUser(t, keeper, "bob")
+
+	key, err := keeper.NewEnvelopeKey(ctx)
+	if err != nil {
+		t.Fatalf("NewEnvelopeKey() error = %v", err)
+	}
+	var ciphertexts [][]byte
+	for _, secret := range []string{"one", "two"} {
+		ciphertext, err := key.Seal([]byte(secret))
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+
+	results, err := keeper.RekeyAll(ctx, ciphertexts, []string{"bob"})
+	if err != nil {
+		t.Fatalf("RekeyAll() error = %v", err)
+	}
+
+	var wrappedKeys []string
+	for i, result := range results {
+		if !reflect.DeepEqual(result.Added, []string{"bob"}) || !reflect.DeepEqual(result.Removed, []string{"alice"}) {
+			t.Errorf("results[%d] Added = %v, Removed = %v, want [bob], [alice]", i, result.Added, result.Removed)
+		}
+
+		original, err := crypto.ParseEnvelope(ciphertexts[i])
+		if err != nil {
+			t.Fatalf("ParseEnvelope() error = %v", err)
+		}
+		rekeyed, err := crypto.ParseEnvelope(result.Ciphertext)
+		if err != nil {
+			t.Fatalf("ParseEnvelope() of rekeyed ciphertext error = %v", err)
+		}
+		if !bytes.Equal(rekeyed.Payload, original.Payload) || !bytes.Equal(rekeyed.Nonce, original.Nonce) {
+			t.Errorf("results[%d] payload was re-encrypted", i)
+		}
+		if rekeyed.WrappedKey == original.WrappedKey {
+			t.Errorf("results[%d] wrapped key was not replaced", i)
+		}
+		wrappedKeys = append(wrappedKeys, rekeyed.WrappedKey)
+	}
+	if wrappedKeys[0] != wrappedKeys[1] {
+		t.Error("envelopes sharing a data key no longer share a wrapped key after RekeyAll()")
+	}
+
+	if _, err := keeper.Decrypt(ctx, results[0].Ciphertext); keeper.ErrorCode(err) != gcerrors.PermissionDenied {
+		t.Errorf("Decrypt() by a removed recipient error = %v, want PermissionDenied", err)
+	}
+	keeper.keyring.AddKey(bob.SecretKey)
+	decrypted, err := keeper.Decrypt(ctx, results[1].Ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() by the new recipient error = %v", err)
+	}
+	if string(decrypted) != "two" {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, "two")
+	}
+}
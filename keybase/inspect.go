@@ -0,0 +1,52 @@
+package keybase
+
+import (
+	"context"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// InspectMessage reports who a Saltpack message is encrypted for, or signed
+// by, from its header alone
+//
+// No secret key is needed, so security reviewers can audit which keys each
+// stack secret is readable by. Listed recipient keys and signature senders
+// are mapped back to Keybase users and devices as in DecryptWithInfo, on a
+// best-effort basis. Hidden recipients (see hide_recipients) and the senders
+// of encrypted messages are not revealed by the header.
+//
+// The header is not authenticated: it shows who the message claims to be
+// for, and only decrypting it proves that.
+func (k *Keeper) InspectMessage(ctx context.Context, ciphertext []byte) (*crypto.MessageInspection, error) {
+	if len(ciphertext) == 0 {
+		return nil, &KeeperError{
+			Message: "ciphertext cannot be empty",
+			Code:    gcerrors.InvalidArgument,
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, k.classifyError(err, "inspection aborted", gcerrors.InvalidArgument)
+	}
+
+	if crypto.IsPGPMessage(ciphertext) {
+		return nil, &KeeperError{
+			Message: "message inspection is only available for Saltpack messages",
+			Code:    gcerrors.Unimplemented,
+		}
+	}
+
+	inspection, err := crypto.InspectMessage(ciphertext)
+	if err != nil {
+		return nil, k.classifyError(err, "failed to inspect message", gcerrors.InvalidArgument)
+	}
+
+	owners := []*crypto.KeyOwner{inspection.Sender}
+	for _, recipient := range inspection.Recipients {
+		owners = append(owners, recipient.Key)
+	}
+	k.resolveOwners(ctx, owners)
+
+	return inspection, nil
+}
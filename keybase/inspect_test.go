@@ -0,0 +1,60 @@
+package keybase
+
+import (
+	"context"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// TestKeeperInspectMessage tests auditing the recipients of a message
+// without its secret key
+func TestKeeperInspectMessage(t *testing.T) {
+	ctx := context.Background()
+	keeper, receiver := newStreamTestKeeper(t, "keybase://alice")
+
+	ciphertext, err := keeper.Encrypt(ctx, []byte("prod secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// Inspection needs no secret keys at all
+	keeper.keyring = crypto.NewSimpleKeyring()
+
+	inspection, err := keeper.InspectMessage(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("InspectMessage() error = %v", err)
+	}
+	if inspection.Type != saltpack.MessageTypeEncryption || len(inspection.Recipients) != 1 {
+		t.Fatalf("InspectMessage() = %s with %d recipients, want one encrypted recipient", inspection.Type, len(inspection.Recipients))
+	}
+
+	// The recipient key is resolved to its cached owner
+	recipient := inspection.Recipients[0].Key
+	if recipient == nil || recipient.KID != crypto.EncryptionKID(receiver.PublicKey) {
+		t.Fatalf("Recipients[0].Key = %v, want %s", recipient, crypto.EncryptionKID(receiver.PublicKey))
+	}
+	if recipient.Username != "alice" {
+		t.Errorf("Recipients[0].Key.Username = %q, want %q", recipient.Username, "alice")
+	}
+
+	tests := []struct {
+		name       string
+		ciphertext []byte
+		wantCode   gcerrors.ErrorCode
+	}{
+		{name: "empty", ciphertext: nil, wantCode: gcerrors.InvalidArgument},
+		{name: "garbage", ciphertext: []byte("not a message"), wantCode: gcerrors.InvalidArgument},
+		{name: "pgp", ciphertext: []byte("-----BEGIN PGP MESSAGE-----\n\n-----END PGP MESSAGE-----\n"), wantCode: gcerrors.Unimplemented},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keeper.InspectMessage(ctx, tt.ciphertext)
+			if code := keeper.ErrorCode(err); code != tt.wantCode {
+				t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, tt.wantCode, err)
+			}
+		})
+	}
+}
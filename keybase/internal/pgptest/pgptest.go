@@ -0,0 +1,68 @@
+// Package pgptest provides PGP key helpers for tests of the keybase packages
+package pgptest
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+)
+
+// NewEntity creates a PGP key pair for name and returns it with its armored
+// public key bundle, as Keybase serves it
+func NewEntity(t testing.TB, name string) (*openpgp.Entity, string) {
+	t.Helper()
+
+	entity, err := openpgp.NewEntity(name, "", name+"@keybase.io", nil)
+	if err != nil {
+		t.Fatalf("Failed to generate PGP entity: %v", err)
+	}
+
+	return entity, PublicKeyBundle(t, entity)
+}
+
+// PublicKeyBundle returns the armored public key bundle of entity
+func PublicKeyBundle(t testing.TB, entity *openpgp.Entity) string {
+	t.Helper()
+
+	var bundle bytes.Buffer
+	armorWriter, err := armor.Encode(&bundle, openpgp.PublicKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed to create armor encoder: %v", err)
+	}
+	if err := entity.Serialize(armorWriter); err != nil {
+		t.Fatalf("Failed to serialize PGP public key: %v", err)
+	}
+	if err := armorWriter.Close(); err != nil {
+		t.Fatalf("Failed to close armor encoder: %v", err)
+	}
+
+	return bundle.String()
+}
+
+// ExportSecretKey writes the unprotected armored secret key of entity to a
+// temp file, as `keybase pgp export --secret` would, and returns its path
+func ExportSecretKey(t testing.TB, entity *openpgp.Entity) string {
+	t.Helper()
+
+	var armored bytes.Buffer
+	armorWriter, err := armor.Encode(&armored, openpgp.PrivateKeyType, nil)
+	if err != nil {
+		t.Fatalf("Failed to create armor encoder: %v", err)
+	}
+	if err := entity.SerializePrivate(armorWriter, nil); err != nil {
+		t.Fatalf("Failed to serialize PGP secret key: %v", err)
+	}
+	if err := armorWriter.Close(); err != nil {
+		t.Fatalf("Failed to close armor encoder: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "secret.asc")
+	if err := os.WriteFile(path, armored.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write secret key: %v", err)
+	}
+	return path
+}
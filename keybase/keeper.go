@@ -3,11 +3,19 @@ package keybase
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
 
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgperrors "github.com/ProtonMail/go-crypto/openpgp/errors"
 	"github.com/keybase/saltpack"
 	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
 	"github.com/pulumi/pulumi-keybase-encryption/keybase/cache"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/cli"
 	"github.com/pulumi/pulumi-keybase-encryption/keybase/credentials"
 	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
 	"gocloud.dev/gcerrors"
@@ -21,6 +29,23 @@ type Keeper struct {
 	encryptor   *crypto.Encryptor
 	decryptor   *crypto.Decryptor
 	keyring     *crypto.SimpleKeyring
+	pgpDecryptor *crypto.PGPDecryptor
+	
+	// localSigningKey is the signing key loaded by NewKeeper, wiped on Close
+	localSigningKey saltpack.SigningSecretKey
+	
+	// keyConfig loads the local Keybase key into keyring on the first
+	// decryption; it is nil once the key is loaded, with the cli engine and
+	// after Close. keyMu guards it
+	keyMu     sync.Mutex
+	keyConfig *crypto.SenderKeyConfig
+	
+	// passphrases remembers the passphrase of local key files until the
+	// local key is loaded or the Keeper is closed
+	passphrases *crypto.PassphraseCache
+	
+	// cli runs the keybase client when Config.Engine is cli, and is nil otherwise
+	cli *cli.Client
 }
 
 // KeeperConfig holds configuration for creating a Keeper
@@ -33,6 +58,25 @@ type KeeperConfig struct {
 	
 	// SenderKey is the sender's secret key (optional, can be nil for anonymous sender)
 	SenderKey saltpack.BoxSecretKey
+	
+	// SigningKey is the sender's Ed25519 signing key used when Config.Mode
+	// is signcrypt (optional, the local Keybase user's key is loaded if nil)
+	SigningKey saltpack.SigningSecretKey
+	
+	// PGPPassphrase unlocks the PGP secret key at Config.PGPSecretKeyPath
+	// (optional, falls back to KEYBASE_PGP_PASSPHRASE)
+	PGPPassphrase []byte
+	
+	// KeyPassphrase unlocks passphrase-protected local key files (optional,
+	// falls back to KEYBASE_KEY_PASSPHRASE, KEYBASE_KEY_PASSPHRASE_FD and a
+	// terminal prompt; see crypto.DefaultPassphrase). The local decryption
+	// key is unlocked on the first decryption, not by NewKeeper. NewKeeper
+	// copies it, so the caller may wipe it once NewKeeper returns
+	KeyPassphrase []byte
+	
+	// CLI is the keybase client used when Config.Engine is cli (optional,
+	// the binary found by credentials.DiscoverCredentials is used if nil)
+	CLI *cli.Client
 }
 
 // NewKeeper creates a new Keeper instance
@@ -41,65 +85,177 @@ func NewKeeper(config *KeeperConfig) (*Keeper, error) {
 		return nil, fmt.Errorf("keeper config is required")
 	}
 	
-	if len(config.Config.Recipients) == 0 {
+	if len(config.Config.Recipients) == 0 && len(config.Config.Teams) == 0 {
 		return nil, fmt.Errorf("at least one recipient is required")
 	}
 	
+	if err := validateEngineOptions(config.Config); err != nil {
+		return nil, err
+	}
+	
+	// Load the CA file and client certificate now, rather than failing on
+	// the first key lookup
+	if apiConfig := config.Config.APIConfig; apiConfig != nil {
+		if _, err := api.NewTransport(apiConfig); err != nil {
+			return nil, fmt.Errorf("invalid API network settings: %w", err)
+		}
+	}
+	
+	// The cli engine hands encryption and decryption to the keybase client
+	var cliClient *cli.Client
+	if config.Config.Engine == EngineCLI {
+		cliClient = config.CLI
+		if cliClient == nil {
+			var err error
+			cliClient, err = cli.NewClient("")
+			if err != nil {
+				return nil, fmt.Errorf("engine=%s requires the keybase CLI: %w", EngineCLI, err)
+			}
+		}
+	}
+	
 	// Create cache manager if not provided
 	cacheManager := config.CacheManager
 	if cacheManager == nil {
+		cacheConfig := cache.DefaultCacheConfig()
+		cacheConfig.TTL = config.Config.CacheTTL
+		if config.Config.CachePath != "" {
+			cacheConfig.FilePath = config.Config.CachePath
+		}
+		
+		apiConfig := config.Config.APIConfig
+		if apiConfig == nil {
+			apiConfig = api.DefaultClientConfig()
+		}
+		
+		resolver, err := newKeyResolver(config.Config, apiConfig, cliClient)
+		if err != nil {
+			return nil, err
+		}
+		
 		managerConfig := &cache.ManagerConfig{
-			CacheConfig: &cache.CacheConfig{
-				TTL: config.Config.CacheTTL,
-			},
-			APIConfig: api.DefaultClientConfig(),
+			CacheConfig: cacheConfig,
+			APIConfig:   apiConfig,
+			Resolver:    resolver,
 		}
 		
-		var err error
 		cacheManager, err = cache.NewManager(managerConfig)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create cache manager: %w", err)
 		}
 	}
 	
+	// Local key files share one passphrase source, so that a prompt is
+	// answered only once. The Keeper owns the remembered passphrase and
+	// wipes it
+	passphrases := crypto.NewDefaultPassphraseCache()
+	if len(config.KeyPassphrase) > 0 {
+		passphrases = crypto.NewPassphraseCache(crypto.StaticPassphrase(bytes.Clone(config.KeyPassphrase)))
+	}
+	keyConfig := &crypto.SenderKeyConfig{Passphrase: passphrases.Passphrase}
+	
+	// Signcryption needs a signing key; without one every message would be
+	// anonymous, defeating the point of the mode
+	signingKey := config.SigningKey
+	var localSigningKey saltpack.SigningSecretKey
+	if config.Config.Mode == ModeSigncrypt && signingKey == nil {
+		localKey, err := crypto.LoadSigningKey(keyConfig)
+		if err != nil {
+			return nil, fmt.Errorf("mode=%s requires a signing key: %w", ModeSigncrypt, err)
+		}
+		signingKey = localKey.SecretKey
+		localSigningKey = localKey.SecretKey
+	}
+	
+	// Resolve the Saltpack versions to write and to accept
+	var version *saltpack.Version
+	if config.Config.SaltpackVersion != 0 {
+		v, err := crypto.ParseVersion(config.Config.SaltpackVersion)
+		if err != nil {
+			return nil, fmt.Errorf("invalid saltpack_version: %w", err)
+		}
+		version = &v
+	}
+	
+	var allowedVersions []saltpack.Version
+	for _, major := range config.Config.AllowedVersions {
+		v, err := crypto.ParseVersion(major)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed_versions: %w", err)
+		}
+		allowedVersions = append(allowedVersions, v)
+	}
+	
 	// Create encryptor
 	encryptor, err := crypto.NewEncryptor(&crypto.EncryptorConfig{
-		SenderKey: config.SenderKey,
+		SenderKey:      config.SenderKey,
+		SigningKey:     signingKey,
+		Version:        version,
+		HideRecipients: config.Config.HideRecipients,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create encryptor: %w", err)
 	}
 	
 	// Create keyring for decryption
+	// The local user's secret key is loaded on the first decryption (see
+	// loadLocalKey), so that encrypting never asks for a passphrase. The cli
+	// engine decrypts with the service's keys and needs no key file
 	keyring := crypto.NewSimpleKeyring()
-	
-	// Try to load local user's secret key for decryption
-	// This is optional - if it fails, decryption won't work but encryption will
-	if err := loadLocalSecretKey(keyring); err != nil {
-		// Don't fail here - encryption can still work without local key
-		// Decryption will fail with a clear error if attempted
+	localKeyConfig := keyConfig
+	if cliClient != nil {
+		localKeyConfig = nil
 	}
 	
 	// Create decryptor
 	decryptor, err := crypto.NewDecryptor(&crypto.DecryptorConfig{
-		Keyring: keyring,
+		Keyring:         keyring,
+		AllowedVersions: allowedVersions,
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create decryptor: %w", err)
 	}
 	
+	// Load the PGP secret key for decrypting PGP messages, if configured
+	// Unlike the local saltpack key, an explicitly configured key must load
+	var pgpDecryptor *crypto.PGPDecryptor
+	if config.Config.PGPSecretKeyPath != "" {
+		passphrase := config.PGPPassphrase
+		if len(passphrase) == 0 {
+			passphrase = []byte(os.Getenv(EnvPGPPassphrase))
+			defer clear(passphrase)
+		}
+		
+		pgpKeyring, err := crypto.LoadPGPSecretKey(config.Config.PGPSecretKeyPath, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load PGP secret key: %w", err)
+		}
+		
+		pgpDecryptor, err = crypto.NewPGPDecryptor(pgpKeyring)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create PGP decryptor: %w", err)
+		}
+	}
+	
 	return &Keeper{
 		config:      config.Config,
 		cacheManager: cacheManager,
 		encryptor:   encryptor,
 		decryptor:   decryptor,
 		keyring:     keyring,
+		pgpDecryptor: pgpDecryptor,
+		localSigningKey: localSigningKey,
+		keyConfig:   localKeyConfig,
+		passphrases: passphrases,
+		cli:         cliClient,
 	}, nil
 }
 
 // NewKeeperFromURL creates a new Keeper from a Keybase URL
+//
+// KEYBASE_* environment variables override values from the URL; see LoadConfig.
 func NewKeeperFromURL(url string) (*Keeper, error) {
-	config, err := ParseURL(url)
+	config, _, err := LoadConfig(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse URL: %w", err)
 	}
@@ -109,18 +265,68 @@ func NewKeeperFromURL(url string) (*Keeper, error) {
 	})
 }
 
+// newKeyResolver builds the key sources configured by key_directory,
+// key_mirror and the cli engine, or returns nil to use keybase.io alone
+//
+// The key directory is consulted first, then `keybase id` when cliClient is
+// set; remaining users are looked up with the mirror if one is set, and with
+// keybase.io otherwise. Teams come from the directory, then `keybase team
+// list-members`, and from the API only with trust_server_teams; proofs and
+// devices come from the API.
+func newKeyResolver(config *Config, apiConfig *api.ClientConfig, cliClient *cli.Client) (cache.KeyResolver, error) {
+	if config.KeyDirectory == "" && config.KeyMirror == "" && cliClient == nil {
+		return nil, nil
+	}
+	
+	var resolvers []cache.KeyResolver
+	if config.KeyDirectory != "" {
+		directory, err := cache.NewDirectoryResolver(config.KeyDirectory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key_directory: %w", err)
+		}
+		resolvers = append(resolvers, directory)
+	}
+	
+	if cliClient != nil {
+		resolvers = append(resolvers, cliClient)
+	}
+	
+	if config.KeyMirror != "" {
+		mirror, err := cache.NewMirrorResolver(config.KeyMirror, apiConfig)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key_mirror: %w", err)
+		}
+		resolvers = append(resolvers, mirror)
+	} else {
+		resolvers = append(resolvers, api.NewClient(apiConfig))
+	}
+	
+	return cache.NewChainResolver(resolvers...), nil
+}
+
 // Encrypt encrypts plaintext for all configured recipients
 // 
 // This method:
-// 1. Fetches public keys for all recipients via API/cache
-// 2. Converts PGP keys to Saltpack BoxPublicKey format
+// 1. Expands team recipients into their members and fetches public keys for
+//    all recipients via API/cache
+// 2. Resolves each recipient's active per-device NaCl encryption keys
 // 3. Uses streaming encryption for large messages (>10 MiB)
 // 4. Uses in-memory encryption for smaller messages
 // 5. Returns the encrypted ciphertext
 //
-// For messages larger than 10 MiB, streaming encryption is used to avoid
-// loading the entire ciphertext into memory, improving performance and
-// reducing memory usage.
+// With mode=signcrypt, steps 3-4 use Saltpack signcryption instead, signing
+// the message with the configured Ed25519 signing key.
+//
+// When VerifyProofs is set, recipients' identity proofs are checked against
+// the configured ProofPolicy before any key is fetched, and encryption is
+// refused with FailedPrecondition if any recipient does not comply.
+//
+// With the pgp format, steps 2-4 are replaced by OpenPGP encryption to each
+// recipient's PGP key bundle, producing an ASCII-armored PGP message. With
+// engine=cli they are replaced by `keybase encrypt` to the recipients.
+//
+// Both plaintext and ciphertext are held in memory. Use NewEncryptWriter to
+// encrypt data too large for that.
 func (k *Keeper) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error) {
 	if len(plaintext) == 0 {
 		return nil, &KeeperError{
@@ -129,77 +335,90 @@ func (k *Keeper) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
 		}
 	}
 	
+	if err := ctx.Err(); err != nil {
+		return nil, k.classifyError(err, "encryption aborted", gcerrors.Internal)
+	}
+	
 	// Step 1: Fetch public keys for all recipients
-	userPublicKeys, err := k.cacheManager.GetPublicKeys(ctx, k.config.Recipients)
+	userPublicKeys, err := k.recipientKeys(ctx)
 	if err != nil {
-		// Classify API errors
-		if apiErr, ok := err.(*api.APIError); ok {
-			return nil, k.classifyAPIError(apiErr)
-		}
-		return nil, &KeeperError{
-			Message: fmt.Sprintf("failed to fetch recipient public keys: %v", err),
-			Code:    gcerrors.Internal,
-			Underlying: err,
-		}
+		return nil, err
 	}
 	
-	if len(userPublicKeys) != len(k.config.Recipients) {
-		return nil, &KeeperError{
-			Message: fmt.Sprintf("expected %d public keys, got %d", len(k.config.Recipients), len(userPublicKeys)),
-			Code:    gcerrors.Internal,
-		}
+	if k.cli != nil {
+		return k.encryptCLI(ctx, plaintext, userPublicKeys)
 	}
 	
-	// Step 2: Convert PGP keys to Saltpack BoxPublicKey format
-	receivers := make([]saltpack.BoxPublicKey, 0, len(userPublicKeys))
+	return k.encryptTo(plaintext, userPublicKeys)
+}
+
+// encryptCLI encrypts plaintext for the recipients with `keybase encrypt`
+func (k *Keeper) encryptCLI(ctx context.Context, plaintext []byte, userPublicKeys []api.UserPublicKey) ([]byte, error) {
+	recipients := make([]string, 0, len(userPublicKeys))
+	for _, key := range userPublicKeys {
+		recipients = append(recipients, key.Username)
+	}
 	
-	for _, userKey := range userPublicKeys {
-		// Parse the Keybase public key
-		// Note: Keybase API returns PGP keys, but for now we'll use a workaround
-		// In production, you would extract the Curve25519 subkey from the PGP key
-		publicKey, err := crypto.ParseKeybasePublicKey(userKey.PublicKey)
+	ciphertext, err := k.cli.Encrypt(ctx, plaintext, recipients)
+	if err != nil {
+		return nil, k.classifyError(err, "keybase encrypt failed", gcerrors.Internal)
+	}
+	
+	return ciphertext, nil
+}
+
+// decryptCLI decrypts a Saltpack message or envelope with `keybase decrypt`
+func (k *Keeper) decryptCLI(ctx context.Context, ciphertext []byte) ([]byte, error) {
+	if !crypto.IsEnvelope(ciphertext) {
+		plaintext, err := k.cli.Decrypt(ctx, ciphertext)
 		if err != nil {
-			// For now, try to parse the KeyID directly as it might be a Curve25519 key
-			// This is a workaround - in production you'd properly parse the PGP key bundle
-			keyID, parseErr := crypto.ParseKeybaseKeyID(userKey.KeyID)
-			if parseErr != nil {
-				return nil, &KeeperError{
-					Message: fmt.Sprintf("failed to parse public key for user %s: %v (key ID parse error: %v)", 
-						userKey.Username, err, parseErr),
-					Code: gcerrors.InvalidArgument,
-					Underlying: err,
-				}
-			}
-			
-			// Try to create a public key from the key ID
-			if len(keyID) >= 32 {
-				publicKey, err = crypto.CreatePublicKey(keyID[len(keyID)-32:])
-				if err != nil {
-					return nil, &KeeperError{
-						Message: fmt.Sprintf("failed to create public key for user %s: %v", userKey.Username, err),
-						Code: gcerrors.InvalidArgument,
-						Underlying: err,
-					}
-				}
-			} else {
-				return nil, &KeeperError{
-					Message: fmt.Sprintf("key ID too short for user %s: expected at least 32 bytes, got %d", 
-						userKey.Username, len(keyID)),
-					Code: gcerrors.InvalidArgument,
-				}
-			}
+			return nil, k.classifyError(err, "keybase decrypt failed", gcerrors.Internal)
 		}
-		
-		// Validate the public key
-		if err := crypto.ValidatePublicKey(publicKey); err != nil {
-			return nil, &KeeperError{
-				Message: fmt.Sprintf("invalid public key for user %s: %v", userKey.Username, err),
-				Code: gcerrors.InvalidArgument,
-				Underlying: err,
-			}
-		}
-		
-		receivers = append(receivers, publicKey)
+		return plaintext, nil
+	}
+	
+	envelope, err := k.parseEnvelope(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	
+	dataKey, err := k.cli.Decrypt(ctx, []byte(envelope.WrappedKey))
+	if err != nil {
+		return nil, k.classifyError(err, "failed to unwrap envelope data key with keybase decrypt", gcerrors.Internal)
+	}
+	defer clear(dataKey)
+	
+	return k.openEnvelope(envelope, dataKey)
+}
+
+// requireNativeEngine refuses an operation that the cli engine cannot perform
+func (k *Keeper) requireNativeEngine(operation string) error {
+	if k.cli == nil {
+		return nil
+	}
+	return &KeeperError{
+		Message: fmt.Sprintf("%s is not supported with engine=%s", operation, EngineCLI),
+		Code:    gcerrors.Unimplemented,
+	}
+}
+
+// encryptTo encrypts plaintext to every key of the given recipients in the
+// configured format and mode
+func (k *Keeper) encryptTo(plaintext []byte, userPublicKeys []api.UserPublicKey) ([]byte, error) {
+	// PGP format encrypts straight to the recipients' PGP key bundles
+	if k.config.Format == FormatPGP {
+		return k.encryptPGP(plaintext, userPublicKeys)
+	}
+	
+	// Step 2: Resolve every active device encryption key of each recipient
+	receivers, err := saltpackReceivers(userPublicKeys)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Envelope mode encrypts only a data key using Saltpack
+	if k.config.Envelope != "" {
+		return k.encryptEnvelope(plaintext, receivers)
 	}
 	
 	// Step 3: Encrypt using Saltpack
@@ -212,25 +431,42 @@ func (k *Keeper) Encrypt(ctx context.Context, plaintext []byte) ([]byte, error)
 	}
 	
 	// Use in-memory encryption for smaller messages
+	return k.encryptArmored(plaintext, receivers)
+}
+
+// encryptArmored encrypts or signcrypts plaintext in memory, as configured,
+// to an ASCII-armored Saltpack message
+func (k *Keeper) encryptArmored(plaintext []byte, receivers []saltpack.BoxPublicKey) ([]byte, error) {
 	// Use ASCII-armored output for better compatibility with Pulumi state files
-	ciphertext, err := k.encryptor.EncryptArmored(plaintext, receivers)
+	var ciphertext string
+	var err error
+	if k.config.Mode == ModeSigncrypt {
+		ciphertext, err = k.encryptor.SigncryptArmored(plaintext, receivers)
+	} else {
+		ciphertext, err = k.encryptor.EncryptArmored(plaintext, receivers)
+	}
 	if err != nil {
-		return nil, &KeeperError{
-			Message: fmt.Sprintf("encryption failed: %v", err),
-			Code: gcerrors.Internal,
-			Underlying: err,
-		}
+		return nil, k.classifyError(err, "encryption failed", gcerrors.Internal)
 	}
 	
-	// Step 4: Return as bytes
 	return []byte(ciphertext), nil
 }
 
 // Decrypt decrypts ciphertext using the local Keybase keyring
 //
 // This method automatically detects the message size and uses streaming
-// decryption for large messages (>10 MiB) to avoid loading the entire
-// plaintext into memory.
+// decryption for large messages (>10 MiB), but both ciphertext and
+// plaintext are still held in memory. Use NewDecryptReader to decrypt data
+// too large for that.
+//
+// OpenPGP messages (armored or binary) are detected and decrypted with the
+// PGP secret key configured via Config.PGPSecretKeyPath. Signcrypted Saltpack
+// messages are accepted regardless of the configured mode. With engine=cli,
+// Saltpack messages are decrypted by `keybase decrypt` with the keys held by
+// the Keybase service.
+//
+// When a SenderPolicy is configured, messages from anonymous or disallowed
+// senders are refused with PermissionDenied and no plaintext is returned.
 func (k *Keeper) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error) {
 	if len(ciphertext) == 0 {
 		return nil, &KeeperError{
@@ -239,33 +475,76 @@ func (k *Keeper) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
 		}
 	}
 	
+	if err := ctx.Err(); err != nil {
+		return nil, k.classifyError(err, "decryption aborted", gcerrors.InvalidArgument)
+	}
+	
+	if crypto.IsPGPMessage(ciphertext) {
+		return k.decryptPGP(ciphertext)
+	}
+	
+	if k.cli != nil {
+		return k.decryptCLI(ctx, ciphertext)
+	}
+	
+	decryptor, allowedSenders, err := k.senderDecryptor(ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	return k.decryptWith(decryptor, allowedSenders, ciphertext)
+}
+
+// DecryptSecure decrypts ciphertext like Decrypt, but returns the plaintext
+// in a crypto.SecureBuffer that the caller wipes with Zero once done
+//
+// The plaintext is moved into the locked buffer as soon as it is decrypted
+// and the ordinary copy is wiped. Buffers used inside Saltpack or OpenPGP
+// while decrypting are out of reach and are left to the garbage collector.
+func (k *Keeper) DecryptSecure(ctx context.Context, ciphertext []byte) (*crypto.SecureBuffer, error) {
+	plaintext, err := k.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	
+	return crypto.NewSecureBufferFrom(plaintext), nil
+}
+
+// decryptWith decrypts a Saltpack or PGP message with a decryptor and the
+// allowed senders returned by senderDecryptor
+func (k *Keeper) decryptWith(decryptor *crypto.Decryptor, allowedSenders []api.UserPublicKey, ciphertext []byte) ([]byte, error) {
+	if crypto.IsEnvelope(ciphertext) {
+		return k.decryptEnvelope(decryptor, ciphertext)
+	}
+	
+	if crypto.IsPGPMessage(ciphertext) {
+		return k.decryptPGP(ciphertext)
+	}
+	
 	// Use streaming for large ciphertexts (>10 MiB)
 	const streamingThreshold = 10 * 1024 * 1024 // 10 MiB
 	
+	var message *decryptedMessage
+	var err error
 	if len(ciphertext) > streamingThreshold {
 		// Use streaming decryption for large messages
-		return k.decryptStreaming(ciphertext)
+		message, err = k.decryptStreaming(decryptor, ciphertext)
+	} else {
+		// Use in-memory decryption for smaller messages
+		message, err = k.decryptSaltpack(decryptor, ciphertext)
 	}
-	
-	// Use in-memory decryption for smaller messages
-	// Try to decrypt as ASCII-armored first
-	plaintext, _, err := k.decryptor.DecryptArmored(string(ciphertext))
 	if err != nil {
-		// If armored decryption fails, try binary decryption
-		plaintext, _, err = k.decryptor.Decrypt(ciphertext)
-		if err != nil {
-			return nil, &KeeperError{
-				Message: fmt.Sprintf("decryption failed: %v", err),
-				Code: gcerrors.InvalidArgument,
-				Underlying: err,
-			}
-		}
+		return nil, err
 	}
 	
-	return plaintext, nil
+	// Refuse messages written by senders the policy does not accept
+	if err := k.checkSender(message, allowedSenders); err != nil {
+		return nil, err
+	}
+	
+	return message.plaintext, nil
 }
 
-<<<<<<< HEAD
 // DecryptWithInfo decrypts ciphertext and returns message header information
 // 
 // This method:
@@ -274,6 +553,15 @@ func (k *Keeper) Decrypt(ctx context.Context, ciphertext []byte) ([]byte, error)
 // 3. Extracts MessageKeyInfo to determine which recipient key was used
 // 4. Returns plaintext along with message metadata
 //
+// The receiver and sender keys are mapped back to Keybase users and devices
+// (MessageInfo.Receiver and MessageInfo.Sender) through the key cache and
+// the API, and ReceiverIndex is read from the message header. Resolution is
+// best-effort: a key whose owner cannot be found keeps only its KID.
+//
+// For signcrypted messages the MessageInfo reports the verified signer
+// (see crypto.SigncryptMessageInfo) instead of the receiver key. The
+// configured SenderPolicy is enforced as in Decrypt.
+//
 // Returns:
 //   - Plaintext bytes
 //   - MessageInfo with sender/receiver details
@@ -286,83 +574,622 @@ func (k *Keeper) DecryptWithInfo(ctx context.Context, ciphertext []byte) ([]byte
 		}
 	}
 	
-	var plaintext []byte
-	var messageKeyInfo *saltpack.MessageKeyInfo
-	var err error
+	if err := ctx.Err(); err != nil {
+		return nil, nil, k.classifyError(err, "decryption aborted", gcerrors.InvalidArgument)
+	}
 	
-	// Try to decrypt as ASCII-armored first
-	plaintext, messageKeyInfo, err = k.decryptor.DecryptArmored(string(ciphertext))
+	// PGP messages carry no Saltpack header to report
+	if crypto.IsPGPMessage(ciphertext) {
+		return nil, nil, &KeeperError{
+			Message: "message info is only available for Saltpack messages; use Decrypt for PGP messages",
+			Code:    gcerrors.Unimplemented,
+		}
+	}
+	
+	if err := k.requireNativeEngine("DecryptWithInfo"); err != nil {
+		return nil, nil, err
+	}
+	
+	decryptor, allowedSenders, err := k.senderDecryptor(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	// An envelope is reported on through its wrapped key
+	var envelope *crypto.Envelope
+	if crypto.IsEnvelope(ciphertext) {
+		if err := k.checkEnvelopeDecryption(); err != nil {
+			return nil, nil, err
+		}
+		envelope, err = k.parseEnvelope(ciphertext)
+		if err != nil {
+			return nil, nil, err
+		}
+		ciphertext = []byte(envelope.WrappedKey)
+	}
+	
+	message, err := k.decryptSaltpack(decryptor, ciphertext)
 	if err != nil {
-		// If armored decryption fails, try binary decryption
-		plaintext, messageKeyInfo, err = k.decryptor.Decrypt(ciphertext)
+		return nil, nil, err
+	}
+	
+	if err := k.checkSender(message, allowedSenders); err != nil {
+		return nil, nil, err
+	}
+	
+	if envelope != nil {
+		dataKey := message.plaintext
+		message.plaintext, err = k.openEnvelope(envelope, dataKey)
+		clear(dataKey)
 		if err != nil {
-			return nil, nil, &KeeperError{
-				Message: fmt.Sprintf("decryption failed: %v", err),
-=======
-// encryptStreaming encrypts large plaintext using streaming to avoid memory issues
-func (k *Keeper) encryptStreaming(plaintext []byte, receivers []saltpack.BoxPublicKey) ([]byte, error) {
-	// Create readers and writers for streaming
-	plaintextReader := bytes.NewReader(plaintext)
-	var ciphertextBuf bytes.Buffer
+			return nil, nil, err
+		}
+	}
 	
-	// Use streaming encryption with ASCII armoring
-	err := k.encryptor.EncryptStreamArmored(plaintextReader, &ciphertextBuf, receivers)
+	// Signcrypted messages report their verified signer
+	if message.signcrypted {
+		messageInfo := crypto.SigncryptMessageInfo(message.signer)
+		k.resolveKeyOwners(ctx, messageInfo)
+		return message.plaintext, messageInfo, nil
+	}
+	
+	// Parse the message key info and header to extract message information
+	messageInfo, err := crypto.ParseMessageInfo(message.keyInfo, ciphertext)
 	if err != nil {
-		return nil, &KeeperError{
-			Message: fmt.Sprintf("streaming encryption failed: %v", err),
+		// If we can't parse the info, we still succeeded in decryption
+		// so return plaintext with a warning in the error
+		return message.plaintext, nil, &KeeperError{
+			Message: fmt.Sprintf("decryption succeeded but failed to parse message info: %v", err),
 			Code: gcerrors.Internal,
 			Underlying: err,
 		}
 	}
 	
-	return ciphertextBuf.Bytes(), nil
+	k.resolveKeyOwners(ctx, messageInfo)
+	
+	return message.plaintext, messageInfo, nil
 }
 
-// decryptStreaming decrypts large ciphertext using streaming to avoid memory issues
-func (k *Keeper) decryptStreaming(ciphertext []byte) ([]byte, error) {
-	// Create readers and writers for streaming
-	ciphertextReader := bytes.NewReader(ciphertext)
-	var plaintextBuf bytes.Buffer
+// recipientKeys expands team recipients into their members, enforces the
+// proof policy when VerifyProofs is set, and fetches every recipient's
+// public keys via API/cache
+func (k *Keeper) recipientKeys(ctx context.Context) ([]api.UserPublicKey, error) {
+	return k.publicKeysFor(ctx, k.config.Recipients, k.config.Teams)
+}
+
+// publicKeysFor is recipientKeys for the given usernames and teams rather
+// than the configured ones
+func (k *Keeper) publicKeysFor(ctx context.Context, usernames, teams []string) ([]api.UserPublicKey, error) {
+	// Expand team recipients into their current members
+	recipients, err := k.resolveRecipients(ctx, usernames, teams)
+	if err != nil {
+		return nil, err
+	}
 	
-	// Try armored streaming decryption first
-	_, err := k.decryptor.DecryptStreamArmored(ciphertextReader, &plaintextBuf)
+	// Refuse recipients whose identity proofs do not satisfy the policy
+	if k.config.VerifyProofs {
+		if err := k.verifyProofs(ctx, recipients); err != nil {
+			return nil, err
+		}
+	}
+	
+	userPublicKeys, err := k.cacheManager.GetPublicKeys(ctx, recipients)
 	if err != nil {
-		// If armored decryption fails, try binary streaming decryption
-		ciphertextReader.Reset(ciphertext)
-		plaintextBuf.Reset()
+		// API errors are usually wrapped by the cache manager, so classify
+		// the whole chain rather than the top-level error
+		return nil, k.classifyError(err, "failed to fetch recipient public keys", gcerrors.Internal)
+	}
+	
+	if len(userPublicKeys) != len(recipients) {
+		return nil, &KeeperError{
+			Message: fmt.Sprintf("expected %d public keys, got %d", len(recipients), len(userPublicKeys)),
+			Code:    gcerrors.Internal,
+		}
+	}
+	
+	return userPublicKeys, nil
+}
+
+// saltpackReceivers resolves the device encryption keys of all recipients
+func saltpackReceivers(userPublicKeys []api.UserPublicKey) ([]saltpack.BoxPublicKey, error) {
+	receivers := make([]saltpack.BoxPublicKey, 0, len(userPublicKeys))
+	
+	for i := range userPublicKeys {
+		userReceivers, err := resolveEncryptionKeys(&userPublicKeys[i])
+		if err != nil {
+			return nil, err
+		}
+		receivers = append(receivers, userReceivers...)
+	}
+	
+	return receivers, nil
+}
+
+// resolveEncryptionKeys returns the Saltpack public keys of all of a user's
+// active NaCl DH (0121) device encryption keys
+//
+// Keybase gives every device its own encryption subkey, so encrypting to all
+// of them lets the user decrypt on any device, as `keybase encrypt` does.
+// A raw hex Curve25519 key in PublicKey is accepted for users without KIDs.
+func resolveEncryptionKeys(userKey *api.UserPublicKey) ([]saltpack.BoxPublicKey, error) {
+	kids := userKey.EncryptionKIDs()
+	
+	if len(kids) == 0 {
+		publicKey, err := crypto.ParseKeybasePublicKey(userKey.PublicKey)
+		if err != nil {
+			return nil, &KeeperError{
+				Message:    fmt.Sprintf("user %s has no NaCl encryption keys (use format=pgp for PGP-only users)", userKey.Username),
+				Code:       gcerrors.InvalidArgument,
+				Underlying: err,
+			}
+		}
+		return []saltpack.BoxPublicKey{publicKey}, nil
+	}
+	
+	receivers := make([]saltpack.BoxPublicKey, 0, len(kids))
+	for _, kid := range kids {
+		publicKey, err := crypto.ParseEncryptionKID(kid)
+		if err != nil {
+			return nil, &KeeperError{
+				Message:    fmt.Sprintf("invalid encryption key %s for user %s", kid, userKey.Username),
+				Code:       gcerrors.InvalidArgument,
+				Underlying: err,
+			}
+		}
 		
-		_, err = k.decryptor.DecryptStream(ciphertextReader, &plaintextBuf)
+		// Validate the public key
+		if err := crypto.ValidatePublicKey(publicKey); err != nil {
+			return nil, &KeeperError{
+				Message:    fmt.Sprintf("invalid public key for user %s: %v", userKey.Username, err),
+				Code:       gcerrors.InvalidArgument,
+				Underlying: err,
+			}
+		}
+		
+		receivers = append(receivers, publicKey)
+	}
+	
+	return receivers, nil
+}
+
+// resolveRecipients returns usernames followed by the members of every
+// team, without duplicates
+//
+// A team that has no members with the configured role is an error, so that
+// a typo or an emptied team never silently drops recipients.
+func (k *Keeper) resolveRecipients(ctx context.Context, usernames, teams []string) ([]string, error) {
+	if len(teams) == 0 {
+		return usernames, nil
+	}
+	
+	seen := make(map[string]bool)
+	var recipients []string
+	add := func(username string) {
+		if key := strings.ToLower(username); !seen[key] {
+			seen[key] = true
+			recipients = append(recipients, username)
+		}
+	}
+	
+	for _, username := range usernames {
+		add(username)
+	}
+	
+	for _, team := range teams {
+		members, err := k.cacheManager.GetTeamMembers(ctx, team, k.config.TeamRole)
+		if err != nil {
+			return nil, k.classifyError(err, fmt.Sprintf("failed to resolve members of team %s", team), gcerrors.Internal)
+		}
+		if len(members) == 0 {
+			return nil, &KeeperError{
+				Message: fmt.Sprintf("team %s has no members with role %s or above", team, k.teamRoleName()),
+				Code:    gcerrors.FailedPrecondition,
+			}
+		}
+		for _, username := range members {
+			add(username)
+		}
+	}
+	
+	return recipients, nil
+}
+
+// teamRoleName describes the configured minimum team role for messages
+func (k *Keeper) teamRoleName() string {
+	if k.config.TeamRole == "" {
+		return string(api.TeamRoleReader)
+	}
+	return string(k.config.TeamRole)
+}
+
+// verifyProofs checks every recipient against the configured proof policy
+func (k *Keeper) verifyProofs(ctx context.Context, recipients []string) error {
+	proofs, err := k.cacheManager.GetProofs(ctx, recipients)
+	if err != nil {
+		return k.classifyError(err, "failed to verify recipient identity proofs", gcerrors.Internal)
+	}
+
+	if err := k.config.ProofPolicy.Check(recipients, proofs); err != nil {
+		return &KeeperError{
+			Message:    "refusing to encrypt",
+			Code:       gcerrors.FailedPrecondition,
+			Underlying: err,
+		}
+	}
+
+	return nil
+}
+
+// encryptPGP encrypts plaintext to the PGP key bundles of all recipients
+func (k *Keeper) encryptPGP(plaintext []byte, userPublicKeys []api.UserPublicKey) ([]byte, error) {
+	recipients, err := pgpRecipients(userPublicKeys)
+	if err != nil {
+		return nil, err
+	}
+	
+	ciphertext, err := crypto.NewPGPEncryptor(nil).EncryptArmored(plaintext, recipients)
+	if err != nil {
+		return nil, k.classifyError(err, "PGP encryption failed", gcerrors.Internal)
+	}
+	
+	return []byte(ciphertext), nil
+}
+
+// pgpRecipients parses the PGP key bundles of all recipients
+func pgpRecipients(userPublicKeys []api.UserPublicKey) ([]*openpgp.Entity, error) {
+	recipients := make([]*openpgp.Entity, 0, len(userPublicKeys))
+	
+	for _, userKey := range userPublicKeys {
+		entity, err := crypto.ParsePGPPublicKeyBundle(userKey.PublicKey)
 		if err != nil {
 			return nil, &KeeperError{
-				Message: fmt.Sprintf("streaming decryption failed: %v", err),
->>>>>>> origin/main
-				Code: gcerrors.InvalidArgument,
+				Message:    fmt.Sprintf("failed to parse PGP public key for user %s", userKey.Username),
+				Code:       gcerrors.InvalidArgument,
 				Underlying: err,
 			}
 		}
+		recipients = append(recipients, entity)
+	}
+	
+	return recipients, nil
+}
+
+// decryptPGP decrypts an OpenPGP message with the configured PGP secret key
+//
+// PGP messages written by this package are not signed, so their sender is
+// anonymous to a SenderPolicy and they are refused when one is configured.
+func (k *Keeper) decryptPGP(ciphertext []byte) ([]byte, error) {
+	if err := k.checkPGPDecryption(); err != nil {
+		return nil, err
 	}
 	
-<<<<<<< HEAD
-	// Parse the message key info to extract header information
-	messageInfo, err := crypto.ParseMessageKeyInfo(messageKeyInfo)
+	plaintext, err := k.pgpDecryptor.Decrypt(ciphertext)
 	if err != nil {
-		// If we can't parse the info, we still succeeded in decryption
-		// so return plaintext with a warning in the error
-		return plaintext, nil, &KeeperError{
-			Message: fmt.Sprintf("decryption succeeded but failed to parse message info: %v", err),
-			Code: gcerrors.Internal,
+		return nil, k.classifyError(err, "PGP decryption failed", gcerrors.InvalidArgument)
+	}
+	
+	return plaintext, nil
+}
+
+// checkPGPDecryption reports why PGP messages cannot be decrypted, if so
+func (k *Keeper) checkPGPDecryption() error {
+	if k.config.SenderPolicy.Enabled() {
+		return &KeeperError{
+			Message:    "refusing to decrypt PGP message",
+			Code:       gcerrors.PermissionDenied,
+			Underlying: &SenderPolicyError{Reason: "PGP messages do not identify their sender"},
+		}
+	}
+	
+	if k.pgpDecryptor == nil {
+		return &KeeperError{
+			Message: fmt.Sprintf("cannot decrypt PGP message: no PGP secret key configured (set the pgp_secret_key URL parameter or %s)", EnvPGPSecretKey),
+			Code:    gcerrors.FailedPrecondition,
+		}
+	}
+	
+	return nil
+}
+
+// encryptStreaming encrypts large plaintext using streaming to avoid memory issues
+func (k *Keeper) encryptStreaming(plaintext []byte, receivers []saltpack.BoxPublicKey) ([]byte, error) {
+	var ciphertextBuf bytes.Buffer
+	
+	// Use streaming encryption with ASCII armoring
+	stream, err := k.openEncryptStream(&ciphertextBuf, receivers)
+	if err != nil {
+		return nil, k.classifyError(err, "streaming encryption failed", gcerrors.Internal)
+	}
+	
+	if _, err := stream.Write(plaintext); err != nil {
+		return nil, k.classifyError(err, "streaming encryption failed", gcerrors.Internal)
+	}
+	
+	if err := stream.Close(); err != nil {
+		return nil, k.classifyError(err, "streaming encryption failed", gcerrors.Internal)
+	}
+	
+	return ciphertextBuf.Bytes(), nil
+}
+
+// openEncryptStream starts an ASCII-armored Saltpack message to receivers,
+// signcrypted under mode=signcrypt
+func (k *Keeper) openEncryptStream(ciphertext io.Writer, receivers []saltpack.BoxPublicKey) (io.WriteCloser, error) {
+	if k.config.Mode == ModeSigncrypt {
+		return k.encryptor.NewSigncryptStreamArmored(ciphertext, receivers)
+	}
+	return k.encryptor.NewEncryptStreamArmored(ciphertext, receivers)
+}
+
+// decryptedMessage is a decrypted Saltpack message and what is known about
+// the key that wrote it
+type decryptedMessage struct {
+	plaintext []byte
+	
+	// keyInfo describes the keys of an encrypted message (nil if signcrypted)
+	keyInfo *saltpack.MessageKeyInfo
+	
+	// signer is the verified signer of a signcrypted message (nil if anonymous)
+	signer saltpack.SigningPublicKey
+	
+	signcrypted bool
+}
+
+// senderKID returns the Keybase KID of the key that wrote the message: the
+// sender's 0121 encryption key, or its 0120 signing key if signcrypted
+// Returns "" for an anonymous sender
+func (m *decryptedMessage) senderKID() string {
+	if m.signcrypted {
+		if m.signer == nil {
+			return ""
+		}
+		return crypto.SigningKID(m.signer)
+	}
+	
+	if m.keyInfo == nil || m.keyInfo.SenderIsAnon || m.keyInfo.SenderKey == nil {
+		return ""
+	}
+	return crypto.EncryptionKID(m.keyInfo.SenderKey)
+}
+
+// decryptSaltpack decrypts an encrypted or signcrypted Saltpack message in
+// memory, trying ASCII-armored before binary
+func (k *Keeper) decryptSaltpack(decryptor *crypto.Decryptor, ciphertext []byte) (*decryptedMessage, error) {
+	// Try to decrypt as ASCII-armored first
+	plaintext, keyInfo, armoredErr := decryptor.DecryptArmored(string(ciphertext))
+	if armoredErr == nil {
+		return &decryptedMessage{plaintext: plaintext, keyInfo: keyInfo}, nil
+	}
+	
+	// If armored decryption fails, try binary decryption
+	plaintext, keyInfo, err := decryptor.Decrypt(ciphertext)
+	if err == nil {
+		return &decryptedMessage{plaintext: plaintext, keyInfo: keyInfo}, nil
+	}
+	err = decryptionError(ciphertext, armoredErr, err)
+	
+	// The message may be signcrypted rather than encrypted
+	if crypto.IsSigncryptionMismatch(err) {
+		var signer saltpack.SigningPublicKey
+		plaintext, signer, armoredErr = decryptor.DecryptSigncryptedArmored(string(ciphertext))
+		if armoredErr == nil {
+			return &decryptedMessage{plaintext: plaintext, signer: signer, signcrypted: true}, nil
+		}
+		
+		plaintext, signer, err = decryptor.DecryptSigncrypted(ciphertext)
+		if err == nil {
+			return &decryptedMessage{plaintext: plaintext, signer: signer, signcrypted: true}, nil
+		}
+		err = decryptionError(ciphertext, armoredErr, err)
+	}
+	
+	return nil, k.decryptionFailure(err, "decryption failed")
+}
+
+// decryptStreaming decrypts large ciphertext using streaming to avoid memory issues
+func (k *Keeper) decryptStreaming(decryptor *crypto.Decryptor, ciphertext []byte) (*decryptedMessage, error) {
+	stream, err := decryptor.NewDecryptStream(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, k.decryptionFailure(err, "streaming decryption failed")
+	}
+	
+	var plaintextBuf bytes.Buffer
+	if _, err := io.Copy(&plaintextBuf, stream); err != nil {
+		return nil, k.decryptionFailure(err, "streaming decryption failed")
+	}
+	
+	message := streamMessage(stream)
+	message.plaintext = plaintextBuf.Bytes()
+	return message, nil
+}
+
+// streamMessage describes the keys of a Saltpack message being streamed
+func streamMessage(stream *crypto.DecryptedStream) *decryptedMessage {
+	return &decryptedMessage{
+		keyInfo:     stream.KeyInfo,
+		signer:      stream.Signer,
+		signcrypted: stream.Signcrypted,
+	}
+}
+
+// resolveKeyOwners names the Keybase users and devices owning the receiver
+// and sender keys of a decrypted message
+//
+// KIDs are resolved through the cache manager (cached key families first,
+// then the API), and device names through the API. Failures are ignored:
+// the message is already decrypted and authenticated, so an unresolved key
+// only leaves its owner's name empty.
+func (k *Keeper) resolveKeyOwners(ctx context.Context, info *crypto.MessageInfo) {
+	k.resolveOwners(ctx, []*crypto.KeyOwner{info.Receiver, info.Sender})
+}
+
+// resolveOwners fills in the usernames and device names of key owners, as
+// for resolveKeyOwners; nil owners are skipped
+func (k *Keeper) resolveOwners(ctx context.Context, keys []*crypto.KeyOwner) {
+	var owners []*crypto.KeyOwner
+	var kids []string
+	for _, owner := range keys {
+		if owner != nil && owner.KID != "" {
+			owners = append(owners, owner)
+			kids = append(kids, owner.KID)
+		}
+	}
+	if len(owners) == 0 {
+		return
+	}
+	
+	// A lookup failure still returns the owners found in the cache
+	usernames, _ := k.cacheManager.ResolveKIDs(ctx, kids)
+	
+	seen := make(map[string]bool)
+	var users []string
+	for _, owner := range owners {
+		owner.Username = usernames[owner.KID]
+		if owner.Username != "" && !seen[owner.Username] {
+			seen[owner.Username] = true
+			users = append(users, owner.Username)
+		}
+	}
+	if len(users) == 0 {
+		return
+	}
+	
+	devices, err := k.cacheManager.GetDevices(ctx, users)
+	if err != nil {
+		return
+	}
+	
+	for _, owner := range owners {
+		for _, device := range devices[owner.Username] {
+			if device.HasKID(owner.KID) {
+				owner.Device = device.Name
+				break
+			}
+		}
+	}
+}
+
+// senderDecryptor returns the decryptor to use under the configured
+// SenderPolicy, and the public keys of its allowed senders
+//
+// Saltpack only opens a non-anonymous message if the keyring knows the
+// sender's public key, so the allowed senders' encryption keys are resolved
+// through the cache manager and layered over the local keyring for this call.
+func (k *Keeper) senderDecryptor(ctx context.Context) (*crypto.Decryptor, []api.UserPublicKey, error) {
+	if err := k.loadLocalKey(); err != nil {
+		return nil, nil, err
+	}
+	
+	allowedSenders := k.config.SenderPolicy.AllowedSenders
+	if len(allowedSenders) == 0 {
+		return k.decryptor, nil, nil
+	}
+	
+	userKeys, err := k.cacheManager.GetPublicKeys(ctx, allowedSenders)
+	if err != nil {
+		return nil, nil, k.classifyError(err, "failed to resolve allowed senders", gcerrors.Internal)
+	}
+	
+	keyring := &senderKeyring{Keyring: k.keyring, senders: make(map[string]saltpack.BoxPublicKey)}
+	for _, userKey := range userKeys {
+		for _, kid := range userKey.EncryptionKIDs() {
+			publicKey, err := crypto.ParseEncryptionKID(kid)
+			if err != nil {
+				continue
+			}
+			keyring.senders[string(publicKey.ToKID())] = publicKey
+		}
+	}
+	
+	decryptor, err := crypto.NewDecryptor(&crypto.DecryptorConfig{
+		Keyring:         keyring,
+		AllowedVersions: k.decryptor.AllowedVersions,
+	})
+	if err != nil {
+		return nil, nil, k.classifyError(err, "failed to create decryptor", gcerrors.Internal)
+	}
+	
+	return decryptor, userKeys, nil
+}
+
+// senderKeyring adds the public keys of allowed senders to a keyring
+type senderKeyring struct {
+	saltpack.Keyring
+	senders map[string]saltpack.BoxPublicKey
+}
+
+// LookupBoxPublicKey returns an allowed sender's key, or the wrapped keyring's
+func (k *senderKeyring) LookupBoxPublicKey(kid []byte) saltpack.BoxPublicKey {
+	if publicKey, ok := k.senders[string(kid)]; ok {
+		return publicKey
+	}
+	return k.Keyring.LookupBoxPublicKey(kid)
+}
+
+// checkSender enforces the configured SenderPolicy on a decrypted message
+// allowedSenders holds the allowed senders' keys from senderDecryptor
+func (k *Keeper) checkSender(message *decryptedMessage, allowedSenders []api.UserPublicKey) error {
+	if _, err := k.config.SenderPolicy.Check(message.senderKID(), allowedSenders); err != nil {
+		return &KeeperError{
+			Message:    "refusing to decrypt",
+			Code:       gcerrors.PermissionDenied,
 			Underlying: err,
 		}
 	}
 	
-	return plaintext, messageInfo, nil
-=======
-	return plaintextBuf.Bytes(), nil
->>>>>>> origin/main
+	return nil
 }
 
-// Close releases resources held by the Keeper
+// decryptionFailure classifies a Saltpack decryption error
+//
+// A message in a Saltpack version outside allowed_versions is refused, as is,
+// under a SenderPolicy, a message from a sender whose key is unknown: both
+// are policy failures rather than malformed input or a missing key.
+func (k *Keeper) decryptionFailure(err error, message string) *KeeperError {
+	var versionErr *crypto.VersionError
+	if errors.As(err, &versionErr) {
+		return &KeeperError{
+			Message:    "refusing to decrypt",
+			Code:       gcerrors.PermissionDenied,
+			Underlying: err,
+		}
+	}
+	
+	var noSenderKey saltpack.ErrNoSenderKey
+	if k.config.SenderPolicy.Enabled() && errors.As(err, &noSenderKey) {
+		return &KeeperError{
+			Message:    "refusing to decrypt",
+			Code:       gcerrors.PermissionDenied,
+			Underlying: &SenderPolicyError{Reason: fmt.Sprintf("message sender is not a known key (%v)", err)},
+		}
+	}
+	
+	return k.classifyError(err, message, gcerrors.InvalidArgument)
+}
+
+// Close wipes the secret keys held by the Keeper and releases its resources
+//
+// The local Keybase key, a signing key loaded for signcryption, the PGP
+// secret key loaded from Config.PGPSecretKeyPath and a remembered key file
+// passphrase are zeroed, so the Keeper cannot decrypt or signcrypt
+// afterwards. Keys and passphrases passed in KeeperConfig belong to the
+// caller and are left as they are.
 func (k *Keeper) Close() error {
+	k.keyMu.Lock()
+	k.keyConfig = nil
+	if k.passphrases != nil {
+		k.passphrases.Zero()
+	}
+	k.keyMu.Unlock()
+	
+	if k.keyring != nil {
+		k.keyring.Zero()
+	}
+	crypto.ZeroKey(k.localSigningKey)
+	if k.pgpDecryptor != nil {
+		k.pgpDecryptor.Zero()
+	}
+	
 	if k.cacheManager != nil {
 		return k.cacheManager.Close()
 	}
@@ -370,20 +1197,15 @@ func (k *Keeper) Close() error {
 }
 
 // ErrorAs maps Keeper errors to specific error types
+//
+// The whole error chain is searched, so an *api.APIError wrapped by the
+// cache manager or a KeeperError can still be extracted.
 func (k *Keeper) ErrorAs(err error, target interface{}) bool {
-	if keeperErr, ok := err.(*KeeperError); ok {
-		if ptr, ok := target.(**KeeperError); ok {
-			*ptr = keeperErr
-			return true
-		}
-	}
-	
-	// Check for API errors
-	if apiErr, ok := err.(*api.APIError); ok {
-		if ptr, ok := target.(**api.APIError); ok {
-			*ptr = apiErr
-			return true
-		}
+	switch ptr := target.(type) {
+	case **KeeperError:
+		return errors.As(err, ptr)
+	case **api.APIError:
+		return errors.As(err, ptr)
 	}
 	
 	return false
@@ -391,15 +1213,12 @@ func (k *Keeper) ErrorAs(err error, target interface{}) bool {
 
 // ErrorCode maps errors to Go Cloud error codes
 func (k *Keeper) ErrorCode(err error) gcerrors.ErrorCode {
-	if keeperErr, ok := err.(*KeeperError); ok {
+	var keeperErr *KeeperError
+	if errors.As(err, &keeperErr) {
 		return keeperErr.Code
 	}
 	
-	if apiErr, ok := err.(*api.APIError); ok {
-		return k.classifyAPIError(apiErr).Code
-	}
-	
-	return gcerrors.Unknown
+	return k.classifyError(err, "", gcerrors.Unknown).Code
 }
 
 // KeeperError represents a Keeper-specific error
@@ -420,6 +1239,53 @@ func (e *KeeperError) Unwrap() error {
 	return e.Underlying
 }
 
+// classifyError wraps err in a KeeperError whose code reflects the most
+// specific failure found in the error chain. fallback is used when nothing
+// in the chain is recognised.
+func (k *Keeper) classifyError(err error, message string, fallback gcerrors.ErrorCode) *KeeperError {
+	var keeperErr *KeeperError
+	if errors.As(err, &keeperErr) {
+		return keeperErr
+	}
+	
+	code := fallback
+	
+	var apiErr *api.APIError
+	var noSenderKey saltpack.ErrNoSenderKey
+	switch {
+	case errors.Is(err, context.Canceled):
+		code = gcerrors.Canceled
+	case errors.Is(err, context.DeadlineExceeded):
+		code = gcerrors.DeadlineExceeded
+	case errors.As(err, &apiErr):
+		code = k.classifyAPIError(apiErr).Code
+	case errors.Is(err, saltpack.ErrNoDecryptionKey):
+		// None of our keys is a recipient of the message
+		code = gcerrors.PermissionDenied
+	case errors.As(err, &noSenderKey):
+		code = gcerrors.NotFound
+	case errors.Is(err, pgperrors.ErrKeyIncorrect):
+		// None of our PGP keys is a recipient of the message
+		code = gcerrors.PermissionDenied
+	}
+	
+	return &KeeperError{
+		Message:    message,
+		Code:       code,
+		Underlying: err,
+	}
+}
+
+// decryptionError picks the error to report after both armored and binary
+// decryption failed. The binary attempt on armored input only ever fails
+// with a parse error, which would hide the real cause.
+func decryptionError(ciphertext []byte, armoredErr, binaryErr error) error {
+	if bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte("BEGIN ")) {
+		return armoredErr
+	}
+	return binaryErr
+}
+
 // classifyAPIError maps API errors to Keeper errors with appropriate error codes
 func (k *Keeper) classifyAPIError(apiErr *api.APIError) *KeeperError {
 	var code gcerrors.ErrorCode
@@ -429,6 +1295,10 @@ func (k *Keeper) classifyAPIError(apiErr *api.APIError) *KeeperError {
 		code = gcerrors.Internal // Network errors are transient internal issues
 	case api.ErrorKindTimeout:
 		code = gcerrors.DeadlineExceeded
+		// The API client reports cancellation as a timeout kind
+		if errors.Is(apiErr, context.Canceled) {
+			code = gcerrors.Canceled
+		}
 	case api.ErrorKindNotFound:
 		code = gcerrors.NotFound
 	case api.ErrorKindInvalidInput:
@@ -448,15 +1318,54 @@ func (k *Keeper) classifyAPIError(apiErr *api.APIError) *KeeperError {
 	}
 }
 
+// loadLocalKey loads the local user's secret key into the keyring, if it
+// has not been loaded yet
+//
+// A key file that cannot be unlocked fails with PermissionDenied for a wrong
+// passphrase and FailedPrecondition when no passphrase is available. Other
+// failures, such as Keybase not being set up, leave decryption to the keys
+// already in the keyring. Failures are not remembered: the next decryption
+// tries again, so a passphrase set in the meantime is picked up.
+func (k *Keeper) loadLocalKey() error {
+	k.keyMu.Lock()
+	defer k.keyMu.Unlock()
+	
+	if k.keyConfig == nil {
+		return nil
+	}
+	
+	err := loadLocalSecretKey(k.keyring, k.keyConfig)
+	switch {
+	case err == nil:
+		k.keyConfig = nil
+		k.passphrases.Zero()
+		return nil
+	case errors.Is(err, crypto.ErrIncorrectPassphrase):
+		return &KeeperError{
+			Message:    "failed to unlock the local Keybase key",
+			Code:       gcerrors.PermissionDenied,
+			Underlying: err,
+		}
+	case errors.Is(err, crypto.ErrNoPassphrase):
+		return &KeeperError{
+			Message:    "failed to unlock the local Keybase key",
+			Code:       gcerrors.FailedPrecondition,
+			Underlying: err,
+		}
+	default:
+		return nil
+	}
+}
+
 // loadLocalSecretKey attempts to load the local user's secret key for decryption
-func loadLocalSecretKey(keyring *crypto.SimpleKeyring) error {
+func loadLocalSecretKey(keyring *crypto.SimpleKeyring, keyConfig *crypto.SenderKeyConfig) error {
 	// Verify Keybase is available
 	if err := credentials.VerifyKeybaseAvailable(); err != nil {
 		return fmt.Errorf("keybase not available: %w", err)
 	}
 	
 	// Load the sender key (which includes the secret key for the current user)
-	senderKey, err := crypto.LoadSenderKey(nil)
+	senderKey, err := crypto.LoadSenderKey(keyConfig)
 	if err != nil {
 		return fmt.Errorf("failed to load sender key: %w", err)
 	}
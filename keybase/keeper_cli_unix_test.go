@@ -0,0 +1,218 @@
+//go:build unix
+
+package keybase
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/cache"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+func TestKeeperCLIEngine(t *testing.T) {
+	// The fake keybase client knows alice and bob and can decrypt for alice
+	fakeDir, err := filepath.Abs(filepath.Join("cli", "testdata"))
+	if err != nil {
+		t.Fatalf("Failed to find fake keybase: %v", err)
+	}
+	t.Setenv("PATH", fakeDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config, _, err := loadConfig("keybase://alice,bob?engine=cli", mapEnv(nil))
+	if err != nil {
+		t.Fatalf("loadConfig() error = %v", err)
+	}
+	config.CachePath = filepath.Join(t.TempDir(), "cache.json")
+
+	keeper, err := NewKeeper(&KeeperConfig{Config: config})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	defer keeper.Close()
+
+	ctx := context.Background()
+	ciphertext, err := keeper.Encrypt(ctx, []byte("database password"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !strings.HasPrefix(string(ciphertext), "FAKE SALTPACK FOR alice bob\n") {
+		t.Errorf("Encrypt() = %q, want a message from keybase encrypt alice bob", ciphertext)
+	}
+
+	plaintext, err := keeper.Decrypt(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(plaintext) != "database password" {
+		t.Errorf("Decrypt() = %q, want database password", plaintext)
+	}
+
+	buf, err := keeper.DecryptSecure(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptSecure() error = %v", err)
+	}
+	if string(buf.Bytes()) != "database password" {
+		t.Errorf("DecryptSecure() = %q, want database password", buf.Bytes())
+	}
+	buf.Zero()
+
+	// A message for someone else is refused by the client
+	_, err = keeper.Decrypt(ctx, []byte("FAKE SALTPACK FOR bob\nsecret"))
+	if code := keeper.ErrorCode(err); code != gcerrors.PermissionDenied {
+		t.Errorf("Decrypt() for another user code = %v, want PermissionDenied (%v)", code, err)
+	}
+
+	if _, _, err := keeper.DecryptWithInfo(ctx, ciphertext); keeper.ErrorCode(err) != gcerrors.Unimplemented {
+		t.Errorf("DecryptWithInfo() error = %v, want Unimplemented", err)
+	}
+	if _, err := keeper.Rekey(ctx, ciphertext, []string{"alice"}); keeper.ErrorCode(err) != gcerrors.Unimplemented {
+		t.Errorf("Rekey() error = %v, want Unimplemented", err)
+	}
+}
+
+func TestKeeperCLIEngineTeam(t *testing.T) {
+	fakeDir, err := filepath.Abs(filepath.Join("cli", "testdata"))
+	if err != nil {
+		t.Fatalf("Failed to find fake keybase: %v", err)
+	}
+	t.Setenv("PATH", fakeDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	// Team members come from the local service, which verifies the team's
+	// sigchain; the unroutable mirror is never asked
+	config, err := ParseURL("keybase://team:acme.ops?engine=cli&key_mirror=http%3A%2F%2F127.0.0.1%3A1")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	config.CachePath = filepath.Join(t.TempDir(), "cache.json")
+	config.APIConfig = &api.ClientConfig{Timeout: time.Second}
+
+	keeper, err := NewKeeper(&KeeperConfig{Config: config})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	defer keeper.Close()
+
+	ciphertext, err := keeper.Encrypt(context.Background(), []byte("team secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !strings.HasPrefix(string(ciphertext), "FAKE SALTPACK FOR alice bob\n") {
+		t.Errorf("Encrypt() = %q, want a message for the active members alice and bob", ciphertext)
+	}
+}
+
+func TestKeeperCLIEngineUnknownRecipient(t *testing.T) {
+	fakeDir, err := filepath.Abs(filepath.Join("cli", "testdata"))
+	if err != nil {
+		t.Fatalf("Failed to find fake keybase: %v", err)
+	}
+	t.Setenv("PATH", fakeDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	config, err := ParseURL("keybase://mallory?engine=cli")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	config.CachePath = filepath.Join(t.TempDir(), "cache.json")
+	// An unroutable mirror, so that the fallback lookup never reaches keybase.io
+	config.KeyMirror = "http://127.0.0.1:1"
+	config.APIConfig = &api.ClientConfig{Timeout: time.Second}
+
+	keeper, err := NewKeeper(&KeeperConfig{Config: config})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	defer keeper.Close()
+
+	_, err = keeper.Encrypt(context.Background(), []byte("secret"))
+	if code := keeper.ErrorCode(err); code != gcerrors.NotFound {
+		t.Errorf("Encrypt() for an unknown user code = %v, want NotFound (%v)", code, err)
+	}
+}
+
+// TestKeeperLocalKeyPassphrase tests that a passphrase-protected local key is
+// only unlocked when decrypting, and that passphrase failures are reported
+// and not remembered
+func TestKeeperLocalKeyPassphrase(t *testing.T) {
+	// VerifyKeybaseAvailable needs a keybase binary and a logged-in user
+	fakeDir, err := filepath.Abs(filepath.Join("cli", "testdata"))
+	if err != nil {
+		t.Fatalf("Failed to find fake keybase: %v", err)
+	}
+	t.Setenv("PATH", fakeDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(crypto.PassphraseEnv, "")
+	t.Setenv(crypto.PassphraseFDEnv, "")
+
+	configDir := filepath.Join(home, ".config", "keybase")
+	if err := os.MkdirAll(configDir, 0700); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(configDir, "config.json"), []byte(`{"current_user": "alice"}`), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	senderKey, err := crypto.CreateTestSenderKey("alice")
+	if err != nil {
+		t.Fatalf("CreateTestSenderKey() error = %v", err)
+	}
+	if _, err := crypto.SaveSenderKey(senderKey, configDir, &crypto.KeyFileOptions{Passphrase: []byte("hunter2"), KDF: crypto.KDFScrypt}); err != nil {
+		t.Fatalf("SaveSenderKey() error = %v", err)
+	}
+
+	manager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{FilePath: filepath.Join(t.TempDir(), "cache.json"), TTL: time.Hour},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer manager.Close()
+	if err := manager.Cache().Set("alice", "", crypto.EncryptionKID(senderKey.PublicKey)); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+
+	config, err := ParseURL("keybase://alice")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	newKeeper := func(passphrase string) *Keeper {
+		keeper, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: manager, KeyPassphrase: []byte(passphrase)})
+		if err != nil {
+			t.Fatalf("NewKeeper() error = %v", err)
+		}
+		t.Cleanup(func() { keeper.Close() })
+		return keeper
+	}
+
+	// Encrypting needs no passphrase
+	ctx := context.Background()
+	keeper := newKeeper("")
+	ciphertext, err := keeper.Encrypt(ctx, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	_, err = keeper.Decrypt(ctx, ciphertext)
+	if keeper.ErrorCode(err) != gcerrors.FailedPrecondition || !errors.Is(err, crypto.ErrNoPassphrase) {
+		t.Errorf("Decrypt() without a passphrase error = %v, want FailedPrecondition wrapping ErrNoPassphrase", err)
+	}
+
+	// The failure is not remembered: a passphrase set afterwards is used
+	t.Setenv(crypto.PassphraseEnv, "hunter2")
+	if plaintext, err := keeper.Decrypt(ctx, ciphertext); err != nil || string(plaintext) != "secret" {
+		t.Errorf("Decrypt() after setting the passphrase = %q, %v, want secret", plaintext, err)
+	}
+
+	wrong := newKeeper("hunter3")
+	_, err = wrong.Decrypt(ctx, ciphertext)
+	if wrong.ErrorCode(err) != gcerrors.PermissionDenied || !errors.Is(err, crypto.ErrIncorrectPassphrase) {
+		t.Errorf("Decrypt() with a wrong passphrase error = %v, want PermissionDenied wrapping ErrIncorrectPassphrase", err)
+	}
+}
@@ -0,0 +1,238 @@
+package keybase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/cache"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/secrets"
+	"gocloud.dev/secrets/driver"
+	"gocloud.dev/secrets/drivertest"
+)
+
+// conformanceHarness builds two keepers that each encrypt to, and hold the
+// secret key of, a different user
+type conformanceHarness struct {
+	cacheManager *cache.Manager
+	keyPairs     map[string]*crypto.KeyPair
+}
+
+func newConformanceHarness(ctx context.Context, t *testing.T) (drivertest.Harness, error) {
+	keyPairs := make(map[string]*crypto.KeyPair)
+	publicKeys := make(map[string]saltpack.BoxPublicKey)
+	for _, username := range []string{"alice", "bob"} {
+		keyPair, err := crypto.GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		keyPairs[username] = keyPair
+		publicKeys[username] = keyPair.PublicKey
+	}
+
+	cacheManager, err := createMockCacheManager(publicKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conformanceHarness{
+		cacheManager: cacheManager,
+		keyPairs:     keyPairs,
+	}, nil
+}
+
+func (h *conformanceHarness) makeKeeper(username string) (*Keeper, error) {
+	keeper, err := NewKeeper(&KeeperConfig{
+		Config: &Config{
+			Recipients: []string{username},
+			Format:     FormatSaltpack,
+			CacheTTL:   24 * time.Hour,
+		},
+		CacheManager: h.cacheManager,
+	})
+	if err != nil {
+		return nil, err
+	}
+	keeper.keyring.AddKey(h.keyPairs[username].SecretKey)
+	return keeper, nil
+}
+
+func (h *conformanceHarness) MakeDriver(ctx context.Context) (driver.Keeper, driver.Keeper, error) {
+	keeper1, err := h.makeKeeper("alice")
+	if err != nil {
+		return nil, nil, err
+	}
+	keeper2, err := h.makeKeeper("bob")
+	if err != nil {
+		return nil, nil, err
+	}
+	return keeper1, keeper2, nil
+}
+
+func (h *conformanceHarness) Close() {}
+
+// verifyAsKeeperError checks that decrypt errors expose *KeeperError through ErrorAs
+type verifyAsKeeperError struct{}
+
+func (verifyAsKeeperError) Name() string {
+	return "verify ErrorAs extracts *KeeperError"
+}
+
+func (verifyAsKeeperError) ErrorCheck(k *secrets.Keeper, err error) error {
+	var keeperErr *KeeperError
+	if !k.ErrorAs(err, &keeperErr) {
+		return errors.New("Keeper.ErrorAs failed for *KeeperError")
+	}
+	if gcerrors.Code(err) != keeperErr.Code {
+		return fmt.Errorf("gcerrors.Code() = %v, want %v", gcerrors.Code(err), keeperErr.Code)
+	}
+	return nil
+}
+
+// TestConformance runs the Go CDK driver conformance suite against the Keeper
+func TestConformance(t *testing.T) {
+	drivertest.RunConformanceTests(t, newConformanceHarness, []drivertest.AsTest{verifyAsKeeperError{}})
+}
+
+// TestPortableKeeperErrorCodes tests gcerrors codes surfaced through *secrets.Keeper
+func TestPortableKeeperErrorCodes(t *testing.T) {
+	ctx := context.Background()
+
+	h, err := newConformanceHarness(ctx, t)
+	if err != nil {
+		t.Fatalf("Failed to create harness: %v", err)
+	}
+	harness := h.(*conformanceHarness)
+	defer harness.cacheManager.Close()
+
+	alice, err := harness.makeKeeper("alice")
+	if err != nil {
+		t.Fatalf("Failed to create keeper: %v", err)
+	}
+	bob, err := harness.makeKeeper("bob")
+	if err != nil {
+		t.Fatalf("Failed to create keeper: %v", err)
+	}
+	aliceKeeper := secrets.NewKeeper(alice)
+	defer aliceKeeper.Close()
+	bobKeeper := secrets.NewKeeper(bob)
+	defer bobKeeper.Close()
+
+	ciphertext, err := aliceKeeper.Encrypt(ctx, []byte("for alice only"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	binaryCiphertext, err := alice.encryptor.Encrypt([]byte("for alice only"), []saltpack.BoxPublicKey{
+		harness.keyPairs["alice"].PublicKey,
+	})
+	if err != nil {
+		t.Fatalf("Encrypt() binary error = %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	offlineManager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{
+			FilePath: t.TempDir() + "/cache.json",
+			TTL:      time.Hour,
+		},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create offline cache manager: %v", err)
+	}
+	offline, err := NewKeeper(&KeeperConfig{
+		Config:       &Config{Recipients: []string{"carol"}, Format: FormatSaltpack},
+		CacheManager: offlineManager,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create keeper: %v", err)
+	}
+	offlineKeeper := secrets.NewKeeper(offline)
+	defer offlineKeeper.Close()
+
+	tests := []struct {
+		name     string
+		call     func() error
+		wantCode gcerrors.ErrorCode
+	}{
+		{
+			name: "decrypt armored without matching key",
+			call: func() error {
+				_, err := bobKeeper.Decrypt(ctx, ciphertext)
+				return err
+			},
+			wantCode: gcerrors.PermissionDenied,
+		},
+		{
+			name: "decrypt binary without matching key",
+			call: func() error {
+				_, err := bobKeeper.Decrypt(ctx, binaryCiphertext)
+				return err
+			},
+			wantCode: gcerrors.PermissionDenied,
+		},
+		{
+			name: "decrypt malformed ciphertext",
+			call: func() error {
+				_, err := aliceKeeper.Decrypt(ctx, []byte("not a saltpack message"))
+				return err
+			},
+			wantCode: gcerrors.InvalidArgument,
+		},
+		{
+			name: "decrypt with cancelled context",
+			call: func() error {
+				_, err := aliceKeeper.Decrypt(cancelled, ciphertext)
+				return err
+			},
+			wantCode: gcerrors.Canceled,
+		},
+		{
+			name: "encrypt with cancelled context",
+			call: func() error {
+				_, err := aliceKeeper.Encrypt(cancelled, []byte("secret"))
+				return err
+			},
+			wantCode: gcerrors.Canceled,
+		},
+		{
+			name: "encrypt with recipient missing from offline cache",
+			call: func() error {
+				_, err := offlineKeeper.Encrypt(ctx, []byte("secret"))
+				return err
+			},
+			wantCode: gcerrors.NotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call()
+			if err == nil {
+				t.Fatal("expected an error")
+			}
+			if code := gcerrors.Code(err); code != tt.wantCode {
+				t.Errorf("gcerrors.Code() = %v, want %v (error: %v)", code, tt.wantCode, err)
+			}
+		})
+	}
+
+	// The wrapped API error must remain reachable through the portable keeper
+	_, err = offlineKeeper.Encrypt(ctx, []byte("secret"))
+	var apiErr *api.APIError
+	if !offlineKeeper.ErrorAs(err, &apiErr) {
+		t.Fatalf("ErrorAs() could not extract *api.APIError from %v", err)
+	}
+	if apiErr.Kind != api.ErrorKindNotFound {
+		t.Errorf("APIError.Kind = %v, want %v", apiErr.Kind, api.ErrorKindNotFound)
+	}
+}
@@ -0,0 +1,273 @@
+package keybase
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/cache"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/internal/pgptest"
+	"gocloud.dev/gcerrors"
+)
+
+// createPGPCacheManager creates an offline cache manager holding PGP key bundles
+func createPGPCacheManager(t *testing.T, bundles map[string]string) *cache.Manager {
+	t.Helper()
+
+	manager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{
+			FilePath: t.TempDir() + "/cache.json",
+			TTL:      time.Hour,
+		},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+
+	for username, bundle := range bundles {
+		if err := manager.Cache().Set(username, bundle, "0101"+username); err != nil {
+			t.Fatalf("Failed to populate cache: %v", err)
+		}
+	}
+
+	return manager
+}
+
+// TestKeeperEncryptPGP tests that format=pgp produces a PGP message for every recipient
+func TestKeeperEncryptPGP(t *testing.T) {
+	alice, aliceBundle := pgptest.NewEntity(t, "alice")
+	bob, bobBundle := pgptest.NewEntity(t, "bob")
+
+	cacheManager := createPGPCacheManager(t, map[string]string{
+		"alice": aliceBundle,
+		"bob":   bobBundle,
+	})
+	defer cacheManager.Close()
+
+	keeper, err := NewKeeper(&KeeperConfig{
+		Config: &Config{
+			Recipients: []string{"alice", "bob"},
+			Format:     FormatPGP,
+		},
+		CacheManager: cacheManager,
+	})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+
+	plaintext := []byte("database password")
+	ciphertext, err := keeper.Encrypt(context.Background(), plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	if !strings.HasPrefix(string(ciphertext), "-----BEGIN PGP MESSAGE-----") {
+		t.Fatalf("Encrypt() did not produce an armored PGP message")
+	}
+
+	for _, entity := range []*openpgp.Entity{alice, bob} {
+		block, err := armor.Decode(bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("Failed to decode armor: %v", err)
+		}
+		md, err := openpgp.ReadMessage(block.Body, openpgp.EntityList{entity}, nil, nil)
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		decrypted, err := io.ReadAll(md.UnverifiedBody)
+		if err != nil {
+			t.Fatalf("Failed to read plaintext: %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("decrypted = %q, want %q", decrypted, plaintext)
+		}
+	}
+}
+
+// TestKeeperEncryptPGPInvalidBundle tests that an unusable PGP bundle is an invalid argument
+func TestKeeperEncryptPGPInvalidBundle(t *testing.T) {
+	cacheManager := createPGPCacheManager(t, map[string]string{
+		"alice": "-----BEGIN PGP PUBLIC KEY BLOCK----- test key -----",
+	})
+	defer cacheManager.Close()
+
+	keeper, err := NewKeeper(&KeeperConfig{
+		Config: &Config{
+			Recipients: []string{"alice"},
+			Format:     FormatPGP,
+		},
+		CacheManager: cacheManager,
+	})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+
+	_, err = keeper.Encrypt(context.Background(), []byte("secret"))
+	if err == nil {
+		t.Fatal("Encrypt() with an invalid PGP bundle should fail")
+	}
+	if code := keeper.ErrorCode(err); code != gcerrors.InvalidArgument {
+		t.Errorf("ErrorCode() = %v, want %v", code, gcerrors.InvalidArgument)
+	}
+}
+
+// TestKeeperDecryptPGP tests PGP round trips and error codes on the decrypt side
+func TestKeeperDecryptPGP(t *testing.T) {
+	ctx := context.Background()
+	alice, aliceBundle := pgptest.NewEntity(t, "alice")
+	_, bobBundle := pgptest.NewEntity(t, "bob")
+
+	cacheManager := createPGPCacheManager(t, map[string]string{
+		"alice": aliceBundle,
+		"bob":   bobBundle,
+	})
+	defer cacheManager.Close()
+
+	newKeeper := func(recipient, secretKeyPath string) *Keeper {
+		keeper, err := NewKeeper(&KeeperConfig{
+			Config: &Config{
+				Recipients:       []string{recipient},
+				Format:           FormatPGP,
+				PGPSecretKeyPath: secretKeyPath,
+			},
+			CacheManager: cacheManager,
+		})
+		if err != nil {
+			t.Fatalf("NewKeeper() error = %v", err)
+		}
+		return keeper
+	}
+
+	aliceKeeper := newKeeper("alice", pgptest.ExportSecretKey(t, alice))
+	noKeyKeeper := newKeeper("alice", "")
+
+	plaintext := []byte("existing PGP secret")
+	forAlice, err := aliceKeeper.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	forBob, err := newKeeper("bob", "").Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	decrypted, err := aliceKeeper.Decrypt(ctx, forAlice)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+
+	// A literal data packet armored as a PGP message needs no key to write
+	injected := []byte("injected secret")
+	literal := append([]byte{0xc0 | 11, byte(6 + len(injected)), 'b', 0, 0, 0, 0, 0}, injected...)
+	var unencrypted bytes.Buffer
+	armorWriter, err := armor.Encode(&unencrypted, crypto.PGPMessageType, nil)
+	if err != nil {
+		t.Fatalf("Failed to create armor encoder: %v", err)
+	}
+	armorWriter.Write(literal)
+	armorWriter.Close()
+
+	tests := []struct {
+		name       string
+		keeper     *Keeper
+		ciphertext []byte
+		wantCode   gcerrors.ErrorCode
+	}{
+		{
+			name:       "message for another key",
+			keeper:     aliceKeeper,
+			ciphertext: forBob,
+			wantCode:   gcerrors.PermissionDenied,
+		},
+		{
+			name:       "unencrypted message",
+			keeper:     aliceKeeper,
+			ciphertext: unencrypted.Bytes(),
+			wantCode:   gcerrors.InvalidArgument,
+		},
+		{
+			name:       "no PGP secret key configured",
+			keeper:     noKeyKeeper,
+			ciphertext: forAlice,
+			wantCode:   gcerrors.FailedPrecondition,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.keeper.Decrypt(ctx, tt.ciphertext)
+			if err == nil {
+				t.Fatal("Decrypt() should fail")
+			}
+			if code := tt.keeper.ErrorCode(err); code != tt.wantCode {
+				t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, tt.wantCode, err)
+			}
+		})
+	}
+
+	if err := aliceKeeper.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if aliceKeeper.pgpDecryptor.Keyring != nil {
+		t.Error("Close() left the PGP secret key in memory")
+	}
+	if _, err := aliceKeeper.Decrypt(ctx, forAlice); err == nil {
+		t.Error("Decrypt() after Close() should fail")
+	}
+}
+
+// TestKeeperPGPSecretKeyPassphrase tests loading a passphrase-protected PGP secret key
+func TestKeeperPGPSecretKeyPassphrase(t *testing.T) {
+	keyPath := filepath.Join("crypto", "testdata", "pgp_secret_key_protected.asc")
+	config := &Config{
+		Recipients:       []string{"alice"},
+		Format:           FormatPGP,
+		PGPSecretKeyPath: keyPath,
+	}
+
+	cacheManager := createPGPCacheManager(t, nil)
+	defer cacheManager.Close()
+
+	if _, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: cacheManager}); err == nil {
+		t.Error("NewKeeper() without a passphrase should fail for a protected key")
+	}
+
+	if _, err := NewKeeper(&KeeperConfig{
+		Config:        config,
+		CacheManager:  cacheManager,
+		PGPPassphrase: []byte("correct horse"),
+	}); err != nil {
+		t.Errorf("NewKeeper() with passphrase error = %v", err)
+	}
+
+	t.Setenv(EnvPGPPassphrase, "correct horse")
+	keeper, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: cacheManager})
+	if err != nil {
+		t.Fatalf("NewKeeper() with %s error = %v", EnvPGPPassphrase, err)
+	}
+
+	// A message produced by gpg for the same key decrypts
+	ciphertext, err := os.ReadFile(filepath.Join("crypto", "testdata", "pgp_message_gpg.asc"))
+	if err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	decrypted, err := keeper.Decrypt(context.Background(), ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if string(decrypted) != "secret encrypted by gpg" {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, "secret encrypted by gpg")
+	}
+}
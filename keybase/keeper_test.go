@@ -1,7 +1,16 @@
 package keybase
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -465,6 +474,43 @@ func TestKeeperErrorCode(t *testing.T) {
 			},
 			wantCode: gcerrors.ResourceExhausted,
 		},
+		{
+			name: "wrapped API error - not found",
+			err: fmt.Errorf("failed to fetch public key for alice: %w", &api.APIError{
+				Message: "user not found",
+				Kind:    api.ErrorKindNotFound,
+			}),
+			wantCode: gcerrors.NotFound,
+		},
+		{
+			name: "API error - cancelled request",
+			err: &api.APIError{
+				Message:    "request was cancelled",
+				Kind:       api.ErrorKindTimeout,
+				Underlying: context.Canceled,
+			},
+			wantCode: gcerrors.Canceled,
+		},
+		{
+			name:     "context cancelled",
+			err:      fmt.Errorf("decryption failed: %w", context.Canceled),
+			wantCode: gcerrors.Canceled,
+		},
+		{
+			name:     "context deadline exceeded",
+			err:      context.DeadlineExceeded,
+			wantCode: gcerrors.DeadlineExceeded,
+		},
+		{
+			name:     "no decryption key",
+			err:      fmt.Errorf("armored decryption failed: %w", saltpack.ErrNoDecryptionKey),
+			wantCode: gcerrors.PermissionDenied,
+		},
+		{
+			name:     "unrecognised error",
+			err:      errors.New("something else"),
+			wantCode: gcerrors.Unknown,
+		},
 	}
 
 	for _, tt := range tests {
@@ -507,6 +553,27 @@ func TestKeeperErrorAs(t *testing.T) {
 			target: new(*api.APIError),
 			want:   false,
 		},
+		{
+			name:   "wrapped API error to API error",
+			err:    fmt.Errorf("failed to fetch public keys: %w", &api.APIError{Message: "test"}),
+			target: new(*api.APIError),
+			want:   true,
+		},
+		{
+			name: "keeper error wrapping API error to API error",
+			err: &KeeperError{
+				Message:    "failed to fetch recipient public keys",
+				Underlying: fmt.Errorf("lookup: %w", &api.APIError{Message: "test"}),
+			},
+			target: new(*api.APIError),
+			want:   true,
+		},
+		{
+			name:   "unsupported target type",
+			err:    &KeeperError{Message: "test"},
+			target: new(error),
+			want:   false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -574,6 +641,7 @@ func TestKeeperDecryptWithInfo(t *testing.T) {
 		decryptKey saltpack.BoxSecretKey
 		senderKey  saltpack.BoxSecretKey
 		wantAnonymous bool
+		wantReceiver  string
 	}{
 		{
 			name:       "single recipient with sender",
@@ -582,6 +650,7 @@ func TestKeeperDecryptWithInfo(t *testing.T) {
 			decryptKey: keyPair1.SecretKey,
 			senderKey:  sender.SecretKey,
 			wantAnonymous: false,
+			wantReceiver:  "alice",
 		},
 		{
 			name:       "single recipient anonymous sender",
@@ -590,6 +659,7 @@ func TestKeeperDecryptWithInfo(t *testing.T) {
 			decryptKey: keyPair1.SecretKey,
 			senderKey:  nil,
 			wantAnonymous: true,
+			wantReceiver:  "alice",
 		},
 		{
 			name:       "multiple recipients - decrypt with first key",
@@ -598,6 +668,7 @@ func TestKeeperDecryptWithInfo(t *testing.T) {
 			decryptKey: keyPair1.SecretKey,
 			senderKey:  sender.SecretKey,
 			wantAnonymous: false,
+			wantReceiver:  "alice",
 		},
 		{
 			name:       "multiple recipients - decrypt with second key",
@@ -606,6 +677,7 @@ func TestKeeperDecryptWithInfo(t *testing.T) {
 			decryptKey: keyPair2.SecretKey,
 			senderKey:  sender.SecretKey,
 			wantAnonymous: false,
+			wantReceiver:  "bob",
 		},
 	}
 
@@ -683,6 +755,16 @@ func TestKeeperDecryptWithInfo(t *testing.T) {
 					messageInfo.ReceiverKID, expectedReceiverKID)
 			}
 			
+			// Verify the receiver is mapped back to its cached owner
+			if messageInfo.Receiver == nil || messageInfo.Receiver.Username != tt.wantReceiver {
+				t.Errorf("Receiver = %v, want username %q", messageInfo.Receiver, tt.wantReceiver)
+			}
+			
+			// Saltpack shuffles the header's recipients, so only the range is fixed
+			if messageInfo.ReceiverIndex < 0 || messageInfo.ReceiverIndex >= len(tt.recipients) {
+				t.Errorf("ReceiverIndex = %d, want in [0, %d)", messageInfo.ReceiverIndex, len(tt.recipients))
+			}
+			
 			// Verify sender anonymity
 			if messageInfo.IsAnonymousSender != tt.wantAnonymous {
 				t.Errorf("IsAnonymousSender = %v, want %v", 
@@ -777,32 +859,21 @@ func TestKeeperDecryptWithInfoErrors(t *testing.T) {
 
 // createMockCacheManager creates a cache manager with pre-populated test keys
 func createMockCacheManager(keys map[string]saltpack.BoxPublicKey) (*cache.Manager, error) {
-	manager, err := cache.NewManager(nil)
+	// Offline, so that nothing the cache cannot answer reaches the real API
+	config := cache.DefaultManagerConfig()
+	config.OfflineMode = true
+	manager, err := cache.NewManager(config)
 	if err != nil {
 		return nil, err
 	}
 
 	// Pre-populate cache with test keys
 	for username, publicKey := range keys {
-		// Convert public key to hex string for storage
-		keyID := publicKey.ToKID()
-		
-		// For testing, we need to store the key ID in a format that can be parsed
-		// Since the Keeper tries to parse the KID as a Curve25519 key, we'll store
-		// the actual key bytes as a hex string prefixed with "01" to make it look like
-		// a Keybase KID format
-		var hexEncoder = func(b []byte) string {
-			result := make([]byte, len(b)*2)
-			const hexTable = "0123456789abcdef"
-			for i, v := range b {
-				result[i*2] = hexTable[v>>4]
-				result[i*2+1] = hexTable[v&0x0f]
-			}
-			return string(result)
-		}
-		keyIDHex := "0120" + hexEncoder(keyID) // Prefix with 0120 to simulate Keybase KID format
+		// Store the key as a Keybase NaCl DH KID (0121...0a), the way the
+		// lookup API reports device encryption keys
+		keyIDHex := crypto.EncryptionKID(publicKey)
 		
-		// Store a mock PGP key bundle (will fail to parse, but KID parsing will succeed)
+		// Store a mock PGP key bundle; it is not used for saltpack encryption
 		mockKeyBundle := "-----BEGIN PGP PUBLIC KEY BLOCK----- test key -----"
 		
 		if err := manager.Cache().Set(username, mockKeyBundle, keyIDHex); err != nil {
@@ -813,6 +884,97 @@ func createMockCacheManager(keys map[string]saltpack.BoxPublicKey) (*cache.Manag
 	return manager, nil
 }
 
+// TestKeeperEncryptAllDevices tests that every active device key of a recipient can decrypt
+func TestKeeperEncryptAllDevices(t *testing.T) {
+	var devices []*crypto.KeyPair
+	var subkeys []string
+	for i := 0; i < 3; i++ {
+		keyPair, err := crypto.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		devices = append(devices, keyPair)
+		subkeys = append(subkeys, crypto.EncryptionKID(keyPair.PublicKey))
+	}
+	
+	manager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{
+			FilePath: t.TempDir() + "/cache.json",
+			TTL:      time.Hour,
+		},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer manager.Close()
+	
+	// alice has a PGP primary key, an Ed25519 eldest key and three device DH keys
+	err = manager.Cache().SetEntry(cache.CacheEntry{
+		Username:  "alice",
+		PublicKey: "-----BEGIN PGP PUBLIC KEY BLOCK----- test key -----",
+		KeyID:     "0101abcdef0a",
+		EldestKID: "0120" + strings.Repeat("11", 32) + "0a",
+		Sibkeys:   []string{"0120" + strings.Repeat("11", 32) + "0a"},
+		Subkeys:   subkeys,
+	})
+	if err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+	// bob only has a PGP key
+	if err := manager.Cache().Set("bob", "-----BEGIN PGP PUBLIC KEY BLOCK----- test key -----", "0101fedcba0a"); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+	
+	keeper, err := NewKeeper(&KeeperConfig{
+		Config:       &Config{Recipients: []string{"alice"}, Format: FormatSaltpack},
+		CacheManager: manager,
+	})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	
+	ctx := context.Background()
+	plaintext := []byte("readable on every device")
+	ciphertext, err := keeper.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	
+	for i, device := range devices {
+		keyring := crypto.NewSimpleKeyring()
+		keyring.AddKey(device.SecretKey)
+		decryptor, err := crypto.NewDecryptor(&crypto.DecryptorConfig{Keyring: keyring})
+		if err != nil {
+			t.Fatalf("Failed to create decryptor: %v", err)
+		}
+		
+		decrypted, _, err := decryptor.DecryptArmored(string(ciphertext))
+		if err != nil {
+			t.Fatalf("device %d: DecryptArmored() error = %v", i, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("device %d: decrypted = %q, want %q", i, decrypted, plaintext)
+		}
+	}
+	
+	// A PGP-only user cannot receive Saltpack messages
+	pgpOnly, err := NewKeeper(&KeeperConfig{
+		Config:       &Config{Recipients: []string{"bob"}, Format: FormatSaltpack},
+		CacheManager: manager,
+	})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	_, err = pgpOnly.Encrypt(ctx, plaintext)
+	if err == nil {
+		t.Fatal("Encrypt() to a PGP-only user should fail")
+	}
+	if code := pgpOnly.ErrorCode(err); code != gcerrors.InvalidArgument {
+		t.Errorf("ErrorCode() = %v, want %v", code, gcerrors.InvalidArgument)
+	}
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -1143,3 +1305,482 @@ func BenchmarkKeeperDecryptLarge(b *testing.B) {
 		_, _ = decryptKeeper.Decrypt(ctx, ciphertext)
 	}
 }
+
+// TestKeeperEncryptTeam tests that team recipients expand to their members
+func TestKeeperEncryptTeam(t *testing.T) {
+	manager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{
+			FilePath: t.TempDir() + "/cache.json",
+			TTL:      time.Hour,
+		},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer manager.Close()
+	
+	keyPairs := make(map[string]*crypto.KeyPair)
+	for _, username := range []string{"alice", "bob", "carol"} {
+		keyPair, err := crypto.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		keyPairs[username] = keyPair
+		if err := manager.Cache().Set(username, "", crypto.EncryptionKID(keyPair.PublicKey)); err != nil {
+			t.Fatalf("Failed to populate cache: %v", err)
+		}
+	}
+	
+	err = manager.Cache().SetTeam("acme.ops", []cache.TeamMember{
+		{Username: "alice", Role: "owner"},
+		{Username: "bob", Role: "writer"},
+		{Username: "carol", Role: "reader"},
+	}, true)
+	if err != nil {
+		t.Fatalf("Failed to populate team cache: %v", err)
+	}
+	
+	canDecrypt := func(username string, ciphertext []byte) bool {
+		keyring := crypto.NewSimpleKeyring()
+		keyring.AddKey(keyPairs[username].SecretKey)
+		decryptor, err := crypto.NewDecryptor(&crypto.DecryptorConfig{Keyring: keyring})
+		if err != nil {
+			t.Fatalf("Failed to create decryptor: %v", err)
+		}
+		_, _, err = decryptor.DecryptArmored(string(ciphertext))
+		return err == nil
+	}
+	
+	tests := []struct {
+		name     string
+		url      string
+		wantRead map[string]bool
+		wantCode gcerrors.ErrorCode
+	}{
+		{
+			name:     "every member",
+			url:      "keybase://team:acme.ops",
+			wantRead: map[string]bool{"alice": true, "bob": true, "carol": true},
+		},
+		{
+			name:     "writers and above",
+			url:      "keybase://team:acme.ops?team_role=writer",
+			wantRead: map[string]bool{"alice": true, "bob": true, "carol": false},
+		},
+		{
+			name:     "explicit user alongside a team",
+			url:      "keybase://carol,team:acme.ops?team_role=owner",
+			wantRead: map[string]bool{"alice": true, "bob": false, "carol": true},
+		},
+		{
+			name:     "team not in the offline cache",
+			url:      "keybase://team:acme.dev",
+			wantCode: gcerrors.NotFound,
+		},
+	}
+	
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseURL(tt.url)
+			if err != nil {
+				t.Fatalf("ParseURL() error = %v", err)
+			}
+			keeper, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: manager})
+			if err != nil {
+				t.Fatalf("NewKeeper() error = %v", err)
+			}
+			
+			ciphertext, err := keeper.Encrypt(context.Background(), []byte("team secret"))
+			if tt.wantCode != gcerrors.OK {
+				if code := keeper.ErrorCode(err); code != tt.wantCode {
+					t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, tt.wantCode, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			
+			for username, want := range tt.wantRead {
+				if got := canDecrypt(username, ciphertext); got != want {
+					t.Errorf("%s can decrypt = %t, want %t", username, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestKeeperEncryptTeamFromAPI tests that team members are only taken from
+// the API when its unverified member lists are trusted
+func TestKeeperEncryptTeamFromAPI(t *testing.T) {
+	var teamLookups int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/team/get.json" {
+			t.Errorf("unexpected API request %s", r.URL.Path)
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		teamLookups++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": {"code": 0, "name": "OK"}, "name": "acme.ops", "members": {"owners": [{"username": "alice"}]}}`))
+	}))
+	defer server.Close()
+	
+	for _, trust := range []bool{false, true} {
+		t.Run(fmt.Sprintf("trust_server_teams=%t", trust), func(t *testing.T) {
+			teamLookups = 0
+			manager, err := cache.NewManager(&cache.ManagerConfig{
+				CacheConfig: &cache.CacheConfig{
+					FilePath: t.TempDir() + "/cache.json",
+					TTL:      time.Hour,
+				},
+				APIConfig: &api.ClientConfig{BaseURL: server.URL, Timeout: 5 * time.Second, TrustServerTeams: trust},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create cache manager: %v", err)
+			}
+			defer manager.Close()
+			
+			keyPair, err := crypto.GenerateKeyPair()
+			if err != nil {
+				t.Fatalf("Failed to generate key pair: %v", err)
+			}
+			if err := manager.Cache().Set("alice", "", crypto.EncryptionKID(keyPair.PublicKey)); err != nil {
+				t.Fatalf("Failed to populate cache: %v", err)
+			}
+			
+			config, err := ParseURL("keybase://team:acme.ops")
+			if err != nil {
+				t.Fatalf("ParseURL() error = %v", err)
+			}
+			keeper, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: manager})
+			if err != nil {
+				t.Fatalf("NewKeeper() error = %v", err)
+			}
+			
+			_, err = keeper.Encrypt(context.Background(), []byte("team secret"))
+			if trust {
+				if err != nil || teamLookups != 1 {
+					t.Errorf("Encrypt() error = %v after %d team lookups, want success after 1", err, teamLookups)
+				}
+				return
+			}
+			if code := keeper.ErrorCode(err); code != gcerrors.InvalidArgument || !errors.Is(err, api.ErrUnverifiedTeam) {
+				t.Errorf("Encrypt() error = %v (%v), want InvalidArgument wrapping api.ErrUnverifiedTeam", err, code)
+			}
+			if teamLookups != 0 {
+				t.Errorf("server got %d team lookups, want none", teamLookups)
+			}
+		})
+	}
+}
+
+func TestKeeperSigncrypt(t *testing.T) {
+	manager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{
+			FilePath: t.TempDir() + "/cache.json",
+			TTL:      time.Hour,
+		},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer manager.Close()
+	
+	receiver, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	if err := manager.Cache().Set("alice", "", crypto.EncryptionKID(receiver.PublicKey)); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+	
+	signingKey, err := crypto.GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+	
+	config, err := ParseURL("keybase://alice?mode=signcrypt")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	keeper, err := NewKeeper(&KeeperConfig{
+		Config:       config,
+		CacheManager: manager,
+		SigningKey:   signingKey,
+	})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	keeper.keyring.AddKey(receiver.SecretKey)
+	
+	ctx := context.Background()
+	plaintext := []byte("rotated by alice")
+	ciphertext, err := keeper.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	
+	// A plain Saltpack decryptor must not mistake the message for an encrypted one
+	if _, _, err := keeper.decryptor.DecryptArmored(string(ciphertext)); !crypto.IsSigncryptionMismatch(err) {
+		t.Errorf("DecryptArmored() error = %v, want a message type mismatch", err)
+	}
+	
+	decrypted, err := keeper.Decrypt(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+	
+	decrypted, info, err := keeper.DecryptWithInfo(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptWithInfo() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptWithInfo() = %q, want %q", decrypted, plaintext)
+	}
+	if !info.Signcrypted || info.IsAnonymousSender {
+		t.Errorf("MessageInfo = %+v, want a signcrypted message with a known signer", info)
+	}
+	if want := crypto.SigningKID(signingKey.GetPublicKey()); info.SignerKID != want {
+		t.Errorf("SignerKID = %s, want %s", info.SignerKID, want)
+	}
+	
+	// Someone who is not a recipient is denied
+	other, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: manager, SigningKey: signingKey})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	_, err = other.Decrypt(ctx, ciphertext)
+	if code := other.ErrorCode(err); code != gcerrors.PermissionDenied {
+		t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, gcerrors.PermissionDenied, err)
+	}
+	
+	// Without a signing key, signcryption cannot start
+	t.Setenv("HOME", t.TempDir())
+	if _, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: manager}); err == nil {
+		t.Error("NewKeeper() with mode=signcrypt and no signing key should fail")
+	}
+}
+
+// TestKeeperSaltpackVersion tests writing version 1 messages and refusing
+// versions outside allowed_versions
+func TestKeeperSaltpackVersion(t *testing.T) {
+	ctx := context.Background()
+	plaintext := []byte("state readable by older clients")
+
+	t.Run("version 1 round trip", func(t *testing.T) {
+		keeper, _ := newStreamTestKeeper(t, "keybase://alice?saltpack_version=1")
+
+		ciphertext, err := keeper.Encrypt(ctx, plaintext)
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		decrypted, info, err := keeper.DecryptWithInfo(ctx, ciphertext)
+		if err != nil {
+			t.Fatalf("DecryptWithInfo() error = %v", err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("DecryptWithInfo() = %q, want %q", decrypted, plaintext)
+		}
+		if info.Version != saltpack.Version1() {
+			t.Errorf("MessageInfo.Version = %s, want %s", info.Version, saltpack.Version1())
+		}
+	})
+
+	t.Run("version outside allowed_versions", func(t *testing.T) {
+		keeper, receiver := newStreamTestKeeper(t, "keybase://alice?allowed_versions=2")
+
+		version := saltpack.Version1()
+		downgraded, err := crypto.NewEncryptor(&crypto.EncryptorConfig{Version: &version})
+		if err != nil {
+			t.Fatalf("NewEncryptor() error = %v", err)
+		}
+		ciphertext, err := downgraded.EncryptArmored(plaintext, []saltpack.BoxPublicKey{receiver.PublicKey})
+		if err != nil {
+			t.Fatalf("EncryptArmored() error = %v", err)
+		}
+
+		_, err = keeper.Decrypt(ctx, []byte(ciphertext))
+		if code := keeper.ErrorCode(err); code != gcerrors.PermissionDenied {
+			t.Errorf("Decrypt() ErrorCode() = %v, want %v (error: %v)", code, gcerrors.PermissionDenied, err)
+		}
+		var versionErr *crypto.VersionError
+		if !errors.As(err, &versionErr) {
+			t.Errorf("Decrypt() error = %v, want a *crypto.VersionError", err)
+		}
+
+		_, err = keeper.NewDecryptReader(ctx, strings.NewReader(ciphertext))
+		if code := keeper.ErrorCode(err); code != gcerrors.PermissionDenied {
+			t.Errorf("NewDecryptReader() ErrorCode() = %v, want %v (error: %v)", code, gcerrors.PermissionDenied, err)
+		}
+	})
+}
+
+// TestKeeperHideRecipients tests that hidden-recipient messages decrypt
+// through every Keeper decryption path
+func TestKeeperHideRecipients(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice?hide_recipients=true")
+	plaintext := []byte("prod secret")
+
+	// The keyring holds other keys that trial decryption must skip
+	other, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	keeper.keyring.AddKeyPair(other)
+
+	ciphertext, err := keeper.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	decrypted, info, err := keeper.DecryptWithInfo(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptWithInfo() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("DecryptWithInfo() = %q, want %q", decrypted, plaintext)
+	}
+	if !info.IsHiddenReceiver {
+		t.Error("MessageInfo.IsHiddenReceiver = false, want true")
+	}
+
+	reader, err := keeper.NewDecryptReader(ctx, bytes.NewReader(ciphertext))
+	if err != nil {
+		t.Fatalf("NewDecryptReader() error = %v", err)
+	}
+	decrypted, err = io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("ReadAll() error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("NewDecryptReader() = %q, want %q", decrypted, plaintext)
+	}
+}
+
+// TestKeeperDecryptSecureAndClose tests that DecryptSecure returns the
+// plaintext in a wipeable buffer and that Close wipes the local secret key
+func TestKeeperDecryptSecureAndClose(t *testing.T) {
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	cacheManager, err := createMockCacheManager(map[string]saltpack.BoxPublicKey{
+		"alice": keyPair.PublicKey,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create mock cache manager: %v", err)
+	}
+	defer cacheManager.Close()
+
+	config := &Config{
+		Recipients: []string{"alice"},
+		Format:     FormatSaltpack,
+		CacheTTL:   24 * time.Hour,
+	}
+
+	keyring := crypto.NewSimpleKeyring()
+	keyring.AddKey(keyPair.SecretKey)
+	decryptor, err := crypto.NewDecryptor(&crypto.DecryptorConfig{Keyring: keyring})
+	if err != nil {
+		t.Fatalf("Failed to create decryptor: %v", err)
+	}
+	encryptor, err := crypto.NewEncryptor(nil)
+	if err != nil {
+		t.Fatalf("Failed to create encryptor: %v", err)
+	}
+
+	keeper := &Keeper{
+		config:       config,
+		cacheManager: cacheManager,
+		encryptor:    encryptor,
+		decryptor:    decryptor,
+		keyring:      keyring,
+	}
+
+	ctx := context.Background()
+	plaintext := []byte("database password")
+	ciphertext, err := keeper.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	buf, err := keeper.DecryptSecure(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("DecryptSecure() error = %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), plaintext) {
+		t.Errorf("DecryptSecure() = %q, want %q", buf.Bytes(), plaintext)
+	}
+	buf.Zero()
+	if !bytes.Equal(buf.Bytes(), make([]byte, len(plaintext))) {
+		t.Error("Zero() left plaintext in the buffer")
+	}
+
+	if err := keeper.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !bytes.Equal(crypto.ExportSecretKeyBytes(keyPair.SecretKey), make([]byte, 32)) {
+		t.Error("Close() left the secret key in memory")
+	}
+	if _, err := keeper.Decrypt(ctx, ciphertext); err == nil {
+		t.Error("Decrypt() after Close() should fail")
+	}
+}
+
+func TestNewKeeperWithKeyDirectory(t *testing.T) {
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	dir := t.TempDir()
+	directoryPath := filepath.Join(dir, "keys.yaml")
+	directory := fmt.Sprintf("users:\n  alice:\n    kid: %s\n", crypto.EncryptionKID(keyPair.PublicKey))
+	if err := os.WriteFile(directoryPath, []byte(directory), 0600); err != nil {
+		t.Fatalf("Failed to write key directory: %v", err)
+	}
+
+	config := DefaultConfig()
+	config.Recipients = []string{"alice"}
+	config.CachePath = filepath.Join(dir, "cache.json")
+	config.KeyDirectory = directoryPath
+	// An unroutable mirror, so that nothing reaches keybase.io
+	config.KeyMirror = "http://127.0.0.1:1"
+	config.APIConfig = &api.ClientConfig{Timeout: time.Second}
+
+	keeper, err := NewKeeper(&KeeperConfig{Config: config})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	defer keeper.Close()
+
+	if _, err := keeper.Encrypt(context.Background(), []byte("secret")); err != nil {
+		t.Fatalf("Encrypt() with a key directory error = %v", err)
+	}
+
+	config.KeyDirectory = filepath.Join(dir, "missing.yaml")
+	if _, err := NewKeeper(&KeeperConfig{Config: config}); err == nil {
+		t.Error("NewKeeper() with a missing key directory should fail")
+	}
+
+	config.KeyDirectory = ""
+	config.KeyMirror = "ftp://keys.example.com"
+	if _, err := NewKeeper(&KeeperConfig{Config: config}); err == nil {
+		t.Error("NewKeeper() with an invalid key mirror should fail")
+	}
+
+	// Network settings are loaded when the keeper is created
+	config.KeyMirror = ""
+	config.APIConfig = &api.ClientConfig{Timeout: time.Second, CAFile: filepath.Join(dir, "missing-ca.pem")}
+	if _, err := NewKeeper(&KeeperConfig{Config: config}); err == nil || !strings.Contains(err.Error(), "CA file") {
+		t.Errorf("NewKeeper() with a missing CA file error = %v, want it to name the CA file", err)
+	}
+}
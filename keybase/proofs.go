@@ -0,0 +1,213 @@
+package keybase
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+)
+
+// ProofPolicy decides whether a recipient's identity proofs are strong
+// enough to encrypt to them
+//
+// It is enforced when Config.VerifyProofs is set. The zero value requires at
+// least one verified proof of any kind.
+type ProofPolicy struct {
+	// RequiredTypes lists proof services ("github", "dns", "twitter", "web",
+	// ...) of which each recipient needs at least one verified proof
+	// If empty, a verified proof of any service is enough
+	RequiredTypes []string
+
+	// Assertions maps a recipient's lowercase username to Keybase assertions
+	// that must all hold for that recipient, e.g. "alice" ->
+	// ["alice_gh@github", "alice.dev@dns"]. Every user named must be a
+	// recipient or a member of a recipient team.
+	Assertions map[string][]string
+}
+
+// ProofPolicyError reports the recipients that failed the proof policy
+type ProofPolicyError struct {
+	// Failures maps each failing recipient to the reason it failed
+	Failures map[string]string
+}
+
+func (e *ProofPolicyError) Error() string {
+	usernames := make([]string, 0, len(e.Failures))
+	for username := range e.Failures {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	reasons := make([]string, 0, len(usernames))
+	for _, username := range usernames {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", username, e.Failures[username]))
+	}
+	return "identity proof policy not satisfied: " + strings.Join(reasons, "; ")
+}
+
+// Check verifies every recipient's proofs against the policy
+//
+// proofs maps usernames to their proof summaries, as returned by
+// api.Client.LookupProofs. Returns a *ProofPolicyError listing every
+// recipient that fails, and every asserted user who is not a recipient: a
+// misspelt assertion would otherwise never be checked.
+func (p *ProofPolicy) Check(recipients []string, proofs map[string][]api.Proof) error {
+	failures := make(map[string]string)
+
+	isRecipient := make(map[string]bool, len(recipients))
+	for _, username := range recipients {
+		isRecipient[strings.ToLower(username)] = true
+
+		userProofs, ok := proofs[username]
+		if !ok {
+			userProofs = proofs[strings.ToLower(username)]
+		}
+		if reason := p.checkUser(username, userProofs); reason != "" {
+			failures[username] = reason
+		}
+	}
+
+	for username := range p.Assertions {
+		if !isRecipient[strings.ToLower(username)] {
+			failures[username] = "assertion for a user who is not a recipient"
+		}
+	}
+
+	if len(failures) > 0 {
+		return &ProofPolicyError{Failures: failures}
+	}
+	return nil
+}
+
+// checkUser returns why a user fails the policy, or "" if they pass
+func (p *ProofPolicy) checkUser(username string, proofs []api.Proof) string {
+	var verified []api.Proof
+	for _, proof := range proofs {
+		if proof.IsVerified() {
+			verified = append(verified, proof)
+		}
+	}
+
+	if len(verified) == 0 {
+		return "no verified identity proofs"
+	}
+
+	if len(p.RequiredTypes) > 0 && !hasProofOfType(verified, p.RequiredTypes) {
+		return fmt.Sprintf("no verified proof of type %s", strings.Join(p.RequiredTypes, " or "))
+	}
+
+	for _, assertion := range p.Assertions[strings.ToLower(username)] {
+		if !satisfiesAssertion(verified, assertion) {
+			return fmt.Sprintf("assertion %q not satisfied", assertion)
+		}
+	}
+
+	return ""
+}
+
+// hasProofOfType reports whether any proof is from one of the services
+func hasProofOfType(proofs []api.Proof, services []string) bool {
+	for _, proof := range proofs {
+		for _, service := range services {
+			if proofMatchesService(proof, service) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// satisfiesAssertion reports whether any proof matches an assertion of
+// the form "name@service"
+func satisfiesAssertion(proofs []api.Proof, assertion string) bool {
+	name, service, ok := strings.Cut(strings.ToLower(assertion), "@")
+	if !ok {
+		return false
+	}
+
+	for _, proof := range proofs {
+		if strings.EqualFold(proof.Nametag, name) && proofMatchesService(proof, service) {
+			return true
+		}
+	}
+	return false
+}
+
+// proofMatchesService reports whether a proof is from the named service
+// "web" matches both website and DNS proofs, as in Keybase assertions
+func proofMatchesService(proof api.Proof, service string) bool {
+	service = strings.ToLower(service)
+	proofType := strings.ToLower(proof.ProofType)
+
+	switch service {
+	case "web":
+		return proofType == "generic_web_site" || proofType == "dns"
+	case "http", "https":
+		return proofType == "generic_web_site" && strings.HasSuffix(proof.Assertion(), "@"+service)
+	default:
+		return proofType == service
+	}
+}
+
+// parseProofTypes parses a comma-separated list of proof services
+func parseProofTypes(list string) ([]string, error) {
+	var types []string
+	for _, proofType := range strings.Split(list, ",") {
+		proofType = strings.ToLower(strings.TrimSpace(proofType))
+		if proofType == "" {
+			continue
+		}
+		if strings.ContainsAny(proofType, "@:+") {
+			return nil, fmt.Errorf("invalid proof type %q", proofType)
+		}
+		types = append(types, proofType)
+	}
+	return types, nil
+}
+
+// parseProofAssertion parses "user:name@service+name@service" into the
+// recipient's lowercase username and its assertions
+func parseProofAssertion(value string) (string, []string, error) {
+	username, list, ok := strings.Cut(value, ":")
+	if !ok {
+		return "", nil, fmt.Errorf("invalid assertion %q: expected user:name@service", value)
+	}
+
+	username = strings.ToLower(strings.TrimSpace(username))
+	if err := api.ValidateUsername(username); err != nil {
+		return "", nil, fmt.Errorf("invalid assertion %q: %w", value, err)
+	}
+
+	var assertions []string
+	for _, assertion := range strings.Split(list, "+") {
+		assertion = strings.TrimSpace(assertion)
+		name, service, ok := strings.Cut(assertion, "@")
+		if !ok || name == "" || service == "" {
+			return "", nil, fmt.Errorf("invalid assertion %q: expected name@service", assertion)
+		}
+		assertions = append(assertions, assertion)
+	}
+
+	return username, assertions, nil
+}
+
+// validateProofAssertions checks that every asserted user is a recipient
+//
+// Team members are only known once the teams are resolved, so with team
+// recipients the check is left to ProofPolicy.Check.
+func validateProofAssertions(config *Config) error {
+	if len(config.Teams) > 0 {
+		return nil
+	}
+
+	for username := range config.ProofPolicy.Assertions {
+		if !slices.ContainsFunc(config.Recipients, func(recipient string) bool {
+			return strings.EqualFold(recipient, username)
+		}) {
+			return fmt.Errorf("assert names %q, who is not a recipient", username)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package keybase
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// RekeyResult is the outcome of re-encrypting one ciphertext
+type RekeyResult struct {
+	// Ciphertext is the message re-encrypted for the new recipients
+	Ciphertext []byte
+
+	// Recipients are the users the message is now encrypted for, with teams
+	// expanded into their members
+	Recipients []string
+
+	// PreviousRecipients are the users the original message was encrypted
+	// for, as far as they could be identified from its header
+	PreviousRecipients []string
+
+	// PreviousRecipientsKnown reports whether every recipient of the original
+	// message was identified. It is false for PGP and signcrypted messages,
+	// messages with hidden recipients (see hide_recipients), and keys whose
+	// owner could not be resolved, in which case Added and Removed are empty
+	PreviousRecipientsKnown bool
+
+	// Added are the recipients who gained access
+	Added []string
+
+	// Removed are the previous recipients who lost access
+	Removed []string
+}
+
+// Rekey decrypts ciphertext with the local keyring and encrypts the plaintext
+// again for newRecipients, reporting who gained and who lost access
+//
+// newRecipients takes usernames and "team:" entries as in the URL's host.
+// Their public keys are fetched through the cache manager, and the proof
+// policy, format and mode of the Keeper apply as in Encrypt. The plaintext
+// never leaves memory.
+//
+// An envelope (see Config.Envelope) stays an envelope: only its data key is
+// decrypted and wrapped for the new recipients, and its payload is copied
+// unchanged without being decrypted.
+func (k *Keeper) Rekey(ctx context.Context, ciphertext []byte, newRecipients []string) (*RekeyResult, error) {
+	results, err := k.RekeyAll(ctx, [][]byte{ciphertext}, newRecipients)
+	if err != nil {
+		return nil, err
+	}
+
+	return results[0], nil
+}
+
+// RekeyAll is Rekey for many ciphertexts, such as every secret of a stack
+//
+// The new recipients' keys, the allowed senders' keys and the owners of the
+// original recipient keys are each looked up once for the whole batch.
+// Processing stops at the first ciphertext that cannot be rekeyed; the
+// error names its index and keeps the code of the underlying failure.
+func (k *Keeper) RekeyAll(ctx context.Context, ciphertexts [][]byte, newRecipients []string) ([]*RekeyResult, error) {
+	if err := k.requireNativeEngine("rekeying"); err != nil {
+		return nil, err
+	}
+
+	usernames, teams, err := parseRecipients(strings.Join(newRecipients, ","))
+	if err != nil {
+		return nil, &KeeperError{
+			Message:    "invalid rekey recipients",
+			Code:       gcerrors.InvalidArgument,
+			Underlying: err,
+		}
+	}
+	if len(usernames) == 0 && len(teams) == 0 {
+		return nil, &KeeperError{
+			Message: "at least one recipient is required to rekey",
+			Code:    gcerrors.InvalidArgument,
+		}
+	}
+
+	for i, ciphertext := range ciphertexts {
+		if len(ciphertext) == 0 {
+			return nil, &KeeperError{
+				Message: fmt.Sprintf("ciphertext %d cannot be empty", i),
+				Code:    gcerrors.InvalidArgument,
+			}
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, k.classifyError(err, "rekey aborted", gcerrors.InvalidArgument)
+	}
+
+	userPublicKeys, err := k.publicKeysFor(ctx, usernames, teams)
+	if err != nil {
+		return nil, err
+	}
+	recipients := rekeyRecipients(userPublicKeys)
+
+	decryptor, allowedSenders, err := k.senderDecryptor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Envelopes keep their payload and only have their data key rewrapped
+	var receivers []saltpack.BoxPublicKey
+	if k.config.Format == FormatSaltpack {
+		receivers, err = saltpackReceivers(userPublicKeys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rewrapped := make(map[string]string)
+
+	previous := k.previousRecipients(ctx, ciphertexts)
+
+	results := make([]*RekeyResult, len(ciphertexts))
+	for i, ciphertext := range ciphertexts {
+		if err := ctx.Err(); err != nil {
+			return nil, k.rekeyError(i, k.classifyError(err, "rekey aborted", gcerrors.InvalidArgument))
+		}
+
+		var rekeyed []byte
+		if receivers != nil && crypto.IsEnvelope(ciphertext) {
+			rekeyed, err = k.rewrapEnvelope(decryptor, ciphertext, receivers, rewrapped)
+		} else {
+			rekeyed, err = k.reencrypt(decryptor, allowedSenders, ciphertext, userPublicKeys)
+		}
+		if err != nil {
+			return nil, k.rekeyError(i, err)
+		}
+
+		result := &RekeyResult{
+			Ciphertext:              rekeyed,
+			Recipients:              recipients,
+			PreviousRecipients:      previous[i].usernames,
+			PreviousRecipientsKnown: previous[i].known,
+		}
+		if result.PreviousRecipientsKnown {
+			result.Added = missingUsers(recipients, result.PreviousRecipients)
+			result.Removed = missingUsers(result.PreviousRecipients, recipients)
+		}
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// reencrypt decrypts ciphertext and encrypts the plaintext for userPublicKeys
+func (k *Keeper) reencrypt(decryptor *crypto.Decryptor, allowedSenders []api.UserPublicKey, ciphertext []byte, userPublicKeys []api.UserPublicKey) ([]byte, error) {
+	plaintext, err := k.decryptWith(decryptor, allowedSenders, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(plaintext)
+
+	return k.encryptTo(plaintext, userPublicKeys)
+}
+
+// previousRecipientSet is what the header of a ciphertext tells about the
+// users it is encrypted for
+type previousRecipientSet struct {
+	usernames []string
+	known     bool
+}
+
+// previousRecipients identifies the recipients of each ciphertext from its
+// header, resolving the keys of the whole batch with one lookup
+func (k *Keeper) previousRecipients(ctx context.Context, ciphertexts [][]byte) []previousRecipientSet {
+	inspections := make([]*crypto.MessageInspection, len(ciphertexts))
+	var kids []string
+	for i, ciphertext := range ciphertexts {
+		if crypto.IsPGPMessage(ciphertext) {
+			continue
+		}
+		inspection, err := crypto.InspectMessage(ciphertext)
+		if err != nil {
+			continue
+		}
+		inspections[i] = inspection
+		for _, recipient := range inspection.Recipients {
+			if recipient.Key != nil {
+				kids = append(kids, recipient.Key.KID)
+			}
+		}
+	}
+
+	var owners map[string]string
+	if len(kids) > 0 {
+		// A lookup failure still returns the owners found in the cache
+		owners, _ = k.cacheManager.ResolveKIDs(ctx, kids)
+	}
+
+	sets := make([]previousRecipientSet, len(ciphertexts))
+	for i, inspection := range inspections {
+		if inspection == nil {
+			continue
+		}
+
+		known := len(inspection.Recipients) > 0
+		seen := make(map[string]bool)
+		for _, recipient := range inspection.Recipients {
+			if recipient.Key == nil {
+				known = false
+				continue
+			}
+			username := owners[recipient.Key.KID]
+			if username == "" {
+				known = false
+				continue
+			}
+			if !seen[strings.ToLower(username)] {
+				seen[strings.ToLower(username)] = true
+				sets[i].usernames = append(sets[i].usernames, username)
+			}
+		}
+		sets[i].known = known
+	}
+
+	return sets
+}
+
+// rekeyError prefixes a failure with the index of the ciphertext it is for,
+// keeping its error code
+func (k *Keeper) rekeyError(index int, err error) error {
+	keeperErr := k.classifyError(err, "", gcerrors.Internal)
+
+	return &KeeperError{
+		Message:    fmt.Sprintf("failed to rekey ciphertext %d", index),
+		Code:       keeperErr.Code,
+		Underlying: err,
+	}
+}
+
+// missingUsers returns the users of a that are not in b, ignoring case as
+// Keybase usernames do
+func missingUsers(a, b []string) []string {
+	present := make(map[string]bool, len(b))
+	for _, username := range b {
+		present[strings.ToLower(username)] = true
+	}
+
+	var missing []string
+	for _, username := range a {
+		if !present[strings.ToLower(username)] {
+			missing = append(missing, username)
+		}
+	}
+
+	return missing
+}
+
+// rekeyRecipients returns the usernames of userPublicKeys
+func rekeyRecipients(userPublicKeys []api.UserPublicKey) []string {
+	usernames := make([]string, len(userPublicKeys))
+	for i, userPublicKey := range userPublicKeys {
+		usernames[i] = userPublicKey.Username
+	}
+	return usernames
+}
@@ -0,0 +1,187 @@
+package keybase
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// addRekeyTestUser caches a new user for keeper and returns their key pair
+func addRekeyTestUser(t *testing.T, keeper *Keeper, username string) *crypto.KeyPair {
+	t.Helper()
+
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	if err := keeper.cacheManager.Cache().Set(username, "", crypto.EncryptionKID(keyPair.PublicKey)); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+	return keyPair
+}
+
+// TestKeeperRekey tests adding and removing recipients of a ciphertext
+func TestKeeperRekey(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice")
+	bob := addRekeyTestUser(t, keeper, "bob")
+	plaintext := []byte("db password")
+
+	ciphertext, err := keeper.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// Grant bob access
+	granted, err := keeper.Rekey(ctx, ciphertext, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if !granted.PreviousRecipientsKnown {
+		t.Error("PreviousRecipientsKnown = false, want true")
+	}
+	if !reflect.DeepEqual(granted.Recipients, []string{"alice", "bob"}) {
+		t.Errorf("Recipients = %v, want [alice bob]", granted.Recipients)
+	}
+	if !reflect.DeepEqual(granted.PreviousRecipients, []string{"alice"}) {
+		t.Errorf("PreviousRecipients = %v, want [alice]", granted.PreviousRecipients)
+	}
+	if !reflect.DeepEqual(granted.Added, []string{"bob"}) || len(granted.Removed) != 0 {
+		t.Errorf("Added = %v, Removed = %v, want [bob], []", granted.Added, granted.Removed)
+	}
+
+	// Revoke alice's access
+	revoked, err := keeper.Rekey(ctx, granted.Ciphertext, []string{"bob"})
+	if err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if !reflect.DeepEqual(revoked.Removed, []string{"alice"}) || len(revoked.Added) != 0 {
+		t.Errorf("Added = %v, Removed = %v, want [], [alice]", revoked.Added, revoked.Removed)
+	}
+
+	_, err = keeper.Decrypt(ctx, revoked.Ciphertext)
+	if code := keeper.ErrorCode(err); code != gcerrors.PermissionDenied {
+		t.Errorf("Decrypt() by a removed recipient ErrorCode() = %v, want %v (error: %v)", code, gcerrors.PermissionDenied, err)
+	}
+
+	keeper.keyring.AddKey(bob.SecretKey)
+	decrypted, err := keeper.Decrypt(ctx, revoked.Ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() by the new recipient error = %v", err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+}
+
+// TestKeeperRekeyAll tests rekeying a batch and reporting the failing ciphertext
+func TestKeeperRekeyAll(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice")
+	addRekeyTestUser(t, keeper, "bob")
+
+	var ciphertexts [][]byte
+	for _, secret := range []string{"one", "two", "three"} {
+		ciphertext, err := keeper.Encrypt(ctx, []byte(secret))
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		ciphertexts = append(ciphertexts, ciphertext)
+	}
+
+	results, err := keeper.RekeyAll(ctx, ciphertexts, []string{"alice", "bob"})
+	if err != nil {
+		t.Fatalf("RekeyAll() error = %v", err)
+	}
+	if len(results) != len(ciphertexts) {
+		t.Fatalf("RekeyAll() returned %d results, want %d", len(results), len(ciphertexts))
+	}
+	for i, result := range results {
+		if !reflect.DeepEqual(result.Added, []string{"bob"}) {
+			t.Errorf("results[%d].Added = %v, want [bob]", i, result.Added)
+		}
+		decrypted, err := keeper.Decrypt(ctx, result.Ciphertext)
+		if err != nil {
+			t.Fatalf("Decrypt() error = %v", err)
+		}
+		if original, _ := keeper.Decrypt(ctx, ciphertexts[i]); !bytes.Equal(decrypted, original) {
+			t.Errorf("results[%d] plaintext = %q, want %q", i, decrypted, original)
+		}
+	}
+
+	// A ciphertext the keyring cannot open stops the batch and is named
+	stranger, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair() error = %v", err)
+	}
+	foreign, err := keeper.encryptor.EncryptArmored([]byte("not ours"), []saltpack.BoxPublicKey{stranger.PublicKey})
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	_, err = keeper.RekeyAll(ctx, [][]byte{ciphertexts[0], []byte(foreign)}, []string{"bob"})
+	if code := keeper.ErrorCode(err); code != gcerrors.PermissionDenied {
+		t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, gcerrors.PermissionDenied, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "ciphertext 1") {
+		t.Errorf("error = %v, want it to name ciphertext 1", err)
+	}
+}
+
+// TestKeeperRekeyPreviousRecipientsUnknown tests that hidden recipients are not diffed
+func TestKeeperRekeyPreviousRecipientsUnknown(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice?hide_recipients=true")
+	addRekeyTestUser(t, keeper, "bob")
+
+	ciphertext, err := keeper.Encrypt(ctx, []byte("hidden"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	result, err := keeper.Rekey(ctx, ciphertext, []string{"bob"})
+	if err != nil {
+		t.Fatalf("Rekey() error = %v", err)
+	}
+	if result.PreviousRecipientsKnown {
+		t.Error("PreviousRecipientsKnown = true for hidden recipients, want false")
+	}
+	if len(result.Added) != 0 || len(result.Removed) != 0 {
+		t.Errorf("Added = %v, Removed = %v, want both empty", result.Added, result.Removed)
+	}
+}
+
+// TestKeeperRekeyInvalidArguments tests argument validation
+func TestKeeperRekeyInvalidArguments(t *testing.T) {
+	ctx := context.Background()
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice")
+
+	ciphertext, err := keeper.Encrypt(ctx, []byte("secret"))
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		ciphertexts [][]byte
+		recipients  []string
+	}{
+		{"no recipients", [][]byte{ciphertext}, nil},
+		{"invalid username", [][]byte{ciphertext}, []string{"not a user"}},
+		{"empty ciphertext", [][]byte{ciphertext, nil}, []string{"alice"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := keeper.RekeyAll(ctx, tt.ciphertexts, tt.recipients)
+			if code := keeper.ErrorCode(err); code != gcerrors.InvalidArgument {
+				t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, gcerrors.InvalidArgument, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package keybase
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+)
+
+// SenderPolicy decides which senders Decrypt accepts messages from
+//
+// Anyone who can write to a state backend can plant ciphertext encrypted to
+// the recipients, so a policy lets a keeper refuse messages that were not
+// written by a trusted Keybase user. The zero value accepts every message.
+type SenderPolicy struct {
+	// RejectAnonymous refuses messages whose sender is anonymous
+	RejectAnonymous bool
+
+	// AllowedSenders lists the Keybase users whose keys may have written a
+	// message. A non-empty list implies RejectAnonymous
+	AllowedSenders []string
+}
+
+// Enabled reports whether the policy restricts senders at all
+func (p *SenderPolicy) Enabled() bool {
+	return p.RejectAnonymous || len(p.AllowedSenders) > 0
+}
+
+// SenderPolicyError reports why a message's sender was refused
+type SenderPolicyError struct {
+	// SenderKID is the Keybase KID of the sender key (empty if anonymous)
+	SenderKID string
+
+	// Reason describes why the sender is not accepted
+	Reason string
+}
+
+func (e *SenderPolicyError) Error() string {
+	return "sender policy not satisfied: " + e.Reason
+}
+
+// Check verifies a message's sender against the policy
+//
+// senderKID is the Keybase KID of the key that wrote the message: a 0121
+// encryption KID for encrypted messages, a 0120 signing KID for signcrypted
+// ones, or "" for an anonymous sender. allowed holds the public keys of
+// AllowedSenders. Returns the matching username (empty when the policy has
+// no allowlist) or a *SenderPolicyError.
+func (p *SenderPolicy) Check(senderKID string, allowed []api.UserPublicKey) (string, error) {
+	if !p.Enabled() {
+		return "", nil
+	}
+
+	if senderKID == "" {
+		return "", &SenderPolicyError{Reason: "message sender is anonymous"}
+	}
+
+	if len(p.AllowedSenders) == 0 {
+		return "", nil
+	}
+
+	if username := userForKID(allowed, senderKID); username != "" {
+		return username, nil
+	}
+
+	return "", &SenderPolicyError{
+		SenderKID: senderKID,
+		Reason: fmt.Sprintf("sender key %s does not belong to any of %s",
+			senderKID, strings.Join(p.AllowedSenders, ", ")),
+	}
+}
+
+// userForKID returns the user owning the encryption or signing key kid,
+// or "" if none of userKeys has it
+func userForKID(userKeys []api.UserPublicKey, kid string) string {
+	kid = strings.ToLower(kid)
+	for i := range userKeys {
+		for _, candidate := range append(userKeys[i].EncryptionKIDs(), userKeys[i].SigningKIDs()...) {
+			if candidate == kid {
+				return userKeys[i].Username
+			}
+		}
+	}
+	return ""
+}
+
+// parseAllowedSenders parses a comma-separated list of sender usernames
+func parseAllowedSenders(list string) ([]string, error) {
+	var senders []string
+	for _, username := range strings.Split(list, ",") {
+		username = strings.TrimSpace(username)
+		if username == "" {
+			continue
+		}
+		if err := api.ValidateUsername(username); err != nil {
+			return nil, fmt.Errorf("invalid sender username '%s': %w", username, err)
+		}
+		senders = append(senders, username)
+	}
+	return senders, nil
+}
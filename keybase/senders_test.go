@@ -0,0 +1,248 @@
+package keybase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/api"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/cache"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+func TestSenderPolicyCheck(t *testing.T) {
+	const (
+		aliceDH      = "0121aa0a"
+		aliceSigning = "0120bb0a"
+		bobDH        = "0121cc0a"
+	)
+	allowed := []api.UserPublicKey{
+		{Username: "alice", Subkeys: []string{aliceDH}, Sibkeys: []string{aliceSigning}},
+		{Username: "bob", KeyID: bobDH},
+	}
+
+	tests := []struct {
+		name      string
+		policy    SenderPolicy
+		senderKID string
+		wantUser  string
+		wantErr   bool
+	}{
+		{name: "no policy, anonymous", senderKID: ""},
+		{name: "reject anonymous", policy: SenderPolicy{RejectAnonymous: true}, senderKID: "", wantErr: true},
+		{name: "reject anonymous, known sender", policy: SenderPolicy{RejectAnonymous: true}, senderKID: bobDH},
+		{name: "allowlist implies reject anonymous", policy: SenderPolicy{AllowedSenders: []string{"alice"}}, senderKID: "", wantErr: true},
+		{name: "allowed encryption key", policy: SenderPolicy{AllowedSenders: []string{"alice", "bob"}}, senderKID: "0121CC0A", wantUser: "bob"},
+		{name: "allowed signing key", policy: SenderPolicy{AllowedSenders: []string{"alice"}}, senderKID: aliceSigning, wantUser: "alice"},
+		{name: "unknown key", policy: SenderPolicy{AllowedSenders: []string{"alice"}}, senderKID: "0121dd0a", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			username, err := tt.policy.Check(tt.senderKID, allowed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Check() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var policyErr *SenderPolicyError
+			if err != nil && !errors.As(err, &policyErr) {
+				t.Errorf("Check() error = %T, want *SenderPolicyError", err)
+			}
+			if username != tt.wantUser {
+				t.Errorf("Check() username = %q, want %q", username, tt.wantUser)
+			}
+		})
+	}
+}
+
+func TestParseURLSenderPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		want    SenderPolicy
+		wantErr bool
+	}{
+		{name: "default", url: "keybase://alice"},
+		{name: "reject anonymous", url: "keybase://alice?reject_anonymous=true", want: SenderPolicy{RejectAnonymous: true}},
+		{name: "allowed senders", url: "keybase://alice?allowed_senders=bob,%20carol", want: SenderPolicy{AllowedSenders: []string{"bob", "carol"}}},
+		{name: "invalid bool", url: "keybase://alice?reject_anonymous=maybe", wantErr: true},
+		{name: "invalid sender", url: "keybase://alice?allowed_senders=bob,c@rol", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseURL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			// Check the parsed policy and that it survives a round trip
+			parsed, err := ParseURL(config.ToURL())
+			if err != nil {
+				t.Fatalf("ParseURL(ToURL()) error = %v", err)
+			}
+			for _, got := range []SenderPolicy{config.SenderPolicy, parsed.SenderPolicy} {
+				if got.RejectAnonymous != tt.want.RejectAnonymous {
+					t.Errorf("RejectAnonymous = %t, want %t", got.RejectAnonymous, tt.want.RejectAnonymous)
+				}
+				if len(got.AllowedSenders) != len(tt.want.AllowedSenders) {
+					t.Fatalf("AllowedSenders = %v, want %v", got.AllowedSenders, tt.want.AllowedSenders)
+				}
+				for i := range got.AllowedSenders {
+					if got.AllowedSenders[i] != tt.want.AllowedSenders[i] {
+						t.Errorf("AllowedSenders[%d] = %s, want %s", i, got.AllowedSenders[i], tt.want.AllowedSenders[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestKeeperDecryptSenderPolicy(t *testing.T) {
+	manager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{
+			FilePath: t.TempDir() + "/cache.json",
+			TTL:      time.Hour,
+		},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	defer manager.Close()
+
+	// alice receives; bob and mallory write with their device keys
+	keyPairs := make(map[string]*crypto.KeyPair)
+	signingKeys := make(map[string]saltpack.SigningSecretKey)
+	for _, username := range []string{"alice", "bob", "mallory"} {
+		keyPair, err := crypto.GenerateKeyPair()
+		if err != nil {
+			t.Fatalf("Failed to generate key pair: %v", err)
+		}
+		signingKey, err := crypto.GenerateSigningKey()
+		if err != nil {
+			t.Fatalf("Failed to generate signing key: %v", err)
+		}
+		keyPairs[username] = keyPair
+		signingKeys[username] = signingKey
+
+		err = manager.Cache().SetEntry(cache.CacheEntry{
+			Username: username,
+			KeyID:    crypto.EncryptionKID(keyPair.PublicKey),
+			Sibkeys:  []string{crypto.SigningKID(signingKey.GetPublicKey())},
+			Subkeys:  []string{crypto.EncryptionKID(keyPair.PublicKey)},
+		})
+		if err != nil {
+			t.Fatalf("Failed to populate cache: %v", err)
+		}
+	}
+
+	encryptAs := func(sender, mode string) []byte {
+		config, err := ParseURL("keybase://alice?mode=" + mode)
+		if err != nil {
+			t.Fatalf("ParseURL() error = %v", err)
+		}
+		keeperConfig := &KeeperConfig{Config: config, CacheManager: manager}
+		if sender != "" {
+			keeperConfig.SenderKey = keyPairs[sender].SecretKey
+			keeperConfig.SigningKey = signingKeys[sender]
+		}
+		if mode == string(ModeSigncrypt) && sender == "" {
+			// An explicit anonymous signcryption, bypassing the keeper's key loading
+			encryptor, err := crypto.NewEncryptor(&crypto.EncryptorConfig{})
+			if err != nil {
+				t.Fatalf("NewEncryptor() error = %v", err)
+			}
+			ciphertext, err := encryptor.SigncryptArmored([]byte("planted"), []saltpack.BoxPublicKey{keyPairs["alice"].PublicKey})
+			if err != nil {
+				t.Fatalf("SigncryptArmored() error = %v", err)
+			}
+			return []byte(ciphertext)
+		}
+		writer, err := NewKeeper(keeperConfig)
+		if err != nil {
+			t.Fatalf("NewKeeper() error = %v", err)
+		}
+		ciphertext, err := writer.Encrypt(context.Background(), []byte("secret"))
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		return ciphertext
+	}
+
+	tests := []struct {
+		name     string
+		url      string
+		sender   string
+		mode     string
+		wantCode gcerrors.ErrorCode
+	}{
+		{name: "no policy accepts anonymous", url: "keybase://alice", mode: "encrypt"},
+		{name: "anonymous rejected", url: "keybase://alice?reject_anonymous=true", mode: "encrypt", wantCode: gcerrors.PermissionDenied},
+		{name: "own key accepted", url: "keybase://alice?reject_anonymous=true", sender: "alice", mode: "encrypt"},
+		{name: "unknown sender key", url: "keybase://alice?reject_anonymous=true", sender: "mallory", mode: "encrypt", wantCode: gcerrors.PermissionDenied},
+		{name: "allowed sender", url: "keybase://alice?allowed_senders=bob", sender: "bob", mode: "encrypt"},
+		{name: "sender not allowed", url: "keybase://alice?allowed_senders=bob", sender: "mallory", mode: "encrypt", wantCode: gcerrors.PermissionDenied},
+		{name: "allowed signer", url: "keybase://alice?allowed_senders=bob", sender: "bob", mode: "signcrypt"},
+		{name: "signer not allowed", url: "keybase://alice?allowed_senders=bob", sender: "mallory", mode: "signcrypt", wantCode: gcerrors.PermissionDenied},
+		{name: "anonymous signcryption rejected", url: "keybase://alice?allowed_senders=bob", mode: "signcrypt", wantCode: gcerrors.PermissionDenied},
+		{name: "allowed sender not cached", url: "keybase://alice?allowed_senders=carol", sender: "bob", mode: "encrypt", wantCode: gcerrors.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ciphertext := encryptAs(tt.sender, tt.mode)
+
+			config, err := ParseURL(tt.url)
+			if err != nil {
+				t.Fatalf("ParseURL() error = %v", err)
+			}
+			reader, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: manager})
+			if err != nil {
+				t.Fatalf("NewKeeper() error = %v", err)
+			}
+			reader.keyring.AddKey(keyPairs["alice"].SecretKey)
+
+			for _, decrypt := range []func() ([]byte, error){
+				func() ([]byte, error) { return reader.Decrypt(context.Background(), ciphertext) },
+				func() ([]byte, error) {
+					plaintext, _, err := reader.DecryptWithInfo(context.Background(), ciphertext)
+					return plaintext, err
+				},
+			} {
+				plaintext, err := decrypt()
+				if tt.wantCode == gcerrors.OK {
+					if err != nil {
+						t.Fatalf("decryption error = %v", err)
+					}
+					continue
+				}
+				if code := reader.ErrorCode(err); code != tt.wantCode {
+					t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, tt.wantCode, err)
+				}
+				if plaintext != nil {
+					t.Errorf("refused decryption returned plaintext %q", plaintext)
+				}
+			}
+		})
+	}
+
+	// PGP messages are unsigned, so a policy refuses them outright
+	config, err := ParseURL("keybase://alice?reject_anonymous=true")
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	reader, err := NewKeeper(&KeeperConfig{Config: config, CacheManager: manager})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	_, err = reader.Decrypt(context.Background(), []byte("-----BEGIN PGP MESSAGE-----\n\n-----END PGP MESSAGE-----"))
+	if code := reader.ErrorCode(err); code != gcerrors.PermissionDenied {
+		t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, gcerrors.PermissionDenied, err)
+	}
+}
@@ -0,0 +1,230 @@
+package keybase
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// NewEncryptWriter returns a writer that encrypts everything written to it
+// for all configured recipients and writes the ciphertext to w
+//
+// Recipients are resolved exactly as in Encrypt before the writer is
+// returned, and the output is the same ASCII-armored Saltpack (or, with the
+// pgp format, OpenPGP) message that Encrypt produces. Plaintext is encrypted
+// one 1 MiB chunk at a time, so memory use does not grow with the message.
+// An envelope cannot be streamed, so a Keeper in envelope mode (see
+// Config.Envelope) refuses with FailedPrecondition rather than write another
+// format than the one configured.
+//
+// ctx is checked before every chunk. Once it is done, Write and Close fail
+// with Canceled or DeadlineExceeded and the message is left without its
+// final chunk, so the partial ciphertext in w will not decrypt.
+//
+// Close must be called to finish the message; it does not close w.
+func (k *Keeper) NewEncryptWriter(ctx context.Context, w io.Writer) (io.WriteCloser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, k.classifyError(err, "encryption aborted", gcerrors.Internal)
+	}
+	if err := k.requireNativeEngine("streaming encryption"); err != nil {
+		return nil, err
+	}
+	if k.config.Envelope != "" {
+		return nil, &KeeperError{
+			Message: "streaming encryption does not support envelope mode",
+			Code:    gcerrors.FailedPrecondition,
+		}
+	}
+
+	userPublicKeys, err := k.recipientKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var stream io.WriteCloser
+	if k.config.Format == FormatPGP {
+		recipients, err := pgpRecipients(userPublicKeys)
+		if err != nil {
+			return nil, err
+		}
+		stream, err = crypto.NewPGPEncryptor(nil).NewEncryptStreamArmored(w, recipients)
+		if err != nil {
+			return nil, k.classifyError(err, "PGP encryption failed", gcerrors.Internal)
+		}
+	} else {
+		receivers, err := saltpackReceivers(userPublicKeys)
+		if err != nil {
+			return nil, err
+		}
+		stream, err = k.openEncryptStream(w, receivers)
+		if err != nil {
+			return nil, k.classifyError(err, "streaming encryption failed", gcerrors.Internal)
+		}
+	}
+
+	return &encryptWriter{ctx: ctx, keeper: k, stream: stream}, nil
+}
+
+// encryptWriter feeds an encryption stream in chunks, checking its context
+// between them
+type encryptWriter struct {
+	ctx    context.Context
+	keeper *Keeper
+	stream io.WriteCloser
+
+	// err is the first failure; the stream is unusable after it
+	err error
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if err := w.ctx.Err(); err != nil {
+			w.err = w.keeper.classifyError(err, "encryption aborted", gcerrors.Internal)
+			return written, w.err
+		}
+
+		chunk := p
+		if len(chunk) > crypto.StreamChunkSize {
+			chunk = chunk[:crypto.StreamChunkSize]
+		}
+
+		n, err := w.stream.Write(chunk)
+		written += n
+		if err != nil {
+			w.err = w.keeper.classifyError(err, "streaming encryption failed", gcerrors.Internal)
+			return written, w.err
+		}
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+// Close writes the final chunk, unless the context is done or a write failed
+func (w *encryptWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+
+	if err := w.ctx.Err(); err != nil {
+		w.err = w.keeper.classifyError(err, "encryption aborted", gcerrors.Internal)
+		return w.err
+	}
+
+	if err := w.stream.Close(); err != nil {
+		w.err = w.keeper.classifyError(err, "streaming encryption failed", gcerrors.Internal)
+		return w.err
+	}
+
+	// Further writes after Close are an error, as for the underlying stream
+	w.err = &KeeperError{Message: "encrypt writer is closed", Code: gcerrors.FailedPrecondition}
+	return nil
+}
+
+// NewDecryptReader returns a reader of the plaintext of the message read
+// from r
+//
+// Saltpack messages, encrypted or signcrypted and armored or binary, and
+// OpenPGP messages are detected as in Decrypt. The message header is read
+// and the configured SenderPolicy is enforced before the reader is returned;
+// the rest of the message is decrypted one chunk at a time as it is read,
+// so memory use does not grow with the message. Envelopes are read and
+// decrypted whole, as in Decrypt.
+//
+// Every chunk is authenticated before it is returned, but a truncated
+// message is only detected at its end: plaintext must not be trusted until
+// Read has returned io.EOF. ctx is checked before every Read.
+func (k *Keeper) NewDecryptReader(ctx context.Context, r io.Reader) (io.Reader, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, k.classifyError(err, "decryption aborted", gcerrors.InvalidArgument)
+	}
+	if err := k.requireNativeEngine("streaming decryption"); err != nil {
+		return nil, err
+	}
+
+	buffered := bufio.NewReader(r)
+	peek, _ := buffered.Peek(64)
+	if len(peek) == 0 {
+		return nil, &KeeperError{
+			Message: "ciphertext cannot be empty",
+			Code:    gcerrors.InvalidArgument,
+		}
+	}
+
+	if crypto.IsPGPMessage(peek) {
+		if err := k.checkPGPDecryption(); err != nil {
+			return nil, err
+		}
+
+		plaintext, err := k.pgpDecryptor.NewDecryptStream(buffered)
+		if err != nil {
+			return nil, k.classifyError(err, "PGP decryption failed", gcerrors.InvalidArgument)
+		}
+
+		return &decryptReader{ctx: ctx, keeper: k, plaintext: plaintext, failure: "PGP decryption failed"}, nil
+	}
+
+	if crypto.IsEnvelope(peek) {
+		ciphertext, err := io.ReadAll(buffered)
+		if err != nil {
+			return nil, k.classifyError(err, "failed to read envelope", gcerrors.Internal)
+		}
+
+		plaintext, err := k.Decrypt(ctx, ciphertext)
+		if err != nil {
+			return nil, err
+		}
+
+		return bytes.NewReader(plaintext), nil
+	}
+
+	decryptor, allowedSenders, err := k.senderDecryptor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := decryptor.NewDecryptStream(buffered)
+	if err != nil {
+		return nil, k.decryptionFailure(err, "streaming decryption failed")
+	}
+
+	// Refuse messages written by senders the policy does not accept before
+	// any of their plaintext is read
+	if err := k.checkSender(streamMessage(stream), allowedSenders); err != nil {
+		return nil, err
+	}
+
+	return &decryptReader{ctx: ctx, keeper: k, plaintext: stream, failure: "streaming decryption failed"}, nil
+}
+
+// decryptReader reads from a decryption stream, checking its context before
+// every read
+type decryptReader struct {
+	ctx       context.Context
+	keeper    *Keeper
+	plaintext io.Reader
+
+	// failure describes errors from the decryption stream
+	failure string
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, r.keeper.classifyError(err, "decryption aborted", gcerrors.InvalidArgument)
+	}
+
+	n, err := r.plaintext.Read(p)
+	if err != nil && err != io.EOF {
+		return n, r.keeper.decryptionFailure(err, r.failure)
+	}
+	return n, err
+}
@@ -0,0 +1,296 @@
+package keybase
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/cache"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/gcerrors"
+)
+
+// newStreamTestKeeper creates a keeper for url whose recipient alice is
+// cached and whose keyring holds alice's secret key
+func newStreamTestKeeper(t *testing.T, url string) (*Keeper, *crypto.KeyPair) {
+	t.Helper()
+
+	manager, err := cache.NewManager(&cache.ManagerConfig{
+		CacheConfig: &cache.CacheConfig{
+			FilePath: t.TempDir() + "/cache.json",
+			TTL:      time.Hour,
+		},
+		OfflineMode: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create cache manager: %v", err)
+	}
+	t.Cleanup(func() { manager.Close() })
+
+	receiver, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	if err := manager.Cache().Set("alice", "", crypto.EncryptionKID(receiver.PublicKey)); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+
+	signingKey, err := crypto.GenerateSigningKey()
+	if err != nil {
+		t.Fatalf("Failed to generate signing key: %v", err)
+	}
+
+	config, err := ParseURL(url)
+	if err != nil {
+		t.Fatalf("ParseURL() error = %v", err)
+	}
+	keeper, err := NewKeeper(&KeeperConfig{
+		Config:       config,
+		CacheManager: manager,
+		SenderKey:    receiver.SecretKey,
+		SigningKey:   signingKey,
+	})
+	if err != nil {
+		t.Fatalf("NewKeeper() error = %v", err)
+	}
+	keeper.keyring.AddKey(receiver.SecretKey)
+	keeper.keyring.AddPublicKey(receiver.PublicKey)
+
+	return keeper, receiver
+}
+
+// streamTestPlaintext returns a message spanning several Saltpack chunks
+func streamTestPlaintext() []byte {
+	plaintext := make([]byte, 3*crypto.StreamChunkSize+17)
+	for i := range plaintext {
+		plaintext[i] = byte(i % 251)
+	}
+	return plaintext
+}
+
+// TestKeeperEncryptWriterDecryptReader tests round trips through the streaming API
+func TestKeeperEncryptWriterDecryptReader(t *testing.T) {
+	plaintext := streamTestPlaintext()
+
+	for _, mode := range []string{"encrypt", "signcrypt"} {
+		t.Run(mode, func(t *testing.T) {
+			keeper, _ := newStreamTestKeeper(t, "keybase://alice?mode="+mode)
+			ctx := context.Background()
+
+			var ciphertext bytes.Buffer
+			writer, err := keeper.NewEncryptWriter(ctx, &ciphertext)
+			if err != nil {
+				t.Fatalf("NewEncryptWriter() error = %v", err)
+			}
+
+			// A small write followed by one spanning several chunks
+			if _, err := writer.Write(plaintext[:100]); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if n, err := writer.Write(plaintext[100:]); err != nil || n != len(plaintext)-100 {
+				t.Fatalf("Write() = %d, %v, want %d, nil", n, err, len(plaintext)-100)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if _, err := writer.Write([]byte("late")); err == nil {
+				t.Error("Write() after Close() should fail")
+			}
+
+			// The output is the same armored message Encrypt produces
+			if !bytes.HasPrefix(ciphertext.Bytes(), []byte("BEGIN SALTPACK ENCRYPTED MESSAGE")) {
+				t.Errorf("ciphertext does not start with the Saltpack armor header: %q", ciphertext.Bytes()[:40])
+			}
+			decrypted, err := keeper.Decrypt(ctx, ciphertext.Bytes())
+			if err != nil {
+				t.Fatalf("Decrypt() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Error("Decrypt() of streamed ciphertext does not match plaintext")
+			}
+
+			reader, err := keeper.NewDecryptReader(ctx, &ciphertext)
+			if err != nil {
+				t.Fatalf("NewDecryptReader() error = %v", err)
+			}
+			decrypted, err = io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Error("NewDecryptReader() plaintext does not match")
+			}
+		})
+	}
+}
+
+// TestKeeperDecryptReaderFormats tests that every message format is detected from a stream
+func TestKeeperDecryptReaderFormats(t *testing.T) {
+	keeper, receiver := newStreamTestKeeper(t, "keybase://alice")
+	receivers := []saltpack.BoxPublicKey{receiver.PublicKey}
+	plaintext := []byte("state export")
+
+	binary, err := keeper.encryptor.Encrypt(plaintext, receivers)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	armored, err := keeper.encryptor.EncryptArmored(plaintext, receivers)
+	if err != nil {
+		t.Fatalf("EncryptArmored() error = %v", err)
+	}
+	signcrypted, err := keeper.encryptor.Signcrypt(plaintext, receivers)
+	if err != nil {
+		t.Fatalf("Signcrypt() error = %v", err)
+	}
+	signcryptedArmored, err := keeper.encryptor.SigncryptArmored(plaintext, receivers)
+	if err != nil {
+		t.Fatalf("SigncryptArmored() error = %v", err)
+	}
+
+	messages := map[string][]byte{
+		"binary":              binary,
+		"armored":             []byte(armored),
+		"signcrypted":         signcrypted,
+		"signcrypted armored": []byte(signcryptedArmored),
+	}
+
+	for name, ciphertext := range messages {
+		t.Run(name, func(t *testing.T) {
+			// One-byte reads make sure nothing relies on large reads
+			reader, err := keeper.NewDecryptReader(context.Background(), &oneByteReader{bytes.NewReader(ciphertext)})
+			if err != nil {
+				t.Fatalf("NewDecryptReader() error = %v", err)
+			}
+			decrypted, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if !bytes.Equal(decrypted, plaintext) {
+				t.Errorf("plaintext = %q, want %q", decrypted, plaintext)
+			}
+		})
+	}
+}
+
+// oneByteReader returns at most one byte per Read
+type oneByteReader struct {
+	reader io.Reader
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return r.reader.Read(p[:1])
+}
+
+// TestKeeperEncryptWriterCancel tests that cancellation stops encryption between chunks
+func TestKeeperEncryptWriterCancel(t *testing.T) {
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice")
+	plaintext := streamTestPlaintext()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var ciphertext bytes.Buffer
+	writer, err := keeper.NewEncryptWriter(ctx, &ciphertext)
+	if err != nil {
+		t.Fatalf("NewEncryptWriter() error = %v", err)
+	}
+	if _, err := writer.Write(plaintext[:crypto.StreamChunkSize]); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+
+	cancel()
+
+	n, err := writer.Write(plaintext[crypto.StreamChunkSize:])
+	if code := keeper.ErrorCode(err); code != gcerrors.Canceled {
+		t.Errorf("Write() after cancel ErrorCode() = %v, want %v (error: %v)", code, gcerrors.Canceled, err)
+	}
+	if n != 0 {
+		t.Errorf("Write() after cancel wrote %d bytes, want 0", n)
+	}
+	if err := writer.Close(); keeper.ErrorCode(err) != gcerrors.Canceled {
+		t.Errorf("Close() after cancel error = %v, want Canceled", err)
+	}
+
+	// The abandoned message has no final chunk and must not decrypt
+	if _, err := keeper.Decrypt(context.Background(), ciphertext.Bytes()); err == nil {
+		t.Error("Decrypt() of a cancelled message should fail")
+	}
+
+	// A context that is already done refuses to start
+	if _, err := keeper.NewEncryptWriter(ctx, io.Discard); keeper.ErrorCode(err) != gcerrors.Canceled {
+		t.Errorf("NewEncryptWriter() with cancelled context error = %v, want Canceled", err)
+	}
+}
+
+// TestKeeperDecryptReaderErrors tests cancellation, truncation and policy failures
+func TestKeeperDecryptReaderErrors(t *testing.T) {
+	keeper, _ := newStreamTestKeeper(t, "keybase://alice")
+	plaintext := streamTestPlaintext()
+
+	ciphertext, err := keeper.Encrypt(context.Background(), plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	t.Run("cancelled between reads", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		reader, err := keeper.NewDecryptReader(ctx, bytes.NewReader(ciphertext))
+		if err != nil {
+			t.Fatalf("NewDecryptReader() error = %v", err)
+		}
+		if _, err := reader.Read(make([]byte, 1024)); err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+
+		cancel()
+
+		if _, err := reader.Read(make([]byte, 1024)); keeper.ErrorCode(err) != gcerrors.Canceled {
+			t.Errorf("Read() after cancel error = %v, want Canceled", err)
+		}
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		truncated := ciphertext[:len(ciphertext)/2]
+		reader, err := keeper.NewDecryptReader(context.Background(), bytes.NewReader(truncated))
+		if err != nil {
+			t.Fatalf("NewDecryptReader() error = %v", err)
+		}
+		if _, err := io.ReadAll(reader); err == nil {
+			t.Error("ReadAll() of a truncated message should fail")
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := keeper.NewDecryptReader(context.Background(), bytes.NewReader(nil))
+		if code := keeper.ErrorCode(err); code != gcerrors.InvalidArgument {
+			t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, gcerrors.InvalidArgument, err)
+		}
+	})
+
+	t.Run("sender policy", func(t *testing.T) {
+		strict, receiver := newStreamTestKeeper(t, "keybase://alice?reject_anonymous=true")
+
+		anonymous, err := crypto.NewEncryptor(nil)
+		if err != nil {
+			t.Fatalf("NewEncryptor() error = %v", err)
+		}
+		message, err := anonymous.EncryptArmored([]byte("planted"), []saltpack.BoxPublicKey{receiver.PublicKey})
+		if err != nil {
+			t.Fatalf("EncryptArmored() error = %v", err)
+		}
+
+		// The policy is enforced before any plaintext can be read
+		_, err = strict.NewDecryptReader(context.Background(), bytes.NewReader([]byte(message)))
+		if code := strict.ErrorCode(err); code != gcerrors.PermissionDenied {
+			t.Errorf("ErrorCode() = %v, want %v (error: %v)", code, gcerrors.PermissionDenied, err)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package keybase
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/cache"
+	"gocloud.dev/secrets"
+	"gocloud.dev/secrets/driver"
+)
+
+// Scheme is the URL scheme the Keybase keeper is registered under with
+// secrets.DefaultURLMux
+const Scheme = "keybase"
+
+// Compile-time check that Keeper satisfies the Go CDK driver interface
+var _ driver.Keeper = (*Keeper)(nil)
+
+func init() {
+	secrets.DefaultURLMux().RegisterKeeper(Scheme, &URLOpener{})
+}
+
+// URLOpener opens Keybase keepers from URLs of the form
+// keybase://user1,user2?format=saltpack&cache_ttl=86400
+//
+// It is registered with secrets.DefaultURLMux for the "keybase" scheme, so
+// secrets.OpenKeeper(ctx, "keybase://alice,bob") works once this package is
+// imported. See ParseURL for the supported query parameters; KEYBASE_*
+// environment variables take precedence over them (see LoadConfig).
+type URLOpener struct {
+	// CacheManager is the cache manager used by opened keepers
+	// If nil, each keeper creates its own from the URL configuration
+	CacheManager *cache.Manager
+
+	// SenderKey is the sender's secret key (nil for anonymous sender)
+	SenderKey saltpack.BoxSecretKey
+
+	// SigningKey is the sender's Ed25519 signing key for mode=signcrypt
+	// If nil, the local Keybase user's signing key is loaded when needed
+	SigningKey saltpack.SigningSecretKey
+
+	// PGPPassphrase unlocks the PGP secret key named by pgp_secret_key
+	PGPPassphrase []byte
+}
+
+// OpenKeeperURL opens a Keybase keeper for the given URL
+func (o *URLOpener) OpenKeeperURL(ctx context.Context, u *url.URL) (*secrets.Keeper, error) {
+	config, _, err := LoadConfig(u.String())
+	if err != nil {
+		return nil, fmt.Errorf("open keeper %v: %w", u, err)
+	}
+
+	keeper, err := OpenKeeper(&KeeperConfig{
+		Config:        config,
+		CacheManager:  o.CacheManager,
+		SenderKey:     o.SenderKey,
+		SigningKey:    o.SigningKey,
+		PGPPassphrase: o.PGPPassphrase,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open keeper %v: %w", u, err)
+	}
+
+	return keeper, nil
+}
+
+// OpenKeeper creates a portable *secrets.Keeper backed by a Keybase Keeper
+//
+// This is the Go CDK counterpart of NewKeeper: the returned keeper wraps
+// errors with gcerrors codes and can be used anywhere a *secrets.Keeper is
+// expected.
+func OpenKeeper(config *KeeperConfig) (*secrets.Keeper, error) {
+	keeper, err := NewKeeper(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets.NewKeeper(keeper), nil
+}
@@ -0,0 +1,156 @@
+package keybase
+
+import (
+	"context"
+	"net/url"
+	"testing"
+
+	"github.com/keybase/saltpack"
+	"github.com/pulumi/pulumi-keybase-encryption/keybase/crypto"
+	"gocloud.dev/secrets"
+)
+
+// TestURLOpenerRegistered tests that the keybase scheme is registered with the default mux
+func TestURLOpenerRegistered(t *testing.T) {
+	if !secrets.DefaultURLMux().ValidKeeperScheme(Scheme) {
+		t.Fatalf("scheme %q is not registered with secrets.DefaultURLMux", Scheme)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{
+			name:    "single recipient",
+			url:     "keybase://alice",
+			wantErr: false,
+		},
+		{
+			name:    "multiple recipients",
+			url:     "keybase://alice,bob",
+			wantErr: false,
+		},
+		{
+			name:    "with query parameters",
+			url:     "keybase://alice,bob?format=saltpack&cache_ttl=3600",
+			wantErr: false,
+		},
+		{
+			name:    "invalid format",
+			url:     "keybase://alice?format=invalid",
+			wantErr: true,
+		},
+		{
+			name:    "invalid recipient",
+			url:     "keybase://alice@example.com",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keeper, err := secrets.OpenKeeper(context.Background(), tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("secrets.OpenKeeper(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				if keeper == nil {
+					t.Fatal("secrets.OpenKeeper() returned nil keeper")
+				}
+				defer keeper.Close()
+			}
+		})
+	}
+}
+
+// TestURLOpenerEncryptDecrypt tests a round trip through a *secrets.Keeper opened by URL
+func TestURLOpenerEncryptDecrypt(t *testing.T) {
+	keyPair, err := crypto.GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	cacheManager, err := createMockCacheManager(map[string]saltpack.BoxPublicKey{
+		"alice": keyPair.PublicKey,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create mock cache manager: %v", err)
+	}
+	defer cacheManager.Close()
+
+	opener := &URLOpener{CacheManager: cacheManager}
+	u, err := url.Parse("keybase://alice")
+	if err != nil {
+		t.Fatalf("Failed to parse URL: %v", err)
+	}
+
+	keeper, err := opener.OpenKeeperURL(context.Background(), u)
+	if err != nil {
+		t.Fatalf("OpenKeeperURL() error = %v", err)
+	}
+	defer keeper.Close()
+
+	ctx := context.Background()
+	plaintext := []byte("secret through the portable keeper")
+
+	ciphertext, err := keeper.Encrypt(ctx, plaintext)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// Decrypt with a driver keeper holding alice's key, wrapped the same way
+	keyring := crypto.NewSimpleKeyring()
+	keyring.AddKey(keyPair.SecretKey)
+
+	decryptor, err := crypto.NewDecryptor(&crypto.DecryptorConfig{
+		Keyring: keyring,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create decryptor: %v", err)
+	}
+
+	decryptKeeper := secrets.NewKeeper(&Keeper{
+		config:    DefaultConfig(),
+		decryptor: decryptor,
+		keyring:   keyring,
+	})
+
+	decrypted, err := decryptKeeper.Decrypt(ctx, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+
+	if string(decrypted) != string(plaintext) {
+		t.Errorf("Decrypt() = %q, want %q", decrypted, plaintext)
+	}
+}
+
+// TestOpenKeeper tests the secrets.Keeper-returning constructor
+func TestOpenKeeper(t *testing.T) {
+	if _, err := OpenKeeper(nil); err == nil {
+		t.Error("OpenKeeper(nil) should fail")
+	}
+
+	keeper, err := OpenKeeper(&KeeperConfig{
+		Config: &Config{
+			Recipients: []string{"alice"},
+			Format:     FormatSaltpack,
+		},
+	})
+	if err != nil {
+		t.Fatalf("OpenKeeper() error = %v", err)
+	}
+	defer keeper.Close()
+
+	// Empty plaintext is rejected before any key lookup
+	_, err = keeper.Encrypt(context.Background(), nil)
+	if err == nil {
+		t.Fatal("Encrypt() with empty plaintext should fail")
+	}
+
+	var keeperErr *KeeperError
+	if !keeper.ErrorAs(err, &keeperErr) {
+		t.Errorf("ErrorAs() could not extract *KeeperError from %v", err)
+	}
+}